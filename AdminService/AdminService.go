@@ -0,0 +1,123 @@
+package AdminService
+
+import (
+	"EntitlementServer/AuditLog"
+	"EntitlementServer/AuthenticationManagement"
+	"EntitlementServer/DatabaseAbstraction"
+	"EntitlementServer/ProductService"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/sirupsen/logrus"
+	"strconv"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// AdminService contains the endpoints for administrators, every route requires the admin role
+// API keys can only be used for the catalog routes that are mapped to the products:write scope
+type AdminService struct {
+	DB    DatabaseAbstraction.DBOrm
+	Auth  AuthenticationManagement.AuthenticationManager
+	Audit AuditLog.Auditor // records every change made by admins
+	// Refunds is checked again when a refund is approved, ProductService.DefaultRefundPolicy if nil
+	Refunds *ProductService.RefundPolicy
+}
+
+func (s AdminService) RegisterHandlers(r *gin.Engine, middleware ...gin.HandlerFunc) {
+	admin := r.Group("/api/admin", middleware[0], AuthenticationManagement.RequireRole(DatabaseAbstraction.RoleAdmin))
+
+	admin.GET("/users", s.ListUsersHandler)
+	admin.GET("/users/:id", s.GetUserHandler)
+	admin.GET("/users/:id/purchases", s.GetPurchasesHandler)
+	admin.GET("/users/:id/progress", s.GetProgressHandler)
+	admin.POST("/users/:id/products/:productID", s.GrantProductHandler)
+	admin.DELETE("/users/:id/products/:productID", s.RevokeProductHandler)
+	admin.POST("/users/:id/balance", s.AdjustBalanceHandler)
+	admin.DELETE("/users/:id/sessions", s.ResetSessionsHandler)
+	admin.POST("/users/:id/suspension", s.SuspendUserHandler)
+	admin.DELETE("/users/:id/suspension", s.UnsuspendUserHandler)
+	admin.POST("/users/:id/impersonate", s.ImpersonateUserHandler)
+	admin.DELETE("/comments/:id", s.DeleteCommentHandler)
+	admin.POST("/categories", s.CreateCategoryHandler)
+	admin.PUT("/categories/:id", s.UpdateCategoryHandler)
+	admin.DELETE("/categories/:id", s.DeleteCategoryHandler)
+	admin.PUT("/products/:id/category", s.SetProductCategoryHandler)
+	admin.PUT("/products/:id/tags", s.SetProductTagsHandler)
+	admin.POST("/bundles", s.CreateBundleHandler)
+	admin.PUT("/bundles/:id", s.UpdateBundleHandler)
+	admin.DELETE("/bundles/:id", s.DeleteBundleHandler)
+	admin.GET("/coupons", s.ListCouponsHandler)
+	admin.POST("/coupons", s.CreateCouponHandler)
+	admin.DELETE("/coupons/:id", s.DisableCouponHandler)
+	admin.GET("/refunds", s.ListRefundsHandler)
+	admin.POST("/refunds/:id/approve", s.ApproveRefundHandler)
+	admin.POST("/refunds/:id/reject", s.RejectRefundHandler)
+	admin.GET("/keys", s.ListLicenseKeysHandler)
+	admin.POST("/products/:id/keys", s.GenerateLicenseKeysHandler)
+	admin.DELETE("/keys/:id", s.RevokeLicenseKeyHandler)
+	admin.GET("/plans", s.ListPlansHandler)
+	admin.POST("/plans", s.CreatePlanHandler)
+	admin.DELETE("/plans/:id", s.DeactivatePlanHandler)
+	admin.PUT("/products/:id/rental", s.SetRentalOfferHandler)
+	admin.DELETE("/products/:id/rental", s.DeleteRentalOfferHandler)
+	admin.GET("/vat-rates", s.ListVATRatesHandler)
+	admin.PUT("/vat-rates/:country", s.SetVATRateHandler)
+	admin.DELETE("/vat-rates/:country", s.DeleteVATRateHandler)
+	admin.GET("/audit", s.GetAuditEventsHandler)
+}
+
+func (s AdminService) GetLabel() string {
+	return "Admin Service"
+}
+
+// audit records a successful admin action on the target user
+func (s AdminService) audit(c *gin.Context, action string, target DatabaseAbstraction.User, details map[string]interface{}) {
+	event := AuditLog.NewEvent(c, action)
+	event.TargetUserID = target.IndexID
+	for key, value := range details {
+		event.Details[key] = value
+	}
+	AuditLog.OrDefault(s.Audit).Record(event)
+}
+
+// pagination reads the page and per_page query parameters, pages start at 1
+func pagination(c *gin.Context) (page int, perPage int) {
+	page, err := strconv.Atoi(c.Query("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	perPage, err = strconv.Atoi(c.Query("per_page"))
+	if err != nil || perPage < 1 {
+		perPage = defaultPageSize
+	}
+	if perPage > maxPageSize {
+		perPage = maxPageSize
+	}
+	return page, perPage
+}
+
+// targetUser loads the user of the :id parameter, it responds with an error and returns false if there is none
+func (s AdminService) targetUser(c *gin.Context) (DatabaseAbstraction.User, bool) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid user ID"})
+		return DatabaseAbstraction.User{}, false
+	}
+
+	user, err := s.DB.GetUserByIndexID(userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(404, gin.H{"error": "User not found"})
+		return DatabaseAbstraction.User{}, false
+	}
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, gin.H{"error": "Failed to get user"})
+		return DatabaseAbstraction.User{}, false
+	}
+
+	return user, true
+}
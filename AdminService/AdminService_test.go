@@ -0,0 +1,123 @@
+package AdminService_test
+
+import (
+	"EntitlementServer/AdminService"
+	"EntitlementServer/AuthenticationManagement"
+	"EntitlementServer/DatabaseAbstraction"
+	"EntitlementServer/DatabaseAbstraction/mocks"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var (
+	adminUser   = DatabaseAbstraction.User{IndexID: 1, Username: "admin", Role: DatabaseAbstraction.RoleAdmin}
+	studentUser = DatabaseAbstraction.User{IndexID: 2, Username: "student", Password: "$argon2id$secret-hash", Role: DatabaseAbstraction.RoleStudent, Balance: 300}
+)
+
+// newRouter registers the admin routes with a middleware that signs in the given user
+func newRouter(mockDB *mocks.DBOrm, signedIn DatabaseAbstraction.User) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	svc := AdminService.AdminService{DB: mockDB, Auth: AuthenticationManagement.AuthenticationService{DB: mockDB}}
+
+	r := gin.New()
+	svc.RegisterHandlers(r, func(c *gin.Context) {
+		c.Set("user", signedIn)
+	})
+	return r
+}
+
+func request(r *gin.Engine, method string, path string, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestAdminRoutesRequireAdminRole(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	r := newRouter(mockDB, studentUser)
+
+	w := request(r, http.MethodGet, "/api/admin/users", "")
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	mockDB.AssertNotCalled(t, "SearchUsers", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestListUsers(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	mockDB.On("SearchUsers", "stud", 10, 10).Return([]DatabaseAbstraction.User{studentUser}, 11, nil)
+	r := newRouter(mockDB, adminUser)
+
+	w := request(r, http.MethodGet, "/api/admin/users?q=stud&page=2&per_page=10", "")
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"total":11`)
+	assert.Contains(t, w.Body.String(), `"username":"student"`)
+	assert.NotContains(t, w.Body.String(), "secret-hash")
+	mockDB.AssertExpectations(t)
+}
+
+func TestAdjustBalance(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	mockDB.On("GetUserByIndexID", 2).Return(studentUser, nil)
+	mockDB.On("DecreaseUserBalance", 2, 100, "adjustment: duplicate top-up").Return(nil)
+	r := newRouter(mockDB, adminUser)
+
+	w := request(r, http.MethodPost, "/api/admin/users/2/balance", `{"amount": -100, "reason": "duplicate top-up"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"balance":200`)
+
+	// the reason is required and the balance can't become negative
+	w = request(r, http.MethodPost, "/api/admin/users/2/balance", `{"amount": 100}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = request(r, http.MethodPost, "/api/admin/users/2/balance", `{"amount": -500, "reason": "too much"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	mockDB.AssertExpectations(t)
+}
+
+func TestGrantProduct(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	mockDB.On("GetUserByIndexID", 2).Return(studentUser, nil)
+	mockDB.On("GetProductByIndexID", 3).Return(DatabaseAbstraction.Product{IndexID: 3}, nil)
+	mockDB.On("GetProductByIndexID", 4).Return(DatabaseAbstraction.Product{IndexID: 4}, nil)
+	mockDB.On("GetOwnedProducts", 2).Return([]DatabaseAbstraction.Product{{IndexID: 4}}, nil)
+	mockDB.On("AddOwnedProduct", 2, 3).Return(nil)
+	r := newRouter(mockDB, adminUser)
+
+	w := request(r, http.MethodPost, "/api/admin/users/2/products/3", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = request(r, http.MethodPost, "/api/admin/users/2/products/4", "")
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	// granting never touches the balance
+	mockDB.AssertNotCalled(t, "DecreaseUserBalance", mock.Anything, mock.Anything, mock.Anything)
+	mockDB.AssertExpectations(t)
+}
+
+func TestSuspendUser(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	mockDB.On("GetUserByIndexID", 1).Return(adminUser, nil)
+	mockDB.On("GetUserByIndexID", 2).Return(studentUser, nil)
+	mockDB.On("SuspendUser", 2, "spam").Return(nil)
+	mockDB.On("DeleteTokensByUserID", 2).Return(nil)
+	mockDB.On("DeleteAPIKeysByUserID", 2).Return(nil)
+	r := newRouter(mockDB, adminUser)
+
+	w := request(r, http.MethodPost, "/api/admin/users/2/suspension", `{"reason": "spam"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"suspended":true`)
+
+	w = request(r, http.MethodPost, "/api/admin/users/1/suspension", `{"reason": "oops"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	mockDB.AssertExpectations(t)
+	mockDB.AssertNotCalled(t, "SuspendUser", 1, mock.Anything)
+}
@@ -0,0 +1,171 @@
+package AdminService
+
+import (
+	"EntitlementServer/DatabaseAbstraction"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"strconv"
+	"time"
+)
+
+// a CSV export is a single query, larger ranges have to be split with from and to
+const maxCSVRows = 10000
+
+type auditEventResponse struct {
+	ID             int                    `json:"id"`
+	CreatedAt      time.Time              `json:"created_at"`
+	Action         string                 `json:"action"`
+	Outcome        string                 `json:"outcome"`
+	ActorID        int                    `json:"actor_id,omitempty"`
+	ImpersonatorID int                    `json:"impersonator_id,omitempty"`
+	TargetUserID   int                    `json:"target_user_id,omitempty"`
+	IP             string                 `json:"ip,omitempty"`
+	Details        map[string]interface{} `json:"details,omitempty"`
+}
+
+type auditListResponse struct {
+	Events  []auditEventResponse `json:"events"`
+	Total   int                  `json:"total"`
+	Page    int                  `json:"page"`
+	PerPage int                  `json:"per_page"`
+}
+
+// auditFilter reads the filter query parameters, it responds with an error and returns false if one is invalid
+func auditFilter(c *gin.Context) (DatabaseAbstraction.AuditFilter, bool) {
+	filter := DatabaseAbstraction.AuditFilter{
+		Action:  c.Query("action"),
+		Outcome: c.Query("outcome"),
+	}
+
+	for param, target := range map[string]*int{"actor_id": &filter.ActorID, "user_id": &filter.TargetUserID} {
+		if c.Query(param) == "" {
+			continue
+		}
+		id, err := strconv.Atoi(c.Query(param))
+		if err != nil || id < 1 {
+			c.JSON(400, gin.H{"error": "Invalid " + param})
+			return filter, false
+		}
+		*target = id
+	}
+
+	for param, target := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+		if c.Query(param) == "" {
+			continue
+		}
+		parsed, err := parseAuditTime(c.Query(param))
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Invalid " + param + ", expected a date or an RFC 3339 time"})
+			return filter, false
+		}
+		*target = parsed
+	}
+
+	return filter, true
+}
+
+// parseAuditTime accepts a full timestamp or a date, which means midnight UTC of that day
+func parseAuditTime(value string) (time.Time, error) {
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err == nil {
+		return parsed, nil
+	}
+	return time.Parse("2006-01-02", value)
+}
+
+// GetAuditEventsHandler godoc
+//
+//	@Summary		List audit events
+//	@Description	Logins, account changes, purchases and admin actions, newest first
+//	@Description	action matches exactly or as prefix, e.g. "auth" or "admin". user_id matches events by or about the user
+//	@Description	from and to are dates or RFC 3339 times, to is exclusive. format=csv downloads up to 10000 events instead of a page
+//	@Tags			Admin
+//	@Produce		json
+//	@Produce		text/csv
+//	@Param			action		query		string	false	"Action or action prefix"
+//	@Param			outcome		query		string	false	"success or failure"
+//	@Param			actor_id	query		int		false	"User who acted"
+//	@Param			user_id		query		int		false	"User who acted or was acted upon"
+//	@Param			from		query		string	false	"Earliest time"
+//	@Param			to			query		string	false	"Latest time, exclusive"
+//	@Param			format		query		string	false	"json or csv"
+//	@Param			page		query		int		false	"Page, starting at 1"
+//	@Param			per_page	query		int		false	"Events per page, at most 100"
+//	@Success		200			{object}	auditListResponse
+//	@Failure		400			{object}	map[string]string
+//	@Failure		403			{object}	map[string]string
+//	@Failure		500			{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/api/admin/audit [get]
+func (s AdminService) GetAuditEventsHandler(c *gin.Context) {
+	filter, ok := auditFilter(c)
+	if !ok {
+		return
+	}
+
+	if c.Query("format") == "csv" {
+		s.exportAuditEvents(c, filter)
+		return
+	}
+
+	page, perPage := pagination(c)
+	events, total, err := s.DB.GetAuditEvents(filter, perPage, (page-1)*perPage)
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, gin.H{"error": "Failed to get audit events"})
+		return
+	}
+
+	response := auditListResponse{
+		Events:  []auditEventResponse{},
+		Total:   total,
+		Page:    page,
+		PerPage: perPage,
+	}
+	for _, event := range events {
+		response.Events = append(response.Events, auditEventResponse{event.IndexID, event.CreatedAt, event.Action, event.Outcome,
+			event.ActorID, event.ImpersonatorID, event.TargetUserID, event.IP, event.Details})
+	}
+
+	c.JSON(200, response)
+}
+
+// exportAuditEvents writes the matching events as CSV, the details column holds them as a JSON object
+func (s AdminService) exportAuditEvents(c *gin.Context, filter DatabaseAbstraction.AuditFilter) {
+	events, total, err := s.DB.GetAuditEvents(filter, maxCSVRows, 0)
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, gin.H{"error": "Failed to get audit events"})
+		return
+	}
+	if total > maxCSVRows {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("%d events match, narrow the filter to at most %d events", total, maxCSVRows)})
+		return
+	}
+
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", `attachment; filename="audit.csv"`)
+	c.Status(200)
+
+	writer := csv.NewWriter(c.Writer)
+	_ = writer.Write([]string{"id", "created_at", "action", "outcome", "actor_id", "impersonator_id", "target_user_id", "ip", "details"})
+	for _, event := range events {
+		details, _ := json.Marshal(event.Details)
+		_ = writer.Write([]string{strconv.Itoa(event.IndexID), event.CreatedAt.Format(time.RFC3339), event.Action, event.Outcome,
+			optionalID(event.ActorID), optionalID(event.ImpersonatorID), optionalID(event.TargetUserID), event.IP, string(details)})
+	}
+	writer.Flush()
+	if writer.Error() != nil {
+		logrus.Error(writer.Error())
+	}
+}
+
+func optionalID(id int) string {
+	if id == 0 {
+		return ""
+	}
+	return strconv.Itoa(id)
+}
@@ -0,0 +1,114 @@
+package AdminService_test
+
+import (
+	"EntitlementServer/AdminService"
+	"EntitlementServer/AuditLog"
+	"EntitlementServer/AuthenticationManagement"
+	"EntitlementServer/DatabaseAbstraction"
+	"EntitlementServer/DatabaseAbstraction/mocks"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recordingAuditor keeps the events in memory
+type recordingAuditor struct {
+	events *[]DatabaseAbstraction.AuditEvent
+}
+
+func (a recordingAuditor) Record(event DatabaseAbstraction.AuditEvent) {
+	*a.events = append(*a.events, event)
+}
+
+func newAuditedRouter(mockDB *mocks.DBOrm, signedIn DatabaseAbstraction.User) (*gin.Engine, *[]DatabaseAbstraction.AuditEvent) {
+	gin.SetMode(gin.TestMode)
+	events := &[]DatabaseAbstraction.AuditEvent{}
+	svc := AdminService.AdminService{DB: mockDB, Auth: AuthenticationManagement.AuthenticationService{DB: mockDB}, Audit: recordingAuditor{events}}
+
+	r := gin.New()
+	svc.RegisterHandlers(r, func(c *gin.Context) {
+		c.Set("user", signedIn)
+	})
+	return r, events
+}
+
+func TestGetAuditEvents(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	filter := DatabaseAbstraction.AuditFilter{
+		Action:       "auth",
+		Outcome:      AuditLog.OutcomeFailure,
+		TargetUserID: 2,
+		From:         time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+	}
+	mockDB.On("GetAuditEvents", filter, 20, 0).Return([]DatabaseAbstraction.AuditEvent{
+		{IndexID: 7, Action: AuditLog.ActionLogin, Outcome: AuditLog.OutcomeFailure, Details: map[string]interface{}{"username": "student"}},
+	}, 1, nil)
+	r := newRouter(mockDB, adminUser)
+
+	w := request(r, http.MethodGet, "/api/admin/audit?action=auth&outcome=failure&user_id=2&from=2024-05-01", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"action":"auth.login"`)
+	assert.Contains(t, w.Body.String(), `"total":1`)
+
+	w = request(r, http.MethodGet, "/api/admin/audit?from=yesterday", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	mockDB.AssertExpectations(t)
+}
+
+func TestExportAuditEventsAsCSV(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	mockDB.On("GetAuditEvents", DatabaseAbstraction.AuditFilter{Action: "admin"}, 10000, 0).Return([]DatabaseAbstraction.AuditEvent{
+		{IndexID: 3, CreatedAt: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC), Action: AuditLog.ActionUserSuspended, Outcome: AuditLog.OutcomeSuccess,
+			ActorID: 1, TargetUserID: 2, IP: "192.0.2.1", Details: map[string]interface{}{"reason": "spam, again"}},
+	}, 1, nil)
+	r := newRouter(mockDB, adminUser)
+
+	w := request(r, http.MethodGet, "/api/admin/audit?action=admin&format=csv", "")
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Type"), "text/csv")
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	assert.Len(t, lines, 2)
+	assert.Equal(t, `3,2024-05-01T12:00:00Z,admin.user_suspended,success,1,,2,192.0.2.1,"{""reason"":""spam, again""}"`, lines[1])
+}
+
+func TestDeleteCommentIsAudited(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	mockDB.On("DeleteComment", 12).Return(DatabaseAbstraction.Comment{IndexID: 12, UserID: 2, ProductID: 1, Comment: "buy cheap followers"}, nil)
+	mockDB.On("DeleteComment", 13).Return(DatabaseAbstraction.Comment{}, pgx.ErrNoRows)
+	r, events := newAuditedRouter(mockDB, adminUser)
+
+	w := request(r, http.MethodDelete, "/api/admin/comments/12", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = request(r, http.MethodDelete, "/api/admin/comments/13", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	assert.Len(t, *events, 1)
+	event := (*events)[0]
+	assert.Equal(t, AuditLog.ActionCommentDeleted, event.Action)
+	assert.Equal(t, 1, event.ActorID)
+	assert.Equal(t, 2, event.TargetUserID)
+	assert.Equal(t, "buy cheap followers", event.Details["comment"])
+}
+
+func TestAdminActionsAreAudited(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	mockDB.On("GetUserByIndexID", 2).Return(studentUser, nil)
+	mockDB.On("IncreaseUserBalance", 2, 50, "adjustment: goodwill").Return(nil)
+	r, events := newAuditedRouter(mockDB, adminUser)
+
+	w := request(r, http.MethodPost, "/api/admin/users/2/balance", `{"amount": 50, "reason": "goodwill"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	assert.Equal(t, []DatabaseAbstraction.AuditEvent{{
+		Action:       AuditLog.ActionBalanceAdjusted,
+		Outcome:      AuditLog.OutcomeSuccess,
+		ActorID:      1,
+		TargetUserID: 2,
+		Details:      map[string]interface{}{"amount": 50, "reason": "goodwill"},
+	}}, *events)
+}
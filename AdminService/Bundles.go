@@ -0,0 +1,195 @@
+package AdminService
+
+import (
+	"EntitlementServer/AuditLog"
+	"EntitlementServer/DatabaseAbstraction"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/sirupsen/logrus"
+	"strconv"
+	"strings"
+)
+
+const minBundleProducts = 2
+
+type bundleRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Price       int    `json:"price"`
+	Image       string `json:"image"`
+	ProductIDs  []int  `json:"product_ids"` // in the recommended order
+}
+
+type bundleResponse struct {
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Price       int    `json:"price"`
+	Image       string `json:"image"`
+	ProductIDs  []int  `json:"product_ids"`
+}
+
+// bundleFromRequest binds and checks a bundle, it responds with an error and returns false if it is invalid
+func (s AdminService) bundleFromRequest(c *gin.Context) (DatabaseAbstraction.Bundle, bool) {
+	var request bundleRequest
+	err := c.ShouldBindJSON(&request)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request"})
+		return DatabaseAbstraction.Bundle{}, false
+	}
+
+	bundle := DatabaseAbstraction.Bundle{
+		Name:        strings.TrimSpace(request.Name),
+		Description: strings.TrimSpace(request.Description),
+		Price:       request.Price,
+		Image:       request.Image,
+		ProductIDs:  request.ProductIDs,
+	}
+	if bundle.Name == "" {
+		c.JSON(400, gin.H{"error": "The name is required"})
+		return bundle, false
+	}
+	if bundle.Price < 0 {
+		c.JSON(400, gin.H{"error": "The price can't be negative"})
+		return bundle, false
+	}
+
+	products, err := s.DB.GetAllProducts()
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, gin.H{"error": "Failed to get products"})
+		return bundle, false
+	}
+	known := make(map[int]bool, len(products))
+	for _, product := range products {
+		known[product.IndexID] = true
+	}
+
+	seen := make(map[int]bool, len(bundle.ProductIDs))
+	for _, productID := range bundle.ProductIDs {
+		if !known[productID] {
+			c.JSON(400, gin.H{"error": "Product " + strconv.Itoa(productID) + " not found"})
+			return bundle, false
+		}
+		if seen[productID] {
+			c.JSON(400, gin.H{"error": "Product " + strconv.Itoa(productID) + " is listed twice"})
+			return bundle, false
+		}
+		seen[productID] = true
+	}
+	if len(bundle.ProductIDs) < minBundleProducts {
+		c.JSON(400, gin.H{"error": "A bundle needs at least 2 products"})
+		return bundle, false
+	}
+
+	return bundle, true
+}
+
+// CreateBundleHandler godoc
+//
+//	@Summary		Create a bundle
+//	@Description	Groups at least 2 products into a learning path sold for one price
+//	@Tags			Admin
+//	@Accept			json
+//	@Produce		json
+//	@Param			bundle	body		bundleRequest	true	"Bundle"
+//	@Success		201		{object}	bundleResponse
+//	@Failure		400		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/api/admin/bundles [post]
+func (s AdminService) CreateBundleHandler(c *gin.Context) {
+	bundle, ok := s.bundleFromRequest(c)
+	if !ok {
+		return
+	}
+
+	bundleID, err := s.DB.AddBundle(bundle)
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, gin.H{"error": "Failed to create bundle"})
+		return
+	}
+
+	s.auditCatalog(c, AuditLog.ActionBundleCreated, map[string]interface{}{"bundle_id": bundleID, "price": bundle.Price, "product_ids": bundle.ProductIDs})
+	c.JSON(201, bundleResponse{bundleID, bundle.Name, bundle.Description, bundle.Price, bundle.Image, bundle.ProductIDs})
+}
+
+// UpdateBundleHandler godoc
+//
+//	@Summary		Update a bundle
+//	@Description	Replaces the bundle, users who bought it before keep their products
+//	@Tags			Admin
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		int				true	"Bundle ID"
+//	@Param			bundle	body		bundleRequest	true	"Bundle"
+//	@Success		200		{object}	bundleResponse
+//	@Failure		400		{object}	map[string]string
+//	@Failure		404		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/api/admin/bundles/{id} [put]
+func (s AdminService) UpdateBundleHandler(c *gin.Context) {
+	bundleID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid bundle ID"})
+		return
+	}
+
+	bundle, ok := s.bundleFromRequest(c)
+	if !ok {
+		return
+	}
+	bundle.IndexID = bundleID
+
+	err = s.DB.UpdateBundle(bundle)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(404, gin.H{"error": "Bundle not found"})
+		return
+	}
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, gin.H{"error": "Failed to update bundle"})
+		return
+	}
+
+	s.auditCatalog(c, AuditLog.ActionBundleUpdated, map[string]interface{}{"bundle_id": bundleID, "price": bundle.Price, "product_ids": bundle.ProductIDs})
+	c.JSON(200, bundleResponse{bundleID, bundle.Name, bundle.Description, bundle.Price, bundle.Image, bundle.ProductIDs})
+}
+
+// DeleteBundleHandler godoc
+//
+//	@Summary		Delete a bundle
+//	@Description	The bundle can't be bought anymore, users who bought it keep their products
+//	@Tags			Admin
+//	@Produce		json
+//	@Param			id	path		int	true	"Bundle ID"
+//	@Success		200	{object}	map[string]string
+//	@Failure		400	{object}	map[string]string
+//	@Failure		404	{object}	map[string]string
+//	@Failure		500	{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/api/admin/bundles/{id} [delete]
+func (s AdminService) DeleteBundleHandler(c *gin.Context) {
+	bundleID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid bundle ID"})
+		return
+	}
+
+	err = s.DB.DeleteBundle(bundleID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(404, gin.H{"error": "Bundle not found"})
+		return
+	}
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, gin.H{"error": "Failed to delete bundle"})
+		return
+	}
+
+	s.auditCatalog(c, AuditLog.ActionBundleDeleted, map[string]interface{}{"bundle_id": bundleID})
+	c.JSON(200, gin.H{"message": "Bundle deleted"})
+}
@@ -0,0 +1,48 @@
+package AdminService_test
+
+import (
+	"EntitlementServer/AuditLog"
+	"EntitlementServer/DatabaseAbstraction"
+	"EntitlementServer/DatabaseAbstraction/mocks"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+func TestCreateBundle(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	mockDB.On("GetAllProducts").Return([]DatabaseAbstraction.Product{{IndexID: 3}, {IndexID: 6}, {IndexID: 8}}, nil)
+	mockDB.On("AddBundle", DatabaseAbstraction.Bundle{Name: "Lernpfad Webentwicklung", Price: 1000, ProductIDs: []int{3, 6, 8}}).Return(1, nil)
+	r, events := newAuditedRouter(mockDB, adminUser)
+
+	w := request(r, http.MethodPost, "/api/admin/bundles", `{"name": "Lernpfad Webentwicklung", "price": 1000, "product_ids": [3, 6, 8]}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	for _, body := range []string{
+		`{"name": "Einzelkurs", "price": 1000, "product_ids": [3]}`,
+		`{"name": "Doppelt", "price": 1000, "product_ids": [3, 3]}`,
+		`{"name": "Unbekannt", "price": 1000, "product_ids": [3, 42]}`,
+		`{"name": "Negativ", "price": -1, "product_ids": [3, 6]}`,
+	} {
+		w = request(r, http.MethodPost, "/api/admin/bundles", body)
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+	}
+
+	assert.Len(t, *events, 1)
+	assert.Equal(t, AuditLog.ActionBundleCreated, (*events)[0].Action)
+	mockDB.AssertExpectations(t)
+}
+
+func TestUpdateAndDeleteMissingBundle(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	mockDB.On("GetAllProducts").Return([]DatabaseAbstraction.Product{{IndexID: 3}, {IndexID: 6}}, nil)
+	mockDB.On("UpdateBundle", DatabaseAbstraction.Bundle{IndexID: 9, Name: "Weg", ProductIDs: []int{3, 6}}).Return(pgx.ErrNoRows)
+	mockDB.On("DeleteBundle", 9).Return(pgx.ErrNoRows)
+	r := newRouter(mockDB, adminUser)
+
+	w := request(r, http.MethodPut, "/api/admin/bundles/9", `{"name": "Weg", "product_ids": [3, 6]}`)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = request(r, http.MethodDelete, "/api/admin/bundles/9", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,379 @@
+package AdminService
+
+import (
+	"EntitlementServer/AuditLog"
+	"EntitlementServer/DatabaseAbstraction"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/sirupsen/logrus"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	maxCategoryNameLength = 64
+	maxTagLength          = 32
+	maxTagsPerProduct     = 20
+)
+
+var slugPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+type categoryRequest struct {
+	Name     string `json:"name"`
+	Slug     string `json:"slug"`
+	ParentID int    `json:"parent_id"` // 0 for a top-level category
+}
+
+type categoryResponse struct {
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	Slug     string `json:"slug"`
+	ParentID int    `json:"parent_id,omitempty"`
+}
+
+type productCategoryRequest struct {
+	CategoryID int `json:"category_id"` // 0 removes the product from its category
+}
+
+type productTagsRequest struct {
+	Tags []string `json:"tags"`
+}
+
+type productTagsResponse struct {
+	ProductID int      `json:"product_id"`
+	Tags      []string `json:"tags"`
+}
+
+// auditCatalog records a change to the catalog, it doesn't concern a user
+func (s AdminService) auditCatalog(c *gin.Context, action string, details map[string]interface{}) {
+	event := AuditLog.NewEvent(c, action)
+	for key, value := range details {
+		event.Details[key] = value
+	}
+	AuditLog.OrDefault(s.Audit).Record(event)
+}
+
+// normalizeTags lowercases, trims and deduplicates tags, it returns false if one is empty, too long or contains other
+// characters than letters, digits and "+#.-", which keeps names like "c++" or "node.js" possible
+func normalizeTags(tags []string) ([]string, bool) {
+	seen := make(map[string]bool, len(tags))
+	normalized := []string{}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || utf8.RuneCountInString(tag) > maxTagLength {
+			return nil, false
+		}
+		for _, r := range tag {
+			if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune("+#.-", r) {
+				return nil, false
+			}
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			normalized = append(normalized, tag)
+		}
+	}
+	sort.Strings(normalized)
+	return normalized, true
+}
+
+// validCategory checks a new or changed category against the existing ones, it responds with an error and returns
+// false if it is invalid. A category can't be moved below itself or one of its subcategories.
+func (s AdminService) validCategory(c *gin.Context, category DatabaseAbstraction.Category) bool {
+	if category.Name == "" || utf8.RuneCountInString(category.Name) > maxCategoryNameLength {
+		c.JSON(400, gin.H{"error": "The name must be between 1 and 64 characters long"})
+		return false
+	}
+	if !slugPattern.MatchString(category.Slug) {
+		c.JSON(400, gin.H{"error": "The slug may only contain lowercase letters, digits and single dashes"})
+		return false
+	}
+
+	categories, err := s.DB.GetCategories()
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, gin.H{"error": "Failed to get categories"})
+		return false
+	}
+
+	parents := make(map[int]int, len(categories))
+	for _, existing := range categories {
+		parents[existing.IndexID] = existing.ParentID
+		if existing.Slug == category.Slug && existing.IndexID != category.IndexID {
+			c.JSON(409, gin.H{"error": "The slug is already used by another category"})
+			return false
+		}
+	}
+
+	if category.ParentID == 0 {
+		return true
+	}
+	if _, found := parents[category.ParentID]; !found {
+		c.JSON(400, gin.H{"error": "Parent category not found"})
+		return false
+	}
+	for ancestor := category.ParentID; ancestor != 0; ancestor = parents[ancestor] {
+		if ancestor == category.IndexID {
+			c.JSON(400, gin.H{"error": "A category can't be moved below itself"})
+			return false
+		}
+	}
+	return true
+}
+
+// CreateCategoryHandler godoc
+//
+//	@Summary		Create a category
+//	@Description	The slug identifies the category in catalog filters and has to be unique
+//	@Tags			Admin
+//	@Accept			json
+//	@Produce		json
+//	@Param			category	body		categoryRequest	true	"Category"
+//	@Success		201			{object}	categoryResponse
+//	@Failure		400			{object}	map[string]string
+//	@Failure		409			{object}	map[string]string
+//	@Failure		500			{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/api/admin/categories [post]
+func (s AdminService) CreateCategoryHandler(c *gin.Context) {
+	var request categoryRequest
+	err := c.ShouldBindJSON(&request)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	category := DatabaseAbstraction.Category{Name: strings.TrimSpace(request.Name), Slug: request.Slug, ParentID: request.ParentID}
+	if !s.validCategory(c, category) {
+		return
+	}
+
+	category.IndexID, err = s.DB.AddCategory(category)
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, gin.H{"error": "Failed to create category"})
+		return
+	}
+
+	s.auditCatalog(c, AuditLog.ActionCategoryCreated, map[string]interface{}{"category_id": category.IndexID, "slug": category.Slug})
+	c.JSON(201, categoryResponse{category.IndexID, category.Name, category.Slug, category.ParentID})
+}
+
+// UpdateCategoryHandler godoc
+//
+//	@Summary		Update a category
+//	@Description	Renames or moves a category, its subcategories and products move with it
+//	@Tags			Admin
+//	@Accept			json
+//	@Produce		json
+//	@Param			id			path		int				true	"Category ID"
+//	@Param			category	body		categoryRequest	true	"Category"
+//	@Success		200			{object}	categoryResponse
+//	@Failure		400			{object}	map[string]string
+//	@Failure		404			{object}	map[string]string
+//	@Failure		409			{object}	map[string]string
+//	@Failure		500			{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/api/admin/categories/{id} [put]
+func (s AdminService) UpdateCategoryHandler(c *gin.Context) {
+	categoryID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid category ID"})
+		return
+	}
+
+	var request categoryRequest
+	err = c.ShouldBindJSON(&request)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	category := DatabaseAbstraction.Category{IndexID: categoryID, Name: strings.TrimSpace(request.Name), Slug: request.Slug, ParentID: request.ParentID}
+	if !s.validCategory(c, category) {
+		return
+	}
+
+	err = s.DB.UpdateCategory(category)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(404, gin.H{"error": "Category not found"})
+		return
+	}
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, gin.H{"error": "Failed to update category"})
+		return
+	}
+
+	s.auditCatalog(c, AuditLog.ActionCategoryUpdated, map[string]interface{}{"category_id": category.IndexID, "slug": category.Slug, "parent_id": category.ParentID})
+	c.JSON(200, categoryResponse{category.IndexID, category.Name, category.Slug, category.ParentID})
+}
+
+// DeleteCategoryHandler godoc
+//
+//	@Summary		Delete a category
+//	@Description	Deletes the category with its subcategories, their products become uncategorized
+//	@Tags			Admin
+//	@Produce		json
+//	@Param			id	path		int	true	"Category ID"
+//	@Success		200	{object}	map[string]string
+//	@Failure		400	{object}	map[string]string
+//	@Failure		404	{object}	map[string]string
+//	@Failure		500	{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/api/admin/categories/{id} [delete]
+func (s AdminService) DeleteCategoryHandler(c *gin.Context) {
+	categoryID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid category ID"})
+		return
+	}
+
+	err = s.DB.DeleteCategory(categoryID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(404, gin.H{"error": "Category not found"})
+		return
+	}
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, gin.H{"error": "Failed to delete category"})
+		return
+	}
+
+	s.auditCatalog(c, AuditLog.ActionCategoryDeleted, map[string]interface{}{"category_id": categoryID})
+	c.JSON(200, gin.H{"message": "Category deleted"})
+}
+
+// SetProductCategoryHandler godoc
+//
+//	@Summary		Set the category of a product
+//	@Description	category_id 0 removes the product from its category
+//	@Tags			Admin
+//	@Accept			json
+//	@Produce		json
+//	@Param			id			path		int						true	"Product ID"
+//	@Param			category	body		productCategoryRequest	true	"Category"
+//	@Success		200			{object}	map[string]string
+//	@Failure		400			{object}	map[string]string
+//	@Failure		404			{object}	map[string]string
+//	@Failure		500			{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/api/admin/products/{id}/category [put]
+func (s AdminService) SetProductCategoryHandler(c *gin.Context) {
+	productID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid product ID"})
+		return
+	}
+
+	var request productCategoryRequest
+	err = c.ShouldBindJSON(&request)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	if request.CategoryID != 0 {
+		categories, err := s.DB.GetCategories()
+		if err != nil {
+			logrus.Error(err)
+			c.JSON(500, gin.H{"error": "Failed to get categories"})
+			return
+		}
+		found := false
+		for _, category := range categories {
+			found = found || category.IndexID == request.CategoryID
+		}
+		if !found {
+			c.JSON(400, gin.H{"error": "Category not found"})
+			return
+		}
+	}
+
+	err = s.DB.SetProductCategory(productID, request.CategoryID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(404, gin.H{"error": "Product not found"})
+		return
+	}
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, gin.H{"error": "Failed to set category"})
+		return
+	}
+
+	s.auditCatalog(c, AuditLog.ActionProductCategorized, map[string]interface{}{"product_id": productID, "category_id": request.CategoryID})
+	c.JSON(200, gin.H{"message": "Category set"})
+}
+
+// SetProductTagsHandler godoc
+//
+//	@Summary		Set the tags of a product
+//	@Description	Replaces all tags of the product, they are lowercased and deduplicated. An empty list removes all tags
+//	@Tags			Admin
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		int					true	"Product ID"
+//	@Param			tags	body		productTagsRequest	true	"Tags"
+//	@Success		200		{object}	productTagsResponse
+//	@Failure		400		{object}	map[string]string
+//	@Failure		404		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/api/admin/products/{id}/tags [put]
+func (s AdminService) SetProductTagsHandler(c *gin.Context) {
+	productID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid product ID"})
+		return
+	}
+
+	var request productTagsRequest
+	err = c.ShouldBindJSON(&request)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	tags, ok := normalizeTags(request.Tags)
+	if !ok {
+		c.JSON(400, gin.H{"error": "Tags must be 1 to 32 letters, digits or +#.- characters"})
+		return
+	}
+	if len(tags) > maxTagsPerProduct {
+		c.JSON(400, gin.H{"error": "A product can have at most 20 tags"})
+		return
+	}
+
+	_, err = s.DB.GetProductByIndexID(productID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(404, gin.H{"error": "Product not found"})
+		return
+	}
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, gin.H{"error": "Failed to get product"})
+		return
+	}
+
+	previous, err := s.DB.GetProductTags(productID)
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, gin.H{"error": "Failed to get tags"})
+		return
+	}
+
+	err = s.DB.SetProductTags(productID, tags)
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, gin.H{"error": "Failed to set tags"})
+		return
+	}
+
+	s.auditCatalog(c, AuditLog.ActionProductTagsReplaced, map[string]interface{}{"product_id": productID, "previous": previous, "tags": tags})
+	c.JSON(200, productTagsResponse{productID, tags})
+}
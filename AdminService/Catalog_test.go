@@ -0,0 +1,108 @@
+package AdminService_test
+
+import (
+	"EntitlementServer/AuditLog"
+	"EntitlementServer/DatabaseAbstraction"
+	"EntitlementServer/DatabaseAbstraction/mocks"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+var categories = []DatabaseAbstraction.Category{
+	{IndexID: 1, Name: "Programmierung", Slug: "programmierung"},
+	{IndexID: 2, Name: "Webentwicklung", Slug: "webentwicklung", ParentID: 1},
+	{IndexID: 3, Name: "Frontend", Slug: "frontend", ParentID: 2},
+}
+
+func TestCreateCategory(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	mockDB.On("GetCategories").Return(categories, nil)
+	mockDB.On("AddCategory", DatabaseAbstraction.Category{Name: "Backend", Slug: "backend", ParentID: 2}).Return(4, nil)
+	r, events := newAuditedRouter(mockDB, adminUser)
+
+	w := request(r, http.MethodPost, "/api/admin/categories", `{"name": " Backend ", "slug": "backend", "parent_id": 2}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Contains(t, w.Body.String(), `"id":4`)
+
+	w = request(r, http.MethodPost, "/api/admin/categories", `{"name": "Web", "slug": "webentwicklung"}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	w = request(r, http.MethodPost, "/api/admin/categories", `{"name": "Web", "slug": "Web Dev"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = request(r, http.MethodPost, "/api/admin/categories", `{"name": "Web", "slug": "web", "parent_id": 9}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	assert.Len(t, *events, 1)
+	assert.Equal(t, AuditLog.ActionCategoryCreated, (*events)[0].Action)
+	mockDB.AssertExpectations(t)
+}
+
+func TestUpdateCategoryRejectsCycles(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	mockDB.On("GetCategories").Return(categories, nil)
+	mockDB.On("UpdateCategory", DatabaseAbstraction.Category{IndexID: 3, Name: "Frontend", Slug: "frontend", ParentID: 1}).Return(nil)
+	r := newRouter(mockDB, adminUser)
+
+	// below its own subcategory and below itself
+	w := request(r, http.MethodPut, "/api/admin/categories/1", `{"name": "Programmierung", "slug": "programmierung", "parent_id": 3}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = request(r, http.MethodPut, "/api/admin/categories/2", `{"name": "Webentwicklung", "slug": "webentwicklung", "parent_id": 2}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// keeping its own slug is no conflict
+	w = request(r, http.MethodPut, "/api/admin/categories/3", `{"name": "Frontend", "slug": "frontend", "parent_id": 1}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	mockDB.AssertExpectations(t)
+}
+
+func TestDeleteCategory(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	mockDB.On("DeleteCategory", 2).Return(nil)
+	mockDB.On("DeleteCategory", 9).Return(pgx.ErrNoRows)
+	r := newRouter(mockDB, adminUser)
+
+	w := request(r, http.MethodDelete, "/api/admin/categories/2", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = request(r, http.MethodDelete, "/api/admin/categories/9", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestSetProductCategory(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	mockDB.On("GetCategories").Return(categories, nil)
+	mockDB.On("SetProductCategory", 5, 2).Return(nil)
+	mockDB.On("SetProductCategory", 5, 0).Return(nil)
+	mockDB.On("SetProductCategory", 99, 2).Return(pgx.ErrNoRows)
+	r := newRouter(mockDB, adminUser)
+
+	w := request(r, http.MethodPut, "/api/admin/products/5/category", `{"category_id": 2}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = request(r, http.MethodPut, "/api/admin/products/5/category", `{"category_id": 0}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = request(r, http.MethodPut, "/api/admin/products/5/category", `{"category_id": 8}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = request(r, http.MethodPut, "/api/admin/products/99/category", `{"category_id": 2}`)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	mockDB.AssertExpectations(t)
+}
+
+func TestSetProductTags(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	mockDB.On("GetProductByIndexID", 5).Return(DatabaseAbstraction.Product{IndexID: 5}, nil)
+	mockDB.On("GetProductTags", 5).Return([]string{"cpp"}, nil)
+	mockDB.On("SetProductTags", 5, []string{"c++", "oop"}).Return(nil)
+	r, events := newAuditedRouter(mockDB, adminUser)
+
+	w := request(r, http.MethodPut, "/api/admin/products/5/tags", `{"tags": ["OOP", " c++", "oop"]}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"tags":["c++","oop"]`)
+
+	w = request(r, http.MethodPut, "/api/admin/products/5/tags", `{"tags": ["<script>"]}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	assert.Len(t, *events, 1)
+	assert.Equal(t, AuditLog.ActionProductTagsReplaced, (*events)[0].Action)
+	assert.Equal(t, []string{"cpp"}, (*events)[0].Details["previous"])
+	mockDB.AssertExpectations(t)
+}
@@ -0,0 +1,51 @@
+package AdminService
+
+import (
+	"EntitlementServer/AuditLog"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/sirupsen/logrus"
+	"strconv"
+)
+
+// DeleteCommentHandler godoc
+//
+//	@Summary		Delete a comment
+//	@Description	Removes a comment from its product, the text is kept in the audit log
+//	@Tags			Admin
+//	@Produce		json
+//	@Param			id	path		int	true	"Comment ID"
+//	@Success		200	{object}	map[string]string
+//	@Failure		400	{object}	map[string]string
+//	@Failure		404	{object}	map[string]string
+//	@Failure		500	{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/api/admin/comments/{id} [delete]
+func (s AdminService) DeleteCommentHandler(c *gin.Context) {
+	commentID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid comment ID"})
+		return
+	}
+
+	comment, err := s.DB.DeleteComment(commentID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(404, gin.H{"error": "Comment not found"})
+		return
+	}
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, gin.H{"error": "Failed to delete comment"})
+		return
+	}
+
+	event := AuditLog.NewEvent(c, AuditLog.ActionCommentDeleted)
+	event.TargetUserID = comment.UserID
+	event.Details["comment_id"] = comment.IndexID
+	event.Details["product_id"] = comment.ProductID
+	event.Details["comment"] = comment.Comment
+	AuditLog.OrDefault(s.Audit).Record(event)
+
+	c.JSON(200, gin.H{"message": "Comment deleted"})
+}
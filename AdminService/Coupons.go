@@ -0,0 +1,209 @@
+package AdminService
+
+import (
+	"EntitlementServer/AuditLog"
+	"EntitlementServer/DatabaseAbstraction"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/sirupsen/logrus"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var couponCodePattern = regexp.MustCompile(`^[A-Z0-9_-]{3,32}$`)
+
+type couponRequest struct {
+	Code           string     `json:"code"`
+	Kind           string     `json:"kind"`  // percent or amount
+	Value          int        `json:"value"` // percent or amount off
+	ProductID      int        `json:"product_id,omitempty"`
+	MaxUses        int        `json:"max_uses,omitempty"`
+	MaxUsesPerUser int        `json:"max_uses_per_user,omitempty"`
+	ValidFrom      *time.Time `json:"valid_from,omitempty"`
+	ValidUntil     *time.Time `json:"valid_until,omitempty"`
+}
+
+type couponResponse struct {
+	ID             int        `json:"id"`
+	Code           string     `json:"code"`
+	Kind           string     `json:"kind"`
+	Value          int        `json:"value"`
+	ProductID      int        `json:"product_id,omitempty"`
+	MaxUses        int        `json:"max_uses,omitempty"`
+	MaxUsesPerUser int        `json:"max_uses_per_user,omitempty"`
+	Uses           int        `json:"uses"`
+	ValidFrom      *time.Time `json:"valid_from,omitempty"`
+	ValidUntil     *time.Time `json:"valid_until,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+func newCouponResponse(coupon DatabaseAbstraction.Coupon) couponResponse {
+	return couponResponse{coupon.IndexID, coupon.Code, coupon.Kind, coupon.Value, coupon.ProductID, coupon.MaxUses, coupon.MaxUsesPerUser,
+		coupon.Uses, coupon.ValidFrom, coupon.ValidUntil, coupon.CreatedAt}
+}
+
+// validCoupon checks a new coupon, it responds with an error and returns false if it is invalid
+func (s AdminService) validCoupon(c *gin.Context, coupon DatabaseAbstraction.Coupon) bool {
+	if !couponCodePattern.MatchString(coupon.Code) {
+		c.JSON(400, gin.H{"error": "The code must be 3 to 32 letters, digits, dashes or underscores"})
+		return false
+	}
+	switch {
+	case coupon.Kind == DatabaseAbstraction.CouponPercent && (coupon.Value < 1 || coupon.Value > 100):
+		c.JSON(400, gin.H{"error": "A percent coupon takes 1 to 100 percent off"})
+		return false
+	case coupon.Kind == DatabaseAbstraction.CouponAmount && coupon.Value < 1:
+		c.JSON(400, gin.H{"error": "An amount coupon needs a positive value"})
+		return false
+	case coupon.Kind != DatabaseAbstraction.CouponPercent && coupon.Kind != DatabaseAbstraction.CouponAmount:
+		c.JSON(400, gin.H{"error": "The kind must be percent or amount"})
+		return false
+	}
+	if coupon.MaxUses < 0 || coupon.MaxUsesPerUser < 0 {
+		c.JSON(400, gin.H{"error": "Usage limits can't be negative"})
+		return false
+	}
+	if coupon.ValidFrom != nil && coupon.ValidUntil != nil && !coupon.ValidUntil.After(*coupon.ValidFrom) {
+		c.JSON(400, gin.H{"error": "valid_until has to be after valid_from"})
+		return false
+	}
+
+	if coupon.ProductID != 0 {
+		_, err := s.DB.GetProductByIndexID(coupon.ProductID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			c.JSON(400, gin.H{"error": "Product not found"})
+			return false
+		}
+		if err != nil {
+			logrus.Error(err)
+			c.JSON(500, gin.H{"error": "Failed to get product"})
+			return false
+		}
+	}
+
+	_, err := s.DB.GetCouponByCode(coupon.Code)
+	if err == nil {
+		c.JSON(409, gin.H{"error": "The code is already used by another coupon"})
+		return false
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		logrus.Error(err)
+		c.JSON(500, gin.H{"error": "Failed to get coupon"})
+		return false
+	}
+
+	return true
+}
+
+// ListCouponsHandler godoc
+//
+//	@Summary		List coupons
+//	@Description	All coupons with their uses, newest first
+//	@Tags			Admin
+//	@Produce		json
+//	@Success		200	{object}	[]couponResponse
+//	@Failure		500	{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/api/admin/coupons [get]
+func (s AdminService) ListCouponsHandler(c *gin.Context) {
+	coupons, err := s.DB.GetCoupons()
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, gin.H{"error": "Failed to get coupons"})
+		return
+	}
+
+	response := []couponResponse{}
+	for _, coupon := range coupons {
+		response = append(response, newCouponResponse(coupon))
+	}
+
+	c.JSON(200, response)
+}
+
+// CreateCouponHandler godoc
+//
+//	@Summary		Create a coupon
+//	@Description	Codes are case-insensitive. Without product_id the coupon applies to every product,
+//	@Description	without limits it can be used any number of times and without valid_from and valid_until it never expires
+//	@Tags			Admin
+//	@Accept			json
+//	@Produce		json
+//	@Param			coupon	body		couponRequest	true	"Coupon"
+//	@Success		201		{object}	couponResponse
+//	@Failure		400		{object}	map[string]string
+//	@Failure		409		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/api/admin/coupons [post]
+func (s AdminService) CreateCouponHandler(c *gin.Context) {
+	var request couponRequest
+	err := c.ShouldBindJSON(&request)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	coupon := DatabaseAbstraction.Coupon{
+		Code:           strings.ToUpper(strings.TrimSpace(request.Code)),
+		Kind:           request.Kind,
+		Value:          request.Value,
+		ProductID:      request.ProductID,
+		MaxUses:        request.MaxUses,
+		MaxUsesPerUser: request.MaxUsesPerUser,
+		ValidFrom:      request.ValidFrom,
+		ValidUntil:     request.ValidUntil,
+	}
+	if !s.validCoupon(c, coupon) {
+		return
+	}
+
+	coupon.IndexID, err = s.DB.AddCoupon(coupon)
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, gin.H{"error": "Failed to create coupon"})
+		return
+	}
+	coupon.CreatedAt = time.Now()
+
+	s.auditCatalog(c, AuditLog.ActionCouponCreated, map[string]interface{}{"coupon_id": coupon.IndexID, "code": coupon.Code, "kind": coupon.Kind, "value": coupon.Value})
+	c.JSON(201, newCouponResponse(coupon))
+}
+
+// DisableCouponHandler godoc
+//
+//	@Summary		Disable a coupon
+//	@Description	Ends the validity of the coupon now, purchases made with it keep referring to it
+//	@Tags			Admin
+//	@Produce		json
+//	@Param			id	path		int	true	"Coupon ID"
+//	@Success		200	{object}	map[string]string
+//	@Failure		400	{object}	map[string]string
+//	@Failure		404	{object}	map[string]string
+//	@Failure		500	{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/api/admin/coupons/{id} [delete]
+func (s AdminService) DisableCouponHandler(c *gin.Context) {
+	couponID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid coupon ID"})
+		return
+	}
+
+	err = s.DB.DisableCoupon(couponID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(404, gin.H{"error": "Coupon not found"})
+		return
+	}
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, gin.H{"error": "Failed to disable coupon"})
+		return
+	}
+
+	s.auditCatalog(c, AuditLog.ActionCouponDisabled, map[string]interface{}{"coupon_id": couponID})
+	c.JSON(200, gin.H{"message": "Coupon disabled"})
+}
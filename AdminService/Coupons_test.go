@@ -0,0 +1,54 @@
+package AdminService_test
+
+import (
+	"EntitlementServer/AuditLog"
+	"EntitlementServer/DatabaseAbstraction"
+	"EntitlementServer/DatabaseAbstraction/mocks"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+func TestCreateCoupon(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	mockDB.On("GetCouponByCode", "SOMMER25").Return(DatabaseAbstraction.Coupon{}, pgx.ErrNoRows)
+	mockDB.On("GetCouponByCode", "WILLKOMMEN10").Return(DatabaseAbstraction.Coupon{IndexID: 1}, nil)
+	mockDB.On("GetProductByIndexID", 2).Return(DatabaseAbstraction.Product{IndexID: 2}, nil)
+	mockDB.On("AddCoupon", DatabaseAbstraction.Coupon{Code: "SOMMER25", Kind: DatabaseAbstraction.CouponPercent, Value: 25, ProductID: 2, MaxUses: 100}).Return(3, nil)
+	r, events := newAuditedRouter(mockDB, adminUser)
+
+	w := request(r, http.MethodPost, "/api/admin/coupons", `{"code": "sommer25", "kind": "percent", "value": 25, "product_id": 2, "max_uses": 100}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Contains(t, w.Body.String(), `"code":"SOMMER25"`)
+
+	w = request(r, http.MethodPost, "/api/admin/coupons", `{"code": "WILLKOMMEN10", "kind": "amount", "value": 100}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	for _, body := range []string{
+		`{"code": "X", "kind": "percent", "value": 10}`,
+		`{"code": "ZUVIEL", "kind": "percent", "value": 150}`,
+		`{"code": "NICHTS", "kind": "amount", "value": 0}`,
+		`{"code": "GRATIS", "kind": "free", "value": 1}`,
+		`{"code": "RUECKWAERTS", "kind": "amount", "value": 1, "valid_from": "2024-06-01T00:00:00Z", "valid_until": "2024-05-01T00:00:00Z"}`,
+	} {
+		w = request(r, http.MethodPost, "/api/admin/coupons", body)
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+	}
+
+	assert.Len(t, *events, 1)
+	assert.Equal(t, AuditLog.ActionCouponCreated, (*events)[0].Action)
+	mockDB.AssertExpectations(t)
+}
+
+func TestDisableCoupon(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	mockDB.On("DisableCoupon", 1).Return(nil)
+	mockDB.On("DisableCoupon", 9).Return(pgx.ErrNoRows)
+	r := newRouter(mockDB, adminUser)
+
+	w := request(r, http.MethodDelete, "/api/admin/coupons/1", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = request(r, http.MethodDelete, "/api/admin/coupons/9", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,244 @@
+package AdminService
+
+import (
+	"EntitlementServer/AuditLog"
+	"EntitlementServer/DatabaseAbstraction"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/sirupsen/logrus"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	maxGeneratedLicenseKeys = 500
+	maxLicenseKeyLabel      = 100
+)
+
+type licenseKeyResponse struct {
+	ID          int        `json:"id"`
+	Key         string     `json:"key"`
+	ProductID   int        `json:"product_id"`
+	ProductName string     `json:"product_name"`
+	CreatedBy   int        `json:"created_by,omitempty"`
+	Label       string     `json:"label,omitempty"`
+	Price       int        `json:"price"`
+	Status      string     `json:"status"`
+	RedeemedBy  int        `json:"redeemed_by,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	RedeemedAt  *time.Time `json:"redeemed_at,omitempty"`
+	RevokedAt   *time.Time `json:"revoked_at,omitempty"`
+}
+
+func newLicenseKeyResponse(key DatabaseAbstraction.LicenseKey) licenseKeyResponse {
+	return licenseKeyResponse{key.IndexID, key.Code, key.ProductID, key.ProductName, key.CreatedBy, key.Label, key.Price, key.Status,
+		key.RedeemedBy, key.CreatedAt, key.RedeemedAt, key.RevokedAt}
+}
+
+type licenseKeyListResponse struct {
+	Keys    []licenseKeyResponse `json:"keys"`
+	Total   int                  `json:"total"`
+	Page    int                  `json:"page"`
+	PerPage int                  `json:"per_page"`
+}
+
+type generateLicenseKeysRequest struct {
+	Quantity int    `json:"quantity"`
+	Label    string `json:"label"`
+}
+
+// licenseKeyFilter reads the filter query parameters, it responds with an error and returns false if one is invalid
+func licenseKeyFilter(c *gin.Context) (DatabaseAbstraction.LicenseKeyFilter, bool) {
+	filter := DatabaseAbstraction.LicenseKeyFilter{Status: c.Query("status"), Label: c.Query("label")}
+
+	switch filter.Status {
+	case "", DatabaseAbstraction.LicenseKeyUnused, DatabaseAbstraction.LicenseKeyRedeemed, DatabaseAbstraction.LicenseKeyRevoked:
+	default:
+		c.JSON(400, gin.H{"error": "Invalid status"})
+		return filter, false
+	}
+
+	for param, target := range map[string]*int{"product_id": &filter.ProductID, "created_by": &filter.CreatedBy} {
+		if c.Query(param) == "" {
+			continue
+		}
+		id, err := strconv.Atoi(c.Query(param))
+		if err != nil || id < 1 {
+			c.JSON(400, gin.H{"error": "Invalid " + param})
+			return filter, false
+		}
+		*target = id
+	}
+
+	return filter, true
+}
+
+// ListLicenseKeysHandler godoc
+//
+//	@Summary		List license keys
+//	@Description	Bought and generated license keys, newest first
+//	@Tags			Admin
+//	@Produce		json
+//	@Param			product_id	query		int		false	"Product of the keys"
+//	@Param			created_by	query		int		false	"User who bought or generated the keys"
+//	@Param			status		query		string	false	"unused, redeemed or revoked"
+//	@Param			label		query		string	false	"Label of the batch"
+//	@Param			page		query		int		false	"Page, starting at 1"
+//	@Param			per_page	query		int		false	"Keys per page, at most 100"
+//	@Success		200			{object}	licenseKeyListResponse
+//	@Failure		400			{object}	map[string]string
+//	@Failure		500			{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/api/admin/keys [get]
+func (s AdminService) ListLicenseKeysHandler(c *gin.Context) {
+	filter, ok := licenseKeyFilter(c)
+	if !ok {
+		return
+	}
+
+	page, perPage := pagination(c)
+	keys, total, err := s.DB.GetLicenseKeys(filter, perPage, (page-1)*perPage)
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, gin.H{"error": "Failed to get license keys"})
+		return
+	}
+
+	response := licenseKeyListResponse{Keys: []licenseKeyResponse{}, Total: total, Page: page, PerPage: perPage}
+	for _, key := range keys {
+		response.Keys = append(response.Keys, newLicenseKeyResponse(key))
+	}
+
+	c.JSON(200, response)
+}
+
+// GenerateLicenseKeysHandler godoc
+//
+//	@Summary		Generate license keys
+//	@Description	Creates up to 500 free keys for a product, e.g. for a school class. The label groups them in the list
+//	@Tags			Admin
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		int							true	"Product ID"
+//	@Param			keys	body		generateLicenseKeysRequest	true	"Quantity and label"
+//	@Success		201		{object}	[]licenseKeyResponse
+//	@Failure		400		{object}	map[string]string
+//	@Failure		404		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/api/admin/products/{id}/keys [post]
+func (s AdminService) GenerateLicenseKeysHandler(c *gin.Context) {
+	productID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid product ID"})
+		return
+	}
+
+	var request generateLicenseKeysRequest
+	err = c.ShouldBindJSON(&request)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request"})
+		return
+	}
+	request.Label = strings.TrimSpace(request.Label)
+	if request.Quantity < 1 || request.Quantity > maxGeneratedLicenseKeys {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("The quantity must be between 1 and %d", maxGeneratedLicenseKeys)})
+		return
+	}
+	if utf8.RuneCountInString(request.Label) > maxLicenseKeyLabel {
+		c.JSON(400, gin.H{"error": "The label is too long"})
+		return
+	}
+
+	_, err = s.DB.GetProductByIndexID(productID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(404, gin.H{"error": "Product not found"})
+		return
+	}
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, gin.H{"error": "Failed to get product"})
+		return
+	}
+
+	admin := c.MustGet("user").(DatabaseAbstraction.User)
+	keys, err := s.DB.CreateLicenseKeys(DatabaseAbstraction.LicenseKeyBatch{
+		ProductID: productID,
+		CreatedBy: admin.IndexID,
+		Quantity:  request.Quantity,
+		Label:     request.Label,
+	})
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, gin.H{"error": "Failed to generate license keys"})
+		return
+	}
+
+	s.auditCatalog(c, AuditLog.ActionLicenseKeysGenerated, map[string]interface{}{
+		"product_id": productID,
+		"quantity":   len(keys),
+		"label":      request.Label,
+	})
+
+	response := make([]licenseKeyResponse, len(keys))
+	for i, key := range keys {
+		response[i] = newLicenseKeyResponse(key)
+	}
+	c.JSON(201, response)
+}
+
+// RevokeLicenseKeyHandler godoc
+//
+//	@Summary		Revoke a license key
+//	@Description	An unused key can't be redeemed anymore, a redeemed key takes the product away from the user who redeemed it
+//	@Description	The price of bought keys is not refunded
+//	@Tags			Admin
+//	@Produce		json
+//	@Param			id	path		int	true	"License key ID"
+//	@Success		200	{object}	map[string]string
+//	@Failure		404	{object}	map[string]string
+//	@Failure		409	{object}	map[string]string
+//	@Failure		500	{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/api/admin/keys/{id} [delete]
+func (s AdminService) RevokeLicenseKeyHandler(c *gin.Context) {
+	keyID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid license key ID"})
+		return
+	}
+
+	key, err := s.DB.GetLicenseKeyByIndexID(keyID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(404, gin.H{"error": "License key not found"})
+		return
+	}
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, gin.H{"error": "Failed to get license key"})
+		return
+	}
+
+	err = s.DB.RevokeLicenseKey(key.IndexID)
+	if errors.Is(err, DatabaseAbstraction.ErrLicenseKeyUnavailable) {
+		c.JSON(409, gin.H{"error": "The license key has already been revoked"})
+		return
+	}
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, gin.H{"error": "Failed to revoke license key"})
+		return
+	}
+
+	// the user who redeemed the key loses the product, for unused keys there is nobody yet
+	s.audit(c, AuditLog.ActionLicenseKeyRevoked, DatabaseAbstraction.User{IndexID: key.RedeemedBy}, map[string]interface{}{
+		"license_key_id": key.IndexID,
+		"product_id":     key.ProductID,
+		"status":         key.Status,
+	})
+	c.JSON(200, gin.H{"message": "License key revoked"})
+}
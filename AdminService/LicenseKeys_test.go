@@ -0,0 +1,71 @@
+package AdminService_test
+
+import (
+	"EntitlementServer/AuditLog"
+	"EntitlementServer/DatabaseAbstraction"
+	"EntitlementServer/DatabaseAbstraction/mocks"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+func TestGenerateLicenseKeys(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	mockDB.On("GetProductByIndexID", 2).Return(DatabaseAbstraction.Product{IndexID: 2}, nil)
+	mockDB.On("GetProductByIndexID", 9).Return(DatabaseAbstraction.Product{}, pgx.ErrNoRows)
+	mockDB.On("CreateLicenseKeys", DatabaseAbstraction.LicenseKeyBatch{ProductID: 2, CreatedBy: adminUser.IndexID, Quantity: 2, Label: "Klasse 7b"}).
+		Return([]DatabaseAbstraction.LicenseKey{{IndexID: 1, Code: "AAAAA-BBBBB-CCCCC-DDDDD"}, {IndexID: 2, Code: "EEEEE-FFFFF-GGGGG-HHHHH"}}, nil)
+	r, events := newAuditedRouter(mockDB, adminUser)
+
+	w := request(r, http.MethodPost, "/api/admin/products/2/keys", `{"quantity": 2, "label": " Klasse 7b "}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Contains(t, w.Body.String(), `"key":"EEEEE-FFFFF-GGGGG-HHHHH"`)
+
+	w = request(r, http.MethodPost, "/api/admin/products/9/keys", `{"quantity": 2}`)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	for _, body := range []string{`{"quantity": 0}`, `{"quantity": 501}`} {
+		w = request(r, http.MethodPost, "/api/admin/products/2/keys", body)
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+	}
+
+	assert.Len(t, *events, 1)
+	assert.Equal(t, AuditLog.ActionLicenseKeysGenerated, (*events)[0].Action)
+	assert.Equal(t, 2, (*events)[0].Details["quantity"])
+}
+
+func TestRevokeLicenseKey(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	mockDB.On("GetLicenseKeyByIndexID", 1).Return(DatabaseAbstraction.LicenseKey{IndexID: 1, ProductID: 2, Status: DatabaseAbstraction.LicenseKeyRedeemed, RedeemedBy: 2}, nil)
+	mockDB.On("GetLicenseKeyByIndexID", 9).Return(DatabaseAbstraction.LicenseKey{}, pgx.ErrNoRows)
+	mockDB.On("RevokeLicenseKey", 1).Return(nil).Once()
+	mockDB.On("RevokeLicenseKey", 1).Return(DatabaseAbstraction.ErrLicenseKeyUnavailable)
+	r, events := newAuditedRouter(mockDB, adminUser)
+
+	w := request(r, http.MethodDelete, "/api/admin/keys/1", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = request(r, http.MethodDelete, "/api/admin/keys/1", "")
+	assert.Equal(t, http.StatusConflict, w.Code)
+	w = request(r, http.MethodDelete, "/api/admin/keys/9", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// the user who redeemed the key lost the product
+	assert.Len(t, *events, 1)
+	assert.Equal(t, 2, (*events)[0].TargetUserID)
+}
+
+func TestListLicenseKeys(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	mockDB.On("GetLicenseKeys", DatabaseAbstraction.LicenseKeyFilter{ProductID: 2, Status: DatabaseAbstraction.LicenseKeyUnused, Label: "7b"}, 20, 0).
+		Return([]DatabaseAbstraction.LicenseKey{{IndexID: 1, ProductID: 2}}, 1, nil)
+	r := newRouter(mockDB, adminUser)
+
+	w := request(r, http.MethodGet, "/api/admin/keys?product_id=2&status=unused&label=7b", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"total":1`)
+
+	for _, query := range []string{"status=lost", "product_id=x", "created_by=0"} {
+		w = request(r, http.MethodGet, "/api/admin/keys?"+query, "")
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+	}
+}
@@ -0,0 +1,199 @@
+package AdminService
+
+import (
+	"EntitlementServer/AuditLog"
+	"EntitlementServer/DatabaseAbstraction"
+	"EntitlementServer/ProductService"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/sirupsen/logrus"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+type refundResponse struct {
+	ID             int        `json:"id"`
+	UserID         int        `json:"user_id"`
+	PurchaseID     int        `json:"purchase_id,omitempty"`
+	ProductID      int        `json:"product_id"`
+	ProductName    string     `json:"product_name"`
+	Amount         int        `json:"amount"`
+	Reason         string     `json:"reason"`
+	WatchedPercent int        `json:"watched_percent"`
+	Status         string     `json:"status"`
+	DecidedBy      int        `json:"decided_by,omitempty"`
+	DecisionNote   string     `json:"decision_note,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	DecidedAt      *time.Time `json:"decided_at,omitempty"`
+}
+
+type refundListResponse struct {
+	Refunds []refundResponse `json:"refunds"`
+	Total   int              `json:"total"`
+	Page    int              `json:"page"`
+	PerPage int              `json:"per_page"`
+}
+
+type refundDecisionRequest struct {
+	Note string `json:"note"` // shown to the user
+}
+
+// ListRefundsHandler godoc
+//
+//	@Summary		List refunds
+//	@Description	Refund requests, oldest first so pending ones can be handled in order
+//	@Tags			Admin
+//	@Produce		json
+//	@Param			status		query		string	false	"pending, approved or rejected"
+//	@Param			page		query		int		false	"Page, starting at 1"
+//	@Param			per_page	query		int		false	"Refunds per page, at most 100"
+//	@Success		200			{object}	refundListResponse
+//	@Failure		400			{object}	map[string]string
+//	@Failure		500			{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/api/admin/refunds [get]
+func (s AdminService) ListRefundsHandler(c *gin.Context) {
+	status := c.Query("status")
+	switch status {
+	case "", DatabaseAbstraction.RefundPending, DatabaseAbstraction.RefundApproved, DatabaseAbstraction.RefundRejected:
+	default:
+		c.JSON(400, gin.H{"error": "Invalid status"})
+		return
+	}
+
+	page, perPage := pagination(c)
+	refunds, total, err := s.DB.GetRefunds(status, perPage, (page-1)*perPage)
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, gin.H{"error": "Failed to get refunds"})
+		return
+	}
+
+	response := refundListResponse{Refunds: []refundResponse{}, Total: total, Page: page, PerPage: perPage}
+	for _, refund := range refunds {
+		response.Refunds = append(response.Refunds, refundResponse{refund.IndexID, refund.UserID, refund.PurchaseID, refund.ProductID, refund.ProductName,
+			refund.Amount, refund.Reason, refund.WatchedPercent, refund.Status, refund.DecidedBy, refund.DecisionNote, refund.CreatedAt, refund.DecidedAt})
+	}
+
+	c.JSON(200, response)
+}
+
+// refundPolicy returns the configured policy, a zero value AdminService uses the defaults
+func (s AdminService) refundPolicy() ProductService.RefundPolicy {
+	if s.Refunds == nil {
+		return ProductService.DefaultRefundPolicy
+	}
+	return *s.Refunds
+}
+
+// decideRefund approves or rejects a pending refund and records the decision
+func (s AdminService) decideRefund(c *gin.Context, approve bool) {
+	refundID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid refund ID"})
+		return
+	}
+
+	var request refundDecisionRequest
+	err = c.ShouldBindJSON(&request)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request"})
+		return
+	}
+	if utf8.RuneCountInString(request.Note) > maxReasonLength {
+		c.JSON(400, gin.H{"error": "The note is too long"})
+		return
+	}
+
+	refund, err := s.DB.GetRefundByIndexID(refundID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(404, gin.H{"error": "Refund not found"})
+		return
+	}
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, gin.H{"error": "Failed to get refund"})
+		return
+	}
+
+	admin := c.MustGet("user").(DatabaseAbstraction.User)
+	action, message := AuditLog.ActionRefundRejected, "Refund rejected"
+	if approve {
+		action, message = AuditLog.ActionRefundApproved, "Refund approved"
+		err = s.DB.ApproveRefund(refund.IndexID, admin.IndexID, request.Note, s.refundPolicy().MaxWatchedPercent)
+	} else {
+		err = s.DB.RejectRefund(refund.IndexID, admin.IndexID, request.Note)
+	}
+	if errors.Is(err, DatabaseAbstraction.ErrRefundTooMuchWatched) {
+		s.audit(c, AuditLog.ActionRefundRejected, DatabaseAbstraction.User{IndexID: refund.UserID}, map[string]interface{}{
+			"refund_id":   refund.IndexID,
+			"purchase_id": refund.PurchaseID,
+			"product_id":  refund.ProductID,
+			"amount":      refund.Amount,
+			"reason":      "watched",
+		})
+		c.JSON(409, gin.H{"error": "The user watched too much of the product while the refund was pending, it was rejected"})
+		return
+	}
+	if errors.Is(err, DatabaseAbstraction.ErrRefundDecided) {
+		c.JSON(409, gin.H{"error": "The refund has already been decided"})
+		return
+	}
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, gin.H{"error": "Failed to decide refund"})
+		return
+	}
+
+	s.audit(c, action, DatabaseAbstraction.User{IndexID: refund.UserID}, map[string]interface{}{
+		"refund_id":   refund.IndexID,
+		"purchase_id": refund.PurchaseID,
+		"product_id":  refund.ProductID,
+		"amount":      refund.Amount,
+		"note":        request.Note,
+	})
+	c.JSON(200, gin.H{"message": message})
+}
+
+// ApproveRefundHandler godoc
+//
+//	@Summary		Approve a refund
+//	@Description	Credits the amount to the user's balance, gives back the coupon use and revokes the product
+//	@Description	If the user watched more than the refund policy allows while the refund was pending, it is rejected with 409 instead
+//	@Tags			Admin
+//	@Accept			json
+//	@Produce		json
+//	@Param			id			path		int						true	"Refund ID"
+//	@Param			decision	body		refundDecisionRequest	true	"Note for the user"
+//	@Success		200			{object}	map[string]string
+//	@Failure		400			{object}	map[string]string
+//	@Failure		404			{object}	map[string]string
+//	@Failure		409			{object}	map[string]string
+//	@Failure		500			{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/api/admin/refunds/{id}/approve [post]
+func (s AdminService) ApproveRefundHandler(c *gin.Context) {
+	s.decideRefund(c, true)
+}
+
+// RejectRefundHandler godoc
+//
+//	@Summary		Reject a refund
+//	@Description	The user keeps the product and can't request a refund for the purchase again
+//	@Tags			Admin
+//	@Accept			json
+//	@Produce		json
+//	@Param			id			path		int						true	"Refund ID"
+//	@Param			decision	body		refundDecisionRequest	true	"Note for the user"
+//	@Success		200			{object}	map[string]string
+//	@Failure		400			{object}	map[string]string
+//	@Failure		404			{object}	map[string]string
+//	@Failure		409			{object}	map[string]string
+//	@Failure		500			{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/api/admin/refunds/{id}/reject [post]
+func (s AdminService) RejectRefundHandler(c *gin.Context) {
+	s.decideRefund(c, false)
+}
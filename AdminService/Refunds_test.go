@@ -0,0 +1,84 @@
+package AdminService_test
+
+import (
+	"EntitlementServer/AdminService"
+	"EntitlementServer/AuditLog"
+	"EntitlementServer/DatabaseAbstraction"
+	"EntitlementServer/DatabaseAbstraction/mocks"
+	"EntitlementServer/ProductService"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+var pendingRefund = DatabaseAbstraction.Refund{IndexID: 4, UserID: 2, PurchaseID: 1, ProductID: 2, Amount: 1800, Status: DatabaseAbstraction.RefundPending}
+
+func TestApproveRefund(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	mockDB.On("GetRefundByIndexID", 4).Return(pendingRefund, nil)
+	mockDB.On("GetRefundByIndexID", 9).Return(DatabaseAbstraction.Refund{}, pgx.ErrNoRows)
+	mockDB.On("ApproveRefund", 4, adminUser.IndexID, "Kulanz", ProductService.DefaultRefundPolicy.MaxWatchedPercent).Return(nil).Once()
+	mockDB.On("ApproveRefund", 4, adminUser.IndexID, "", ProductService.DefaultRefundPolicy.MaxWatchedPercent).Return(DatabaseAbstraction.ErrRefundDecided)
+	r, events := newAuditedRouter(mockDB, adminUser)
+
+	w := request(r, http.MethodPost, "/api/admin/refunds/4/approve", `{"note": "Kulanz"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = request(r, http.MethodPost, "/api/admin/refunds/4/approve", `{}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	w = request(r, http.MethodPost, "/api/admin/refunds/9/approve", `{}`)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// only the decision is audited, the refund belongs to the user who asked for it
+	assert.Len(t, *events, 1)
+	assert.Equal(t, AuditLog.ActionRefundApproved, (*events)[0].Action)
+	assert.Equal(t, 2, (*events)[0].TargetUserID)
+	assert.Equal(t, 1800, (*events)[0].Details["amount"])
+	mockDB.AssertExpectations(t)
+}
+
+func TestApproveRefundChecksWatchedAgain(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	mockDB.On("GetRefundByIndexID", 4).Return(pendingRefund, nil)
+	// the user kept watching after requesting the refund
+	mockDB.On("ApproveRefund", 4, adminUser.IndexID, "", 50).Return(DatabaseAbstraction.ErrRefundTooMuchWatched)
+	events := &[]DatabaseAbstraction.AuditEvent{}
+	svc := AdminService.AdminService{DB: mockDB, Audit: recordingAuditor{events}, Refunds: &ProductService.RefundPolicy{MaxWatchedPercent: 50}}
+	r := gin.New()
+	svc.RegisterHandlers(r, func(c *gin.Context) {
+		c.Set("user", adminUser)
+	})
+
+	w := request(r, http.MethodPost, "/api/admin/refunds/4/approve", `{}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Len(t, *events, 1)
+	assert.Equal(t, AuditLog.ActionRefundRejected, (*events)[0].Action)
+	mockDB.AssertExpectations(t)
+}
+
+func TestRejectRefund(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	mockDB.On("GetRefundByIndexID", 4).Return(pendingRefund, nil)
+	mockDB.On("RejectRefund", 4, adminUser.IndexID, "Kurs zur Hälfte gesehen").Return(nil)
+	r, events := newAuditedRouter(mockDB, adminUser)
+
+	w := request(r, http.MethodPost, "/api/admin/refunds/4/reject", `{"note": "Kurs zur Hälfte gesehen"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Len(t, *events, 1)
+	assert.Equal(t, AuditLog.ActionRefundRejected, (*events)[0].Action)
+	mockDB.AssertNotCalled(t, "ApproveRefund")
+}
+
+func TestListRefunds(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	mockDB.On("GetRefunds", DatabaseAbstraction.RefundPending, 20, 0).Return([]DatabaseAbstraction.Refund{pendingRefund}, 1, nil)
+	r := newRouter(mockDB, adminUser)
+
+	w := request(r, http.MethodGet, "/api/admin/refunds?status=pending", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"amount":1800`)
+
+	w = request(r, http.MethodGet, "/api/admin/refunds?status=lost", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -0,0 +1,273 @@
+package AdminService
+
+import (
+	"EntitlementServer/AuditLog"
+	"EntitlementServer/DatabaseAbstraction"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/sirupsen/logrus"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	maxPlanNameLength = 100
+	maxPeriodDays     = 366
+)
+
+type planResponse struct {
+	ID          int       `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Price       int       `json:"price"`
+	PeriodDays  int       `json:"period_days"`
+	TrialDays   int       `json:"trial_days"`
+	Active      bool      `json:"active"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+func newPlanResponse(plan DatabaseAbstraction.SubscriptionPlan) planResponse {
+	return planResponse{plan.IndexID, plan.Name, plan.Description, plan.Price, plan.PeriodDays, plan.TrialDays, plan.Active, plan.CreatedAt}
+}
+
+type createPlanRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Price       int    `json:"price"`
+	PeriodDays  int    `json:"period_days"`
+	TrialDays   int    `json:"trial_days"`
+}
+
+type rentalOfferRequest struct {
+	Price int `json:"price"`
+	Days  int `json:"days"`
+}
+
+type rentalOfferResponse struct {
+	ProductID int `json:"product_id"`
+	Price     int `json:"price"`
+	Days      int `json:"days"`
+}
+
+// ListPlansHandler godoc
+//
+//	@Summary		List subscription plans
+//	@Description	All plans including deactivated ones, cheapest first
+//	@Tags			Admin
+//	@Produce		json
+//	@Success		200	{object}	[]planResponse
+//	@Failure		500	{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/api/admin/plans [get]
+func (s AdminService) ListPlansHandler(c *gin.Context) {
+	plans, err := s.DB.GetSubscriptionPlans(true)
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, gin.H{"error": "Failed to get plans"})
+		return
+	}
+
+	response := make([]planResponse, len(plans))
+	for i, plan := range plans {
+		response[i] = newPlanResponse(plan)
+	}
+
+	c.JSON(200, response)
+}
+
+// CreatePlanHandler godoc
+//
+//	@Summary		Create a subscription plan
+//	@Description	A plan unlocks the whole catalog for period_days and renews from the balance. trial_days gives new subscribers a free start
+//	@Description	Plans can't be changed afterwards, deactivate the plan and create a new one instead
+//	@Tags			Admin
+//	@Accept			json
+//	@Produce		json
+//	@Param			plan	body		createPlanRequest	true	"Plan"
+//	@Success		201		{object}	planResponse
+//	@Failure		400		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/api/admin/plans [post]
+func (s AdminService) CreatePlanHandler(c *gin.Context) {
+	var request createPlanRequest
+	err := c.ShouldBindJSON(&request)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request"})
+		return
+	}
+	request.Name = strings.TrimSpace(request.Name)
+	if request.Name == "" || utf8.RuneCountInString(request.Name) > maxPlanNameLength {
+		c.JSON(400, gin.H{"error": "Invalid name"})
+		return
+	}
+	if request.Price < 0 {
+		c.JSON(400, gin.H{"error": "The price can't be negative"})
+		return
+	}
+	if request.PeriodDays < 1 || request.PeriodDays > maxPeriodDays || request.TrialDays < 0 || request.TrialDays > maxPeriodDays {
+		c.JSON(400, gin.H{"error": "Invalid period or trial days"})
+		return
+	}
+
+	plan := DatabaseAbstraction.SubscriptionPlan{
+		Name:        request.Name,
+		Description: request.Description,
+		Price:       request.Price,
+		PeriodDays:  request.PeriodDays,
+		TrialDays:   request.TrialDays,
+		Active:      true,
+		CreatedAt:   time.Now(),
+	}
+	plan.IndexID, err = s.DB.AddSubscriptionPlan(plan)
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, gin.H{"error": "Failed to create plan"})
+		return
+	}
+
+	s.auditCatalog(c, AuditLog.ActionPlanCreated, map[string]interface{}{
+		"plan_id":     plan.IndexID,
+		"price":       plan.Price,
+		"period_days": plan.PeriodDays,
+		"trial_days":  plan.TrialDays,
+	})
+
+	c.JSON(201, newPlanResponse(plan))
+}
+
+// DeactivatePlanHandler godoc
+//
+//	@Summary		Deactivate a subscription plan
+//	@Description	Nobody can subscribe anymore, running subscriptions end with their current period instead of renewing
+//	@Tags			Admin
+//	@Produce		json
+//	@Param			id	path		int	true	"Plan ID"
+//	@Success		200	{object}	map[string]string
+//	@Failure		400	{object}	map[string]string
+//	@Failure		404	{object}	map[string]string
+//	@Failure		500	{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/api/admin/plans/{id} [delete]
+func (s AdminService) DeactivatePlanHandler(c *gin.Context) {
+	planID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid plan ID"})
+		return
+	}
+
+	err = s.DB.DeactivateSubscriptionPlan(planID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(404, gin.H{"error": "Plan not found"})
+		return
+	}
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, gin.H{"error": "Failed to deactivate plan"})
+		return
+	}
+
+	s.auditCatalog(c, AuditLog.ActionPlanDeactivated, map[string]interface{}{"plan_id": planID})
+
+	c.JSON(200, gin.H{"message": "Plan deactivated"})
+}
+
+// SetRentalOfferHandler godoc
+//
+//	@Summary		Rent out a product
+//	@Description	Creates or replaces the rental offer of a product, running rentals keep their end
+//	@Tags			Admin
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		int					true	"Product ID"
+//	@Param			offer	body		rentalOfferRequest	true	"Price and days"
+//	@Success		200		{object}	rentalOfferResponse
+//	@Failure		400		{object}	map[string]string
+//	@Failure		404		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/api/admin/products/{id}/rental [put]
+func (s AdminService) SetRentalOfferHandler(c *gin.Context) {
+	productID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid product ID"})
+		return
+	}
+
+	var request rentalOfferRequest
+	err = c.ShouldBindJSON(&request)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request"})
+		return
+	}
+	if request.Price < 0 || request.Days < 1 || request.Days > maxPeriodDays {
+		c.JSON(400, gin.H{"error": "Invalid price or days"})
+		return
+	}
+
+	_, err = s.DB.GetProductByIndexID(productID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(404, gin.H{"error": "Product not found"})
+		return
+	}
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, gin.H{"error": "Failed to get product"})
+		return
+	}
+
+	offer := DatabaseAbstraction.RentalOffer{ProductID: productID, Price: request.Price, Days: request.Days}
+	err = s.DB.SetRentalOffer(offer)
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, gin.H{"error": "Failed to set rental offer"})
+		return
+	}
+
+	s.auditCatalog(c, AuditLog.ActionRentalOfferSet, map[string]interface{}{
+		"product_id": productID,
+		"price":      offer.Price,
+		"days":       offer.Days,
+	})
+
+	c.JSON(200, rentalOfferResponse{offer.ProductID, offer.Price, offer.Days})
+}
+
+// DeleteRentalOfferHandler godoc
+//
+//	@Summary		Stop renting out a product
+//	@Description	Removes the rental offer, running rentals continue until they expire
+//	@Tags			Admin
+//	@Produce		json
+//	@Param			id	path		int	true	"Product ID"
+//	@Success		200	{object}	map[string]string
+//	@Failure		400	{object}	map[string]string
+//	@Failure		404	{object}	map[string]string
+//	@Failure		500	{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/api/admin/products/{id}/rental [delete]
+func (s AdminService) DeleteRentalOfferHandler(c *gin.Context) {
+	productID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid product ID"})
+		return
+	}
+
+	err = s.DB.DeleteRentalOffer(productID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(404, gin.H{"error": "The product is not rented out"})
+		return
+	}
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, gin.H{"error": "Failed to remove rental offer"})
+		return
+	}
+
+	s.auditCatalog(c, AuditLog.ActionRentalOfferRemoved, map[string]interface{}{"product_id": productID})
+
+	c.JSON(200, gin.H{"message": "Rental offer removed"})
+}
@@ -0,0 +1,75 @@
+package AdminService_test
+
+import (
+	"EntitlementServer/AuditLog"
+	"EntitlementServer/DatabaseAbstraction"
+	"EntitlementServer/DatabaseAbstraction/mocks"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"net/http"
+	"testing"
+)
+
+func TestCreatePlan(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	mockDB.On("AddSubscriptionPlan", mock.MatchedBy(func(plan DatabaseAbstraction.SubscriptionPlan) bool {
+		return plan.Name == "Flatrate Jahr" && plan.Price == 9000 && plan.PeriodDays == 365 && plan.TrialDays == 0
+	})).Return(3, nil)
+	r, events := newAuditedRouter(mockDB, adminUser)
+
+	w := request(r, http.MethodPost, "/api/admin/plans", `{"name": " Flatrate Jahr ", "price": 9000, "period_days": 365}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Contains(t, w.Body.String(), `"id":3`)
+
+	for _, body := range []string{`{"name": "", "price": 900, "period_days": 30}`, `{"name": "Gratis", "price": -1, "period_days": 30}`,
+		`{"name": "Ewig", "price": 900, "period_days": 0}`, `{"name": "Test", "price": 900, "period_days": 30, "trial_days": -1}`} {
+		w = request(r, http.MethodPost, "/api/admin/plans", body)
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+	}
+
+	assert.Len(t, *events, 1)
+	assert.Equal(t, AuditLog.ActionPlanCreated, (*events)[0].Action)
+}
+
+func TestDeactivatePlan(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	mockDB.On("DeactivateSubscriptionPlan", 1).Return(nil)
+	mockDB.On("DeactivateSubscriptionPlan", 9).Return(pgx.ErrNoRows)
+	r, events := newAuditedRouter(mockDB, adminUser)
+
+	w := request(r, http.MethodDelete, "/api/admin/plans/1", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = request(r, http.MethodDelete, "/api/admin/plans/9", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	assert.Len(t, *events, 1)
+	assert.Equal(t, AuditLog.ActionPlanDeactivated, (*events)[0].Action)
+}
+
+func TestSetRentalOffer(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	mockDB.On("GetProductByIndexID", 2).Return(DatabaseAbstraction.Product{IndexID: 2}, nil)
+	mockDB.On("GetProductByIndexID", 9).Return(DatabaseAbstraction.Product{}, pgx.ErrNoRows)
+	mockDB.On("SetRentalOffer", DatabaseAbstraction.RentalOffer{ProductID: 2, Price: 300, Days: 14}).Return(nil)
+	mockDB.On("DeleteRentalOffer", 2).Return(nil)
+	mockDB.On("DeleteRentalOffer", 9).Return(pgx.ErrNoRows)
+	r, events := newAuditedRouter(mockDB, adminUser)
+
+	w := request(r, http.MethodPut, "/api/admin/products/2/rental", `{"price": 300, "days": 14}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = request(r, http.MethodPut, "/api/admin/products/9/rental", `{"price": 300, "days": 14}`)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = request(r, http.MethodPut, "/api/admin/products/2/rental", `{"price": 300, "days": 0}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = request(r, http.MethodDelete, "/api/admin/products/2/rental", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = request(r, http.MethodDelete, "/api/admin/products/9/rental", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	if assert.Len(t, *events, 2) {
+		assert.Equal(t, AuditLog.ActionRentalOfferSet, (*events)[0].Action)
+		assert.Equal(t, AuditLog.ActionRentalOfferRemoved, (*events)[1].Action)
+	}
+}
@@ -0,0 +1,526 @@
+package AdminService
+
+import (
+	"EntitlementServer/AuditLog"
+	"EntitlementServer/AuthenticationManagement"
+	"EntitlementServer/DatabaseAbstraction"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/sirupsen/logrus"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const maxReasonLength = 200
+
+// adminUserResponse is a user as admins see it, credentials are never included
+type adminUserResponse struct {
+	ID               int       `json:"id"`
+	Username         string    `json:"username"`
+	DisplayName      string    `json:"display_name"`
+	Email            string    `json:"email"`
+	EmailVerified    bool      `json:"email_verified"`
+	Role             string    `json:"role"`
+	TwoFactor        bool      `json:"two_factor_enabled"`
+	Balance          int       `json:"balance"`
+	Points           int       `json:"points"`
+	Suspended        bool      `json:"suspended"`
+	SuspensionReason string    `json:"suspension_reason,omitempty"`
+	Deleted          bool      `json:"deleted"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+func newAdminUserResponse(user DatabaseAbstraction.User) adminUserResponse {
+	return adminUserResponse{
+		ID:               user.IndexID,
+		Username:         user.Username,
+		DisplayName:      user.DisplayName,
+		Email:            user.Email,
+		EmailVerified:    user.EmailVerified,
+		Role:             user.Role,
+		TwoFactor:        user.TOTPEnabled,
+		Balance:          user.Balance,
+		Points:           user.Points,
+		Suspended:        user.Suspended,
+		SuspensionReason: user.SuspensionReason,
+		Deleted:          user.Deleted,
+		CreatedAt:        user.CreatedAt,
+	}
+}
+
+type userListResponse struct {
+	Users   []adminUserResponse `json:"users"`
+	Total   int                 `json:"total"`
+	Page    int                 `json:"page"`
+	PerPage int                 `json:"per_page"`
+}
+
+type purchaseResponse struct {
+	ProductID    int        `json:"product_id"`
+	ProductName  string     `json:"product_name"`
+	BundleID     int        `json:"bundle_id,omitempty"`
+	CouponID     int        `json:"coupon_id,omitempty"`
+	LicenseKeyID int        `json:"license_key_id,omitempty"`
+	Price        int        `json:"price"`
+	Discount     int        `json:"discount"`
+	PurchasedAt  time.Time  `json:"purchased_at"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+}
+
+type progressResponse struct {
+	ProductID     int    `json:"product_id"`
+	ProductName   string `json:"product_name"`
+	WatchedVideos int    `json:"watched_videos"`
+	TotalVideos   int    `json:"total_videos"`
+}
+
+type balanceAdjustmentRequest struct {
+	Amount int    `json:"amount"` // negative to debit
+	Reason string `json:"reason"`
+}
+
+type impersonationResponse struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type suspensionRequest struct {
+	Reason string `json:"reason"`
+}
+
+// validReason checks the free text admins have to give for changes to an account
+func validReason(reason string) bool {
+	return reason != "" && utf8.RuneCountInString(reason) <= maxReasonLength
+}
+
+// ListUsersHandler godoc
+//
+//	@Summary		List users
+//	@Description	Users whose username, display name or email contains the query, ordered by ID
+//	@Tags			Admin
+//	@Produce		json
+//	@Param			q			query		string	false	"Search query"
+//	@Param			page		query		int		false	"Page, starting at 1"
+//	@Param			per_page	query		int		false	"Users per page, at most 100"
+//	@Success		200			{object}	userListResponse
+//	@Failure		403			{object}	map[string]string
+//	@Failure		500			{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/api/admin/users [get]
+func (s AdminService) ListUsersHandler(c *gin.Context) {
+	page, perPage := pagination(c)
+
+	users, total, err := s.DB.SearchUsers(strings.TrimSpace(c.Query("q")), perPage, (page-1)*perPage)
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, gin.H{"error": "Failed to get users"})
+		return
+	}
+
+	response := userListResponse{
+		Users:   []adminUserResponse{},
+		Total:   total,
+		Page:    page,
+		PerPage: perPage,
+	}
+	for _, user := range users {
+		response.Users = append(response.Users, newAdminUserResponse(user))
+	}
+
+	c.JSON(200, response)
+}
+
+// GetUserHandler godoc
+//
+//	@Summary		Get a user
+//	@Description	Account details of a user including balance and suspension
+//	@Tags			Admin
+//	@Produce		json
+//	@Param			id	path		int	true	"User ID"
+//	@Success		200	{object}	adminUserResponse
+//	@Failure		404	{object}	map[string]string
+//	@Failure		500	{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/api/admin/users/{id} [get]
+func (s AdminService) GetUserHandler(c *gin.Context) {
+	user, ok := s.targetUser(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(200, newAdminUserResponse(user))
+}
+
+// GetPurchasesHandler godoc
+//
+//	@Summary		Get the purchases of a user
+//	@Tags			Admin
+//	@Produce		json
+//	@Param			id	path		int	true	"User ID"
+//	@Success		200	{object}	[]purchaseResponse
+//	@Failure		404	{object}	map[string]string
+//	@Failure		500	{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/api/admin/users/{id}/purchases [get]
+func (s AdminService) GetPurchasesHandler(c *gin.Context) {
+	user, ok := s.targetUser(c)
+	if !ok {
+		return
+	}
+
+	purchases, err := s.DB.GetPurchasesByUserID(user.IndexID)
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, gin.H{"error": "Failed to get purchases"})
+		return
+	}
+
+	response := []purchaseResponse{}
+	for _, purchase := range purchases {
+		response = append(response, purchaseResponse{purchase.ProductID, purchase.ProductName, purchase.BundleID, purchase.CouponID, purchase.LicenseKeyID, purchase.Price, purchase.Discount, purchase.CreatedAt, purchase.ExpiresAt})
+	}
+
+	c.JSON(200, response)
+}
+
+// GetProgressHandler godoc
+//
+//	@Summary		Get the progress of a user
+//	@Description	Watched and total videos of each product the user owns
+//	@Tags			Admin
+//	@Produce		json
+//	@Param			id	path		int	true	"User ID"
+//	@Success		200	{object}	[]progressResponse
+//	@Failure		404	{object}	map[string]string
+//	@Failure		500	{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/api/admin/users/{id}/progress [get]
+func (s AdminService) GetProgressHandler(c *gin.Context) {
+	user, ok := s.targetUser(c)
+	if !ok {
+		return
+	}
+
+	progress, err := s.DB.GetProgressByUserID(user.IndexID)
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, gin.H{"error": "Failed to get progress"})
+		return
+	}
+
+	response := []progressResponse{}
+	for _, productProgress := range progress {
+		response = append(response, progressResponse{productProgress.ProductID, productProgress.ProductName, productProgress.WatchedVideos, productProgress.TotalVideos})
+	}
+
+	c.JSON(200, response)
+}
+
+// GrantProductHandler godoc
+//
+//	@Summary		Grant a product to a user
+//	@Description	Gives the user the product without charging their balance
+//	@Tags			Admin
+//	@Produce		json
+//	@Param			id			path		int	true	"User ID"
+//	@Param			productID	path		int	true	"Product ID"
+//	@Success		200			{object}	map[string]string
+//	@Failure		404			{object}	map[string]string
+//	@Failure		409			{object}	map[string]string
+//	@Failure		500			{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/api/admin/users/{id}/products/{productID} [post]
+func (s AdminService) GrantProductHandler(c *gin.Context) {
+	user, ok := s.targetUser(c)
+	if !ok {
+		return
+	}
+
+	productID, err := strconv.Atoi(c.Param("productID"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid product ID"})
+		return
+	}
+
+	_, err = s.DB.GetProductByIndexID(productID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(404, gin.H{"error": "Product not found"})
+		return
+	}
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, gin.H{"error": "Failed to get product"})
+		return
+	}
+
+	ownedProducts, err := s.DB.GetOwnedProducts(user.IndexID)
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, gin.H{"error": "Failed to get owned products"})
+		return
+	}
+	for _, ownedProduct := range ownedProducts {
+		if ownedProduct.IndexID == productID {
+			c.JSON(409, gin.H{"error": "User already owns the product"})
+			return
+		}
+	}
+
+	err = s.DB.AddOwnedProduct(user.IndexID, productID)
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, gin.H{"error": "Failed to grant product"})
+		return
+	}
+
+	s.audit(c, AuditLog.ActionProductGranted, user, map[string]interface{}{"product_id": productID})
+	c.JSON(200, gin.H{"message": "Product granted"})
+}
+
+// RevokeProductHandler godoc
+//
+//	@Summary		Revoke a product from a user
+//	@Description	Removes the product from the user, the price is not refunded
+//	@Tags			Admin
+//	@Produce		json
+//	@Param			id			path		int	true	"User ID"
+//	@Param			productID	path		int	true	"Product ID"
+//	@Success		200			{object}	map[string]string
+//	@Failure		404			{object}	map[string]string
+//	@Failure		500			{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/api/admin/users/{id}/products/{productID} [delete]
+func (s AdminService) RevokeProductHandler(c *gin.Context) {
+	user, ok := s.targetUser(c)
+	if !ok {
+		return
+	}
+
+	productID, err := strconv.Atoi(c.Param("productID"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid product ID"})
+		return
+	}
+
+	err = s.DB.RemoveOwnedProduct(user.IndexID, productID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(404, gin.H{"error": "User doesn't own the product"})
+		return
+	}
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, gin.H{"error": "Failed to revoke product"})
+		return
+	}
+
+	s.audit(c, AuditLog.ActionProductRevoked, user, map[string]interface{}{"product_id": productID})
+	c.JSON(200, gin.H{"message": "Product revoked"})
+}
+
+// AdjustBalanceHandler godoc
+//
+//	@Summary		Adjust the balance of a user
+//	@Description	Credits a positive or debits a negative amount, the reason is recorded in the wallet history
+//	@Tags			Admin
+//	@Accept			json
+//	@Produce		json
+//	@Param			id					path		int							true	"User ID"
+//	@Param			balanceAdjustment	body		balanceAdjustmentRequest	true	"Adjustment"
+//	@Success		200					{object}	adminUserResponse
+//	@Failure		400					{object}	map[string]string
+//	@Failure		404					{object}	map[string]string
+//	@Failure		500					{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/api/admin/users/{id}/balance [post]
+func (s AdminService) AdjustBalanceHandler(c *gin.Context) {
+	user, ok := s.targetUser(c)
+	if !ok {
+		return
+	}
+
+	var request balanceAdjustmentRequest
+	err := c.ShouldBindJSON(&request)
+	request.Reason = strings.TrimSpace(request.Reason)
+	if err != nil || request.Amount == 0 || !validReason(request.Reason) {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("A non-zero amount and a reason of at most %d characters are required", maxReasonLength)})
+		return
+	}
+	if user.Balance+request.Amount < 0 {
+		c.JSON(400, gin.H{"error": "The balance can't become negative"})
+		return
+	}
+
+	reason := "adjustment: " + request.Reason
+	if request.Amount > 0 {
+		err = s.DB.IncreaseUserBalance(user.IndexID, request.Amount, reason)
+	} else {
+		err = s.DB.DecreaseUserBalance(user.IndexID, -request.Amount, reason)
+	}
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, gin.H{"error": "Failed to adjust balance"})
+		return
+	}
+
+	s.audit(c, AuditLog.ActionBalanceAdjusted, user, map[string]interface{}{"amount": request.Amount, "reason": request.Reason})
+	user.Balance += request.Amount
+	c.JSON(200, newAdminUserResponse(user))
+}
+
+// ResetSessionsHandler godoc
+//
+//	@Summary		Reset the sessions of a user
+//	@Description	Logs the user out everywhere and revokes their API keys
+//	@Tags			Admin
+//	@Produce		json
+//	@Param			id	path		int	true	"User ID"
+//	@Success		200	{object}	map[string]string
+//	@Failure		404	{object}	map[string]string
+//	@Failure		500	{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/api/admin/users/{id}/sessions [delete]
+func (s AdminService) ResetSessionsHandler(c *gin.Context) {
+	user, ok := s.targetUser(c)
+	if !ok {
+		return
+	}
+
+	err := s.Auth.RevokeAllTokens(user.IndexID)
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, gin.H{"error": "Failed to reset sessions"})
+		return
+	}
+
+	s.audit(c, AuditLog.ActionSessionsReset, user, nil)
+	c.JSON(200, gin.H{"message": "Sessions reset"})
+}
+
+// SuspendUserHandler godoc
+//
+//	@Summary		Suspend a user
+//	@Description	Locks the account and ends its sessions and API keys, the user can't log in until the suspension is lifted
+//	@Tags			Admin
+//	@Accept			json
+//	@Produce		json
+//	@Param			id			path		int					true	"User ID"
+//	@Param			suspension	body		suspensionRequest	true	"Suspension"
+//	@Success		200			{object}	adminUserResponse
+//	@Failure		400			{object}	map[string]string
+//	@Failure		404			{object}	map[string]string
+//	@Failure		500			{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/api/admin/users/{id}/suspension [post]
+func (s AdminService) SuspendUserHandler(c *gin.Context) {
+	admin := c.MustGet("user").(DatabaseAbstraction.User)
+	user, ok := s.targetUser(c)
+	if !ok {
+		return
+	}
+
+	var request suspensionRequest
+	err := c.ShouldBindJSON(&request)
+	request.Reason = strings.TrimSpace(request.Reason)
+	if err != nil || !validReason(request.Reason) {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("A reason of at most %d characters is required", maxReasonLength)})
+		return
+	}
+	// nobody would be left to lift it if the last admin did this
+	if user.IndexID == admin.IndexID {
+		c.JSON(400, gin.H{"error": "You can't suspend your own account"})
+		return
+	}
+
+	err = s.DB.SuspendUser(user.IndexID, request.Reason)
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, gin.H{"error": "Failed to suspend user"})
+		return
+	}
+
+	// the middleware rejects the tokens anyway, revoking them keeps them from working again after the suspension is lifted
+	err = s.Auth.RevokeAllTokens(user.IndexID)
+	if err != nil {
+		logrus.Error(err)
+	}
+
+	s.audit(c, AuditLog.ActionUserSuspended, user, map[string]interface{}{"reason": request.Reason})
+	user.Suspended = true
+	user.SuspensionReason = request.Reason
+	c.JSON(200, newAdminUserResponse(user))
+}
+
+// UnsuspendUserHandler godoc
+//
+//	@Summary		Lift the suspension of a user
+//	@Tags			Admin
+//	@Produce		json
+//	@Param			id	path		int	true	"User ID"
+//	@Success		200	{object}	adminUserResponse
+//	@Failure		404	{object}	map[string]string
+//	@Failure		500	{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/api/admin/users/{id}/suspension [delete]
+func (s AdminService) UnsuspendUserHandler(c *gin.Context) {
+	user, ok := s.targetUser(c)
+	if !ok {
+		return
+	}
+
+	err := s.DB.UnsuspendUser(user.IndexID)
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, gin.H{"error": "Failed to lift suspension"})
+		return
+	}
+
+	s.audit(c, AuditLog.ActionUserUnsuspended, user, nil)
+	user.Suspended = false
+	user.SuspensionReason = ""
+	c.JSON(200, newAdminUserResponse(user))
+}
+
+// ImpersonateUserHandler godoc
+//
+//	@Summary		Impersonate a user
+//	@Description	Issues a token to use the API as the user for one hour, e.g. to see what a student sees
+//	@Description	The session is read-only: it can't purchase, change credentials or make any other change
+//	@Description	Send the token in the Authorization header, it is not set as cookie. Admins can't be impersonated
+//	@Tags			Admin
+//	@Produce		json
+//	@Param			id	path		int	true	"User ID"
+//	@Success		200	{object}	impersonationResponse
+//	@Failure		400	{object}	map[string]string
+//	@Failure		404	{object}	map[string]string
+//	@Failure		500	{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/api/admin/users/{id}/impersonate [post]
+func (s AdminService) ImpersonateUserHandler(c *gin.Context) {
+	admin := c.MustGet("user").(DatabaseAbstraction.User)
+	user, ok := s.targetUser(c)
+	if !ok {
+		return
+	}
+
+	token, expiry, err := s.Auth.CreateImpersonationToken(user, admin)
+	if errors.Is(err, AuthenticationManagement.ErrImpersonationNotAllowed) {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, gin.H{"error": "Failed to start impersonation"})
+		return
+	}
+
+	s.audit(c, AuditLog.ActionImpersonationStarted, user, map[string]interface{}{"expires_at": expiry})
+	c.Header("Cache-Control", "no-store")
+	c.JSON(200, impersonationResponse{
+		Token:     token,
+		ExpiresAt: expiry,
+	})
+}
@@ -0,0 +1,146 @@
+package AdminService
+
+import (
+	"EntitlementServer/AuditLog"
+	"EntitlementServer/DatabaseAbstraction"
+	"EntitlementServer/Invoicing"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/sirupsen/logrus"
+)
+
+// rates are stored in hundredths of a percent, a rate of 100 % or more is surely a typo
+const maxVATRate = 9999
+
+type vatRateRequest struct {
+	Rate    int  `json:"rate"`    // in hundredths of a percent, 1900 is 19 %
+	Default bool `json:"default"` // also charged to users without billing country
+}
+
+type vatRateResponse struct {
+	Country string `json:"country"`
+	Rate    int    `json:"rate"`
+	Default bool   `json:"default"`
+}
+
+// countryParam reads the country of the route, it responds with 400 and returns false if it is no country code
+func countryParam(c *gin.Context) (string, bool) {
+	country, err := Invoicing.NormalizeCountry(c.Param("country"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid country, " + err.Error()})
+		return "", false
+	}
+	return country, true
+}
+
+// ListVATRatesHandler godoc
+//
+//	@Summary		List VAT rates
+//	@Description	The VAT rates per country, buyers from countries without a rate are invoiced at the default rate
+//	@Tags			Admin
+//	@Produce		json
+//	@Success		200	{object}	[]vatRateResponse
+//	@Failure		500	{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/api/admin/vat-rates [get]
+func (s AdminService) ListVATRatesHandler(c *gin.Context) {
+	rates, err := s.DB.GetVATRates()
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, gin.H{"error": "Failed to get VAT rates"})
+		return
+	}
+
+	response := make([]vatRateResponse, len(rates))
+	for i, rate := range rates {
+		response[i] = vatRateResponse{rate.Country, rate.Rate, rate.Default}
+	}
+
+	c.JSON(200, response)
+}
+
+// SetVATRateHandler godoc
+//
+//	@Summary		Set a VAT rate
+//	@Description	Creates or changes the VAT rate of a country, only invoices issued afterwards use it
+//	@Description	A rate of 0 bills buyers from the country without VAT, countries without a rate are billed at the default rate
+//	@Description	The default rate also applies to users without billing country, setting a new default replaces the old one
+//	@Tags			Admin
+//	@Accept			json
+//	@Produce		json
+//	@Param			country	path		string			true	"ISO 3166 country code"
+//	@Param			rate	body		vatRateRequest	true	"Rate"
+//	@Success		200		{object}	vatRateResponse
+//	@Failure		400		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/api/admin/vat-rates/{country} [put]
+func (s AdminService) SetVATRateHandler(c *gin.Context) {
+	country, ok := countryParam(c)
+	if !ok {
+		return
+	}
+
+	var request vatRateRequest
+	err := c.ShouldBindJSON(&request)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request"})
+		return
+	}
+	if request.Rate < 0 || request.Rate > maxVATRate {
+		c.JSON(400, gin.H{"error": "Invalid rate, it is given in hundredths of a percent"})
+		return
+	}
+
+	rate := DatabaseAbstraction.VATRate{Country: country, Rate: request.Rate, Default: request.Default}
+	err = s.DB.SetVATRate(rate)
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, gin.H{"error": "Failed to set VAT rate"})
+		return
+	}
+
+	s.auditCatalog(c, AuditLog.ActionVATRateSet, map[string]interface{}{
+		"country": rate.Country,
+		"rate":    rate.Rate,
+		"default": rate.Default,
+	})
+
+	c.JSON(200, vatRateResponse{rate.Country, rate.Rate, rate.Default})
+}
+
+// DeleteVATRateHandler godoc
+//
+//	@Summary		Remove a VAT rate
+//	@Description	Buyers from the country are invoiced at the default rate afterwards
+//	@Tags			Admin
+//	@Produce		json
+//	@Param			country	path		string	true	"ISO 3166 country code"
+//	@Success		200		{object}	map[string]string
+//	@Failure		400		{object}	map[string]string
+//	@Failure		404		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/api/admin/vat-rates/{country} [delete]
+func (s AdminService) DeleteVATRateHandler(c *gin.Context) {
+	country, ok := countryParam(c)
+	if !ok {
+		return
+	}
+
+	err := s.DB.DeleteVATRate(country)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(404, gin.H{"error": "The country has no VAT rate"})
+		return
+	}
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, gin.H{"error": "Failed to remove VAT rate"})
+		return
+	}
+
+	s.auditCatalog(c, AuditLog.ActionVATRateRemoved, map[string]interface{}{"country": country})
+
+	c.JSON(200, gin.H{"message": "VAT rate removed"})
+}
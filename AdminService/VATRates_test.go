@@ -0,0 +1,42 @@
+package AdminService_test
+
+import (
+	"EntitlementServer/AuditLog"
+	"EntitlementServer/DatabaseAbstraction"
+	"EntitlementServer/DatabaseAbstraction/mocks"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+func TestSetVATRate(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	mockDB.On("SetVATRate", DatabaseAbstraction.VATRate{Country: "AT", Rate: 2000, Default: true}).Return(nil)
+	mockDB.On("DeleteVATRate", "CH").Return(nil)
+	mockDB.On("DeleteVATRate", "FR").Return(pgx.ErrNoRows)
+	r, events := newAuditedRouter(mockDB, adminUser)
+
+	w := request(r, http.MethodPut, "/api/admin/vat-rates/at", `{"rate": 2000, "default": true}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"country":"AT"`)
+
+	for path, body := range map[string]string{
+		"/api/admin/vat-rates/EU":  `{"rate": 2000}`,
+		"/api/admin/vat-rates/AUT": `{"rate": 2000}`,
+		"/api/admin/vat-rates/DE":  `{"rate": -1}`,
+		"/api/admin/vat-rates/FR":  `{"rate": 10000}`,
+	} {
+		w = request(r, http.MethodPut, path, body)
+		assert.Equal(t, http.StatusBadRequest, w.Code, path)
+	}
+
+	w = request(r, http.MethodDelete, "/api/admin/vat-rates/CH", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = request(r, http.MethodDelete, "/api/admin/vat-rates/FR", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	assert.Len(t, *events, 2)
+	assert.Equal(t, AuditLog.ActionVATRateSet, (*events)[0].Action)
+	assert.Equal(t, AuditLog.ActionVATRateRemoved, (*events)[1].Action)
+}
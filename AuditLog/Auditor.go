@@ -0,0 +1,138 @@
+package AuditLog
+
+import (
+	"EntitlementServer/DatabaseAbstraction"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+// Actions are dotted, the first part groups them so the admin endpoint can filter e.g. all "auth" events
+const (
+	ActionLogin                = "auth.login"
+	ActionLogout               = "auth.logout"
+	ActionRegister             = "auth.register"
+	ActionPasswordChanged      = "auth.password_changed"
+	ActionPasswordReset        = "auth.password_reset"
+	ActionAPIKeyCreated        = "auth.api_key_created"
+	ActionAPIKeyRevoked        = "auth.api_key_revoked"
+	ActionTwoFactorEnabled     = "auth.two_factor_enabled"
+	ActionTwoFactorDisabled    = "auth.two_factor_disabled"
+	ActionAccountDeleted       = "auth.account_deleted"
+	ActionImpersonationStarted = "auth.impersonation_started"
+	ActionImpersonationStopped = "auth.impersonation_stopped"
+
+	ActionPurchase              = "commerce.purchase"
+	ActionBalanceTopUp          = "commerce.balance_top_up"
+	ActionRefundRequested       = "commerce.refund_requested"
+	ActionRefundApproved        = "commerce.refund_approved"
+	ActionRefundRejected        = "commerce.refund_rejected"
+	ActionLicenseKeysPurchased  = "commerce.license_keys_purchased"
+	ActionLicenseKeyRedeemed    = "commerce.license_key_redeemed"
+	ActionRentalPurchased       = "commerce.rental_purchased"
+	ActionRentalExpired         = "commerce.rental_expired"
+	ActionSubscriptionStarted   = "commerce.subscription_started"
+	ActionSubscriptionCancelled = "commerce.subscription_cancelled"
+	ActionSubscriptionRenewed   = "commerce.subscription_renewed"
+	ActionSubscriptionExpired   = "commerce.subscription_expired"
+	ActionSeatsPurchased        = "commerce.seats_purchased"
+
+	ActionBalanceAdjusted      = "admin.balance_adjusted"
+	ActionProductGranted       = "admin.product_granted"
+	ActionProductRevoked       = "admin.product_revoked"
+	ActionSessionsReset        = "admin.sessions_reset"
+	ActionUserSuspended        = "admin.user_suspended"
+	ActionUserUnsuspended      = "admin.user_unsuspended"
+	ActionLicenseKeysGenerated = "admin.license_keys_generated"
+	ActionLicenseKeyRevoked    = "admin.license_key_revoked"
+
+	ActionCommentDeleted = "moderation.comment_deleted"
+
+	ActionOrganizationCreated = "organization.created"
+	ActionMemberInvited       = "organization.member_invited"
+	ActionMemberJoined        = "organization.member_joined"
+	ActionMemberRemoved       = "organization.member_removed"
+	ActionSeatAssigned        = "organization.seat_assigned"
+	ActionSeatReclaimed       = "organization.seat_reclaimed"
+
+	ActionCategoryCreated     = "catalog.category_created"
+	ActionCategoryUpdated     = "catalog.category_updated"
+	ActionCategoryDeleted     = "catalog.category_deleted"
+	ActionProductCategorized  = "catalog.product_categorized"
+	ActionProductTagsReplaced = "catalog.product_tags_replaced"
+	ActionBundleCreated       = "catalog.bundle_created"
+	ActionBundleUpdated       = "catalog.bundle_updated"
+	ActionBundleDeleted       = "catalog.bundle_deleted"
+	ActionCouponCreated       = "catalog.coupon_created"
+	ActionCouponDisabled      = "catalog.coupon_disabled"
+	ActionRentalOfferSet      = "catalog.rental_offer_set"
+	ActionRentalOfferRemoved  = "catalog.rental_offer_removed"
+	ActionPlanCreated         = "catalog.subscription_plan_created"
+	ActionPlanDeactivated     = "catalog.subscription_plan_deactivated"
+	ActionVATRateSet          = "catalog.vat_rate_set"
+	ActionVATRateRemoved      = "catalog.vat_rate_removed"
+)
+
+// Auditor records security and commerce events
+// Recording never fails the request, an event that can't be stored is logged instead
+type Auditor interface {
+	Record(event DatabaseAbstraction.AuditEvent)
+}
+
+// DBAuditor appends the events to the audit_events table
+type DBAuditor struct {
+	DB DatabaseAbstraction.DBOrm
+}
+
+func (a DBAuditor) Record(event DatabaseAbstraction.AuditEvent) {
+	err := a.DB.AddAuditEvent(event)
+	if err != nil {
+		logrus.Errorf("Failed to store audit event: %v", err)
+		LogAuditor{}.Record(event)
+	}
+}
+
+// LogAuditor writes the events to the log, it is used when no other auditor is configured
+type LogAuditor struct{}
+
+// OrDefault returns the auditor, or a LogAuditor if it is nil, so services can leave their Audit field unset
+func OrDefault(a Auditor) Auditor {
+	if a == nil {
+		return LogAuditor{}
+	}
+	return a
+}
+
+func (LogAuditor) Record(event DatabaseAbstraction.AuditEvent) {
+	logrus.WithFields(logrus.Fields{
+		"action":          event.Action,
+		"outcome":         event.Outcome,
+		"actor_id":        event.ActorID,
+		"impersonator_id": event.ImpersonatorID,
+		"target_user_id":  event.TargetUserID,
+		"ip":              event.IP,
+		"details":         event.Details,
+	}).Info("Audit event")
+}
+
+// NewEvent starts a successful event for the request, the signed-in user is the actor
+// For an impersonated session the admin is recorded next to the user they act as
+func NewEvent(c *gin.Context, action string) DatabaseAbstraction.AuditEvent {
+	event := DatabaseAbstraction.AuditEvent{
+		Action:  action,
+		Outcome: OutcomeSuccess,
+		IP:      c.ClientIP(),
+		Details: map[string]interface{}{},
+	}
+	if user, found := c.Get("user"); found {
+		event.ActorID = user.(DatabaseAbstraction.User).IndexID
+	}
+	if impersonator, found := c.Get("impersonator"); found {
+		event.ImpersonatorID = impersonator.(DatabaseAbstraction.User).IndexID
+	}
+	return event
+}
@@ -0,0 +1,52 @@
+package AuditLog
+
+import (
+	"EntitlementServer/DatabaseAbstraction"
+	"EntitlementServer/DatabaseAbstraction/mocks"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNewEvent(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request, _ = http.NewRequest("POST", "/api/products/1/purchase", nil)
+	c.Request.RemoteAddr = "192.0.2.10:4711"
+
+	event := NewEvent(c, ActionPurchase)
+	assert.Equal(t, OutcomeSuccess, event.Outcome)
+	assert.Equal(t, "192.0.2.10", event.IP)
+	assert.Zero(t, event.ActorID)
+
+	// in an impersonated session the admin is recorded next to the user
+	c.Set("user", DatabaseAbstraction.User{IndexID: 5})
+	c.Set("impersonator", DatabaseAbstraction.User{IndexID: 1})
+	event = NewEvent(c, ActionLogout)
+	assert.Equal(t, 5, event.ActorID)
+	assert.Equal(t, 1, event.ImpersonatorID)
+	assert.NotNil(t, event.Details)
+}
+
+func TestDBAuditorKeepsGoingWhenTheDatabaseFails(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	mockDB.On("AddAuditEvent", mock.MatchedBy(func(event DatabaseAbstraction.AuditEvent) bool {
+		return event.Action == ActionLogin
+	})).Return(errors.New("connection refused"))
+
+	assert.NotPanics(t, func() {
+		DBAuditor{DB: mockDB}.Record(DatabaseAbstraction.AuditEvent{Action: ActionLogin, Outcome: OutcomeFailure})
+	})
+	mockDB.AssertExpectations(t)
+}
+
+func TestOrDefault(t *testing.T) {
+	assert.Equal(t, LogAuditor{}, OrDefault(nil))
+
+	auditor := DBAuditor{DB: new(mocks.DBOrm)}
+	assert.Equal(t, auditor, OrDefault(auditor))
+}
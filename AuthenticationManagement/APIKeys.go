@@ -0,0 +1,383 @@
+package AuthenticationManagement
+
+import (
+	"EntitlementServer/AuditLog"
+	"EntitlementServer/DatabaseAbstraction"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/sirupsen/logrus"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Scopes of API keys, a key can only use the routes listed for its scopes in apiKeyRouteScopes
+const (
+	ScopeAccountRead    = "account:read"
+	ScopeProductsRead   = "products:read"
+	ScopeProductsWrite  = "products:write"
+	ScopePurchasesWrite = "purchases:write"
+	ScopeCommentsWrite  = "comments:write"
+	ScopeProgressWrite  = "progress:write"
+	ScopeReportsRead    = "reports:read"
+)
+
+const (
+	// the prefix tells API keys apart from session tokens and makes leaked keys easy to find with secret scanners
+	apiKeyPrefix        = "bkb_"
+	apiKeyDisplayLength = len(apiKeyPrefix) + 8
+	maxAPIKeysPerUser   = 20
+	maxAPIKeyNameLength = 64
+)
+
+var (
+	ErrInvalidAPIKey     = errors.New("invalid or expired API key")
+	ErrInsufficientScope = errors.New("the API key lacks the scope for this request")
+	ErrTooManyAPIKeys    = errors.New("too many API keys")
+)
+
+var knownScopes = map[string]bool{
+	ScopeAccountRead:    true,
+	ScopeProductsRead:   true,
+	ScopeProductsWrite:  true,
+	ScopePurchasesWrite: true,
+	ScopeCommentsWrite:  true,
+	ScopeProgressWrite:  true,
+	ScopeReportsRead:    true,
+}
+
+// apiKeyRouteScopes maps "METHOD route" to the scope an API key needs for it
+// Routes that are not listed can't be used with API keys at all, which includes managing credentials and API keys,
+// so a leaked key can't be turned into a full account takeover. Add new routes here when they should be scriptable.
+var apiKeyRouteScopes = map[string]string{
+	"GET /api/auth/me":                 ScopeAccountRead,
+	"GET /api/auth/oidc/identities":    ScopeAccountRead,
+	"GET /api/products":                ScopeProductsRead,
+	"GET /api/products/owned":          ScopeProductsRead,
+	"GET /api/products/:id/price":      ScopeProductsRead,
+	"GET /api/bundles":                 ScopeProductsRead,
+	"GET /api/bundles/:id":             ScopeProductsRead,
+	"GET /api/video":                   ScopeProductsRead,
+	"GET /api/video/:number":           ScopeProductsRead,
+	"GET /api/video/:number/stream":    ScopeProductsRead,
+	"GET /api/purchases":               ScopeProductsRead,
+	"GET /api/refunds":                 ScopeProductsRead,
+	"GET /api/keys":                    ScopeProductsRead,
+	"GET /api/entitlements":            ScopeProductsRead,
+	"GET /api/subscriptions":           ScopeProductsRead,
+	"GET /api/purchases/:id/invoice":   ScopeProductsRead,
+	"GET /api/invoices":                ScopeProductsRead,
+	"GET /api/invoices/:id":            ScopeProductsRead,
+	"POST /api/products/:id/purchase":  ScopePurchasesWrite,
+	"POST /api/bundles/:id/purchase":   ScopePurchasesWrite,
+	"POST /api/products/:id/keys":      ScopePurchasesWrite,
+	"POST /api/keys/redeem":            ScopePurchasesWrite,
+	"POST /api/products/:id/rent":      ScopePurchasesWrite,
+	"POST /api/subscriptions":          ScopePurchasesWrite,
+	"DELETE /api/subscriptions/:id":    ScopePurchasesWrite,
+	"POST /api/products/:id/comments":  ScopeCommentsWrite,
+	"POST /api/video/:number/progress": ScopeProgressWrite,
+	"GET /api/video/watched":           ScopeReportsRead,
+	// catalog maintenance for publishing scripts, the admin role is still required on top of the scope
+	"POST /api/admin/categories":            ScopeProductsWrite,
+	"PUT /api/admin/categories/:id":         ScopeProductsWrite,
+	"DELETE /api/admin/categories/:id":      ScopeProductsWrite,
+	"PUT /api/admin/products/:id/category":  ScopeProductsWrite,
+	"PUT /api/admin/products/:id/tags":      ScopeProductsWrite,
+	"PUT /api/admin/products/:id/rental":    ScopeProductsWrite,
+	"DELETE /api/admin/products/:id/rental": ScopeProductsWrite,
+	"POST /api/admin/bundles":               ScopeProductsWrite,
+	"PUT /api/admin/bundles/:id":            ScopeProductsWrite,
+	"DELETE /api/admin/bundles/:id":         ScopeProductsWrite,
+}
+
+// isAPIKey distinguishes API keys from session tokens
+func isAPIKey(token string) bool {
+	return strings.HasPrefix(token, apiKeyPrefix)
+}
+
+func hashAPIKey(key string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(key)))
+}
+
+// hasScope reports whether the key may be used for the route
+func hasScope(apiKey DatabaseAbstraction.APIKey, route string) bool {
+	required, found := apiKeyRouteScopes[route]
+	if !found {
+		return false
+	}
+	for _, scope := range apiKey.Scopes {
+		if scope == required {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateAPIKey creates a key for the user and returns it, the key itself is only shown this once
+// A nil expiry creates a key that stays valid until it is revoked
+func (am AuthenticationService) CreateAPIKey(userID int, name string, scopes []string, expiry *time.Time) (string, DatabaseAbstraction.APIKey, error) {
+	validationErr := &ValidationError{}
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > maxAPIKeyNameLength {
+		validationErr.add("name", "invalid", fmt.Sprintf("must be between 1 and %d characters long", maxAPIKeyNameLength))
+	}
+	if len(scopes) == 0 {
+		validationErr.add("scopes", "empty", "at least one scope is required")
+	}
+	for _, scope := range scopes {
+		if !knownScopes[scope] {
+			validationErr.add("scopes", "unknown", "unknown scope "+scope)
+		}
+	}
+	if expiry != nil && !expiry.After(time.Now()) {
+		validationErr.add("expires_at", "in_past", "must be in the future")
+	}
+	err := validationErr.errOrNil()
+	if err != nil {
+		return "", DatabaseAbstraction.APIKey{}, err
+	}
+
+	existing, err := am.DB.GetAPIKeysByUserID(userID)
+	if err != nil {
+		return "", DatabaseAbstraction.APIKey{}, err
+	}
+	if len(existing) >= maxAPIKeysPerUser {
+		return "", DatabaseAbstraction.APIKey{}, ErrTooManyAPIKeys
+	}
+
+	randomBytes := make([]byte, 32)
+	_, err = rand.Read(randomBytes)
+	if err != nil {
+		return "", DatabaseAbstraction.APIKey{}, err
+	}
+	key := fmt.Sprintf("%s%x", apiKeyPrefix, randomBytes)
+
+	apiKey := DatabaseAbstraction.APIKey{
+		UserID:    userID,
+		Name:      name,
+		Prefix:    key[:apiKeyDisplayLength],
+		Scopes:    scopes,
+		Expiry:    expiry,
+		CreatedAt: time.Now(),
+	}
+	apiKey.IndexID, err = am.DB.AddAPIKey(apiKey, hashAPIKey(key))
+	if err != nil {
+		return "", DatabaseAbstraction.APIKey{}, err
+	}
+
+	return key, apiKey, nil
+}
+
+// ValidateAPIKey looks up an API key and its user
+func (am AuthenticationService) ValidateAPIKey(key string) (DatabaseAbstraction.APIKey, DatabaseAbstraction.User, error) {
+	apiKey, err := am.DB.GetAPIKeyByHash(hashAPIKey(key))
+	if err != nil {
+		return DatabaseAbstraction.APIKey{}, DatabaseAbstraction.User{}, ErrInvalidAPIKey
+	}
+
+	user, err := am.DB.GetUserByIndexID(apiKey.UserID)
+	if err != nil {
+		return DatabaseAbstraction.APIKey{}, DatabaseAbstraction.User{}, err
+	}
+
+	err = am.DB.TouchAPIKey(apiKey.IndexID)
+	if err != nil {
+		// not worth failing the request for
+		logrus.Errorf("Error updating last use of API key %d: %v", apiKey.IndexID, err)
+	}
+
+	return apiKey, user, nil
+}
+
+// authenticateAPIKey is the part of the middleware handling API keys, it returns false if the request was aborted
+func (am AuthenticationService) authenticateAPIKey(c *gin.Context, key string) (DatabaseAbstraction.User, bool) {
+	apiKey, user, err := am.ValidateAPIKey(key)
+	if err != nil {
+		c.JSON(401, gin.H{"error": "Invalid token"})
+		c.Abort()
+		return DatabaseAbstraction.User{}, false
+	}
+
+	if !hasScope(apiKey, c.Request.Method+" "+c.FullPath()) {
+		c.JSON(403, gin.H{"error": ErrInsufficientScope.Error()})
+		c.Abort()
+		return DatabaseAbstraction.User{}, false
+	}
+
+	c.Set("api_key", apiKey)
+	return user, true
+}
+
+type apiKeyResponse struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+func newAPIKeyResponse(apiKey DatabaseAbstraction.APIKey) apiKeyResponse {
+	return apiKeyResponse{
+		ID:         apiKey.IndexID,
+		Name:       apiKey.Name,
+		Prefix:     apiKey.Prefix,
+		Scopes:     apiKey.Scopes,
+		ExpiresAt:  apiKey.Expiry,
+		LastUsedAt: apiKey.LastUsedAt,
+		CreatedAt:  apiKey.CreatedAt,
+	}
+}
+
+type createAPIKeyRequest struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"` // optional, RFC 3339
+}
+
+type createAPIKeyResponse struct {
+	Key    string         `json:"key"` // only returned on creation
+	APIKey apiKeyResponse `json:"api_key"`
+	Error  string         `json:"error"`
+	Fields []FieldError   `json:"fields,omitempty"`
+}
+
+// CreateAPIKeyHandler godoc
+//
+//	@Summary		Create an API key
+//	@Description	Create a personal API key for scripts, send it as "Authorization: Bearer <key>"
+//	@Description	The key is only returned once. Available scopes: account:read, products:read, products:write,
+//	@Description	purchases:write, comments:write, progress:write, reports:read
+//	@Tags			Authentication
+//	@Accept			json
+//	@Produce		json
+//	@Param			createAPIKeyRequest	body		createAPIKeyRequest	true	"Create API key request"
+//	@Success		200					{object}	createAPIKeyResponse
+//	@Failure		400					{object}	createAPIKeyResponse
+//	@Failure		500					{object}	createAPIKeyResponse
+//	@Security		ApiKeyAuth
+//	@Router			/api/auth/api-keys [post]
+func (am AuthenticationService) CreateAPIKeyHandler(c *gin.Context) {
+	user := c.MustGet("user").(DatabaseAbstraction.User)
+
+	var request createAPIKeyRequest
+	err := c.ShouldBindJSON(&request)
+	if err != nil {
+		c.JSON(400, createAPIKeyResponse{
+			Error: "Invalid request",
+		})
+		return
+	}
+
+	key, apiKey, err := am.CreateAPIKey(user.IndexID, request.Name, request.Scopes, request.ExpiresAt)
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		c.JSON(400, createAPIKeyResponse{
+			Error:  "Invalid API key",
+			Fields: validationErr.Fields,
+		})
+		return
+	}
+	if errors.Is(err, ErrTooManyAPIKeys) {
+		c.JSON(400, createAPIKeyResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, createAPIKeyResponse{
+			Error: "Failed to create API key",
+		})
+		return
+	}
+
+	am.audit(c, AuditLog.ActionAPIKeyCreated, map[string]interface{}{"api_key_id": apiKey.IndexID, "scopes": apiKey.Scopes})
+
+	c.JSON(200, createAPIKeyResponse{
+		Key:    key,
+		APIKey: newAPIKeyResponse(apiKey),
+	})
+}
+
+// GetAPIKeysHandler godoc
+//
+//	@Summary		List API keys
+//	@Description	List the API keys of the current user, the keys themselves are not included
+//	@Tags			Authentication
+//	@Produce		json
+//	@Success		200	{object}	[]apiKeyResponse
+//	@Failure		500	{object}	messageResponse
+//	@Security		ApiKeyAuth
+//	@Router			/api/auth/api-keys [get]
+func (am AuthenticationService) GetAPIKeysHandler(c *gin.Context) {
+	user := c.MustGet("user").(DatabaseAbstraction.User)
+
+	apiKeys, err := am.DB.GetAPIKeysByUserID(user.IndexID)
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, messageResponse{
+			Error: "Failed to get API keys",
+		})
+		return
+	}
+
+	response := make([]apiKeyResponse, len(apiKeys))
+	for i, apiKey := range apiKeys {
+		response[i] = newAPIKeyResponse(apiKey)
+	}
+
+	c.JSON(200, response)
+}
+
+// DeleteAPIKeyHandler godoc
+//
+//	@Summary		Revoke an API key
+//	@Description	Revoke an API key of the current user
+//	@Tags			Authentication
+//	@Produce		json
+//	@Param			id	path		int	true	"API key ID"
+//	@Success		200	{object}	messageResponse
+//	@Failure		400	{object}	messageResponse
+//	@Failure		404	{object}	messageResponse
+//	@Failure		500	{object}	messageResponse
+//	@Security		ApiKeyAuth
+//	@Router			/api/auth/api-keys/{id} [delete]
+func (am AuthenticationService) DeleteAPIKeyHandler(c *gin.Context) {
+	user := c.MustGet("user").(DatabaseAbstraction.User)
+
+	keyID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, messageResponse{
+			Error: "Invalid API key ID",
+		})
+		return
+	}
+
+	err = am.DB.DeleteAPIKey(user.IndexID, keyID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(404, messageResponse{
+			Error: "API key not found",
+		})
+		return
+	}
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, messageResponse{
+			Error: "Failed to revoke API key",
+		})
+		return
+	}
+
+	am.audit(c, AuditLog.ActionAPIKeyRevoked, map[string]interface{}{"api_key_id": keyID})
+
+	c.JSON(200, messageResponse{
+		Message: "API key revoked",
+	})
+}
@@ -0,0 +1,91 @@
+package AuthenticationManagement
+
+import (
+	"EntitlementServer/DatabaseAbstraction"
+	"EntitlementServer/DatabaseAbstraction/mocks"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCreateAPIKey(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	am := AuthenticationService{DB: mockDB}
+
+	var storedHash string
+	mockDB.On("GetAPIKeysByUserID", 1).Return([]DatabaseAbstraction.APIKey{}, nil)
+	mockDB.On("AddAPIKey", mock.AnythingOfType("DatabaseAbstraction.APIKey"), mock.AnythingOfType("string")).Run(func(args mock.Arguments) {
+		storedHash = args.String(1)
+	}).Return(3, nil)
+
+	past := time.Now().Add(-time.Hour)
+	_, _, err := am.CreateAPIKey(1, " ", []string{"everything"}, &past)
+	var validationErr *ValidationError
+	if assert.ErrorAs(t, err, &validationErr) {
+		assert.Len(t, validationErr.Fields, 3)
+	}
+
+	key, apiKey, err := am.CreateAPIKey(1, "publishing script", []string{ScopeProductsWrite}, nil)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(key, apiKeyPrefix))
+	assert.True(t, strings.HasPrefix(key, apiKey.Prefix))
+	assert.Equal(t, 3, apiKey.IndexID)
+	// only the hash reaches the database
+	assert.Equal(t, hashAPIKey(key), storedHash)
+}
+
+func TestAPIKeyScopes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockDB := new(mocks.DBOrm)
+	am := AuthenticationService{DB: mockDB}
+
+	key := apiKeyPrefix + "0123456789abcdef"
+	mockDB.On("GetAPIKeyByHash", hashAPIKey(key)).Return(DatabaseAbstraction.APIKey{IndexID: 3, UserID: 1, Scopes: []string{ScopeAccountRead}}, nil)
+	mockDB.On("GetAPIKeyByHash", mock.AnythingOfType("string")).Return(DatabaseAbstraction.APIKey{}, errNoRows)
+	mockDB.On("GetUserByIndexID", 1).Return(DatabaseAbstraction.User{IndexID: 1, Username: "teacher"}, nil)
+	mockDB.On("TouchAPIKey", 3).Return(nil)
+
+	r := gin.Default()
+	am.RegisterHandlers(r)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		key    string
+		status int
+	}{
+		{"allowed by scope", "GET", "/api/auth/me", key, 200},
+		{"no key management with keys", "GET", "/api/auth/api-keys", key, 403},
+		{"no password change with keys", "POST", "/api/auth/password", key, 403},
+		{"unknown key", "GET", "/api/auth/me", apiKeyPrefix + "unknown", 401},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(test.method, test.path, nil)
+			req.Header.Set("Authorization", "Bearer "+test.key)
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, test.status, w.Code)
+		})
+	}
+
+	mockDB.AssertNotCalled(t, "GetTokenByHash", mock.Anything)
+	mockDB.AssertCalled(t, "TouchAPIKey", 3)
+}
+
+func TestProductsWriteScope(t *testing.T) {
+	publishing := DatabaseAbstraction.APIKey{Scopes: []string{ScopeProductsWrite}}
+	assert.True(t, hasScope(publishing, "PUT /api/admin/products/:id/tags"))
+	assert.True(t, hasScope(publishing, "POST /api/admin/bundles"))
+	// the rest of the admin API stays out of reach
+	assert.False(t, hasScope(publishing, "POST /api/admin/users/:id/balance"))
+	assert.False(t, hasScope(publishing, "POST /api/admin/refunds/:id/approve"))
+	assert.False(t, hasScope(DatabaseAbstraction.APIKey{Scopes: []string{ScopeProductsRead}}, "PUT /api/admin/products/:id/tags"))
+}
@@ -0,0 +1,272 @@
+package AuthenticationManagement
+
+import (
+	"EntitlementServer/DatabaseAbstraction"
+	"EntitlementServer/JWTManagement"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// AccessTokenAudience is the aud claim of access tokens, services verifying them should check it
+	AccessTokenAudience = "bkbdemy-api"
+	// revocations made by other instances are picked up within this time
+	revocationRefreshInterval = 30 * time.Second
+)
+
+var ErrTokenRevoked = errors.New("token has been revoked")
+
+// AccessTokenClaims are the claims of stateless access tokens, the subject is the user ID
+type AccessTokenClaims struct {
+	JWTManagement.RegisteredClaims
+	Username string       `json:"username"`
+	Roles    []string     `json:"roles"`
+	Actor    *ActorClaims `json:"act,omitempty"` // set if an admin impersonates the subject
+}
+
+// ActorClaims identify who acts on behalf of the subject (RFC 8693), the subject is the admin's user ID
+type ActorClaims struct {
+	Subject string `json:"sub"`
+}
+
+// AccessTokenKeysFromEnv enables stateless access tokens if TOKEN_FORMAT is "jwt", it returns nil for database tokens
+// JWT_SIGNING_KEYS is a comma separated list of PEM private key files, the first one signs new tokens and the others
+// are only kept for verification during a key rotation
+// Without JWT_SIGNING_KEYS a random key is used, which invalidates all tokens on restart
+func AccessTokenKeysFromEnv() (*JWTManagement.KeyRing, error) {
+	switch os.Getenv("TOKEN_FORMAT") {
+	case "", "opaque":
+		return nil, nil
+	case "jwt":
+	default:
+		return nil, fmt.Errorf("TOKEN_FORMAT must be opaque or jwt")
+	}
+
+	var paths []string
+	for _, path := range strings.Split(os.Getenv("JWT_SIGNING_KEYS"), ",") {
+		if path = strings.TrimSpace(path); path != "" {
+			paths = append(paths, path)
+		}
+	}
+	if len(paths) > 0 {
+		return JWTManagement.LoadKeyRing(paths)
+	}
+
+	logrus.Warn("JWT_SIGNING_KEYS is not set, using a random key")
+	key, err := JWTManagement.GenerateSigningKey()
+	if err != nil {
+		return nil, err
+	}
+	return &JWTManagement.KeyRing{Keys: []JWTManagement.SigningKey{key}}, nil
+}
+
+// RevocationList caches the revoked_tokens table, so validating an access token doesn't need a query per request
+type RevocationList struct {
+	mutex     sync.Mutex
+	tokens    map[string]bool
+	users     map[int]time.Time // tokens of the user issued before this time are revoked
+	refreshed time.Time
+}
+
+func NewRevocationList() *RevocationList {
+	return &RevocationList{}
+}
+
+func (l *RevocationList) refresh(db DatabaseAbstraction.DBOrm) error {
+	revokedTokens, err := db.GetRevokedTokens()
+	if err != nil {
+		return err
+	}
+
+	l.tokens = map[string]bool{}
+	l.users = map[int]time.Time{}
+	for _, revoked := range revokedTokens {
+		l.add(revoked)
+	}
+	l.refreshed = time.Now()
+	return nil
+}
+
+func (l *RevocationList) add(revoked DatabaseAbstraction.RevokedToken) {
+	if revoked.TokenID != "" {
+		l.tokens[revoked.TokenID] = true
+		return
+	}
+	if revoked.RevokedAt.After(l.users[revoked.UserID]) {
+		l.users[revoked.UserID] = revoked.RevokedAt
+	}
+}
+
+// IsRevoked reports whether the token was revoked by logout or a revocation of all tokens of its user
+func (l *RevocationList) IsRevoked(db DatabaseAbstraction.DBOrm, claims AccessTokenClaims, userID int) (bool, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if time.Since(l.refreshed) > revocationRefreshInterval {
+		err := l.refresh(db)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if l.tokens[claims.ID] {
+		return true, nil
+	}
+	// iat only has second precision, a token issued in the same second as the revocation stays valid,
+	// otherwise the token handed out right after a password change would be revoked as well
+	revokedBefore, found := l.users[userID]
+	return found && claims.IssuedAt < revokedBefore.Unix(), nil
+}
+
+// Revoke stores the entry and applies it to this instance immediately
+func (l *RevocationList) Revoke(db DatabaseAbstraction.DBOrm, revoked DatabaseAbstraction.RevokedToken) error {
+	err := db.AddRevokedToken(revoked)
+	if err != nil {
+		return err
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.tokens != nil {
+		l.add(revoked)
+	}
+	return nil
+}
+
+// revocations returns the shared revocation list, without one the table is read on every check
+func (am AuthenticationService) revocations() *RevocationList {
+	if am.Revocations == nil {
+		return NewRevocationList()
+	}
+	return am.Revocations
+}
+
+// isAccessToken distinguishes JWTs from the hex encoded database tokens
+func isAccessToken(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+// createAccessToken signs an access token for the user, impersonatorID is 0 unless an admin acts as the user
+func (am AuthenticationService) createAccessToken(user DatabaseAbstraction.User, impersonatorID int, lifetime time.Duration) (string, error) {
+	tokenID := make([]byte, 16)
+	_, err := rand.Read(tokenID)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	claims := AccessTokenClaims{
+		RegisteredClaims: JWTManagement.RegisteredClaims{
+			Issuer:    am.publicURL(),
+			Subject:   strconv.Itoa(user.IndexID),
+			Audience:  JWTManagement.Audience{AccessTokenAudience},
+			ExpiresAt: now.Add(lifetime).Unix(),
+			IssuedAt:  now.Unix(),
+			ID:        fmt.Sprintf("%x", tokenID),
+		},
+		Username: user.Username,
+		Roles:    []string{user.Role},
+	}
+	if impersonatorID != 0 {
+		claims.Actor = &ActorClaims{Subject: strconv.Itoa(impersonatorID)}
+	}
+
+	return am.AccessTokenKeys.Sign(claims)
+}
+
+// parseAccessToken verifies signature, issuer, audience, expiry and revocation of an access token
+func (am AuthenticationService) parseAccessToken(token string) (AccessTokenClaims, int, error) {
+	var claims AccessTokenClaims
+	_, err := JWTManagement.Verify(token, am.AccessTokenKeys.KeyFunc, &claims)
+	if err != nil {
+		return AccessTokenClaims{}, 0, err
+	}
+
+	err = claims.Validate(am.publicURL(), AccessTokenAudience, time.Now(), 0)
+	if err != nil {
+		return AccessTokenClaims{}, 0, err
+	}
+
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return AccessTokenClaims{}, 0, JWTManagement.ErrMalformedToken
+	}
+
+	revoked, err := am.revocations().IsRevoked(am.DB, claims, userID)
+	if err != nil {
+		return AccessTokenClaims{}, 0, err
+	}
+	if revoked {
+		return AccessTokenClaims{}, 0, ErrTokenRevoked
+	}
+
+	return claims, userID, nil
+}
+
+// RevokeToken ends the session of a token, access tokens are put on the revocation list until they expire
+func (am AuthenticationService) RevokeToken(token string) error {
+	if am.AccessTokenKeys == nil || !isAccessToken(token) {
+		return am.DB.DeleteTokenByHash(token)
+	}
+
+	claims, userID, err := am.parseAccessToken(token)
+	if err != nil {
+		// expired or already revoked, nothing left to do
+		return nil
+	}
+
+	return am.revocations().Revoke(am.DB, DatabaseAbstraction.RevokedToken{
+		TokenID:   claims.ID,
+		UserID:    userID,
+		RevokedAt: time.Now(),
+		Expiry:    time.Unix(claims.ExpiresAt, 0),
+	})
+}
+
+// RevokeAllTokens ends every session and revokes every API key of a user, e.g. after a password change
+func (am AuthenticationService) RevokeAllTokens(userID int) error {
+	err := am.DB.DeleteTokensByUserID(userID)
+	if err != nil {
+		return err
+	}
+
+	err = am.DB.DeleteAPIKeysByUserID(userID)
+	if err != nil {
+		return err
+	}
+
+	if am.AccessTokenKeys == nil {
+		return nil
+	}
+
+	now := time.Now()
+	return am.revocations().Revoke(am.DB, DatabaseAbstraction.RevokedToken{
+		UserID:    userID,
+		RevokedAt: now,
+		Expiry:    now.Add(tokenLifetime), // no access token lives longer than a regular session
+	})
+}
+
+// JWKSHandler godoc
+//
+//	@Summary		Access token verification keys
+//	@Description	Public keys for verifying access tokens (JWKS, RFC 7517), select the key by the kid header of the token
+//	@Description	Tokens are issued with iss set to the public URL and aud set to bkbdemy-api
+//	@Description	The key set is empty if access tokens are not enabled
+//	@Tags			Authentication
+//	@Produce		json
+//	@Success		200	{object}	JWTManagement.KeySet
+//	@Router			/.well-known/jwks.json [get]
+func (am AuthenticationService) JWKSHandler(c *gin.Context) {
+	// short enough that verifiers see a new key soon after a rotation
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(200, am.AccessTokenKeys.KeySet())
+}
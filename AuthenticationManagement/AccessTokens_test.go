@@ -0,0 +1,128 @@
+package AuthenticationManagement
+
+import (
+	"EntitlementServer/DatabaseAbstraction"
+	"EntitlementServer/DatabaseAbstraction/mocks"
+	"EntitlementServer/JWTManagement"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newAccessTokenService(t *testing.T) (AuthenticationService, *mocks.DBOrm) {
+	key, err := JWTManagement.GenerateSigningKey()
+	assert.NoError(t, err)
+
+	mockDB := new(mocks.DBOrm)
+	mockDB.On("GetUserByIndexID", 5).Return(DatabaseAbstraction.User{IndexID: 5, Username: "student", Role: DatabaseAbstraction.RoleStudent}, nil)
+
+	return AuthenticationService{
+		DB:              mockDB,
+		AccessTokenKeys: &JWTManagement.KeyRing{Keys: []JWTManagement.SigningKey{key}},
+		Revocations:     NewRevocationList(),
+	}, mockDB
+}
+
+func TestAccessTokenLogout(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	am, mockDB := newAccessTokenService(t)
+
+	var revoked DatabaseAbstraction.RevokedToken
+	mockDB.On("GetRevokedTokens").Return(nil, nil)
+	mockDB.On("AddRevokedToken", mock.AnythingOfType("DatabaseAbstraction.RevokedToken")).Run(func(args mock.Arguments) {
+		revoked = args.Get(0).(DatabaseAbstraction.RevokedToken)
+	}).Return(nil)
+
+	token, err := am.CreateToken(5)
+	assert.NoError(t, err)
+	assert.True(t, isAccessToken(token))
+
+	var claims AccessTokenClaims
+	_, err = JWTManagement.Verify(token, am.AccessTokenKeys.KeyFunc, &claims)
+	assert.NoError(t, err)
+	assert.Equal(t, "5", claims.Subject)
+	assert.Equal(t, []string{DatabaseAbstraction.RoleStudent}, claims.Roles)
+
+	valid, user, err := am.ValidateToken(token)
+	assert.NoError(t, err)
+	assert.True(t, valid)
+	assert.Equal(t, 5, user.IndexID)
+	mockDB.AssertNotCalled(t, "AddToken", mock.Anything, mock.Anything, mock.Anything)
+	mockDB.AssertNotCalled(t, "GetTokenByHash", mock.Anything)
+
+	r := gin.Default()
+	am.RegisterHandlers(r)
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/auth/logout", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, claims.ID, revoked.TokenID)
+	mockDB.AssertNotCalled(t, "DeleteTokenByHash", mock.Anything)
+
+	_, _, err = am.ValidateToken(token)
+	assert.ErrorIs(t, err, ErrTokenRevoked)
+
+	// other instances pick the revocation up from the database
+	otherInstance := am
+	otherInstance.Revocations = NewRevocationList()
+	mockDB.ExpectedCalls = nil
+	mockDB.On("GetRevokedTokens").Return([]DatabaseAbstraction.RevokedToken{revoked}, nil)
+	_, _, err = otherInstance.ValidateToken(token)
+	assert.ErrorIs(t, err, ErrTokenRevoked)
+}
+
+func TestRevokeAllAccessTokens(t *testing.T) {
+	am, mockDB := newAccessTokenService(t)
+
+	token, err := am.CreateToken(5)
+	assert.NoError(t, err)
+
+	// a revocation in the same second as the token was issued keeps it valid,
+	// that is the token handed out right after a password change
+	mockDB.On("GetRevokedTokens").Return([]DatabaseAbstraction.RevokedToken{{UserID: 5, RevokedAt: time.Now()}}, nil).Once()
+	valid, _, err := am.ValidateToken(token)
+	assert.NoError(t, err)
+	assert.True(t, valid)
+
+	var revoked DatabaseAbstraction.RevokedToken
+	mockDB.On("DeleteTokensByUserID", 5).Return(nil)
+	mockDB.On("DeleteAPIKeysByUserID", 5).Return(nil)
+	mockDB.On("AddRevokedToken", mock.AnythingOfType("DatabaseAbstraction.RevokedToken")).Run(func(args mock.Arguments) {
+		revoked = args.Get(0).(DatabaseAbstraction.RevokedToken)
+	}).Return(nil)
+
+	err = am.RevokeAllTokens(5)
+	assert.NoError(t, err)
+	mockDB.AssertCalled(t, "DeleteTokensByUserID", 5)
+	// scripts lose access as well
+	mockDB.AssertCalled(t, "DeleteAPIKeysByUserID", 5)
+	assert.Equal(t, 5, revoked.UserID)
+	assert.Empty(t, revoked.TokenID)
+
+	// tokens issued before a later revocation are rejected
+	err = am.Revocations.Revoke(mockDB, DatabaseAbstraction.RevokedToken{UserID: 5, RevokedAt: time.Now().Add(2 * time.Second)})
+	assert.NoError(t, err)
+	_, _, err = am.ValidateToken(token)
+	assert.ErrorIs(t, err, ErrTokenRevoked)
+}
+
+func TestJWKSHandler(t *testing.T) {
+	am, _ := newAccessTokenService(t)
+
+	r := gin.Default()
+	am.RegisterHandlers(r)
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/.well-known/jwks.json", nil)
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `"kid":"`+am.AccessTokenKeys.Keys[0].KeyID+`"`)
+	assert.Contains(t, w.Body.String(), `"crv":"Ed25519"`)
+	assert.NotContains(t, w.Body.String(), `"d"`)
+}
@@ -0,0 +1,30 @@
+package AuthenticationManagement
+
+import (
+	"EntitlementServer/AuditLog"
+	"EntitlementServer/DatabaseAbstraction"
+	"github.com/gin-gonic/gin"
+)
+
+// audit records a successful action of the signed-in user
+func (am AuthenticationService) audit(c *gin.Context, action string, details map[string]interface{}) {
+	event := AuditLog.NewEvent(c, action)
+	for key, value := range details {
+		event.Details[key] = value
+	}
+	AuditLog.OrDefault(am.Audit).Record(event)
+}
+
+// auditLogin records a login attempt, nobody is signed in yet so the user is set explicitly
+// Failures keep the attempted username, which makes password guessing against an account visible
+func (am AuthenticationService) auditLogin(c *gin.Context, user DatabaseAbstraction.User, username string, method string, failure string) {
+	event := AuditLog.NewEvent(c, AuditLog.ActionLogin)
+	event.ActorID = user.IndexID
+	event.Details["method"] = method
+	if failure != "" {
+		event.Outcome = AuditLog.OutcomeFailure
+		event.Details["username"] = username
+		event.Details["reason"] = failure
+	}
+	AuditLog.OrDefault(am.Audit).Record(event)
+}
@@ -0,0 +1,62 @@
+package AuthenticationManagement
+
+import (
+	"EntitlementServer/AuditLog"
+	"EntitlementServer/DatabaseAbstraction"
+	"EntitlementServer/DatabaseAbstraction/mocks"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFailedLoginIsAudited(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	am := AuthenticationService{DB: mockDB, Audit: AuditLog.DBAuditor{DB: mockDB}}
+	mockDB.On("GetUserByUsername", "nobody").Return(DatabaseAbstraction.User{}, pgx.ErrNoRows)
+	mockDB.On("AddAuditEvent", mock.MatchedBy(func(event DatabaseAbstraction.AuditEvent) bool {
+		return event.Action == AuditLog.ActionLogin && event.Outcome == AuditLog.OutcomeFailure &&
+			event.ActorID == 0 && event.IP == "192.0.2.10" && event.Details["username"] == "nobody"
+	})).Return(nil).Once()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/api/auth/login", am.Login)
+
+	req, _ := http.NewRequest("POST", "/api/auth/login", strings.NewReader(`{"username":"nobody","password":"guess"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.RemoteAddr = "192.0.2.10:4711"
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	mockDB.AssertExpectations(t)
+}
+
+func TestEndingImpersonationIsAudited(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	am := AuthenticationService{DB: mockDB, Audit: AuditLog.DBAuditor{DB: mockDB}}
+	mockDB.On("GetTokenByHash", "impersonation_token").Return(DatabaseAbstraction.Token{UserID: 5, ImpersonatorID: 1}, nil)
+	mockDB.On("GetUserByIndexID", 5).Return(impersonatedUser, nil)
+	mockDB.On("GetUserByIndexID", 1).Return(impersonatingAdmin, nil)
+	mockDB.On("DeleteTokenByHash", "impersonation_token").Return(nil)
+	mockDB.On("AddAuditEvent", mock.MatchedBy(func(event DatabaseAbstraction.AuditEvent) bool {
+		return event.Action == AuditLog.ActionImpersonationStopped && event.ActorID == 5 && event.ImpersonatorID == 1
+	})).Return(nil).Once()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	am.RegisterHandlers(router)
+
+	req, _ := http.NewRequest("POST", "/api/auth/logout", nil)
+	req.Header.Set("Authorization", "Bearer impersonation_token")
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusOK, resp.Code)
+	mockDB.AssertExpectations(t)
+}
@@ -1,25 +1,83 @@
 package AuthenticationManagement
 
 import (
+	"EntitlementServer/AuditLog"
 	"EntitlementServer/DatabaseAbstraction"
+	"EntitlementServer/JWTManagement"
+	"EntitlementServer/MailManagement"
+	"errors"
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type AuthenticationManager interface {
 	AuthenticateUser(username string, password string) (bool, error)
 	CreateToken(userid int) (string, error)
 	ValidateToken(token string) (bool, DatabaseAbstraction.User, error)
-	CreateUser(username string, password string) error
+	RevokeToken(token string) error
+	RevokeAllTokens(userID int) error
+	CreateImpersonationToken(user DatabaseAbstraction.User, impersonator DatabaseAbstraction.User) (string, time.Time, error)
+	CreateAPIKey(userID int, name string, scopes []string, expiry *time.Time) (string, DatabaseAbstraction.APIKey, error)
+	ValidateAPIKey(key string) (DatabaseAbstraction.APIKey, DatabaseAbstraction.User, error)
+	CreateUser(username string, password string, email string) error
+	ValidateUsername(username string) error
+	ValidatePassword(password string, username string) error
+	ChangePassword(userID int, newPassword string) error
+	UpdateProfile(user DatabaseAbstraction.User, update ProfileUpdate) (DatabaseAbstraction.User, error)
+	DeleteAccount(user DatabaseAbstraction.User, password string) error
+	RequestPasswordReset(username string) error
+	ResetPassword(token string, newPassword string) error
+	SetEmail(user DatabaseAbstraction.User, email string) error
+	SendVerificationMail(user DatabaseAbstraction.User) error
+	VerifyEmail(token string) error
+	EnrollTOTP(user DatabaseAbstraction.User) (string, error)
+	ActivateTOTP(user DatabaseAbstraction.User, code string) ([]string, error)
+	DisableTOTP(user DatabaseAbstraction.User, password string, code string) error
+	StartTwoFactorLogin(user DatabaseAbstraction.User) (string, error)
 	HashPassword(password string) (string, error)
 	ComparePasswords(hashedPassword string, password string) (bool, error)
+	NeedsRehash(hashedPassword string) bool
 	AuthenticationMiddleware(c *gin.Context)
+	OptionalAuthenticationMiddleware(c *gin.Context)
+	SetAuthCookie(c *gin.Context, token string)
 }
 
 type AuthenticationService struct {
-	DB DatabaseAbstraction.DBOrm
+	DB         DatabaseAbstraction.DBOrm
+	HashParams Argon2Params          // parameters for new password hashes, DefaultArgon2Params if empty
+	Mailer     MailManagement.Mailer // delivers password reset and verification mails, mails are logged if nil
+	PublicURL  string                // base URL of the frontend for links in mails
+	SigningKey []byte                // HMAC key for signed links
+
+	// RequireEmailVerification makes an email address mandatory on registration,
+	// the ProductService refuses purchases until it is verified
+	RequireEmailVerification bool
+
+	Policy *RegistrationPolicy // username and password rules, DefaultRegistrationPolicy if nil
+
+	// TwoFactorRequiredRoles lists roles that can't use the API beyond enrollment without two-factor authentication
+	TwoFactorRequiredRoles []string
+
+	// AccessTokenKeys makes CreateToken issue stateless JWT access tokens instead of database tokens,
+	// other services can verify them with the keys published at /.well-known/jwks.json
+	AccessTokenKeys *JWTManagement.KeyRing
+	Revocations     *RevocationList // logged out access tokens, shared by all copies of the service
+
+	Cookies *CookieConfig // attributes of the authtoken cookie, DefaultCookieConfig if nil
+	// TrustedOrigins may send cookie-authenticated POSTs in addition to the origin of PublicURL
+	TrustedOrigins []string
+
+	Audit AuditLog.Auditor // records logins and account changes
+}
+
+// OptionalAuthenticationMiddleware signs in the user like AuthenticationMiddleware if the request carries a valid token,
+// requests without one continue anonymously. Public routes use it to personalize their responses.
+// An expired or revoked session is treated like no token at all, so a stale cookie can't lock the user out of public pages
+func (am AuthenticationService) OptionalAuthenticationMiddleware(c *gin.Context) {
+	am.authenticate(c, true)
 }
 
 type NotSignedInResponse struct {
@@ -27,6 +85,11 @@ type NotSignedInResponse struct {
 }
 
 func (am AuthenticationService) AuthenticationMiddleware(c *gin.Context) {
+	am.authenticate(c, false)
+}
+
+// authenticate is the shared body of both middlewares, optional lets requests without a valid session continue anonymously
+func (am AuthenticationService) authenticate(c *gin.Context, optional bool) {
 	// Check token in autorization header
 	// Populate user in context
 
@@ -34,41 +97,128 @@ func (am AuthenticationService) AuthenticationMiddleware(c *gin.Context) {
 	token := c.GetHeader("Authorization")
 	token = strings.TrimPrefix(token, "Bearer ")
 
+	fromCookie := false
 	if token == "" {
-		// Get token from cookie
-		token, _ = c.Cookie("authtoken")
+		// Get token from cookie, browsers send it along with cross-site requests as well
+		token, _ = c.Cookie(am.authCookieName())
+		fromCookie = token != ""
+		if fromCookie && am.checkCSRF(c) != nil {
+			c.JSON(403, gin.H{"error": "Cross-site request rejected"})
+			c.Abort()
+			return
+		}
 	}
 
 	if token == "" {
+		if optional {
+			c.Next()
+			return
+		}
 		c.JSON(401, gin.H{"error": "Invalid token"})
+		c.Abort()
 		return
 	}
 
-	// Validate the token
-	valid, user, err := am.ValidateToken(token)
+	var user DatabaseAbstraction.User
+	impersonatorID := 0
+	if isAPIKey(token) {
+		// API keys are restricted to the routes their scopes allow
+		var ok bool
+		user, ok = am.authenticateAPIKey(c, token)
+		if !ok {
+			return
+		}
+	} else {
+		// Validate the token
+		sessionUser, sessionImpersonatorID, err := am.authenticateToken(token)
+
+		if optional && (err != nil || sessionUser.IndexID == 0 || sessionUser.Deleted) {
+			// drop the stale cookie, so the browser stops sending it
+			if fromCookie {
+				am.clearAuthCookie(c)
+			}
+			c.Next()
+			return
+		}
+
+		if err != nil {
+			c.JSON(401, gin.H{"error": "Invalid token"})
+			c.Abort()
+			return
+		}
+
+		if sessionUser.IndexID == 0 {
+			c.JSON(401, gin.H{"error": "Invalid token"})
+			c.Abort()
+			return
+		}
+		user = sessionUser
+		impersonatorID = sessionImpersonatorID
+	}
 
-	if err != nil {
+	// deleting an account revokes its tokens, this also catches tokens of other instances' caches
+	if user.Deleted {
 		c.JSON(401, gin.H{"error": "Invalid token"})
 		c.Abort()
 		return
 	}
 
-	if !valid {
-		c.JSON(401, gin.H{"error": "Invalid token"})
+	if user.Suspended {
+		c.JSON(403, gin.H{"error": ErrAccountSuspended.Error()})
+		c.Abort()
+		return
+	}
+
+	if am.twoFactorSetupMissing(user) && !twoFactorSetupPaths[c.FullPath()] {
+		c.JSON(403, gin.H{"error": "Two-factor authentication has to be set up for this account"})
 		c.Abort()
 		return
 	}
 
-	// Set the user in the context
+	// Impersonated sessions are read-only, the admin is available as "impersonator" next to the user
+	if impersonatorID != 0 {
+		impersonator, err := am.authenticateImpersonator(impersonatorID)
+		if err != nil {
+			c.JSON(401, gin.H{"error": "Invalid token"})
+			c.Abort()
+			return
+		}
+		if !impersonationAllowed(c) {
+			c.JSON(403, gin.H{"error": ErrImpersonationRestricted.Error()})
+			c.Abort()
+			return
+		}
+		c.Set("impersonator", impersonator)
+	}
+
+	// Set the user in the context, the token is needed to end the session on logout
 	c.Set("user", user)
+	c.Set("token", token)
 	c.Next()
 }
 
 func (am AuthenticationService) RegisterHandlers(r *gin.Engine, _ ...gin.HandlerFunc) {
 	r.POST("/api/auth/login", am.Login)
+	r.POST("/api/auth/login/2fa", am.LoginTwoFactorHandler)
 	r.GET("/api/auth/me", am.AuthenticationMiddleware, am.GetUserHandler)
+	r.PATCH("/api/auth/me", am.AuthenticationMiddleware, am.UpdateProfileHandler)
+	r.DELETE("/api/auth/me", am.AuthenticationMiddleware, am.DeleteAccountHandler)
 	r.POST("/api/auth/logout", am.AuthenticationMiddleware, am.LogoutHandler)
+	r.POST("/api/auth/impersonation/stop", am.AuthenticationMiddleware, am.StopImpersonationHandler)
 	r.POST("/api/auth/register", am.RegisterUserHandler)
+	r.POST("/api/auth/password", am.AuthenticationMiddleware, am.ChangePasswordHandler)
+	r.POST("/api/auth/password/reset/request", am.RequestPasswordResetHandler)
+	r.POST("/api/auth/password/reset", am.ResetPasswordHandler)
+	r.POST("/api/auth/email", am.AuthenticationMiddleware, am.UpdateEmailHandler)
+	r.POST("/api/auth/email/resend", am.AuthenticationMiddleware, am.ResendVerificationHandler)
+	r.POST("/api/auth/email/verify", am.VerifyEmailHandler)
+	r.POST("/api/auth/2fa/enroll", am.AuthenticationMiddleware, am.EnrollTOTPHandler)
+	r.POST("/api/auth/2fa/activate", am.AuthenticationMiddleware, am.ActivateTOTPHandler)
+	r.POST("/api/auth/2fa/disable", am.AuthenticationMiddleware, am.DisableTOTPHandler)
+	r.GET("/.well-known/jwks.json", am.JWKSHandler)
+	r.POST("/api/auth/api-keys", am.AuthenticationMiddleware, am.CreateAPIKeyHandler)
+	r.GET("/api/auth/api-keys", am.AuthenticationMiddleware, am.GetAPIKeysHandler)
+	r.DELETE("/api/auth/api-keys/:id", am.AuthenticationMiddleware, am.DeleteAPIKeyHandler)
 	r.POST("/api/auth/increase_balance/:amount", am.AuthenticationMiddleware, am.IncreaseBalanceHandler)
 }
 
@@ -77,18 +227,26 @@ func (am AuthenticationService) GetLabel() string {
 }
 
 type loginRequest struct {
-	Username string `json:"username"`
+	Username string `json:"username"` // username or email address
 	Password string `json:"password"`
 }
 type loginResponse struct {
-	Token string `json:"token"`
-	Error string `json:"error"`
+	Token  string       `json:"token"`
+	Error  string       `json:"error"`
+	Fields []FieldError `json:"fields,omitempty"` // per-field validation errors
+
+	// Set instead of Token if the user has two-factor authentication enabled,
+	// the login is completed by sending Challenge with a code to /api/auth/login/2fa
+	TwoFactorRequired bool   `json:"two_factor_required,omitempty"`
+	Challenge         string `json:"challenge,omitempty"`
 }
 
 // Login godoc
 //
 //	@Summary		Login to the application and get a token
 //	@Description	Login to the application and get a token, token is valid for 7 days
+//	@Description	username may also be the email address of the account
+//	@Description	For accounts with two-factor authentication no token is returned, but a challenge for /api/auth/login/2fa
 //	@Description	error is empty if login was successful
 //	@Tags			Authentication
 //	@Accept			json
@@ -120,6 +278,7 @@ func (am AuthenticationService) Login(ctx *gin.Context) {
 
 	valid, err := am.AuthenticateUser(request.Username, request.Password)
 	if err != nil {
+		am.auditLogin(ctx, DatabaseAbstraction.User{}, request.Username, "password", "invalid credentials")
 		ctx.JSON(401, loginResponse{
 			Token: "",
 			Error: "Invalid username or password",
@@ -128,6 +287,7 @@ func (am AuthenticationService) Login(ctx *gin.Context) {
 	}
 
 	if !valid {
+		am.auditLogin(ctx, DatabaseAbstraction.User{}, request.Username, "password", "invalid credentials")
 		ctx.JSON(401, loginResponse{
 			Token: "",
 			Error: "Invalid username or password",
@@ -138,7 +298,41 @@ func (am AuthenticationService) Login(ctx *gin.Context) {
 	// Now we need to create a token for the user
 
 	// Get the user from the database
-	user, err := am.DB.GetUserByUsername(request.Username)
+	user, err := am.lookupUser(request.Username)
+	if err != nil {
+		ctx.JSON(500, loginResponse{
+			Token: "",
+			Error: "Failed to get user",
+		})
+		return
+	}
+
+	if user.Suspended {
+		am.auditLogin(ctx, user, request.Username, "password", "account suspended")
+		ctx.JSON(403, loginResponse{
+			Token: "",
+			Error: ErrAccountSuspended.Error(),
+		})
+		return
+	}
+
+	if user.TOTPEnabled {
+		challenge, err := am.createTwoFactorChallenge(user)
+		if err != nil {
+			logrus.Error(err)
+			ctx.JSON(500, loginResponse{
+				Token: "",
+				Error: "Failed to create login challenge",
+			})
+			return
+		}
+
+		ctx.JSON(200, loginResponse{
+			TwoFactorRequired: true,
+			Challenge:         challenge,
+		})
+		return
+	}
 
 	userToken, err := am.CreateToken(user.IndexID)
 	if err != nil {
@@ -149,7 +343,8 @@ func (am AuthenticationService) Login(ctx *gin.Context) {
 		return
 	}
 
-	ctx.SetCookie("authtoken", userToken, 7*24*60*60, "", "", true, true)
+	am.SetAuthCookie(ctx, userToken)
+	am.auditLogin(ctx, user, request.Username, "password", "")
 
 	ctx.JSON(200, loginResponse{
 		Token: userToken,
@@ -158,11 +353,39 @@ func (am AuthenticationService) Login(ctx *gin.Context) {
 }
 
 type meResponse struct {
-	ID        int    `json:"id"`
-	Username  string `json:"username"`
-	Balance   int    `json:"balance"`
-	CreatedAt string `json:"created_at"`
-	Points    int    `json:"points"`
+	ID            int    `json:"id"`
+	Username      string `json:"username"`
+	DisplayName   string `json:"display_name"`
+	AvatarURL     string `json:"avatar_url"`
+	Locale        string `json:"locale"`
+	Country       string `json:"country"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Role          string `json:"role"`
+	TwoFactor     bool   `json:"two_factor_enabled"`
+	Balance       int    `json:"balance"`
+	CreatedAt     string `json:"created_at"`
+	Points        int    `json:"points"`
+	// ImpersonatedBy is the ID of the admin using this session, so the frontend can show it
+	ImpersonatedBy int `json:"impersonated_by,omitempty"`
+}
+
+func newMeResponse(user DatabaseAbstraction.User) meResponse {
+	return meResponse{
+		ID:            user.IndexID,
+		Username:      user.Username,
+		DisplayName:   user.DisplayName,
+		AvatarURL:     user.AvatarURL,
+		Locale:        user.Locale,
+		Country:       user.Country,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified,
+		Role:          user.Role,
+		TwoFactor:     user.TOTPEnabled,
+		Balance:       user.Balance,
+		CreatedAt:     user.CreatedAt.Format("2006-01-02 15:04:05"),
+		Points:        user.Points,
+	}
 }
 
 // GetUserHandler godoc
@@ -177,25 +400,26 @@ type meResponse struct {
 //	@Security		ApiKeyAuth
 //	@Router			/api/auth/me [get]
 func (am AuthenticationService) GetUserHandler(c *gin.Context) {
-	user, _ := c.Get("user")
-	c.JSON(200, meResponse{
-		ID:        user.(DatabaseAbstraction.User).IndexID,
-		Username:  user.(DatabaseAbstraction.User).Username,
-		Balance:   user.(DatabaseAbstraction.User).Balance,
-		CreatedAt: user.(DatabaseAbstraction.User).CreatedAt.Format("2006-01-02 15:04:05"),
-		Points:    user.(DatabaseAbstraction.User).Points,
-	})
+	user := c.MustGet("user").(DatabaseAbstraction.User)
+	response := newMeResponse(user)
+	if impersonator, impersonated := Impersonator(c); impersonated {
+		response.ImpersonatedBy = impersonator.IndexID
+	}
+	c.JSON(200, response)
 }
 
 type registerRequest struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
+	Email    string `json:"email"` // optional unless email verification is required, needed for password resets
 }
 
 // RegisterUserHandler godoc
 //
 //	@Summary		Register a new user
 //	@Description	Register a new user
+//	@Description	A verification link is sent if an email address is given
+//	@Description	Invalid input is reported per field in fields
 //	@Tags			Authentication
 //	@Accept			json
 //	@Produce		json
@@ -223,18 +447,25 @@ func (am AuthenticationService) RegisterUserHandler(c *gin.Context) {
 		return
 	}
 
-	// Check if the user already exists
-	user, err := am.DB.GetUserByUsername(registerRequest.Username)
-	if err == nil {
+	if registerRequest.Email == "" && am.RequireEmailVerification {
 		c.JSON(400, loginResponse{
 			Token: "",
-			Error: "User already exists",
+			Error: "Email address required",
 		})
 		return
 	}
 
-	// Create the user
-	err = am.CreateUser(registerRequest.Username, registerRequest.Password)
+	// Create the user, this also checks that username and email address are not taken yet
+	err = am.CreateUser(registerRequest.Username, registerRequest.Password, registerRequest.Email)
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		c.JSON(400, loginResponse{
+			Token:  "",
+			Error:  "Invalid registration data",
+			Fields: validationErr.Fields,
+		})
+		return
+	}
 	if err != nil {
 		logrus.Error(err)
 		c.JSON(500, loginResponse{
@@ -245,7 +476,7 @@ func (am AuthenticationService) RegisterUserHandler(c *gin.Context) {
 	}
 
 	// Get the user from the database
-	user, err = am.DB.GetUserByUsername(registerRequest.Username)
+	user, err := am.DB.GetUserByUsername(NormalizeUsername(registerRequest.Username))
 	if err != nil {
 		logrus.Error(err)
 		c.JSON(500, loginResponse{
@@ -255,6 +486,18 @@ func (am AuthenticationService) RegisterUserHandler(c *gin.Context) {
 		return
 	}
 
+	if user.Email != "" {
+		// The account is usable without verification, the user can request a new link later
+		err = am.SendVerificationMail(user)
+		if err != nil {
+			logrus.Errorf("Error sending verification mail: %v", err)
+		}
+	}
+
+	event := AuditLog.NewEvent(c, AuditLog.ActionRegister)
+	event.ActorID = user.IndexID
+	AuditLog.OrDefault(am.Audit).Record(event)
+
 	// Generate a token for the user
 	token, err := am.CreateToken(user.IndexID)
 	if err != nil {
@@ -266,7 +509,7 @@ func (am AuthenticationService) RegisterUserHandler(c *gin.Context) {
 		return
 	}
 
-	c.SetCookie("authtoken", token, 7*24*60*60, "", "", true, true)
+	am.SetAuthCookie(c, token)
 
 	c.JSON(200, loginResponse{
 		Token: token,
@@ -291,29 +534,25 @@ type logoutResponse struct {
 //	@Security		ApiKeyAuth
 //	@Router			/api/auth/logout [post]
 func (am AuthenticationService) LogoutHandler(c *gin.Context) {
-	// Delete the token from the database
-	// Middleware handles authentication but doesn't pass token, so extract from header
-
-	token := c.GetHeader("Authorization")
-	if token == "" {
-		c.JSON(401, logoutResponse{
-			Error: "Not signed in",
-		})
-		// should be impossible to get here
-		return
-	}
-
-	token = strings.TrimPrefix(token, "Bearer ")
+	// The middleware found the token in the header or the cookie
+	token := c.GetString("token")
 
-	err := am.DB.DeleteTokenByHash(token)
+	err := am.RevokeToken(token)
 	if err != nil {
+		logrus.Error(err)
 		c.JSON(500, logoutResponse{
 			Error: "Failed to delete token",
 		})
 		return
 	}
 
-	c.SetCookie("authtoken", "", -1, "", "", true, true)
+	// impersonation tokens are never set as cookie, the cookie holds the admin's own session
+	if _, impersonated := Impersonator(c); impersonated {
+		am.audit(c, AuditLog.ActionImpersonationStopped, nil)
+	} else {
+		am.clearAuthCookie(c)
+		am.audit(c, AuditLog.ActionLogout, nil)
+	}
 
 	c.JSON(200, logoutResponse{
 		Error: "",
@@ -338,15 +577,16 @@ func (am AuthenticationService) IncreaseBalanceHandler(c *gin.Context) {
 	// Get the amount from the request
 	amount := c.Param("amount")
 	amountInt, err := strconv.Atoi(amount)
-	if err != nil {
+	// every top-up gets a receipt, a negative one would be a debit without a purchase
+	if err != nil || amountInt <= 0 {
 		c.JSON(400, logoutResponse{
 			Error: "Invalid amount",
 		})
 		return
 	}
 
-	// Increase the balance
-	err = am.DB.IncreaseUserBalance(user.(DatabaseAbstraction.User).IndexID, amountInt)
+	// Increase the balance, the receipt can be downloaded from the invoices
+	invoiceID, err := am.DB.TopUpBalance(user.(DatabaseAbstraction.User).IndexID, amountInt)
 	if err != nil {
 		c.JSON(500, logoutResponse{
 			Error: "Failed to increase balance",
@@ -354,6 +594,8 @@ func (am AuthenticationService) IncreaseBalanceHandler(c *gin.Context) {
 		return
 	}
 
+	am.audit(c, AuditLog.ActionBalanceTopUp, map[string]interface{}{"amount": amountInt, "invoice_id": invoiceID})
+
 	c.JSON(200, logoutResponse{
 		Error: "",
 	})
@@ -244,6 +244,8 @@ func TestLogin(t *testing.T) {
 		mockTokenService.On("CreateToken", user.IndexID).Return("valid_token", nil)
 		mockDB.On("GetUserByIndexID", 1).Return(user, nil)
 		mockDB.On("AddToken", 1, mock.Anything, mock.Anything).Return(nil)
+		// the stored hash uses outdated parameters and gets upgraded on login
+		mockDB.On("UpdateUserPassword", 1, mock.AnythingOfType("string")).Return(nil)
 
 		gin.SetMode(gin.TestMode)
 		router := gin.Default()
@@ -303,7 +305,7 @@ func TestAuthenticationService_RegisterUserHandler(t *testing.T) {
 	router := gin.Default()
 
 	mockDB.On("GetUserByUsername", "testuser").Return(DatabaseAbstraction.User{}, errors.New("User not found")).Once()
-	mockDB.On("AddUser", "testuser", mock.AnythingOfType("string")).Return(nil)
+	mockDB.On("AddUser", "testuser", mock.AnythingOfType("string"), "").Return(nil)
 	mockDB.On("GetUserByUsername", "testuser").Return(DatabaseAbstraction.User{
 		IndexID:  1,
 		Username: "testuser",
@@ -371,3 +373,49 @@ func TestAuthenticationService_RegisterUserHandlerInvalidInput(t *testing.T) {
 	assert.Empty(t, response.Token)
 	assert.NotEmpty(t, response.Error)
 }
+
+func TestAuthenticateUserUpgradesHash(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	am := AuthenticationService{
+		DB:         mockDB,
+		HashParams: Argon2Params{Memory: 64 * 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32},
+	}
+
+	user := DatabaseAbstraction.User{IndexID: 1, Username: "imported", Password: "$2a$04$5t9ceM3.0T4lCX8xUb3L1uGnQpHH3lFfuiB9wtHEJjgURpn3PE04q"}
+	mockDB.On("GetUserByUsername", "imported").Return(user, nil)
+	mockDB.On("UpdateUserPassword", 1, mock.MatchedBy(func(hash string) bool {
+		return strings.HasPrefix(hash, "$argon2id$v=19$m=65536,t=1,p=1$")
+	})).Return(nil).Once()
+
+	valid, err := am.AuthenticateUser("imported", "admin")
+	assert.NoError(t, err)
+	assert.True(t, valid)
+	mockDB.AssertExpectations(t)
+
+	// a failed login must never touch the stored hash
+	valid, err = am.AuthenticateUser("imported", "wrongpassword")
+	assert.NoError(t, err)
+	assert.False(t, valid)
+	mockDB.AssertNumberOfCalls(t, "UpdateUserPassword", 1)
+}
+
+func TestAuthenticationMiddlewareRejectsSuspendedUser(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	am := AuthenticationService{DB: mockDB}
+
+	mockDB.On("GetTokenByHash", "suspended_token").Return(DatabaseAbstraction.Token{UserID: 1}, nil)
+	mockDB.On("GetUserByIndexID", 1).Return(DatabaseAbstraction.User{IndexID: 1, Username: "spammer", Suspended: true}, nil)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/test", am.AuthenticationMiddleware, func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	req, _ := http.NewRequest("GET", "/test", nil)
+	req.Header.Set("Authorization", "Bearer suspended_token")
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+	assert.Contains(t, resp.Body.String(), ErrAccountSuspended.Error())
+}
@@ -0,0 +1,134 @@
+package AuthenticationManagement
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	authCookieName = "authtoken"
+	// browsers only accept cookies with this prefix if they are Secure, have Path=/ and no Domain,
+	// so a compromised subdomain can't overwrite the session cookie
+	hostCookiePrefix = "__Host-"
+	authCookieMaxAge = 7 * 24 * time.Hour
+)
+
+var ErrCrossSiteRequest = errors.New("cross-site request rejected")
+
+// CookieConfig contains the attributes of the authtoken cookie
+type CookieConfig struct {
+	Domain     string // empty for a host-only cookie
+	SameSite   http.SameSite
+	HostPrefix bool // name the cookie __Host-authtoken, requires an empty Domain
+	// Insecure drops the Secure attribute for local development over plain HTTP
+	Insecure bool
+}
+
+var DefaultCookieConfig = CookieConfig{
+	SameSite: http.SameSiteLaxMode,
+}
+
+// CookieConfigFromEnv reads COOKIE_SAMESITE (lax, strict or none), COOKIE_DOMAIN, COOKIE_HOST_PREFIX and COOKIE_INSECURE
+func CookieConfigFromEnv() (CookieConfig, error) {
+	config := DefaultCookieConfig
+	config.Domain = os.Getenv("COOKIE_DOMAIN")
+	config.HostPrefix = os.Getenv("COOKIE_HOST_PREFIX") == "true"
+	config.Insecure = os.Getenv("COOKIE_INSECURE") == "true"
+
+	switch strings.ToLower(os.Getenv("COOKIE_SAMESITE")) {
+	case "", "lax":
+		config.SameSite = http.SameSiteLaxMode
+	case "strict":
+		config.SameSite = http.SameSiteStrictMode
+	case "none":
+		config.SameSite = http.SameSiteNoneMode
+	default:
+		return CookieConfig{}, fmt.Errorf("COOKIE_SAMESITE must be lax, strict or none")
+	}
+
+	if config.HostPrefix && (config.Domain != "" || config.Insecure) {
+		return CookieConfig{}, errors.New("COOKIE_HOST_PREFIX can't be combined with COOKIE_DOMAIN or COOKIE_INSECURE")
+	}
+	// browsers drop SameSite=None cookies without Secure
+	if config.SameSite == http.SameSiteNoneMode && config.Insecure {
+		return CookieConfig{}, errors.New("COOKIE_SAMESITE=none requires a secure cookie")
+	}
+
+	return config, nil
+}
+
+// cookieConfig returns the configured cookie attributes, a zero value AuthenticationService uses the defaults
+func (am AuthenticationService) cookieConfig() CookieConfig {
+	if am.Cookies == nil {
+		return DefaultCookieConfig
+	}
+	return *am.Cookies
+}
+
+func (am AuthenticationService) authCookieName() string {
+	if am.cookieConfig().HostPrefix {
+		return hostCookiePrefix + authCookieName
+	}
+	return authCookieName
+}
+
+func (am AuthenticationService) authCookie(value string, maxAge int) *http.Cookie {
+	config := am.cookieConfig()
+	return &http.Cookie{
+		Name:     am.authCookieName(),
+		Value:    value,
+		Path:     "/",
+		Domain:   config.Domain,
+		MaxAge:   maxAge,
+		Secure:   !config.Insecure,
+		HttpOnly: true,
+		SameSite: config.SameSite,
+	}
+}
+
+// SetAuthCookie stores the session token in the authtoken cookie
+func (am AuthenticationService) SetAuthCookie(c *gin.Context, token string) {
+	http.SetCookie(c.Writer, am.authCookie(token, int(authCookieMaxAge.Seconds())))
+}
+
+func (am AuthenticationService) clearAuthCookie(c *gin.Context) {
+	http.SetCookie(c.Writer, am.authCookie("", -1))
+}
+
+// trustedOrigins are the origins allowed to send cookie-authenticated state-changing requests
+func (am AuthenticationService) trustedOrigins() map[string]bool {
+	origins := map[string]bool{}
+	for _, origin := range append([]string{am.publicURL()}, am.TrustedOrigins...) {
+		if parsed, err := url.Parse(origin); err == nil && parsed.Host != "" {
+			origins[parsed.Scheme+"://"+parsed.Host] = true
+		}
+	}
+	return origins
+}
+
+// checkCSRF verifies that a cookie-authenticated request comes from a trusted origin
+// Requests with the token in the Authorization header are not affected, browsers never add that header on their own
+// The Referer is only consulted if the browser didn't send an Origin, a request with neither is rejected
+func (am AuthenticationService) checkCSRF(c *gin.Context) error {
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return nil
+	}
+
+	source := c.GetHeader("Origin")
+	if source == "" || source == "null" {
+		source = c.GetHeader("Referer")
+	}
+
+	parsed, err := url.Parse(source)
+	if source == "" || err != nil || !am.trustedOrigins()[parsed.Scheme+"://"+parsed.Host] {
+		return ErrCrossSiteRequest
+	}
+	return nil
+}
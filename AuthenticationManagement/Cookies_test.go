@@ -0,0 +1,126 @@
+package AuthenticationManagement
+
+import (
+	"EntitlementServer/DatabaseAbstraction"
+	"EntitlementServer/DatabaseAbstraction/mocks"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSetAuthCookie(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	am := AuthenticationService{Cookies: &CookieConfig{SameSite: http.SameSiteStrictMode, HostPrefix: true}}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	am.SetAuthCookie(c, "token")
+
+	cookie := w.Header().Get("Set-Cookie")
+	assert.Contains(t, cookie, "__Host-authtoken=token")
+	assert.Contains(t, cookie, "Path=/")
+	assert.Contains(t, cookie, "Secure")
+	assert.Contains(t, cookie, "HttpOnly")
+	assert.Contains(t, cookie, "SameSite=Strict")
+	assert.NotContains(t, cookie, "Domain")
+}
+
+func TestCookieCSRFProtection(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockDB := new(mocks.DBOrm)
+	am := AuthenticationService{DB: mockDB, PublicURL: "https://bkbdemy.example", TrustedOrigins: []string{"https://admin.bkbdemy.example"}}
+
+	mockDB.On("GetTokenByHash", "valid_token").Return(DatabaseAbstraction.Token{UserID: 1}, nil)
+	mockDB.On("GetUserByIndexID", 1).Return(DatabaseAbstraction.User{IndexID: 1, Username: "student"}, nil)
+
+	router := gin.Default()
+	router.POST("/test", am.AuthenticationMiddleware, func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		cookie  bool
+		status  int
+	}{
+		{"same origin", map[string]string{"Origin": "https://bkbdemy.example"}, true, 200},
+		{"trusted origin", map[string]string{"Origin": "https://admin.bkbdemy.example"}, true, 200},
+		{"referer fallback", map[string]string{"Referer": "https://bkbdemy.example/courses/1"}, true, 200},
+		{"foreign origin", map[string]string{"Origin": "https://evil.example"}, true, 403},
+		{"foreign referer", map[string]string{"Referer": "https://bkbdemy.example.evil.example/"}, true, 403},
+		{"no origin", map[string]string{}, true, 403},
+		{"header token without origin", map[string]string{"Authorization": "Bearer valid_token"}, false, 200},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, _ := http.NewRequest("POST", "/test", nil)
+			for key, value := range test.headers {
+				req.Header.Set(key, value)
+			}
+			if test.cookie {
+				req.AddCookie(&http.Cookie{Name: "authtoken", Value: "valid_token"})
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, test.status, w.Code)
+		})
+	}
+}
+
+func TestLogoutWithCookie(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockDB := new(mocks.DBOrm)
+	am := AuthenticationService{DB: mockDB}
+
+	mockDB.On("GetTokenByHash", "cookie_token").Return(DatabaseAbstraction.Token{UserID: 1}, nil)
+	mockDB.On("GetUserByIndexID", 1).Return(DatabaseAbstraction.User{IndexID: 1, Username: "student"}, nil)
+	mockDB.On("DeleteTokenByHash", "cookie_token").Return(nil)
+
+	router := gin.Default()
+	am.RegisterHandlers(router)
+
+	req, _ := http.NewRequest("POST", "/api/auth/logout", nil)
+	req.Header.Set("Origin", "http://localhost:8080")
+	req.AddCookie(&http.Cookie{Name: "authtoken", Value: "cookie_token"})
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+	mockDB.AssertCalled(t, "DeleteTokenByHash", "cookie_token")
+	assert.Contains(t, w.Header().Get("Set-Cookie"), "authtoken=;")
+	assert.Contains(t, w.Header().Get("Set-Cookie"), "Max-Age=0")
+}
+
+func TestOptionalAuthenticationWithStaleCookie(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockDB := new(mocks.DBOrm)
+	am := AuthenticationService{DB: mockDB}
+
+	mockDB.On("GetTokenByHash", "expired_token").Return(DatabaseAbstraction.Token{}, errNoRows)
+	mockDB.On("GetTokenByHash", "valid_token").Return(DatabaseAbstraction.Token{UserID: 1}, nil)
+	mockDB.On("GetUserByIndexID", 1).Return(DatabaseAbstraction.User{IndexID: 1, Username: "student"}, nil)
+
+	router := gin.Default()
+	router.GET("/public", am.OptionalAuthenticationMiddleware, func(c *gin.Context) {
+		_, signedIn := c.Get("user")
+		c.JSON(http.StatusOK, gin.H{"signed_in": signedIn})
+	})
+
+	for token, signedIn := range map[string]string{"expired_token": "false", "valid_token": "true", "": "false"} {
+		req, _ := http.NewRequest("GET", "/public", nil)
+		if token != "" {
+			req.AddCookie(&http.Cookie{Name: "authtoken", Value: token})
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, 200, w.Code, token)
+		assert.JSONEq(t, `{"signed_in": `+signedIn+`}`, w.Body.String(), token)
+		// only the stale cookie is removed
+		assert.Equal(t, token == "expired_token", w.Header().Get("Set-Cookie") != "", token)
+	}
+}
@@ -0,0 +1,266 @@
+package AuthenticationManagement
+
+import (
+	"EntitlementServer/DatabaseAbstraction"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/sirupsen/logrus"
+	"net/mail"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	emailVerificationPurpose      = "email-verification"
+	emailVerificationLinkLifetime = 48 * time.Hour
+	maxEmailLength                = 254 // RFC 5321 path limit
+)
+
+var (
+	ErrInvalidEmail              = errors.New("invalid email address")
+	ErrEmailInUse                = errors.New("email address is already in use")
+	ErrEmailAlreadyVerified      = errors.New("email address is already verified")
+	ErrInvalidVerificationToken  = errors.New("invalid or expired verification link")
+	ErrEmailVerificationRequired = errors.New("email address required")
+)
+
+// NormalizeEmail validates an email address and returns it in the form it is stored in
+// Display names ("Max <max@example.com>") are rejected, the whole address is lowercased because
+// no mail provider our students use treats the local part case-sensitively
+func NormalizeEmail(email string) (string, error) {
+	email = strings.TrimSpace(email)
+	if email == "" || len(email) > maxEmailLength {
+		return "", ErrInvalidEmail
+	}
+
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Name != "" || address.Address != email {
+		return "", ErrInvalidEmail
+	}
+
+	return strings.ToLower(address.Address), nil
+}
+
+// lookupUser finds a user by username, or by email address if the identifier looks like one
+func (am AuthenticationService) lookupUser(identifier string) (DatabaseAbstraction.User, error) {
+	if strings.Contains(identifier, "@") {
+		email, err := NormalizeEmail(identifier)
+		if err != nil {
+			return DatabaseAbstraction.User{}, err
+		}
+		return am.DB.GetUserByEmail(email)
+	}
+
+	return am.DB.GetUserByUsername(identifier)
+}
+
+// emailAvailable checks that no other user already uses the (normalized) address
+// Only a missing user counts as available, other lookup errors are returned
+func (am AuthenticationService) emailAvailable(email string, userID int) (bool, error) {
+	existing, err := am.DB.GetUserByEmail(email)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return existing.IndexID == userID, nil
+}
+
+// SetEmail changes the email address of a user and sends a verification link to the new address
+func (am AuthenticationService) SetEmail(user DatabaseAbstraction.User, email string) error {
+	email, err := NormalizeEmail(email)
+	if err != nil {
+		return err
+	}
+
+	available, err := am.emailAvailable(email, user.IndexID)
+	if err != nil {
+		return err
+	}
+	if !available {
+		return ErrEmailInUse
+	}
+
+	err = am.DB.UpdateUserEmail(user.IndexID, email)
+	if err != nil {
+		return err
+	}
+
+	user.Email = email
+	user.EmailVerified = false
+	return am.SendVerificationMail(user)
+}
+
+// SendVerificationMail mails a signed link that proves ownership of the user's current email address
+func (am AuthenticationService) SendVerificationMail(user DatabaseAbstraction.User) error {
+	if user.Email == "" {
+		return ErrInvalidEmail
+	}
+	if user.EmailVerified {
+		return ErrEmailAlreadyVerified
+	}
+
+	token, err := am.signToken(emailVerificationPurpose, strconv.Itoa(user.IndexID)+"|"+user.Email, time.Now().Add(emailVerificationLinkLifetime))
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/verify-email?token=%s", am.publicURL(), url.QueryEscape(token))
+	body := fmt.Sprintf("Hello %s,\n\n"+
+		"please confirm that this is the email address of your BKBdemy account by opening the following link:\n\n"+
+		"%s\n\n"+
+		"The link is valid for two days. If you did not create an account, you can ignore this mail.\n", user.Username, link)
+
+	return am.mailer().SendMail(user.Email, "Confirm your BKBdemy email address", body)
+}
+
+// VerifyEmail redeems a verification link
+// The link is bound to the address it was sent to and stops working once that address is verified or changed
+func (am AuthenticationService) VerifyEmail(token string) error {
+	payload, err := am.verifySignedToken(emailVerificationPurpose, token)
+	if err != nil {
+		return ErrInvalidVerificationToken
+	}
+
+	userIDString, email, found := strings.Cut(payload, "|")
+	if !found {
+		return ErrInvalidVerificationToken
+	}
+	userID, err := strconv.Atoi(userIDString)
+	if err != nil {
+		return ErrInvalidVerificationToken
+	}
+
+	err = am.DB.VerifyUserEmail(userID, email)
+	if err != nil {
+		return ErrInvalidVerificationToken
+	}
+
+	return nil
+}
+
+type updateEmailRequest struct {
+	Email string `json:"email"`
+}
+
+// UpdateEmailHandler godoc
+//
+//	@Summary		Set the email address of the current user
+//	@Description	Set or change the email address of the current user and send a verification link to it
+//	@Tags			Authentication
+//	@Accept			json
+//	@Produce		json
+//	@Param			updateEmailRequest	body		updateEmailRequest	true	"Update email request"
+//	@Success		200					{object}	messageResponse
+//	@Failure		400					{object}	messageResponse
+//	@Failure		500					{object}	messageResponse
+//	@Security		ApiKeyAuth
+//	@Router			/api/auth/email [post]
+func (am AuthenticationService) UpdateEmailHandler(c *gin.Context) {
+	user := c.MustGet("user").(DatabaseAbstraction.User)
+
+	var request updateEmailRequest
+	err := c.ShouldBindJSON(&request)
+	if err != nil {
+		c.JSON(400, messageResponse{
+			Error: "Invalid request",
+		})
+		return
+	}
+
+	err = am.SetEmail(user, request.Email)
+	if errors.Is(err, ErrInvalidEmail) || errors.Is(err, ErrEmailInUse) {
+		c.JSON(400, messageResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, messageResponse{
+			Error: "Failed to update email address",
+		})
+		return
+	}
+
+	c.JSON(200, messageResponse{
+		Message: "Verification link sent",
+	})
+}
+
+// ResendVerificationHandler godoc
+//
+//	@Summary		Resend the verification link
+//	@Description	Send a new verification link to the email address of the current user
+//	@Tags			Authentication
+//	@Produce		json
+//	@Success		200	{object}	messageResponse
+//	@Failure		400	{object}	messageResponse
+//	@Failure		500	{object}	messageResponse
+//	@Security		ApiKeyAuth
+//	@Router			/api/auth/email/resend [post]
+func (am AuthenticationService) ResendVerificationHandler(c *gin.Context) {
+	user := c.MustGet("user").(DatabaseAbstraction.User)
+
+	err := am.SendVerificationMail(user)
+	if errors.Is(err, ErrInvalidEmail) || errors.Is(err, ErrEmailAlreadyVerified) {
+		c.JSON(400, messageResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, messageResponse{
+			Error: "Failed to send verification link",
+		})
+		return
+	}
+
+	c.JSON(200, messageResponse{
+		Message: "Verification link sent",
+	})
+}
+
+type verifyEmailRequest struct {
+	Token string `json:"token"`
+}
+
+// VerifyEmailHandler godoc
+//
+//	@Summary		Verify an email address
+//	@Description	Verify an email address using the token from a verification link
+//	@Tags			Authentication
+//	@Accept			json
+//	@Produce		json
+//	@Param			verifyEmailRequest	body		verifyEmailRequest	true	"Verify email request"
+//	@Success		200					{object}	messageResponse
+//	@Failure		400					{object}	messageResponse
+//	@Router			/api/auth/email/verify [post]
+func (am AuthenticationService) VerifyEmailHandler(c *gin.Context) {
+	var request verifyEmailRequest
+	err := c.ShouldBindJSON(&request)
+	if err != nil || request.Token == "" {
+		c.JSON(400, messageResponse{
+			Error: "Invalid request",
+		})
+		return
+	}
+
+	err = am.VerifyEmail(request.Token)
+	if err != nil {
+		c.JSON(400, messageResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(200, messageResponse{
+		Message: "Email address verified",
+	})
+}
@@ -0,0 +1,113 @@
+package AuthenticationManagement
+
+import (
+	"EntitlementServer/DatabaseAbstraction"
+	"EntitlementServer/DatabaseAbstraction/mocks"
+	"errors"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestNormalizeEmail(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{name: "plain", input: "student@example.com", want: "student@example.com"},
+		{name: "mixed_case_and_whitespace", input: "  Max.Mustermann@Schule.DE ", want: "max.mustermann@schule.de"},
+		{name: "display_name", input: "Max <max@example.com>", wantErr: true},
+		{name: "missing_domain", input: "max@", wantErr: true},
+		{name: "no_at", input: "max", wantErr: true},
+		{name: "empty", input: "", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := NormalizeEmail(test.input)
+			if test.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidEmail)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.want, got)
+		})
+	}
+}
+
+func TestSignedTokens(t *testing.T) {
+	am := AuthenticationService{SigningKey: []byte("testkey")}
+
+	token, err := am.signToken("purpose", "1|payload", time.Now().Add(time.Minute))
+	assert.NoError(t, err)
+
+	payload, err := am.verifySignedToken("purpose", token)
+	assert.NoError(t, err)
+	assert.Equal(t, "1|payload", payload)
+
+	_, err = am.verifySignedToken("other-purpose", token)
+	assert.ErrorIs(t, err, ErrInvalidSignedToken)
+
+	_, err = AuthenticationService{SigningKey: []byte("otherkey")}.verifySignedToken("purpose", token)
+	assert.ErrorIs(t, err, ErrInvalidSignedToken)
+
+	expired, err := am.signToken("purpose", "1|payload", time.Now().Add(-time.Minute))
+	assert.NoError(t, err)
+	_, err = am.verifySignedToken("purpose", expired)
+	assert.ErrorIs(t, err, ErrExpiredSignedToken)
+}
+
+func TestSetAndVerifyEmail(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	mailer := &recordingMailer{}
+	am := AuthenticationService{DB: mockDB, Mailer: mailer, SigningKey: []byte("testkey")}
+
+	user := DatabaseAbstraction.User{IndexID: 3, Username: "student"}
+	mockDB.On("GetUserByEmail", "student@example.com").Return(DatabaseAbstraction.User{}, pgx.ErrNoRows)
+	mockDB.On("GetUserByEmail", "taken@example.com").Return(DatabaseAbstraction.User{IndexID: 4}, nil)
+	mockDB.On("GetUserByEmail", "unknown@example.com").Return(DatabaseAbstraction.User{}, errors.New("connection refused"))
+	mockDB.On("UpdateUserEmail", 3, "student@example.com").Return(nil)
+	mockDB.On("VerifyUserEmail", 3, "student@example.com").Return(nil).Once()
+	mockDB.On("VerifyUserEmail", 3, "student@example.com").Return(errNoRows)
+
+	assert.ErrorIs(t, am.SetEmail(user, "Taken@Example.com"), ErrEmailInUse)
+	// a failed lookup doesn't mean the address is free
+	err := am.SetEmail(user, "unknown@example.com")
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, ErrEmailInUse)
+	mockDB.AssertNotCalled(t, "UpdateUserEmail", 3, "unknown@example.com")
+
+	assert.NoError(t, am.SetEmail(user, "Student@Example.com"))
+	assert.Equal(t, []string{"student@example.com"}, mailer.to)
+
+	match := regexp.MustCompile(`verify-email\?token=(\S+)`).FindStringSubmatch(mailer.body[0])
+	if !assert.Len(t, match, 2) {
+		return
+	}
+	token, err := url.QueryUnescape(match[1])
+	assert.NoError(t, err)
+
+	assert.NoError(t, am.VerifyEmail(token))
+	// the second use fails because the address is already verified
+	assert.ErrorIs(t, am.VerifyEmail(token), ErrInvalidVerificationToken)
+	assert.ErrorIs(t, am.VerifyEmail(token+"x"), ErrInvalidVerificationToken)
+}
+
+func TestAuthenticateUserByEmail(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	am := AuthenticationService{DB: mockDB, HashParams: testHashParams}
+
+	hash, err := am.HashPassword("secret")
+	assert.NoError(t, err)
+
+	mockDB.On("GetUserByEmail", "student@example.com").Return(DatabaseAbstraction.User{IndexID: 1, Username: "student", Password: hash}, nil)
+
+	valid, err := am.AuthenticateUser("Student@Example.com", "secret")
+	assert.NoError(t, err)
+	assert.True(t, valid)
+	mockDB.AssertNotCalled(t, "GetUserByUsername", "Student@Example.com")
+}
@@ -7,33 +7,99 @@ import (
 	"errors"
 	"fmt"
 	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"os"
+	"strconv"
 	"strings"
 )
 
-type argon2Params struct {
-	memory      uint32
-	iterations  uint32
-	parallelism uint8
-	saltLength  uint32
-	keyLength   uint32
+// Argon2Params are the cost parameters used when hashing new passwords.
+// Existing hashes keep the parameters they were created with and are upgraded on the next successful login.
+type Argon2Params struct {
+	Memory      uint32 // in KiB
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
 }
 
-var argon2settings = argon2Params{
-	memory:      256 * 1024, // 256 MB memory cost
-	iterations:  12,
-	parallelism: 4,
-	saltLength:  16,
-	keyLength:   32,
+var DefaultArgon2Params = Argon2Params{
+	Memory:      256 * 1024, // 256 MB memory cost
+	Iterations:  12,
+	Parallelism: 4,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+// Argon2ParamsFromEnv reads the hashing parameters from ARGON2_MEMORY, ARGON2_ITERATIONS and ARGON2_PARALLELISM,
+// falling back to DefaultArgon2Params for every value that is not set
+func Argon2ParamsFromEnv() (Argon2Params, error) {
+	params := DefaultArgon2Params
+
+	memory, err := getenvUint("ARGON2_MEMORY", uint64(params.Memory), 32)
+	if err != nil {
+		return Argon2Params{}, err
+	}
+	iterations, err := getenvUint("ARGON2_ITERATIONS", uint64(params.Iterations), 32)
+	if err != nil {
+		return Argon2Params{}, err
+	}
+	parallelism, err := getenvUint("ARGON2_PARALLELISM", uint64(params.Parallelism), 8)
+	if err != nil {
+		return Argon2Params{}, err
+	}
+
+	params.Memory = uint32(memory)
+	params.Iterations = uint32(iterations)
+	params.Parallelism = uint8(parallelism)
+
+	if params.Memory == 0 || params.Iterations == 0 || params.Parallelism == 0 {
+		return Argon2Params{}, errors.New("argon2 parameters must be greater than zero")
+	}
+
+	return params, nil
+}
+
+func getenvUint(key string, fallback uint64, bitSize int) (uint64, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback, nil
+	}
+	parsed, err := strconv.ParseUint(value, 10, bitSize)
+	if err != nil {
+		return 0, fmt.Errorf("%s is not a valid unsigned integer: %w", key, err)
+	}
+	return parsed, nil
+}
+
+// hashParams returns the configured hashing parameters, a zero value AuthenticationService uses the defaults
+func (am AuthenticationService) hashParams() Argon2Params {
+	if am.HashParams == (Argon2Params{}) {
+		return DefaultArgon2Params
+	}
+	return am.HashParams
 }
 
 // TODO: RATELIMITING
 func (am AuthenticationService) ComparePasswords(hashedPassword string, password string) (bool, error) {
+	if isBcryptHash(hashedPassword) {
+		// Legacy hashes of imported users, these are replaced by argon2id on the next login
+		err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+
 	params, salt, hash, err := decodeHash(hashedPassword)
 	if err != nil {
 		return false, err
 	}
 
-	dbHash := argon2.IDKey([]byte(password), salt, params.iterations, params.memory, params.parallelism, params.keyLength)
+	dbHash := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
 
 	if subtle.ConstantTimeCompare(hash, dbHash) == 1 {
 		return true, nil
@@ -43,29 +109,57 @@ func (am AuthenticationService) ComparePasswords(hashedPassword string, password
 }
 
 func (am AuthenticationService) HashPassword(password string) (string, error) {
+	params := am.hashParams()
+
 	// generate salt
-	salt := make([]byte, argon2settings.saltLength)
+	salt := make([]byte, params.SaltLength)
 	_, err := rand.Read(salt)
 	if err != nil {
 		return "", err
 	}
 
 	// hash password
-	hashedPassword := argon2.IDKey([]byte(password), salt, argon2settings.iterations, argon2settings.memory, argon2settings.parallelism, argon2settings.keyLength)
+	hashedPassword := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
 
 	b64Salt := base64.RawStdEncoding.EncodeToString(salt)
 	b64Hash := base64.RawStdEncoding.EncodeToString(hashedPassword)
 
-	encodedHash := fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, argon2settings.memory, argon2settings.iterations, argon2settings.parallelism, b64Salt, b64Hash)
+	encodedHash := fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, params.Memory, params.Iterations, params.Parallelism, b64Salt, b64Hash)
 	return encodedHash, nil
 }
 
+// NeedsRehash reports whether a stored hash was created with a different algorithm or different parameters
+// than the ones currently configured
+func (am AuthenticationService) NeedsRehash(hashedPassword string) bool {
+	if !strings.HasPrefix(hashedPassword, "$argon2id$") {
+		return true
+	}
+
+	params, _, _, err := decodeHash(hashedPassword)
+	if err != nil {
+		return true
+	}
+
+	current := am.hashParams()
+	return params.Memory != current.Memory ||
+		params.Iterations != current.Iterations ||
+		params.Parallelism != current.Parallelism ||
+		params.SaltLength != current.SaltLength ||
+		params.KeyLength != current.KeyLength
+}
+
+func isBcryptHash(hashedPassword string) bool {
+	return strings.HasPrefix(hashedPassword, "$2a$") ||
+		strings.HasPrefix(hashedPassword, "$2b$") ||
+		strings.HasPrefix(hashedPassword, "$2y$")
+}
+
 var (
 	ErrInvalidHash         = errors.New("the encoded hash is not in the correct format")
 	ErrIncompatibleVersion = errors.New("incompatible version of argon2")
 )
 
-func decodeHash(encodedHash string) (p *argon2Params, salt, hash []byte, err error) {
+func decodeHash(encodedHash string) (p *Argon2Params, salt, hash []byte, err error) {
 	vals := strings.Split(encodedHash, "$")
 	if len(vals) != 6 {
 		return nil, nil, nil, ErrInvalidHash
@@ -80,8 +174,8 @@ func decodeHash(encodedHash string) (p *argon2Params, salt, hash []byte, err err
 		return nil, nil, nil, ErrIncompatibleVersion
 	}
 
-	p = &argon2Params{}
-	_, err = fmt.Sscanf(vals[3], "m=%d,t=%d,p=%d", &p.memory, &p.iterations, &p.parallelism)
+	p = &Argon2Params{}
+	_, err = fmt.Sscanf(vals[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Iterations, &p.Parallelism)
 	if err != nil {
 		return nil, nil, nil, err
 	}
@@ -90,13 +184,13 @@ func decodeHash(encodedHash string) (p *argon2Params, salt, hash []byte, err err
 	if err != nil {
 		return nil, nil, nil, err
 	}
-	p.saltLength = uint32(len(salt))
+	p.SaltLength = uint32(len(salt))
 
 	hash, err = base64.RawStdEncoding.Strict().DecodeString(vals[5])
 	if err != nil {
 		return nil, nil, nil, err
 	}
-	p.keyLength = uint32(len(hash))
+	p.KeyLength = uint32(len(hash))
 
 	return p, salt, hash, nil
 }
@@ -112,3 +112,68 @@ func TestAuthenticationService_ComparePasswords(t *testing.T) {
 		})
 	}
 }
+
+func TestAuthenticationService_ComparePasswordsBcrypt(t *testing.T) {
+	am := AuthenticationManagement.AuthenticationService{}
+	hash := "$2a$04$5t9ceM3.0T4lCX8xUb3L1uGnQpHH3lFfuiB9wtHEJjgURpn3PE04q"
+
+	got, err := am.ComparePasswords(hash, "admin")
+	if err != nil || !got {
+		t.Errorf("ComparePasswords() = %v, %v, want true", got, err)
+	}
+
+	got, err = am.ComparePasswords(hash, "wrongpassword")
+	if err != nil || got {
+		t.Errorf("ComparePasswords() = %v, %v, want false", got, err)
+	}
+}
+
+func TestAuthenticationService_NeedsRehash(t *testing.T) {
+	params := AuthenticationManagement.Argon2Params{
+		Memory:      64 * 1024,
+		Iterations:  1,
+		Parallelism: 1,
+		SaltLength:  16,
+		KeyLength:   32,
+	}
+	am := AuthenticationManagement.AuthenticationService{HashParams: params}
+
+	current, err := am.HashPassword("admin")
+	if err != nil {
+		t.Fatalf("HashPassword() error = %v", err)
+	}
+
+	tests := []struct {
+		name string
+		hash string
+		want bool
+	}{
+		{
+			name: "current_params",
+			hash: current,
+			want: false,
+		},
+		{
+			name: "outdated_params",
+			hash: "$argon2id$v=19$m=65536,t=6,p=1$dGVzdHRlc3Q$TvWngSaVheFLS5b6VzkFgQ21gscr2YBdH5tjum0FpqA",
+			want: true,
+		},
+		{
+			name: "bcrypt",
+			hash: "$2a$04$5t9ceM3.0T4lCX8xUb3L1uGnQpHH3lFfuiB9wtHEJjgURpn3PE04q",
+			want: true,
+		},
+		{
+			name: "malformed_hash",
+			hash: "$argon2id$v=19$garbage",
+			want: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := am.NeedsRehash(test.hash); got != test.want {
+				t.Errorf("NeedsRehash() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
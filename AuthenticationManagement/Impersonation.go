@@ -0,0 +1,116 @@
+package AuthenticationManagement
+
+import (
+	"EntitlementServer/AuditLog"
+	"EntitlementServer/DatabaseAbstraction"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"time"
+)
+
+// support sessions are short, a new one can be started at any time
+const impersonationLifetime = time.Hour
+
+var (
+	ErrImpersonationNotAllowed = errors.New("this user can't be impersonated")
+	ErrImpersonationRestricted = errors.New("not allowed while impersonating a user")
+)
+
+// impersonationWriteRoutes are the only state-changing routes an impersonated session may use
+// Impersonation is for seeing what the user sees, so purchases, progress, comments and credential changes are all off limits
+var impersonationWriteRoutes = map[string]bool{
+	"POST /api/auth/logout":             true,
+	"POST /api/auth/impersonation/stop": true,
+}
+
+// impersonationBlockedReadRoutes are GET routes with side effects or that hand out the user's data wholesale
+var impersonationBlockedReadRoutes = map[string]bool{
+	"GET /api/auth/me/export":              true,
+	"GET /api/auth/me/export/:id/download": true,
+	"GET /api/auth/oidc/:provider/link":    true,
+}
+
+func impersonationAllowed(c *gin.Context) bool {
+	route := c.Request.Method + " " + c.FullPath()
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return !impersonationBlockedReadRoutes[route]
+	}
+	return impersonationWriteRoutes[route]
+}
+
+// Impersonator returns the admin acting as the signed-in user, the second value is false for the user's own sessions
+func Impersonator(c *gin.Context) (DatabaseAbstraction.User, bool) {
+	impersonator, found := c.Get("impersonator")
+	if !found {
+		return DatabaseAbstraction.User{}, false
+	}
+	return impersonator.(DatabaseAbstraction.User), true
+}
+
+// CreateImpersonationToken issues a short-lived session in which the admin acts as the user
+// Admins can't be impersonated, otherwise an admin could act in another admin's name
+func (am AuthenticationService) CreateImpersonationToken(user DatabaseAbstraction.User, impersonator DatabaseAbstraction.User) (string, time.Time, error) {
+	if impersonator.Role != DatabaseAbstraction.RoleAdmin {
+		return "", time.Time{}, ErrForbidden
+	}
+	if user.IndexID == impersonator.IndexID || user.Role == DatabaseAbstraction.RoleAdmin || user.Deleted || user.Suspended {
+		return "", time.Time{}, ErrImpersonationNotAllowed
+	}
+
+	expiry := time.Now().Add(impersonationLifetime)
+	token, err := am.createSessionToken(user, impersonator.IndexID, impersonationLifetime)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return token, expiry, nil
+}
+
+// authenticateImpersonator loads the admin of an impersonated session, the session ends if they lost the admin role
+func (am AuthenticationService) authenticateImpersonator(impersonatorID int) (DatabaseAbstraction.User, error) {
+	impersonator, err := am.DB.GetUserByIndexID(impersonatorID)
+	if err != nil {
+		return DatabaseAbstraction.User{}, err
+	}
+	if impersonator.Role != DatabaseAbstraction.RoleAdmin || impersonator.Deleted || impersonator.Suspended {
+		return DatabaseAbstraction.User{}, ErrForbidden
+	}
+	return impersonator, nil
+}
+
+// StopImpersonationHandler godoc
+//
+//	@Summary		Stop impersonating a user
+//	@Description	Ends the impersonation session of the token, the admin's own session is not affected
+//	@Tags			Authentication
+//	@Produce		json
+//	@Success		200	{object}	messageResponse
+//	@Failure		400	{object}	messageResponse
+//	@Failure		500	{object}	messageResponse
+//	@Security		ApiKeyAuth
+//	@Router			/api/auth/impersonation/stop [post]
+func (am AuthenticationService) StopImpersonationHandler(c *gin.Context) {
+	if _, impersonated := Impersonator(c); !impersonated {
+		c.JSON(400, messageResponse{
+			Error: "This session is not an impersonation",
+		})
+		return
+	}
+
+	err := am.RevokeToken(c.GetString("token"))
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, messageResponse{
+			Error: "Failed to end impersonation",
+		})
+		return
+	}
+
+	am.audit(c, AuditLog.ActionImpersonationStopped, nil)
+	c.JSON(200, messageResponse{
+		Message: "Impersonation ended",
+	})
+}
@@ -0,0 +1,119 @@
+package AuthenticationManagement
+
+import (
+	"EntitlementServer/DatabaseAbstraction"
+	"EntitlementServer/DatabaseAbstraction/mocks"
+	"EntitlementServer/JWTManagement"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var (
+	impersonatingAdmin = DatabaseAbstraction.User{IndexID: 1, Username: "admin", Role: DatabaseAbstraction.RoleAdmin}
+	impersonatedUser   = DatabaseAbstraction.User{IndexID: 5, Username: "student", Role: DatabaseAbstraction.RoleStudent}
+)
+
+func TestCreateImpersonationToken(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	am := AuthenticationService{DB: mockDB}
+	mockDB.On("AddImpersonationToken", 5, 1, mock.AnythingOfType("string"), mock.AnythingOfType("time.Time")).Return(nil)
+
+	token, expiry, err := am.CreateImpersonationToken(impersonatedUser, impersonatingAdmin)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, token)
+	assert.WithinDuration(t, time.Now().Add(impersonationLifetime), expiry, time.Minute)
+	mockDB.AssertNotCalled(t, "AddToken", mock.Anything, mock.Anything, mock.Anything)
+
+	// admins can't be impersonated and only admins can impersonate
+	_, _, err = am.CreateImpersonationToken(DatabaseAbstraction.User{IndexID: 2, Role: DatabaseAbstraction.RoleAdmin}, impersonatingAdmin)
+	assert.ErrorIs(t, err, ErrImpersonationNotAllowed)
+	_, _, err = am.CreateImpersonationToken(impersonatedUser, DatabaseAbstraction.User{IndexID: 3, Role: DatabaseAbstraction.RoleInstructor})
+	assert.ErrorIs(t, err, ErrForbidden)
+}
+
+func TestImpersonatedSessionIsReadOnly(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockDB := new(mocks.DBOrm)
+	am := AuthenticationService{DB: mockDB}
+
+	mockDB.On("GetTokenByHash", "impersonation_token").Return(DatabaseAbstraction.Token{UserID: 5, ImpersonatorID: 1}, nil)
+	mockDB.On("GetUserByIndexID", 5).Return(impersonatedUser, nil)
+	mockDB.On("GetUserByIndexID", 1).Return(impersonatingAdmin, nil)
+	mockDB.On("DeleteTokenByHash", "impersonation_token").Return(nil)
+
+	router := gin.New()
+	am.RegisterHandlers(router)
+	router.POST("/api/products/:id/purchase", am.AuthenticationMiddleware, func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	send := func(method string, path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer impersonation_token")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp
+	}
+
+	resp := send("GET", "/api/auth/me")
+	assert.Equal(t, http.StatusOK, resp.Code)
+	var me meResponse
+	assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &me))
+	assert.Equal(t, 5, me.ID)
+	assert.Equal(t, 1, me.ImpersonatedBy)
+
+	assert.Equal(t, http.StatusForbidden, send("POST", "/api/products/1/purchase").Code)
+	assert.Equal(t, http.StatusForbidden, send("POST", "/api/auth/password").Code)
+	assert.Equal(t, http.StatusForbidden, send("DELETE", "/api/auth/me").Code)
+
+	resp = send("POST", "/api/auth/impersonation/stop")
+	assert.Equal(t, http.StatusOK, resp.Code)
+	mockDB.AssertCalled(t, "DeleteTokenByHash", "impersonation_token")
+	// the admin's own session in the cookie stays
+	assert.Empty(t, resp.Header().Get("Set-Cookie"))
+}
+
+func TestImpersonationEndsWhenAdminIsDemoted(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockDB := new(mocks.DBOrm)
+	am := AuthenticationService{DB: mockDB}
+
+	mockDB.On("GetTokenByHash", "impersonation_token").Return(DatabaseAbstraction.Token{UserID: 5, ImpersonatorID: 1}, nil)
+	mockDB.On("GetUserByIndexID", 5).Return(impersonatedUser, nil)
+	mockDB.On("GetUserByIndexID", 1).Return(DatabaseAbstraction.User{IndexID: 1, Role: DatabaseAbstraction.RoleStudent}, nil)
+
+	router := gin.New()
+	am.RegisterHandlers(router)
+	req, _ := http.NewRequest("GET", "/api/auth/me", nil)
+	req.Header.Set("Authorization", "Bearer impersonation_token")
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+}
+
+func TestImpersonationAccessToken(t *testing.T) {
+	am, mockDB := newAccessTokenService(t)
+	mockDB.On("GetRevokedTokens").Return(nil, nil)
+
+	token, _, err := am.CreateImpersonationToken(impersonatedUser, impersonatingAdmin)
+	assert.NoError(t, err)
+
+	var claims AccessTokenClaims
+	_, err = JWTManagement.Verify(token, am.AccessTokenKeys.KeyFunc, &claims)
+	assert.NoError(t, err)
+	assert.Equal(t, "5", claims.Subject)
+	assert.Equal(t, &ActorClaims{Subject: "1"}, claims.Actor)
+	assert.LessOrEqual(t, claims.ExpiresAt, time.Now().Add(impersonationLifetime).Unix())
+
+	user, impersonatorID, err := am.authenticateToken(token)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, user.IndexID)
+	assert.Equal(t, 1, impersonatorID)
+}
@@ -0,0 +1,329 @@
+package AuthenticationManagement
+
+import (
+	"EntitlementServer/AuditLog"
+	"EntitlementServer/DatabaseAbstraction"
+	"EntitlementServer/MailManagement"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// reset tokens are short-lived because whoever reads the mail can take over the account
+const passwordResetTokenLifetime = time.Hour
+
+var ErrInvalidResetToken = errors.New("invalid or expired reset token")
+
+// mailer returns the configured mailer, mails are only logged if none is set
+func (am AuthenticationService) mailer() MailManagement.Mailer {
+	if am.Mailer == nil {
+		return MailManagement.LogMailer{}
+	}
+	return am.Mailer
+}
+
+// publicURL is the base URL of the frontend used in links sent to users
+func (am AuthenticationService) publicURL() string {
+	if am.PublicURL == "" {
+		return "http://localhost:8080"
+	}
+	return strings.TrimSuffix(am.PublicURL, "/")
+}
+
+// ChangePassword sets a new password and revokes all sessions and outstanding reset tokens of the user
+// A password violating the policy is reported as a *ValidationError
+func (am AuthenticationService) ChangePassword(userID int, newPassword string) error {
+	user, err := am.DB.GetUserByIndexID(userID)
+	if err != nil {
+		return err
+	}
+
+	err = am.ValidatePassword(newPassword, user.Username)
+	if err != nil {
+		return err
+	}
+
+	passwordHash, err := am.HashPassword(newPassword)
+	if err != nil {
+		return err
+	}
+
+	err = am.DB.UpdateUserPassword(userID, passwordHash)
+	if err != nil {
+		return err
+	}
+
+	err = am.RevokeAllTokens(userID)
+	if err != nil {
+		return err
+	}
+
+	return am.DB.DeletePasswordResetTokensByUserID(userID)
+}
+
+// RequestPasswordReset mails a single-use reset link to the user
+// Unknown users and users without an email address are silently ignored, so the endpoint can't be used to enumerate accounts
+func (am AuthenticationService) RequestPasswordReset(username string) error {
+	user, err := am.lookupUser(username)
+	if err != nil || user.Email == "" {
+		return nil
+	}
+
+	token, err := generateResetToken()
+	if err != nil {
+		return err
+	}
+
+	err = am.DB.AddPasswordResetToken(user.IndexID, hashResetToken(token), time.Now().Add(passwordResetTokenLifetime))
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/reset-password?token=%s", am.publicURL(), url.QueryEscape(token))
+	body := fmt.Sprintf("Hello %s,\n\n"+
+		"someone requested to reset the password of your BKBdemy account.\n"+
+		"Open the following link within one hour to choose a new password:\n\n"+
+		"%s\n\n"+
+		"If you did not request this, you can ignore this mail.\n", user.Username, link)
+
+	return am.mailer().SendMail(user.Email, "Reset your BKBdemy password", body)
+}
+
+// ResetPassword redeems a reset token and sets the new password
+func (am AuthenticationService) ResetPassword(token string, newPassword string) error {
+	_, err := am.resetPassword(token, newPassword)
+	return err
+}
+
+// resetPassword is ResetPassword returning the ID of the user whose password was reset
+func (am AuthenticationService) resetPassword(token string, newPassword string) (int, error) {
+	// Validate against the token's user before redeeming, a rejected password shouldn't burn the token
+	resetToken, err := am.DB.GetPasswordResetToken(hashResetToken(token))
+	if err != nil {
+		return 0, ErrInvalidResetToken
+	}
+	user, err := am.DB.GetUserByIndexID(resetToken.UserID)
+	if err != nil {
+		return 0, err
+	}
+	err = am.ValidatePassword(newPassword, user.Username)
+	if err != nil {
+		return 0, err
+	}
+
+	resetToken, err = am.DB.ConsumePasswordResetToken(resetToken.TokenHash)
+	if err != nil {
+		return 0, ErrInvalidResetToken
+	}
+
+	return resetToken.UserID, am.ChangePassword(resetToken.UserID, newPassword)
+}
+
+func generateResetToken() (string, error) {
+	randomBytes := make([]byte, 32)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%x", randomBytes), nil
+}
+
+// only the hash is stored, a database leak must not allow taking over accounts
+func hashResetToken(token string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(token)))
+}
+
+type messageResponse struct {
+	Message string       `json:"message"`
+	Error   string       `json:"error"`
+	Fields  []FieldError `json:"fields,omitempty"`
+}
+
+type changePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// ChangePasswordHandler godoc
+//
+//	@Summary		Change the password of the current user
+//	@Description	Change the password of the current user, requires the current password
+//	@Description	All sessions and API keys are revoked, the response contains a new token for the current client
+//	@Tags			Authentication
+//	@Accept			json
+//	@Produce		json
+//	@Param			changePasswordRequest	body		changePasswordRequest	true	"Change password request"
+//	@Success		200						{object}	loginResponse
+//	@Failure		400						{object}	loginResponse
+//	@Failure		401						{object}	loginResponse
+//	@Failure		500						{object}	loginResponse
+//	@Security		ApiKeyAuth
+//	@Router			/api/auth/password [post]
+func (am AuthenticationService) ChangePasswordHandler(c *gin.Context) {
+	user := c.MustGet("user").(DatabaseAbstraction.User)
+
+	var request changePasswordRequest
+	err := c.ShouldBindJSON(&request)
+	if err != nil {
+		c.JSON(400, loginResponse{
+			Error: "Invalid request",
+		})
+		return
+	}
+
+	if request.CurrentPassword == "" || request.NewPassword == "" {
+		c.JSON(400, loginResponse{
+			Error: "Empty password",
+		})
+		return
+	}
+
+	valid, err := am.ComparePasswords(user.Password, request.CurrentPassword)
+	if err != nil || !valid {
+		c.JSON(401, loginResponse{
+			Error: "Invalid current password",
+		})
+		return
+	}
+
+	err = am.ChangePassword(user.IndexID, request.NewPassword)
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		c.JSON(400, loginResponse{
+			Error:  "Invalid password",
+			Fields: validationErr.Fields,
+		})
+		return
+	}
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, loginResponse{
+			Error: "Failed to change password",
+		})
+		return
+	}
+
+	am.audit(c, AuditLog.ActionPasswordChanged, nil)
+
+	// All tokens were revoked, including the one used for this request
+	token, err := am.CreateToken(user.IndexID)
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, loginResponse{
+			Error: "Failed to create token",
+		})
+		return
+	}
+
+	am.SetAuthCookie(c, token)
+
+	c.JSON(200, loginResponse{
+		Token: token,
+		Error: "",
+	})
+}
+
+type passwordResetRequest struct {
+	Username string `json:"username"` // username or email address
+}
+
+// RequestPasswordResetHandler godoc
+//
+//	@Summary		Request a password reset mail
+//	@Description	Sends a single-use reset link to the email address of the user
+//	@Description	The response is the same whether or not the user exists
+//	@Tags			Authentication
+//	@Accept			json
+//	@Produce		json
+//	@Param			passwordResetRequest	body		passwordResetRequest	true	"Password reset request"
+//	@Success		200						{object}	messageResponse
+//	@Failure		400						{object}	messageResponse
+//	@Router			/api/auth/password/reset/request [post]
+func (am AuthenticationService) RequestPasswordResetHandler(c *gin.Context) {
+	var request passwordResetRequest
+	err := c.ShouldBindJSON(&request)
+	if err != nil || request.Username == "" {
+		c.JSON(400, messageResponse{
+			Error: "Invalid request",
+		})
+		return
+	}
+
+	err = am.RequestPasswordReset(request.Username)
+	if err != nil {
+		// Don't reveal to the client that the user exists
+		logrus.Errorf("Error sending password reset mail: %v", err)
+	}
+
+	c.JSON(200, messageResponse{
+		Message: "If the account exists and has an email address, a reset link has been sent",
+	})
+}
+
+type resetPasswordRequest struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password"`
+}
+
+// ResetPasswordHandler godoc
+//
+//	@Summary		Reset a password
+//	@Description	Set a new password using the token from a password reset mail
+//	@Description	All sessions and API keys of the user are revoked
+//	@Tags			Authentication
+//	@Accept			json
+//	@Produce		json
+//	@Param			resetPasswordRequest	body		resetPasswordRequest	true	"Reset password request"
+//	@Success		200						{object}	messageResponse
+//	@Failure		400						{object}	messageResponse
+//	@Failure		500						{object}	messageResponse
+//	@Router			/api/auth/password/reset [post]
+func (am AuthenticationService) ResetPasswordHandler(c *gin.Context) {
+	var request resetPasswordRequest
+	err := c.ShouldBindJSON(&request)
+	if err != nil || request.Token == "" || request.NewPassword == "" {
+		c.JSON(400, messageResponse{
+			Error: "Invalid request",
+		})
+		return
+	}
+
+	userID, err := am.resetPassword(request.Token, request.NewPassword)
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		c.JSON(400, messageResponse{
+			Error:  "Invalid password",
+			Fields: validationErr.Fields,
+		})
+		return
+	}
+	if errors.Is(err, ErrInvalidResetToken) {
+		c.JSON(400, messageResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, messageResponse{
+			Error: "Failed to reset password",
+		})
+		return
+	}
+
+	// nobody is signed in, the user proved who they are with the mailed token
+	event := AuditLog.NewEvent(c, AuditLog.ActionPasswordReset)
+	event.ActorID = userID
+	AuditLog.OrDefault(am.Audit).Record(event)
+
+	c.JSON(200, messageResponse{
+		Message: "Password has been reset",
+	})
+}
@@ -0,0 +1,134 @@
+package AuthenticationManagement
+
+import (
+	"EntitlementServer/DatabaseAbstraction"
+	"EntitlementServer/DatabaseAbstraction/mocks"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+type recordingMailer struct {
+	to   []string
+	body []string
+}
+
+func (m *recordingMailer) SendMail(to string, subject string, body string) error {
+	m.to = append(m.to, to)
+	m.body = append(m.body, body)
+	return nil
+}
+
+var testHashParams = Argon2Params{Memory: 64 * 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+var errNoRows = errors.New("no rows in result set")
+
+func TestRequestPasswordReset(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	mailer := &recordingMailer{}
+	am := AuthenticationService{DB: mockDB, Mailer: mailer, PublicURL: "https://bkbdemy.example/"}
+
+	var storedHash string
+	mockDB.On("GetUserByUsername", "student").Return(DatabaseAbstraction.User{IndexID: 1, Username: "student", Email: "student@example.com"}, nil)
+	mockDB.On("AddPasswordResetToken", 1, mock.AnythingOfType("string"), mock.AnythingOfType("time.Time")).Run(func(args mock.Arguments) {
+		storedHash = args.String(1)
+	}).Return(nil)
+
+	err := am.RequestPasswordReset("student")
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{"student@example.com"}, mailer.to)
+	token := regexp.MustCompile(`https://bkbdemy\.example/reset-password\?token=([0-9a-f]+)`).FindStringSubmatch(mailer.body[0])
+	if assert.Len(t, token, 2) {
+		// the database only ever sees the hash of the mailed token
+		assert.NotEqual(t, token[1], storedHash)
+		assert.Equal(t, hashResetToken(token[1]), storedHash)
+	}
+}
+
+func TestRequestPasswordResetUnknownUser(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	mailer := &recordingMailer{}
+	am := AuthenticationService{DB: mockDB, Mailer: mailer}
+
+	mockDB.On("GetUserByUsername", "nobody").Return(DatabaseAbstraction.User{}, errors.New("no rows in result set"))
+	mockDB.On("GetUserByUsername", "nomail").Return(DatabaseAbstraction.User{IndexID: 2, Username: "nomail"}, nil)
+
+	assert.NoError(t, am.RequestPasswordReset("nobody"))
+	assert.NoError(t, am.RequestPasswordReset("nomail"))
+	assert.Empty(t, mailer.to)
+}
+
+func TestResetPassword(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	am := AuthenticationService{DB: mockDB, HashParams: testHashParams}
+
+	validToken := DatabaseAbstraction.PasswordResetToken{IndexID: 1, UserID: 7, TokenHash: hashResetToken("valid")}
+	mockDB.On("GetPasswordResetToken", hashResetToken("valid")).Return(validToken, nil)
+	mockDB.On("GetPasswordResetToken", hashResetToken("used")).Return(DatabaseAbstraction.PasswordResetToken{}, errors.New("no rows in result set"))
+	mockDB.On("ConsumePasswordResetToken", hashResetToken("valid")).Return(validToken, nil)
+	mockDB.On("GetUserByIndexID", 7).Return(DatabaseAbstraction.User{IndexID: 7, Username: "student"}, nil)
+	mockDB.On("UpdateUserPassword", 7, mock.AnythingOfType("string")).Return(nil)
+	mockDB.On("DeleteTokensByUserID", 7).Return(nil)
+	mockDB.On("DeleteAPIKeysByUserID", 7).Return(nil)
+	mockDB.On("DeletePasswordResetTokensByUserID", 7).Return(nil)
+
+	assert.NoError(t, am.ResetPassword("valid", "newpassword"))
+	assert.ErrorIs(t, am.ResetPassword("used", "newpassword"), ErrInvalidResetToken)
+
+	var validationErr *ValidationError
+	assert.ErrorAs(t, am.ResetPassword("valid", "short"), &validationErr)
+	// the username check needs the token's user, it has to pass before the token is redeemed
+	assert.ErrorAs(t, am.ResetPassword("valid", "Student2026!"), &validationErr)
+	assert.Equal(t, "contains_username", validationErr.Fields[0].Code)
+	mockDB.AssertNumberOfCalls(t, "ConsumePasswordResetToken", 1)
+	mockDB.AssertNumberOfCalls(t, "UpdateUserPassword", 1)
+}
+
+func TestChangePasswordHandler(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	am := AuthenticationService{DB: mockDB, HashParams: testHashParams}
+
+	hash, err := am.HashPassword("oldpassword")
+	assert.NoError(t, err)
+	user := DatabaseAbstraction.User{IndexID: 1, Username: "student", Password: hash}
+
+	mockDB.On("UpdateUserPassword", 1, mock.AnythingOfType("string")).Return(nil)
+	mockDB.On("DeleteTokensByUserID", 1).Return(nil)
+	mockDB.On("DeleteAPIKeysByUserID", 1).Return(nil)
+	mockDB.On("DeletePasswordResetTokensByUserID", 1).Return(nil)
+	mockDB.On("GetUserByIndexID", 1).Return(user, nil)
+	mockDB.On("AddToken", 1, mock.AnythingOfType("string"), mock.AnythingOfType("time.Time")).Return(nil)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/api/auth/password", func(c *gin.Context) {
+		c.Set("user", user)
+	}, am.ChangePasswordHandler)
+
+	t.Run("wrong current password", func(t *testing.T) {
+		req, _ := http.NewRequest("POST", "/api/auth/password", strings.NewReader(`{"current_password":"wrong","new_password":"newpassword"}`))
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusUnauthorized, resp.Code)
+		mockDB.AssertNotCalled(t, "UpdateUserPassword", 1, mock.Anything)
+	})
+
+	t.Run("correct current password", func(t *testing.T) {
+		req, _ := http.NewRequest("POST", "/api/auth/password", strings.NewReader(`{"current_password":"oldpassword","new_password":"newpassword"}`))
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+
+		assert.Equal(t, http.StatusOK, resp.Code)
+		mockDB.AssertCalled(t, "DeleteTokensByUserID", 1)
+		mockDB.AssertCalled(t, "DeleteAPIKeysByUserID", 1)
+		mockDB.AssertCalled(t, "AddToken", 1, mock.AnythingOfType("string"), mock.AnythingOfType("time.Time"))
+	})
+}
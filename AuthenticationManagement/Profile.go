@@ -0,0 +1,247 @@
+package AuthenticationManagement
+
+import (
+	"EntitlementServer/AuditLog"
+	"EntitlementServer/DatabaseAbstraction"
+	"EntitlementServer/Invoicing"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/text/language"
+	"net/url"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	maxDisplayNameLength = 64
+	maxAvatarURLLength   = 2048
+)
+
+// ProfileUpdate contains the fields of a profile change, nil fields are left unchanged and empty strings clear them
+type ProfileUpdate struct {
+	Username    *string `json:"username"`
+	DisplayName *string `json:"display_name"`
+	AvatarURL   *string `json:"avatar_url"`
+	Locale      *string `json:"locale"`
+	Country     *string `json:"country"` // billing country for invoices, e.g. DE
+}
+
+// UpdateProfile validates and applies a profile change and returns the updated user
+// Invalid fields are reported as a *ValidationError
+func (am AuthenticationService) UpdateProfile(user DatabaseAbstraction.User, update ProfileUpdate) (DatabaseAbstraction.User, error) {
+	validationErr := &ValidationError{}
+
+	username := user.Username
+	if update.Username != nil {
+		username = NormalizeUsername(*update.Username)
+		err := am.ValidateUsername(username)
+		if !collectFieldErrors(validationErr, err) {
+			return DatabaseAbstraction.User{}, err
+		}
+		if err == nil {
+			// changing only the case of the own username is fine
+			existing, err := am.DB.GetUserByUsername(username)
+			if err == nil && existing.IndexID != user.IndexID {
+				validationErr.add("username", "taken", "is already taken")
+			}
+		}
+	}
+
+	displayName := user.DisplayName
+	if update.DisplayName != nil {
+		displayName = strings.TrimSpace(*update.DisplayName)
+		if utf8.RuneCountInString(displayName) > maxDisplayNameLength {
+			validationErr.add("display_name", "too_long", fmt.Sprintf("must be at most %d characters long", maxDisplayNameLength))
+		} else if strings.IndexFunc(displayName, unicode.IsControl) != -1 {
+			validationErr.add("display_name", "invalid_characters", "must not contain control characters")
+		}
+	}
+
+	avatarURL := user.AvatarURL
+	if update.AvatarURL != nil {
+		avatarURL = strings.TrimSpace(*update.AvatarURL)
+		if avatarURL != "" {
+			// only https, the frontend embeds the URL and must not load mixed content or javascript: URLs
+			parsed, err := url.Parse(avatarURL)
+			if err != nil || parsed.Scheme != "https" || parsed.Host == "" || len(avatarURL) > maxAvatarURLLength {
+				validationErr.add("avatar_url", "invalid", "must be an https URL")
+			}
+		}
+	}
+
+	locale := user.Locale
+	if update.Locale != nil {
+		locale = strings.TrimSpace(*update.Locale)
+		if locale != "" {
+			tag, err := language.Parse(locale)
+			if err != nil {
+				validationErr.add("locale", "invalid", "must be a language tag like de or en-US")
+			} else {
+				locale = tag.String()
+			}
+		}
+	}
+
+	country := user.Country
+	if update.Country != nil {
+		country = strings.TrimSpace(*update.Country)
+		if country != "" {
+			normalized, err := Invoicing.NormalizeCountry(country)
+			if err != nil {
+				validationErr.add("country", "invalid", err.Error())
+			} else {
+				country = normalized
+			}
+		}
+	}
+
+	err := validationErr.errOrNil()
+	if err != nil {
+		return DatabaseAbstraction.User{}, err
+	}
+
+	if username != user.Username {
+		err = am.DB.UpdateUserUsername(user.IndexID, username)
+		if err != nil {
+			return DatabaseAbstraction.User{}, err
+		}
+		user.Username = username
+	}
+
+	err = am.DB.UpdateUserProfile(user.IndexID, displayName, avatarURL, locale, country)
+	if err != nil {
+		return DatabaseAbstraction.User{}, err
+	}
+	user.DisplayName = displayName
+	user.AvatarURL = avatarURL
+	user.Locale = locale
+	user.Country = country
+
+	return user, nil
+}
+
+// DeleteAccount anonymizes the account after checking the password and ends all of its sessions
+// Purchases are kept for accounting, comments stay without the author's name
+func (am AuthenticationService) DeleteAccount(user DatabaseAbstraction.User, password string) error {
+	valid, err := am.ComparePasswords(user.Password, password)
+	if err != nil || !valid {
+		return ErrInvalidPassword
+	}
+
+	err = am.DB.AnonymizeUser(user.IndexID)
+	if err != nil {
+		return err
+	}
+
+	// database tokens are gone with the account, this covers stateless access tokens
+	err = am.RevokeAllTokens(user.IndexID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// UpdateProfileHandler godoc
+//
+//	@Summary		Update the profile of the current user
+//	@Description	Change username, display name, avatar URL, locale or billing country, omitted fields are left unchanged
+//	@Description	Empty strings clear display name, avatar URL, locale and country
+//	@Tags			Authentication
+//	@Accept			json
+//	@Produce		json
+//	@Param			profileUpdate	body		ProfileUpdate	true	"Profile update"
+//	@Success		200				{object}	meResponse
+//	@Failure		400				{object}	messageResponse
+//	@Failure		500				{object}	messageResponse
+//	@Security		ApiKeyAuth
+//	@Router			/api/auth/me [patch]
+func (am AuthenticationService) UpdateProfileHandler(c *gin.Context) {
+	user := c.MustGet("user").(DatabaseAbstraction.User)
+
+	var update ProfileUpdate
+	err := c.ShouldBindJSON(&update)
+	if err != nil {
+		c.JSON(400, messageResponse{
+			Error: "Invalid request",
+		})
+		return
+	}
+
+	user, err = am.UpdateProfile(user, update)
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		c.JSON(400, messageResponse{
+			Error:  "Invalid profile",
+			Fields: validationErr.Fields,
+		})
+		return
+	}
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, messageResponse{
+			Error: "Failed to update profile",
+		})
+		return
+	}
+
+	c.JSON(200, newMeResponse(user))
+}
+
+type deleteAccountRequest struct {
+	Password string `json:"password"`
+}
+
+// DeleteAccountHandler godoc
+//
+//	@Summary		Delete the current user
+//	@Description	Delete the account of the current user, requires the password
+//	@Description	Personal data is removed and all sessions and API keys are revoked, subscriptions end right away. Purchases are kept for accounting,
+//	@Description	comments are kept without the author's name. The user leaves their organizations, which frees their seats
+//	@Tags			Authentication
+//	@Accept			json
+//	@Produce		json
+//	@Param			deleteAccountRequest	body		deleteAccountRequest	true	"Delete account request"
+//	@Success		200						{object}	messageResponse
+//	@Failure		400						{object}	messageResponse
+//	@Failure		401						{object}	messageResponse
+//	@Failure		500						{object}	messageResponse
+//	@Security		ApiKeyAuth
+//	@Router			/api/auth/me [delete]
+func (am AuthenticationService) DeleteAccountHandler(c *gin.Context) {
+	user := c.MustGet("user").(DatabaseAbstraction.User)
+
+	var request deleteAccountRequest
+	err := c.ShouldBindJSON(&request)
+	if err != nil || request.Password == "" {
+		c.JSON(400, messageResponse{
+			Error: "Invalid request",
+		})
+		return
+	}
+
+	err = am.DeleteAccount(user, request.Password)
+	if errors.Is(err, ErrInvalidPassword) {
+		c.JSON(401, messageResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, messageResponse{
+			Error: "Failed to delete account",
+		})
+		return
+	}
+
+	am.clearAuthCookie(c)
+	am.audit(c, AuditLog.ActionAccountDeleted, nil)
+
+	c.JSON(200, messageResponse{
+		Message: "Account deleted",
+	})
+}
@@ -0,0 +1,77 @@
+package AuthenticationManagement
+
+import (
+	"EntitlementServer/DatabaseAbstraction"
+	"EntitlementServer/DatabaseAbstraction/mocks"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestUpdateProfile(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	am := AuthenticationService{DB: mockDB}
+	user := DatabaseAbstraction.User{IndexID: 1, Username: "max", Locale: "de"}
+
+	mockDB.On("GetUserByUsername", "admin").Return(DatabaseAbstraction.User{IndexID: 2, Username: "admin"}, nil)
+	mockDB.On("GetUserByUsername", "Max").Return(user, nil)
+	mockDB.On("UpdateUserUsername", 1, "Max").Return(nil)
+	mockDB.On("UpdateUserProfile", 1, "Max Mustermann", "https://cdn.example/max.png", "de", "AT").Return(nil)
+
+	taken, invalidURL, invalidLocale, invalidCountry := "admin", "javascript:alert(1)", "not a locale", "EU"
+	_, err := am.UpdateProfile(user, ProfileUpdate{Username: &taken, AvatarURL: &invalidURL, Locale: &invalidLocale, Country: &invalidCountry})
+	var validationErr *ValidationError
+	if assert.ErrorAs(t, err, &validationErr) {
+		assert.Len(t, validationErr.Fields, 4)
+	}
+	mockDB.AssertNotCalled(t, "UpdateUserProfile", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+
+	// a case change of the own username is not a conflict, omitted fields are kept
+	username, displayName, avatarURL, country := "Max", " Max Mustermann ", "https://cdn.example/max.png", "at"
+	updated, err := am.UpdateProfile(user, ProfileUpdate{Username: &username, DisplayName: &displayName, AvatarURL: &avatarURL, Country: &country})
+	assert.NoError(t, err)
+	assert.Equal(t, "Max", updated.Username)
+	assert.Equal(t, "Max Mustermann", updated.DisplayName)
+	assert.Equal(t, "de", updated.Locale)
+	assert.Equal(t, "AT", updated.Country)
+}
+
+func TestDeleteAccountHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockDB := new(mocks.DBOrm)
+	am := AuthenticationService{DB: mockDB, HashParams: testHashParams}
+
+	passwordHash, err := am.HashPassword("correct password")
+	assert.NoError(t, err)
+	user := DatabaseAbstraction.User{IndexID: 1, Username: "max", Password: passwordHash}
+
+	mockDB.On("GetTokenByHash", "token").Return(DatabaseAbstraction.Token{UserID: 1}, nil)
+	mockDB.On("GetUserByIndexID", 1).Return(user, nil)
+	mockDB.On("AnonymizeUser", 1).Return(nil)
+	mockDB.On("DeleteTokensByUserID", 1).Return(nil)
+	mockDB.On("DeleteAPIKeysByUserID", 1).Return(nil)
+
+	router := gin.Default()
+	am.RegisterHandlers(router)
+
+	deleteAccount := func(password string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("DELETE", "/api/auth/me", strings.NewReader(`{"password": "`+password+`"}`))
+		req.Header.Set("Authorization", "Bearer token")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, 401, deleteAccount("wrong password").Code)
+	mockDB.AssertNotCalled(t, "AnonymizeUser", 1)
+
+	w := deleteAccount("correct password")
+	assert.Equal(t, 200, w.Code)
+	mockDB.AssertCalled(t, "AnonymizeUser", 1)
+	mockDB.AssertCalled(t, "DeleteTokensByUserID", 1)
+	assert.Contains(t, w.Header().Get("Set-Cookie"), "Max-Age=0")
+}
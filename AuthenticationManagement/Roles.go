@@ -0,0 +1,49 @@
+package AuthenticationManagement
+
+import (
+	"EntitlementServer/DatabaseAbstraction"
+	"errors"
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	ErrAccountSuspended = errors.New("this account has been suspended")
+	ErrAccountDeleted   = errors.New("this account has been deleted")
+	ErrForbidden        = errors.New("insufficient permissions")
+)
+
+// CheckAccount returns ErrAccountSuspended or ErrAccountDeleted if the user must not get a new session
+// Every way of logging in has to call it before creating a token
+func CheckAccount(user DatabaseAbstraction.User) error {
+	switch {
+	case user.Deleted:
+		return ErrAccountDeleted
+	case user.Suspended:
+		return ErrAccountSuspended
+	}
+	return nil
+}
+
+// HasRole reports whether the user has one of the roles
+func HasRole(user DatabaseAbstraction.User, roles ...string) bool {
+	for _, role := range roles {
+		if user.Role == role {
+			return true
+		}
+	}
+	return false
+}
+
+// RequireRole returns a middleware that only lets users with one of the roles through
+// It has to run after AuthenticationMiddleware, which sets the user
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("user").(DatabaseAbstraction.User)
+		if !HasRole(user, roles...) {
+			c.JSON(403, gin.H{"error": ErrForbidden.Error()})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
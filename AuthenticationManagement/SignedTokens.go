@@ -0,0 +1,76 @@
+package AuthenticationManagement
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Signed tokens carry their own payload and expiry, so they don't need a database table
+// The purpose is part of the signature, a token issued for one flow can't be replayed in another
+
+var (
+	ErrNoSigningKey       = errors.New("no signing key configured")
+	ErrInvalidSignedToken = errors.New("invalid signed token")
+	ErrExpiredSignedToken = errors.New("signed token has expired")
+)
+
+func (am AuthenticationService) signToken(purpose string, payload string, expiry time.Time) (string, error) {
+	if len(am.SigningKey) == 0 {
+		return "", ErrNoSigningKey
+	}
+
+	body := strconv.FormatInt(expiry.Unix(), 10) + "|" + payload
+	encodedBody := base64.RawURLEncoding.EncodeToString([]byte(body))
+	signature := am.tokenSignature(purpose, encodedBody)
+
+	return encodedBody + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+func (am AuthenticationService) verifySignedToken(purpose string, token string) (string, error) {
+	if len(am.SigningKey) == 0 {
+		return "", ErrNoSigningKey
+	}
+
+	encodedBody, encodedSignature, found := strings.Cut(token, ".")
+	if !found {
+		return "", ErrInvalidSignedToken
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(encodedSignature)
+	if err != nil {
+		return "", ErrInvalidSignedToken
+	}
+	if !hmac.Equal(signature, am.tokenSignature(purpose, encodedBody)) {
+		return "", ErrInvalidSignedToken
+	}
+
+	body, err := base64.RawURLEncoding.DecodeString(encodedBody)
+	if err != nil {
+		return "", ErrInvalidSignedToken
+	}
+
+	expiryString, payload, found := strings.Cut(string(body), "|")
+	if !found {
+		return "", ErrInvalidSignedToken
+	}
+	expiry, err := strconv.ParseInt(expiryString, 10, 64)
+	if err != nil {
+		return "", ErrInvalidSignedToken
+	}
+	if time.Now().Unix() > expiry {
+		return "", ErrExpiredSignedToken
+	}
+
+	return payload, nil
+}
+
+func (am AuthenticationService) tokenSignature(purpose string, encodedBody string) []byte {
+	mac := hmac.New(sha256.New, am.SigningKey)
+	mac.Write([]byte(purpose + "." + encodedBody))
+	return mac.Sum(nil)
+}
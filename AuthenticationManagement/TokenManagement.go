@@ -2,13 +2,18 @@ package AuthenticationManagement
 
 import (
 	"EntitlementServer/DatabaseAbstraction"
+	"EntitlementServer/JWTManagement"
 	"crypto/rand"
 	"crypto/sha256"
 	"fmt"
 	"github.com/sirupsen/logrus"
+	"strconv"
 	"time"
 )
 
+// by default, tokens expire after 7 days, access tokens as well so switching the format doesn't change when users have to log in again
+const tokenLifetime = 7 * 24 * time.Hour
+
 func (am AuthenticationService) CreateToken(userid int) (string, error) {
 	// Get the user from the database
 	user, err := am.DB.GetUserByIndexID(userid)
@@ -17,9 +22,18 @@ func (am AuthenticationService) CreateToken(userid int) (string, error) {
 		return "", err
 	}
 
+	return am.createSessionToken(user, 0, tokenLifetime)
+}
+
+// createSessionToken issues an access token or stores a database token, impersonatorID is 0 for the user's own sessions
+func (am AuthenticationService) createSessionToken(user DatabaseAbstraction.User, impersonatorID int, lifetime time.Duration) (string, error) {
+	if am.AccessTokenKeys != nil {
+		return am.createAccessToken(user, impersonatorID, lifetime)
+	}
+
 	// Use random bytes as salt
-	randomBytes := make([]byte, argon2settings.saltLength)
-	_, err = rand.Read(randomBytes)
+	randomBytes := make([]byte, am.hashParams().SaltLength)
+	_, err := rand.Read(randomBytes)
 	if err != nil {
 		return "", err
 	}
@@ -27,8 +41,12 @@ func (am AuthenticationService) CreateToken(userid int) (string, error) {
 	// Create a token by hashing user+their hashed password+the random bytes
 	token := fmt.Sprintf("%x", sha256.Sum256([]byte(user.Username+user.Password+string(randomBytes))))
 
-	// by default, tokens expire after 7 days
-	err = am.DB.AddToken(user.IndexID, token, time.Now().Add(time.Hour*24*7))
+	expiry := time.Now().Add(lifetime)
+	if impersonatorID != 0 {
+		err = am.DB.AddImpersonationToken(user.IndexID, impersonatorID, token, expiry)
+	} else {
+		err = am.DB.AddToken(user.IndexID, token, expiry)
+	}
 	if err != nil {
 		return "", err
 	}
@@ -37,21 +55,57 @@ func (am AuthenticationService) CreateToken(userid int) (string, error) {
 }
 
 func (am AuthenticationService) ValidateToken(token string) (bool, DatabaseAbstraction.User, error) {
+	user, _, err := am.authenticateToken(token)
+	if err != nil {
+		return false, DatabaseAbstraction.User{}, err
+	}
+	if user.IndexID == 0 {
+		return false, DatabaseAbstraction.User{}, nil
+	}
+
+	return true, user, nil
+}
+
+// authenticateToken returns the user of a session token and the ID of the admin impersonating them, 0 if there is none
+func (am AuthenticationService) authenticateToken(token string) (DatabaseAbstraction.User, int, error) {
+	// Access tokens don't need the token lookup, database tokens issued before the switch stay valid
+	if am.AccessTokenKeys != nil && isAccessToken(token) {
+		claims, userID, err := am.parseAccessToken(token)
+		if err != nil {
+			return DatabaseAbstraction.User{}, 0, err
+		}
+
+		impersonatorID := 0
+		if claims.Actor != nil {
+			impersonatorID, err = strconv.Atoi(claims.Actor.Subject)
+			if err != nil {
+				return DatabaseAbstraction.User{}, 0, JWTManagement.ErrMalformedToken
+			}
+		}
+
+		// The handlers need the current balance and password hash, which can't be taken from the token
+		user, err := am.DB.GetUserByIndexID(userID)
+		if err != nil {
+			return DatabaseAbstraction.User{}, 0, err
+		}
+		return user, impersonatorID, nil
+	}
+
 	// Get the user from the database
 	userToken, err := am.DB.GetTokenByHash(token)
 	if err != nil {
 		logrus.Errorf("Error getting token from database: %v", err)
-		return false, DatabaseAbstraction.User{}, err
+		return DatabaseAbstraction.User{}, 0, err
 	}
 	if userToken == (DatabaseAbstraction.Token{}) {
-		return false, DatabaseAbstraction.User{}, nil
+		return DatabaseAbstraction.User{}, 0, nil
 	}
 
 	// Get the user from the database
 	user, err := am.DB.GetUserByIndexID(userToken.UserID)
 	if err != nil {
-		return false, DatabaseAbstraction.User{}, err
+		return DatabaseAbstraction.User{}, 0, err
 	}
 
-	return true, user, nil
+	return user, userToken.ImpersonatorID, nil
 }
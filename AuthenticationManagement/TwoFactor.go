@@ -0,0 +1,548 @@
+package AuthenticationManagement
+
+import (
+	"EntitlementServer/AuditLog"
+	"EntitlementServer/DatabaseAbstraction"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/sirupsen/logrus"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TOTP as described in RFC 6238 with the parameters every authenticator app supports
+const (
+	totpIssuer        = "BKBdemy"
+	totpPeriod        = 30
+	totpDigits        = 6
+	totpSkew          = 1 // accepted time steps before and after the current one, to tolerate clock drift
+	recoveryCodeCount = 10
+
+	twoFactorChallengePurpose  = "totp-challenge"
+	twoFactorChallengeLifetime = 5 * time.Minute
+	twoFactorChallengeAttempts = 5 // codes that can be tried per challenge, afterwards the password has to be entered again
+)
+
+var (
+	ErrTwoFactorNotEnrolled      = errors.New("two-factor authentication is not set up")
+	ErrTwoFactorAlreadyEnabled   = errors.New("two-factor authentication is already enabled")
+	ErrInvalidTwoFactorCode      = errors.New("invalid two-factor code")
+	ErrInvalidTwoFactorChallenge = errors.New("invalid or expired login challenge")
+	ErrInvalidPassword           = errors.New("invalid password")
+	ErrTwoFactorSetupRequired    = errors.New("two-factor authentication has to be set up after a login with the password first")
+)
+
+var base32NoPadding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// totpCode computes the code of a time step (RFC 4226 dynamic truncation)
+func totpCode(secret []byte, step int64) string {
+	counter := make([]byte, 8)
+	binary.BigEndian.PutUint64(counter, uint64(step))
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(counter)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", totpDigits, value%uint32(math.Pow10(totpDigits)))
+}
+
+// TOTPURI returns the otpauth URI that authenticator apps import, usually shown as a QR code
+func TOTPURI(username string, secret string) string {
+	label := url.PathEscape(totpIssuer + ":" + username)
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", totpIssuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", strconv.Itoa(totpDigits))
+	query.Set("period", strconv.Itoa(totpPeriod))
+
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// validateTOTP checks a code against the user's secret
+// Accepted time steps are recorded, so a code can't be replayed, even within its validity window
+func (am AuthenticationService) validateTOTP(user DatabaseAbstraction.User, code string) error {
+	if user.TOTPSecret == "" {
+		return ErrTwoFactorNotEnrolled
+	}
+
+	secret, err := base32NoPadding.DecodeString(user.TOTPSecret)
+	if err != nil {
+		return err
+	}
+
+	code = strings.TrimSpace(code)
+	currentStep := time.Now().Unix() / totpPeriod
+	for offset := int64(-totpSkew); offset <= totpSkew; offset++ {
+		step := currentStep + offset
+		if hmac.Equal([]byte(totpCode(secret, step)), []byte(code)) {
+			if am.DB.AdvanceUserTOTPStep(user.IndexID, step) != nil {
+				return ErrInvalidTwoFactorCode
+			}
+			return nil
+		}
+	}
+
+	return ErrInvalidTwoFactorCode
+}
+
+// validateSecondFactor accepts either a TOTP code or one of the user's recovery codes
+func (am AuthenticationService) validateSecondFactor(user DatabaseAbstraction.User, code string) error {
+	if len(strings.TrimSpace(code)) == totpDigits {
+		return am.validateTOTP(user, code)
+	}
+
+	if am.DB.ConsumeRecoveryCode(user.IndexID, hashRecoveryCode(code)) != nil {
+		return ErrInvalidTwoFactorCode
+	}
+	logrus.Infof("User %d logged in with a recovery code", user.IndexID)
+	return nil
+}
+
+// EnrollTOTP creates a new secret for the user, it has to be confirmed with ActivateTOTP before it is required on login
+func (am AuthenticationService) EnrollTOTP(user DatabaseAbstraction.User) (string, error) {
+	if user.TOTPEnabled {
+		return "", ErrTwoFactorAlreadyEnabled
+	}
+
+	secretBytes := make([]byte, 20) // 160 bit, as recommended by RFC 4226
+	_, err := rand.Read(secretBytes)
+	if err != nil {
+		return "", err
+	}
+	secret := base32NoPadding.EncodeToString(secretBytes)
+
+	err = am.DB.SetUserTOTPSecret(user.IndexID, secret)
+	if err != nil {
+		return "", err
+	}
+
+	return secret, nil
+}
+
+// ActivateTOTP verifies the first code from the authenticator app and returns the recovery codes
+// The recovery codes are only stored hashed, this is the only time they are shown
+func (am AuthenticationService) ActivateTOTP(user DatabaseAbstraction.User, code string) ([]string, error) {
+	if user.TOTPEnabled {
+		return nil, ErrTwoFactorAlreadyEnabled
+	}
+
+	err := am.validateTOTP(user, code)
+	if err != nil {
+		return nil, err
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	err = am.DB.ReplaceRecoveryCodes(user.IndexID, hashes)
+	if err != nil {
+		return nil, err
+	}
+
+	err = am.DB.EnableUserTOTP(user.IndexID)
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// DisableTOTP turns two-factor authentication off, it requires the password and a current code
+func (am AuthenticationService) DisableTOTP(user DatabaseAbstraction.User, password string, code string) error {
+	if !user.TOTPEnabled {
+		return ErrTwoFactorNotEnrolled
+	}
+
+	valid, err := am.ComparePasswords(user.Password, password)
+	if err != nil || !valid {
+		return ErrInvalidPassword
+	}
+
+	err = am.validateSecondFactor(user, code)
+	if err != nil {
+		return err
+	}
+
+	return am.DB.DisableUserTOTP(user.IndexID)
+}
+
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+
+	for i := range codes {
+		randomBytes := make([]byte, 5)
+		_, err := rand.Read(randomBytes)
+		if err != nil {
+			return nil, nil, err
+		}
+		encoded := strings.ToLower(base32NoPadding.EncodeToString(randomBytes))
+		codes[i] = encoded[:4] + "-" + encoded[4:]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+
+	return codes, hashes, nil
+}
+
+// recovery codes are typed by hand, so case and separators don't matter
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(normalized)))
+}
+
+// createTwoFactorChallenge issues the token that completes a login after the password step
+// It is bound to the current password hash, so changing the password invalidates outstanding challenges.
+// The database counts the attempts per challenge, only its hash is stored
+func (am AuthenticationService) createTwoFactorChallenge(user DatabaseAbstraction.User) (string, error) {
+	nonce := make([]byte, 16)
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return "", err
+	}
+
+	expiry := time.Now().Add(twoFactorChallengeLifetime)
+	payload := strconv.Itoa(user.IndexID) + "|" + passwordFingerprint(user.Password) + "|" + base32NoPadding.EncodeToString(nonce)
+	challenge, err := am.signToken(twoFactorChallengePurpose, payload, expiry)
+	if err != nil {
+		return "", err
+	}
+
+	err = am.DB.AddTwoFactorChallenge(user.IndexID, hashChallenge(challenge), expiry)
+	if err != nil {
+		return "", err
+	}
+
+	return challenge, nil
+}
+
+func hashChallenge(challenge string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(challenge)))
+}
+
+// completeTwoFactorChallenge returns the user of a valid challenge even if the code is wrong, so the failed attempt can be audited
+func (am AuthenticationService) completeTwoFactorChallenge(challenge string, code string) (DatabaseAbstraction.User, error) {
+	payload, err := am.verifySignedToken(twoFactorChallengePurpose, challenge)
+	if err != nil {
+		return DatabaseAbstraction.User{}, ErrInvalidTwoFactorChallenge
+	}
+
+	userIDString, rest, _ := strings.Cut(payload, "|")
+	fingerprint, _, _ := strings.Cut(rest, "|")
+	userID, err := strconv.Atoi(userIDString)
+	if err != nil {
+		return DatabaseAbstraction.User{}, ErrInvalidTwoFactorChallenge
+	}
+
+	user, err := am.DB.GetUserByIndexID(userID)
+	if err != nil || fingerprint != passwordFingerprint(user.Password) || !user.TOTPEnabled {
+		return DatabaseAbstraction.User{}, ErrInvalidTwoFactorChallenge
+	}
+
+	// the attempt is counted before the code is checked, so parallel guesses can't exceed the limit either
+	err = am.DB.UseTwoFactorChallenge(hashChallenge(challenge), twoFactorChallengeAttempts)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return user, ErrInvalidTwoFactorChallenge
+	}
+	if err != nil {
+		return user, err
+	}
+
+	err = am.validateSecondFactor(user, code)
+	if err != nil {
+		return user, err
+	}
+
+	err = am.DB.DeleteTwoFactorChallenge(hashChallenge(challenge))
+	if err != nil {
+		return user, err
+	}
+
+	return user, nil
+}
+
+// StartTwoFactorLogin is the second factor for logins that don't use the password, like external identity providers
+// It returns a challenge for /api/auth/login/2fa if the user has two-factor authentication enabled, or "" if the login
+// can be completed right away. Users whose role requires two-factor authentication but who haven't set it up get
+// ErrTwoFactorSetupRequired, only a password login lets them enroll
+func (am AuthenticationService) StartTwoFactorLogin(user DatabaseAbstraction.User) (string, error) {
+	if am.twoFactorSetupMissing(user) {
+		return "", ErrTwoFactorSetupRequired
+	}
+	if !user.TOTPEnabled {
+		return "", nil
+	}
+	return am.createTwoFactorChallenge(user)
+}
+
+func passwordFingerprint(passwordHash string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(passwordHash)))[:16]
+}
+
+// twoFactorSetupMissing reports whether the role of the user requires two-factor authentication but it isn't enabled yet
+func (am AuthenticationService) twoFactorSetupMissing(user DatabaseAbstraction.User) bool {
+	return !user.TOTPEnabled && am.twoFactorRequiredForRole(user.Role)
+}
+
+func (am AuthenticationService) twoFactorRequiredForRole(role string) bool {
+	for _, requiredRole := range am.TwoFactorRequiredRoles {
+		if requiredRole == role {
+			return true
+		}
+	}
+	return false
+}
+
+// twoFactorSetupPaths are the only routes a user who has to enroll can use before enrolling
+var twoFactorSetupPaths = map[string]bool{
+	"/api/auth/me":           true,
+	"/api/auth/logout":       true,
+	"/api/auth/password":     true,
+	"/api/auth/email":        true,
+	"/api/auth/email/resend": true,
+	"/api/auth/2fa/enroll":   true,
+	"/api/auth/2fa/activate": true,
+}
+
+type enrollTOTPResponse struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+	Error      string `json:"error"`
+}
+
+// EnrollTOTPHandler godoc
+//
+//	@Summary		Start two-factor enrollment
+//	@Description	Creates a new TOTP secret and returns it with an otpauth URI for authenticator apps
+//	@Description	Two-factor authentication is enabled once the first code is confirmed with /api/auth/2fa/activate
+//	@Tags			Authentication
+//	@Produce		json
+//	@Success		200	{object}	enrollTOTPResponse
+//	@Failure		400	{object}	enrollTOTPResponse
+//	@Failure		500	{object}	enrollTOTPResponse
+//	@Security		ApiKeyAuth
+//	@Router			/api/auth/2fa/enroll [post]
+func (am AuthenticationService) EnrollTOTPHandler(c *gin.Context) {
+	user := c.MustGet("user").(DatabaseAbstraction.User)
+
+	secret, err := am.EnrollTOTP(user)
+	if errors.Is(err, ErrTwoFactorAlreadyEnabled) {
+		c.JSON(400, enrollTOTPResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, enrollTOTPResponse{
+			Error: "Failed to start enrollment",
+		})
+		return
+	}
+
+	c.JSON(200, enrollTOTPResponse{
+		Secret:     secret,
+		OTPAuthURI: TOTPURI(user.Username, secret),
+	})
+}
+
+type twoFactorCodeRequest struct {
+	Code string `json:"code"`
+}
+
+type activateTOTPResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+	Error         string   `json:"error"`
+}
+
+// ActivateTOTPHandler godoc
+//
+//	@Summary		Finish two-factor enrollment
+//	@Description	Confirms the first code from the authenticator app and enables two-factor authentication
+//	@Description	The response contains single-use recovery codes, they are not shown again
+//	@Tags			Authentication
+//	@Accept			json
+//	@Produce		json
+//	@Param			twoFactorCodeRequest	body		twoFactorCodeRequest	true	"Code from the authenticator app"
+//	@Success		200						{object}	activateTOTPResponse
+//	@Failure		400						{object}	activateTOTPResponse
+//	@Failure		500						{object}	activateTOTPResponse
+//	@Security		ApiKeyAuth
+//	@Router			/api/auth/2fa/activate [post]
+func (am AuthenticationService) ActivateTOTPHandler(c *gin.Context) {
+	user := c.MustGet("user").(DatabaseAbstraction.User)
+
+	var request twoFactorCodeRequest
+	err := c.ShouldBindJSON(&request)
+	if err != nil || request.Code == "" {
+		c.JSON(400, activateTOTPResponse{
+			Error: "Invalid request",
+		})
+		return
+	}
+
+	codes, err := am.ActivateTOTP(user, request.Code)
+	if errors.Is(err, ErrTwoFactorAlreadyEnabled) || errors.Is(err, ErrTwoFactorNotEnrolled) || errors.Is(err, ErrInvalidTwoFactorCode) {
+		c.JSON(400, activateTOTPResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, activateTOTPResponse{
+			Error: "Failed to enable two-factor authentication",
+		})
+		return
+	}
+
+	am.audit(c, AuditLog.ActionTwoFactorEnabled, nil)
+
+	c.JSON(200, activateTOTPResponse{
+		RecoveryCodes: codes,
+	})
+}
+
+type disableTOTPRequest struct {
+	Password string `json:"password"`
+	Code     string `json:"code"` // TOTP or recovery code
+}
+
+// DisableTOTPHandler godoc
+//
+//	@Summary		Disable two-factor authentication
+//	@Description	Disables two-factor authentication, requires the password and a TOTP or recovery code
+//	@Tags			Authentication
+//	@Accept			json
+//	@Produce		json
+//	@Param			disableTOTPRequest	body		disableTOTPRequest	true	"Disable request"
+//	@Success		200					{object}	messageResponse
+//	@Failure		400					{object}	messageResponse
+//	@Failure		500					{object}	messageResponse
+//	@Security		ApiKeyAuth
+//	@Router			/api/auth/2fa/disable [post]
+func (am AuthenticationService) DisableTOTPHandler(c *gin.Context) {
+	user := c.MustGet("user").(DatabaseAbstraction.User)
+
+	var request disableTOTPRequest
+	err := c.ShouldBindJSON(&request)
+	if err != nil || request.Password == "" || request.Code == "" {
+		c.JSON(400, messageResponse{
+			Error: "Invalid request",
+		})
+		return
+	}
+
+	if am.twoFactorRequiredForRole(user.Role) {
+		c.JSON(400, messageResponse{
+			Error: "Two-factor authentication is mandatory for your account",
+		})
+		return
+	}
+
+	err = am.DisableTOTP(user, request.Password, request.Code)
+	if errors.Is(err, ErrTwoFactorNotEnrolled) || errors.Is(err, ErrInvalidTwoFactorCode) || errors.Is(err, ErrInvalidPassword) {
+		c.JSON(400, messageResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, messageResponse{
+			Error: "Failed to disable two-factor authentication",
+		})
+		return
+	}
+
+	am.audit(c, AuditLog.ActionTwoFactorDisabled, nil)
+
+	c.JSON(200, messageResponse{
+		Message: "Two-factor authentication disabled",
+	})
+}
+
+type loginTwoFactorRequest struct {
+	Challenge string `json:"challenge"`
+	Code      string `json:"code"` // TOTP or recovery code
+}
+
+// LoginTwoFactorHandler godoc
+//
+//	@Summary		Complete a login with two-factor authentication
+//	@Description	Second login step for users with two-factor authentication
+//	@Description	challenge is returned by /api/auth/login, code is a TOTP or recovery code
+//	@Description	A challenge allows 5 attempts, afterwards the login has to be started again
+//	@Description	Suspended accounts are rejected with 403
+//	@Tags			Authentication
+//	@Accept			json
+//	@Produce		json
+//	@Param			loginTwoFactorRequest	body		loginTwoFactorRequest	true	"Second factor"
+//	@Success		200						{object}	loginResponse
+//	@Failure		400						{object}	loginResponse
+//	@Failure		401						{object}	loginResponse
+//	@Failure		403						{object}	loginResponse
+//	@Failure		500						{object}	loginResponse
+//	@Router			/api/auth/login/2fa [post]
+func (am AuthenticationService) LoginTwoFactorHandler(c *gin.Context) {
+	var request loginTwoFactorRequest
+	err := c.ShouldBindJSON(&request)
+	if err != nil || request.Challenge == "" || request.Code == "" {
+		c.JSON(400, loginResponse{
+			Error: "Invalid request",
+		})
+		return
+	}
+
+	user, err := am.completeTwoFactorChallenge(request.Challenge, request.Code)
+	if err != nil {
+		am.auditLogin(c, user, "", "two_factor", err.Error())
+		c.JSON(401, loginResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+
+	// the account may have been suspended since the challenge was created
+	err = CheckAccount(user)
+	if err != nil {
+		am.auditLogin(c, user, user.Username, "two_factor", err.Error())
+		c.JSON(403, loginResponse{
+			Error: err.Error(),
+		})
+		return
+	}
+
+	token, err := am.CreateToken(user.IndexID)
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, loginResponse{
+			Error: "Failed to create token",
+		})
+		return
+	}
+
+	am.SetAuthCookie(c, token)
+	am.auditLogin(c, user, user.Username, "two_factor", "")
+
+	c.JSON(200, loginResponse{
+		Token: token,
+		Error: "",
+	})
+}
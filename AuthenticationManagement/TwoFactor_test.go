@@ -0,0 +1,205 @@
+package AuthenticationManagement
+
+import (
+	"EntitlementServer/DatabaseAbstraction"
+	"EntitlementServer/DatabaseAbstraction/mocks"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 appendix B, SHA1, truncated to 6 digits
+	secret := []byte("12345678901234567890")
+	assert.Equal(t, "287082", totpCode(secret, 59/totpPeriod))
+	assert.Equal(t, "081804", totpCode(secret, 1111111109/totpPeriod))
+	assert.Equal(t, "005924", totpCode(secret, 1234567890/totpPeriod))
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri := TOTPURI("max mustermann", "JBSWY3DPEHPK3PXP")
+	assert.True(t, strings.HasPrefix(uri, "otpauth://totp/BKBdemy:max%20mustermann?"))
+	assert.Contains(t, uri, "secret=JBSWY3DPEHPK3PXP")
+	assert.Contains(t, uri, "issuer=BKBdemy")
+}
+
+func currentTOTP(secret string) string {
+	decoded, _ := base32NoPadding.DecodeString(secret)
+	return totpCode(decoded, time.Now().Unix()/totpPeriod)
+}
+
+func TestActivateTOTP(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	am := AuthenticationService{DB: mockDB}
+	user := DatabaseAbstraction.User{IndexID: 1, Username: "teacher"}
+
+	mockDB.On("SetUserTOTPSecret", 1, mock.AnythingOfType("string")).Return(nil)
+	mockDB.On("AdvanceUserTOTPStep", 1, mock.AnythingOfType("int64")).Return(nil)
+	mockDB.On("ReplaceRecoveryCodes", 1, mock.AnythingOfType("[]string")).Return(nil)
+	mockDB.On("EnableUserTOTP", 1).Return(nil)
+
+	secret, err := am.EnrollTOTP(user)
+	assert.NoError(t, err)
+	user.TOTPSecret = secret
+
+	_, err = am.ActivateTOTP(user, "000000x")
+	assert.ErrorIs(t, err, ErrInvalidTwoFactorCode)
+	mockDB.AssertNotCalled(t, "EnableUserTOTP", 1)
+
+	codes, err := am.ActivateTOTP(user, currentTOTP(secret))
+	assert.NoError(t, err)
+	assert.Len(t, codes, recoveryCodeCount)
+
+	// only hashes of the recovery codes reach the database
+	storedHashes := mockDB.Calls[len(mockDB.Calls)-2].Arguments.Get(1).([]string)
+	assert.Equal(t, hashRecoveryCode(strings.ToUpper(codes[0])), storedHashes[0])
+	assert.NotContains(t, storedHashes, codes[0])
+}
+
+func TestTwoStepLogin(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	am := AuthenticationService{DB: mockDB, HashParams: testHashParams, SigningKey: []byte("testkey")}
+
+	hash, err := am.HashPassword("Lernen macht Spass!")
+	assert.NoError(t, err)
+	secret := "JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP"
+	user := DatabaseAbstraction.User{IndexID: 1, Username: "teacher", Password: hash, TOTPSecret: secret, TOTPEnabled: true}
+
+	mockDB.On("GetUserByUsername", "teacher").Return(user, nil)
+	mockDB.On("GetUserByIndexID", 1).Return(user, nil)
+	mockDB.On("AdvanceUserTOTPStep", 1, mock.AnythingOfType("int64")).Return(nil).Once()
+	mockDB.On("AdvanceUserTOTPStep", 1, mock.AnythingOfType("int64")).Return(errNoRows)
+	mockDB.On("AddToken", 1, mock.AnythingOfType("string"), mock.AnythingOfType("time.Time")).Return(nil)
+	mockDB.On("AddTwoFactorChallenge", 1, mock.AnythingOfType("string"), mock.AnythingOfType("time.Time")).Return(nil)
+	mockDB.On("UseTwoFactorChallenge", mock.AnythingOfType("string"), twoFactorChallengeAttempts).Return(nil)
+	mockDB.On("DeleteTwoFactorChallenge", mock.AnythingOfType("string")).Return(nil)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	am.RegisterHandlers(router)
+
+	// the password step returns a challenge instead of a token
+	req, _ := http.NewRequest("POST", "/api/auth/login", strings.NewReader(`{"username":"teacher","password":"Lernen macht Spass!"}`))
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+
+	var response loginResponse
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &response))
+	assert.Empty(t, response.Token)
+	assert.True(t, response.TwoFactorRequired)
+	mockDB.AssertNotCalled(t, "AddToken", mock.Anything, mock.Anything, mock.Anything)
+
+	body := `{"challenge":"` + response.Challenge + `","code":"` + currentTOTP(secret) + `"}`
+	req, _ = http.NewRequest("POST", "/api/auth/login/2fa", strings.NewReader(body))
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &response))
+	assert.NotEmpty(t, response.Token)
+
+	// the same code can't be used twice
+	req, _ = http.NewRequest("POST", "/api/auth/login/2fa", strings.NewReader(body))
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+}
+
+func TestTwoFactorChallengeAttemptLimit(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	am := AuthenticationService{DB: mockDB, SigningKey: []byte("testkey")}
+	secret := "JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP"
+	user := DatabaseAbstraction.User{IndexID: 1, Username: "teacher", Password: "hash", TOTPSecret: secret, TOTPEnabled: true}
+
+	var challengeHash string
+	mockDB.On("AddTwoFactorChallenge", 1, mock.AnythingOfType("string"), mock.AnythingOfType("time.Time")).Run(func(args mock.Arguments) {
+		challengeHash = args.String(1)
+	}).Return(nil)
+	mockDB.On("GetUserByIndexID", 1).Return(user, nil)
+	// the database hands out the allowed attempts and refuses any further one
+	mockDB.On("UseTwoFactorChallenge", mock.AnythingOfType("string"), twoFactorChallengeAttempts).Return(nil).Times(twoFactorChallengeAttempts)
+	mockDB.On("UseTwoFactorChallenge", mock.AnythingOfType("string"), twoFactorChallengeAttempts).Return(pgx.ErrNoRows)
+	mockDB.On("ConsumeRecoveryCode", 1, mock.AnythingOfType("string")).Return(errNoRows)
+
+	challenge, err := am.createTwoFactorChallenge(user)
+	assert.NoError(t, err)
+	assert.Equal(t, hashChallenge(challenge), challengeHash)
+	assert.NotContains(t, challengeHash, challenge)
+
+	for i := 0; i < twoFactorChallengeAttempts; i++ {
+		_, err = am.completeTwoFactorChallenge(challenge, "aaaa-bbbb")
+		assert.ErrorIs(t, err, ErrInvalidTwoFactorCode)
+	}
+
+	// the sixth attempt fails even with the right code
+	_, err = am.completeTwoFactorChallenge(challenge, currentTOTP(secret))
+	assert.ErrorIs(t, err, ErrInvalidTwoFactorChallenge)
+	mockDB.AssertNotCalled(t, "AdvanceUserTOTPStep", mock.Anything, mock.Anything)
+	mockDB.AssertNotCalled(t, "DeleteTwoFactorChallenge", mock.Anything)
+}
+
+func TestTwoFactorLoginRejectsSuspendedAccount(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	am := AuthenticationService{DB: mockDB, SigningKey: []byte("testkey")}
+	secret := "JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP"
+	user := DatabaseAbstraction.User{IndexID: 1, Username: "teacher", Password: "hash", TOTPSecret: secret, TOTPEnabled: true}
+
+	mockDB.On("AddTwoFactorChallenge", 1, mock.AnythingOfType("string"), mock.AnythingOfType("time.Time")).Return(nil)
+	mockDB.On("UseTwoFactorChallenge", mock.AnythingOfType("string"), twoFactorChallengeAttempts).Return(nil)
+	mockDB.On("AdvanceUserTOTPStep", 1, mock.AnythingOfType("int64")).Return(nil)
+	mockDB.On("DeleteTwoFactorChallenge", mock.AnythingOfType("string")).Return(nil)
+	// an admin suspended the account between the password and the code
+	suspended := user
+	suspended.Suspended = true
+	mockDB.On("GetUserByIndexID", 1).Return(suspended, nil)
+
+	challenge, err := am.createTwoFactorChallenge(user)
+	assert.NoError(t, err)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	am.RegisterHandlers(router)
+
+	req, _ := http.NewRequest("POST", "/api/auth/login/2fa", strings.NewReader(`{"challenge":"`+challenge+`","code":"`+currentTOTP(secret)+`"}`))
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+	assert.Empty(t, resp.Header().Get("Set-Cookie"))
+	mockDB.AssertNotCalled(t, "AddToken", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestAuthenticationMiddlewareRequiresTwoFactorForRole(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	am := AuthenticationService{DB: mockDB, TwoFactorRequiredRoles: []string{DatabaseAbstraction.RoleAdmin}}
+
+	mockDB.On("GetTokenByHash", "admin_token").Return(DatabaseAbstraction.Token{UserID: 1}, nil)
+	mockDB.On("GetUserByIndexID", 1).Return(DatabaseAbstraction.User{IndexID: 1, Username: "admin", Role: DatabaseAbstraction.RoleAdmin}, nil)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	am.RegisterHandlers(router)
+	router.GET("/test", am.AuthenticationMiddleware, func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	req, _ := http.NewRequest("GET", "/test", nil)
+	req.Header.Set("Authorization", "Bearer admin_token")
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+
+	req, _ = http.NewRequest("GET", "/api/auth/me", nil)
+	req.Header.Set("Authorization", "Bearer admin_token")
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusOK, resp.Code)
+}
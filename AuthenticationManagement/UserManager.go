@@ -1,8 +1,13 @@
 package AuthenticationManagement
 
+import (
+	"errors"
+	"github.com/sirupsen/logrus"
+)
+
 func (am AuthenticationService) AuthenticateUser(username string, password string) (bool, error) {
-	// Get the user from the database
-	user, err := am.DB.GetUserByUsername(username)
+	// Get the user from the database, users may log in with their email address as well
+	user, err := am.lookupUser(username)
 	if err != nil {
 		return false, err
 	}
@@ -17,19 +22,104 @@ func (am AuthenticationService) AuthenticateUser(username string, password strin
 		return false, nil
 	}
 
+	// The plaintext password is only available right now, so this is the moment to upgrade outdated hashes
+	if am.NeedsRehash(user.Password) {
+		am.upgradePasswordHash(user.IndexID, password)
+	}
+
 	return true, nil
 }
 
-func (am AuthenticationService) CreateUser(username string, password string) error {
+// upgradePasswordHash stores a new hash created with the current parameters
+// A failure here must not fail the login, the old hash is still valid
+func (am AuthenticationService) upgradePasswordHash(userID int, password string) {
+	passwordHash, err := am.HashPassword(password)
+	if err != nil {
+		logrus.Errorf("Error rehashing password of user %d: %v", userID, err)
+		return
+	}
+
+	err = am.DB.UpdateUserPassword(userID, passwordHash)
+	if err != nil {
+		logrus.Errorf("Error storing upgraded password hash of user %d: %v", userID, err)
+		return
+	}
+
+	logrus.Infof("Upgraded password hash of user %d", userID)
+}
+
+// CreateUser validates and normalizes the registration data and stores the new user
+// Invalid input is reported as a *ValidationError containing every rejected field
+func (am AuthenticationService) CreateUser(username string, password string, email string) error {
+	username, email, err := am.validateRegistration(username, password, email)
+	if err != nil {
+		return err
+	}
+
 	passwordHash, err := am.HashPassword(password)
 	if err != nil {
 		return err
 	}
 
-	err = am.DB.AddUser(username, passwordHash)
+	err = am.DB.AddUser(username, passwordHash, email)
 	if err != nil {
 		return err
 	}
 
 	return nil
 }
+
+// validateRegistration returns the normalized username and email address if the registration data is acceptable
+func (am AuthenticationService) validateRegistration(username string, password string, email string) (string, string, error) {
+	validationErr := &ValidationError{}
+	username = NormalizeUsername(username)
+
+	err := am.ValidateUsername(username)
+	if !collectFieldErrors(validationErr, err) {
+		return "", "", err
+	}
+	if err == nil {
+		// usernames are unique regardless of case, the lookup is case-insensitive
+		_, err = am.DB.GetUserByUsername(username)
+		if err == nil {
+			validationErr.add("username", "taken", "is already taken")
+		}
+	}
+
+	err = am.ValidatePassword(password, username)
+	if !collectFieldErrors(validationErr, err) {
+		return "", "", err
+	}
+
+	if email != "" {
+		email, err = NormalizeEmail(email)
+		if err != nil {
+			validationErr.add("email", "invalid", "is not a valid email address")
+		} else {
+			available, err := am.emailAvailable(email, 0)
+			if err != nil {
+				return "", "", err
+			}
+			if !available {
+				validationErr.add("email", "taken", "is already in use")
+			}
+		}
+	}
+
+	return username, email, validationErr.errOrNil()
+}
+
+// collectFieldErrors merges a *ValidationError into target, it returns false for any other kind of error
+func collectFieldErrors(target *ValidationError, err error) bool {
+	if err == nil {
+		return true
+	}
+
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		return false
+	}
+
+	target.Fields = append(target.Fields, validationErr.Fields...)
+	return true
+}
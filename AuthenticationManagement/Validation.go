@@ -0,0 +1,250 @@
+package AuthenticationManagement
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"fmt"
+	"golang.org/x/text/unicode/norm"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// FieldError describes why a single request field was rejected
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ValidationError collects all field errors of a request, so clients can show them next to the inputs at once
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Fields))
+	for i, field := range e.Fields {
+		messages[i] = field.Field + ": " + field.Message
+	}
+	return strings.Join(messages, ", ")
+}
+
+func (e *ValidationError) add(field string, code string, message string) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Code: code, Message: message})
+}
+
+// errOrNil avoids returning a typed nil pointer as a non-nil error
+func (e *ValidationError) errOrNil() error {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+	return e
+}
+
+// BreachedPasswordChecker reports whether a password appeared in a known data breach
+type BreachedPasswordChecker interface {
+	IsBreached(password string) (bool, error)
+}
+
+// RangeFileChecker looks passwords up in a local copy of the k-anonymity hash list,
+// one file per 5 character SHA-1 prefix (e.g. 21BD1.txt) containing "SUFFIX:COUNT" lines
+// Only the file of the prefix is read, the full list never has to fit into memory
+type RangeFileChecker struct {
+	Dir string
+}
+
+func (r RangeFileChecker) IsBreached(password string) (bool, error) {
+	hash := strings.ToUpper(fmt.Sprintf("%x", sha1.Sum([]byte(password))))
+	prefix, suffix := hash[:5], hash[5:]
+
+	file, err := os.Open(filepath.Join(r.Dir, prefix+".txt"))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lineSuffix, count, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		// padding entries with a count of 0 are not real breaches
+		if strings.EqualFold(lineSuffix, suffix) && count != "0" {
+			return true, nil
+		}
+	}
+
+	return false, scanner.Err()
+}
+
+// RegistrationPolicy contains the rules for usernames and passwords
+type RegistrationPolicy struct {
+	UsernameMinLength int
+	UsernameMaxLength int
+	// UsernamePattern is matched against the normalized username, restricting it to ASCII rules out homoglyphs
+	UsernamePattern *regexp.Regexp
+
+	PasswordMinLength int
+	// PasswordMaxLength bounds the work done by the password hash for a single request
+	PasswordMaxLength int
+	// PasswordMinEntropy is the minimum estimated strength in bits
+	PasswordMinEntropy float64
+	// BreachedPasswords is optional, passwords are not checked against breaches if it is nil
+	BreachedPasswords BreachedPasswordChecker
+}
+
+var DefaultRegistrationPolicy = RegistrationPolicy{
+	UsernameMinLength:  3,
+	UsernameMaxLength:  32,
+	UsernamePattern:    regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]*$`),
+	PasswordMinLength:  8,
+	PasswordMaxLength:  256,
+	PasswordMinEntropy: 35,
+}
+
+// RegistrationPolicyFromEnv reads USERNAME_MIN_LENGTH, USERNAME_MAX_LENGTH, PASSWORD_MIN_LENGTH and
+// PASSWORD_MIN_ENTROPY, falling back to DefaultRegistrationPolicy
+// BREACHED_PASSWORDS_DIR enables the breached password check
+func RegistrationPolicyFromEnv() (RegistrationPolicy, error) {
+	policy := DefaultRegistrationPolicy
+
+	for key, target := range map[string]*int{
+		"USERNAME_MIN_LENGTH": &policy.UsernameMinLength,
+		"USERNAME_MAX_LENGTH": &policy.UsernameMaxLength,
+		"PASSWORD_MIN_LENGTH": &policy.PasswordMinLength,
+	} {
+		value, err := getenvUint(key, uint64(*target), 16)
+		if err != nil {
+			return RegistrationPolicy{}, err
+		}
+		*target = int(value)
+	}
+
+	if value := os.Getenv("PASSWORD_MIN_ENTROPY"); value != "" {
+		entropy, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return RegistrationPolicy{}, fmt.Errorf("PASSWORD_MIN_ENTROPY is not a valid number: %w", err)
+		}
+		policy.PasswordMinEntropy = entropy
+	}
+
+	if dir := os.Getenv("BREACHED_PASSWORDS_DIR"); dir != "" {
+		policy.BreachedPasswords = RangeFileChecker{Dir: dir}
+	}
+
+	return policy, nil
+}
+
+// registrationPolicy returns the configured policy, a zero value AuthenticationService uses the defaults
+func (am AuthenticationService) registrationPolicy() RegistrationPolicy {
+	if am.Policy == nil {
+		return DefaultRegistrationPolicy
+	}
+	return *am.Policy
+}
+
+// NormalizeUsername applies NFKC normalization, so visually identical inputs (e.g. fullwidth letters) end up the same
+func NormalizeUsername(username string) string {
+	return strings.TrimSpace(norm.NFKC.String(username))
+}
+
+// ValidateUsername checks a normalized username against the policy
+func (am AuthenticationService) ValidateUsername(username string) error {
+	policy := am.registrationPolicy()
+	validationErr := &ValidationError{}
+
+	length := utf8.RuneCountInString(username)
+	switch {
+	case length < policy.UsernameMinLength:
+		validationErr.add("username", "too_short", fmt.Sprintf("must be at least %d characters long", policy.UsernameMinLength))
+	case length > policy.UsernameMaxLength:
+		validationErr.add("username", "too_long", fmt.Sprintf("must be at most %d characters long", policy.UsernameMaxLength))
+	case policy.UsernamePattern != nil && !policy.UsernamePattern.MatchString(username):
+		validationErr.add("username", "invalid_characters", "may only contain letters, digits, dots, dashes and underscores and must start with a letter or digit")
+	}
+
+	return validationErr.errOrNil()
+}
+
+// ValidatePassword checks a password against the policy, the username is used to reject passwords containing it
+func (am AuthenticationService) ValidatePassword(password string, username string) error {
+	policy := am.registrationPolicy()
+	validationErr := &ValidationError{}
+
+	length := utf8.RuneCountInString(password)
+	switch {
+	case length < policy.PasswordMinLength:
+		validationErr.add("password", "too_short", fmt.Sprintf("must be at least %d characters long", policy.PasswordMinLength))
+	case length > policy.PasswordMaxLength:
+		validationErr.add("password", "too_long", fmt.Sprintf("must be at most %d characters long", policy.PasswordMaxLength))
+	case username != "" && strings.Contains(strings.ToLower(password), strings.ToLower(username)):
+		validationErr.add("password", "contains_username", "must not contain the username")
+	case estimatePasswordEntropy(password) < policy.PasswordMinEntropy:
+		validationErr.add("password", "too_weak", "is too easy to guess, use a longer password or mix in other kinds of characters")
+	}
+
+	if len(validationErr.Fields) == 0 && policy.BreachedPasswords != nil {
+		breached, err := policy.BreachedPasswords.IsBreached(password)
+		if err != nil {
+			return err
+		}
+		if breached {
+			validationErr.add("password", "breached", "appeared in a data breach, choose a different password")
+		}
+	}
+
+	return validationErr.errOrNil()
+}
+
+// estimatePasswordEntropy is a rough strength estimate: the length of the password without repeated characters
+// times the bits per character of the character classes it uses
+func estimatePasswordEntropy(password string) float64 {
+	var lower, upper, digit, other bool
+	var effectiveLength int
+	var previous rune
+
+	for i, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			other = true
+		}
+
+		// "aaaaaaaa" is not stronger than "a"
+		if i == 0 || r != previous {
+			effectiveLength++
+		}
+		previous = r
+	}
+
+	poolSize := 0
+	if lower {
+		poolSize += 26
+	}
+	if upper {
+		poolSize += 26
+	}
+	if digit {
+		poolSize += 10
+	}
+	if other {
+		poolSize += 33
+	}
+	if poolSize == 0 {
+		return 0
+	}
+
+	return float64(effectiveLength) * math.Log2(float64(poolSize))
+}
@@ -0,0 +1,131 @@
+package AuthenticationManagement
+
+import (
+	"EntitlementServer/DatabaseAbstraction"
+	"EntitlementServer/DatabaseAbstraction/mocks"
+	"encoding/json"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func fieldCodes(err error) []string {
+	validationErr, ok := err.(*ValidationError)
+	if !ok {
+		return nil
+	}
+	codes := make([]string, len(validationErr.Fields))
+	for i, field := range validationErr.Fields {
+		codes[i] = field.Field + ":" + field.Code
+	}
+	return codes
+}
+
+func TestValidateUsername(t *testing.T) {
+	am := AuthenticationService{}
+
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{name: "valid", input: "max.mustermann_2", want: nil},
+		{name: "too_short", input: "ab", want: []string{"username:too_short"}},
+		{name: "too_long", input: strings.Repeat("a", 10*1024), want: []string{"username:too_long"}},
+		{name: "whitespace", input: "max mustermann", want: []string{"username:invalid_characters"}},
+		{name: "cyrillic_homoglyph", input: "аdmin", want: []string{"username:invalid_characters"}},
+		{name: "leading_dot", input: ".admin", want: []string{"username:invalid_characters"}},
+		{name: "fullwidth_normalized", input: NormalizeUsername("ａｄｍｉｎ"), want: nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, fieldCodes(am.ValidateUsername(test.input)))
+		})
+	}
+}
+
+func TestValidatePassword(t *testing.T) {
+	dir := t.TempDir()
+	// SHA-1 of "correct horse battery staple" is ABF7AAD6438836DBE526AA231ABDE2D0EEF74D42
+	err := os.WriteFile(filepath.Join(dir, "ABF7A.txt"), []byte("00000000000000000000000000000000000:0\r\nAD6438836DBE526AA231ABDE2D0EEF74D42:76\r\n"), 0600)
+	assert.NoError(t, err)
+
+	policy := DefaultRegistrationPolicy
+	policy.BreachedPasswords = RangeFileChecker{Dir: dir}
+	am := AuthenticationService{Policy: &policy}
+
+	tests := []struct {
+		name     string
+		password string
+		want     []string
+	}{
+		{name: "valid", password: "Lernen macht Spass!", want: nil},
+		{name: "too_short", password: "aB3$", want: []string{"password:too_short"}},
+		{name: "too_long", password: strings.Repeat("aB3$", 100), want: []string{"password:too_long"}},
+		{name: "repeated", password: "aaaaaaaaaaaaaaaa", want: []string{"password:too_weak"}},
+		{name: "contains_username", password: "student-2023!", want: []string{"password:contains_username"}},
+		{name: "breached", password: "correct horse battery staple", want: []string{"password:breached"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, fieldCodes(am.ValidatePassword(test.password, "student")))
+		})
+	}
+}
+
+func TestRegisterUserHandlerFieldErrors(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	authSvc := AuthenticationService{DB: mockDB}
+
+	mockDB.On("GetUserByEmail", "taken@example.com").Return(DatabaseAbstraction.User{IndexID: 2}, nil)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	authSvc.RegisterHandlers(router)
+
+	reqBody := `{"username":"a b","password":"password","email":"Taken@Example.com"}`
+	req := httptest.NewRequest("POST", "/api/auth/register", strings.NewReader(reqBody))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	var response loginResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	fields := make([]string, len(response.Fields))
+	for i, field := range response.Fields {
+		fields[i] = field.Field + ":" + field.Code
+	}
+	assert.Equal(t, []string{"username:invalid_characters", "password:too_weak", "email:taken"}, fields)
+	mockDB.AssertNotCalled(t, "AddUser", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestRegisterUserHandlerEmailLookupFails(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	authSvc := AuthenticationService{DB: mockDB}
+
+	mockDB.On("GetUserByUsername", "max").Return(DatabaseAbstraction.User{}, errNoRows)
+	mockDB.On("GetUserByEmail", "max@example.com").Return(DatabaseAbstraction.User{}, errors.New("connection refused"))
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	authSvc.RegisterHandlers(router)
+
+	reqBody := `{"username":"max","password":"Lernen macht Spass!","email":"max@example.com"}`
+	req := httptest.NewRequest("POST", "/api/auth/register", strings.NewReader(reqBody))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	// the database being down is no reason to try the insert
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	mockDB.AssertNotCalled(t, "AddUser", mock.Anything, mock.Anything, mock.Anything)
+}
@@ -0,0 +1,258 @@
+package DataExportService
+
+import (
+	"EntitlementServer/DatabaseAbstraction"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/sirupsen/logrus"
+	"strconv"
+	"time"
+)
+
+const (
+	// finished archives can be downloaded for this long
+	exportLifetime = 24 * time.Hour
+	// a pending export older than this was lost in a restart, a new one is started instead of waiting for it
+	exportTimeout = 10 * time.Minute
+	// small exports are returned directly if they are ready within this time
+	defaultSyncTimeout = 2 * time.Second
+)
+
+// DataExportService lets users download everything stored about them (GDPR Art. 15 and 20)
+// Exports are generated in the background and kept for a day
+type DataExportService struct {
+	DB DatabaseAbstraction.DBOrm
+	// SyncTimeout is how long a request waits for an export before answering with its status, 2 seconds if zero
+	SyncTimeout time.Duration
+}
+
+func (s DataExportService) RegisterHandlers(r *gin.Engine, middleware ...gin.HandlerFunc) {
+	r.GET("/api/auth/me/export", middleware[0], s.ExportHandler)
+	r.GET("/api/auth/me/export/:id", middleware[0], s.GetExportHandler)
+	r.GET("/api/auth/me/export/:id/download", middleware[0], s.DownloadExportHandler)
+}
+
+func (s DataExportService) GetLabel() string {
+	return "Data Export Service"
+}
+
+func (s DataExportService) syncTimeout() time.Duration {
+	if s.SyncTimeout == 0 {
+		return defaultSyncTimeout
+	}
+	return s.SyncTimeout
+}
+
+// StartExport starts generating an export of the user's data, or returns the one already in progress
+// The returned channel is closed once a newly started export is finished, it is nil for an export already in progress
+func (s DataExportService) StartExport(user DatabaseAbstraction.User) (DatabaseAbstraction.DataExport, <-chan struct{}, error) {
+	err := s.DB.DeleteExpiredDataExports()
+	if err != nil {
+		logrus.Error(err)
+	}
+
+	// don't let repeated requests start one export each
+	pending, err := s.DB.GetPendingDataExport(user.IndexID)
+	if err == nil && time.Since(pending.CreatedAt) < exportTimeout {
+		return pending, nil, nil
+	}
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return DatabaseAbstraction.DataExport{}, nil, err
+	}
+
+	export, err := s.DB.AddDataExport(user.IndexID, time.Now().Add(exportLifetime))
+	if err != nil {
+		return DatabaseAbstraction.DataExport{}, nil, err
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.runExport(export, user)
+	}()
+
+	return export, done, nil
+}
+
+func (s DataExportService) runExport(export DatabaseAbstraction.DataExport, user DatabaseAbstraction.User) {
+	archive, err := s.BuildArchive(user)
+	if err != nil {
+		logrus.Errorf("Data export %d of user %d failed: %v", export.IndexID, user.IndexID, err)
+		err = s.DB.FailDataExport(export.IndexID, "Failed to collect the data")
+		if err != nil {
+			logrus.Error(err)
+		}
+		return
+	}
+
+	err = s.DB.CompleteDataExport(export.IndexID, archive)
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+
+	logrus.Infof("Data export %d of user %d finished", export.IndexID, user.IndexID)
+}
+
+type dataExportResponse struct {
+	ID          int        `json:"id"`
+	Status      string     `json:"status"`
+	Error       string     `json:"error,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	StatusURL   string     `json:"status_url"`
+	DownloadURL string     `json:"download_url,omitempty"` // only set once the export is done
+}
+
+func newDataExportResponse(export DatabaseAbstraction.DataExport) dataExportResponse {
+	response := dataExportResponse{
+		ID:          export.IndexID,
+		Status:      export.Status,
+		Error:       export.Error,
+		CreatedAt:   export.CreatedAt,
+		CompletedAt: export.CompletedAt,
+		ExpiresAt:   export.Expiry,
+		StatusURL:   fmt.Sprintf("/api/auth/me/export/%d", export.IndexID),
+	}
+	if export.Status == DatabaseAbstraction.DataExportDone {
+		response.DownloadURL = response.StatusURL + "/download"
+	}
+	return response
+}
+
+func sendArchive(c *gin.Context, exportID int, archive []byte) {
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="bkbdemy-export-%d.zip"`, exportID))
+	c.Header("Cache-Control", "no-store")
+	c.Data(200, "application/zip", archive)
+}
+
+// ExportHandler godoc
+//
+//	@Summary		Export the data of the current user
+//	@Description	Starts an export of everything stored about the current user: profile, purchases, comments,
+//	@Description	watch history, sessions, API keys, linked accounts and wallet history as JSON files in a ZIP archive
+//	@Description	If the export is ready within a few seconds the archive is returned directly, otherwise the response
+//	@Description	is 202 with the URL to poll. Exports can be downloaded for 24 hours
+//	@Tags			Authentication
+//	@Produce		application/zip
+//	@Produce		json
+//	@Success		200	{file}		binary
+//	@Success		202	{object}	dataExportResponse
+//	@Failure		500	{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/api/auth/me/export [get]
+func (s DataExportService) ExportHandler(c *gin.Context) {
+	user := c.MustGet("user").(DatabaseAbstraction.User)
+
+	export, done, err := s.StartExport(user)
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, gin.H{"error": "Failed to start export"})
+		return
+	}
+
+	if done != nil {
+		select {
+		case <-done:
+			export, err = s.DB.GetDataExport(user.IndexID, export.IndexID)
+			if err != nil {
+				logrus.Error(err)
+				c.JSON(500, gin.H{"error": "Failed to get export"})
+				return
+			}
+		case <-time.After(s.syncTimeout()):
+		}
+	}
+
+	switch export.Status {
+	case DatabaseAbstraction.DataExportDone:
+		archive, err := s.DB.GetDataExportArchive(user.IndexID, export.IndexID)
+		if err != nil {
+			logrus.Error(err)
+			c.JSON(500, gin.H{"error": "Failed to get export"})
+			return
+		}
+		sendArchive(c, export.IndexID, archive)
+	case DatabaseAbstraction.DataExportFailed:
+		c.JSON(500, newDataExportResponse(export))
+	default:
+		c.Header("Location", newDataExportResponse(export).StatusURL)
+		c.JSON(202, newDataExportResponse(export))
+	}
+}
+
+// GetExportHandler godoc
+//
+//	@Summary		Status of a data export
+//	@Description	Status of an export of the current user, download_url is set once it is done
+//	@Tags			Authentication
+//	@Produce		json
+//	@Param			id	path		int	true	"Export ID"
+//	@Success		200	{object}	dataExportResponse
+//	@Failure		400	{object}	map[string]string
+//	@Failure		404	{object}	map[string]string
+//	@Failure		500	{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/api/auth/me/export/{id} [get]
+func (s DataExportService) GetExportHandler(c *gin.Context) {
+	user := c.MustGet("user").(DatabaseAbstraction.User)
+
+	exportID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid export ID"})
+		return
+	}
+
+	export, err := s.DB.GetDataExport(user.IndexID, exportID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(404, gin.H{"error": "Export not found"})
+		return
+	}
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, gin.H{"error": "Failed to get export"})
+		return
+	}
+
+	c.JSON(200, newDataExportResponse(export))
+}
+
+// DownloadExportHandler godoc
+//
+//	@Summary		Download a data export
+//	@Description	The ZIP archive of a finished export of the current user
+//	@Tags			Authentication
+//	@Produce		application/zip
+//	@Param			id	path		int	true	"Export ID"
+//	@Success		200	{file}		binary
+//	@Failure		400	{object}	map[string]string
+//	@Failure		404	{object}	map[string]string
+//	@Failure		500	{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/api/auth/me/export/{id}/download [get]
+func (s DataExportService) DownloadExportHandler(c *gin.Context) {
+	user := c.MustGet("user").(DatabaseAbstraction.User)
+
+	exportID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid export ID"})
+		return
+	}
+
+	archive, err := s.DB.GetDataExportArchive(user.IndexID, exportID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		// also for exports that are not done yet, the status endpoint tells them apart
+		c.JSON(404, gin.H{"error": "Export not found or not ready"})
+		return
+	}
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, gin.H{"error": "Failed to get export"})
+		return
+	}
+
+	sendArchive(c, exportID, archive)
+}
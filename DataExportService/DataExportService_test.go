@@ -0,0 +1,172 @@
+package DataExportService_test
+
+import (
+	"EntitlementServer/DataExportService"
+	"EntitlementServer/DatabaseAbstraction"
+	"EntitlementServer/DatabaseAbstraction/mocks"
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var exportUser = DatabaseAbstraction.User{
+	IndexID:     1,
+	Username:    "alice",
+	Password:    "$argon2id$secret-hash",
+	Email:       "alice@example.com",
+	TOTPSecret:  "JBSWY3DPEHPK3PXP",
+	TOTPEnabled: true,
+	Balance:     500,
+}
+
+func newRouter(svc DataExportService.DataExportService) *gin.Engine {
+	_, r := gin.CreateTestContext(httptest.NewRecorder())
+	svc.RegisterHandlers(r, func(c *gin.Context) {
+		c.Set("user", exportUser)
+	})
+	return r
+}
+
+// expectUserData sets up the queries of an export and returns the first one
+func expectUserData(mockDB *mocks.DBOrm) *mock.Call {
+	firstQuery := mockDB.On("GetPurchasesByUserID", 1).Return([]DatabaseAbstraction.Purchase{{IndexID: 1, ProductID: 2, ProductName: "Python Grundlagen"}}, nil)
+	mockDB.On("GetCommentsByUserID", 1).Return([]DatabaseAbstraction.Comment{{IndexID: 3, ProductID: 2, Comment: "Great course"}}, nil)
+	mockDB.On("GetWatchHistoryByUserID", 1).Return([]DatabaseAbstraction.WatchedVideo{{VideoID: 4, VideoName: "Variablen"}}, nil)
+	mockDB.On("GetTokensByUserID", 1).Return([]DatabaseAbstraction.Token{{IndexID: 5, UserID: 1, Token: "session-token-hash"}}, nil)
+	mockDB.On("GetAPIKeysByUserID", 1).Return([]DatabaseAbstraction.APIKey{}, nil)
+	mockDB.On("GetUserIdentitiesByUserID", 1).Return([]DatabaseAbstraction.UserIdentity{}, nil)
+	mockDB.On("GetBalanceTransactionsByUserID", 1).Return([]DatabaseAbstraction.BalanceTransaction{
+		{Amount: 2500, Reason: "top-up"},
+		{Amount: -2000, Reason: "purchase of product 2"},
+	}, nil)
+	mockDB.On("GetInvoicesByUserID", 1).Return([]DatabaseAbstraction.Invoice{{Number: "INV-2026-000001", Kind: DatabaseAbstraction.InvoiceTopUp, Gross: 2500}}, nil)
+	return firstQuery
+}
+
+func readArchive(t *testing.T, data []byte) map[string]string {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	assert.NoError(t, err)
+
+	files := map[string]string{}
+	for _, file := range archive.File {
+		reader, err := file.Open()
+		assert.NoError(t, err)
+		content, err := io.ReadAll(reader)
+		assert.NoError(t, err)
+		files[file.Name] = string(content)
+	}
+	return files
+}
+
+func TestExportReturnsArchiveWhenReady(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	export := DatabaseAbstraction.DataExport{IndexID: 7, UserID: 1, Status: DatabaseAbstraction.DataExportPending}
+	mockDB.On("DeleteExpiredDataExports").Return(nil)
+	mockDB.On("GetPendingDataExport", 1).Return(DatabaseAbstraction.DataExport{}, pgx.ErrNoRows)
+	mockDB.On("AddDataExport", 1, mock.Anything).Return(export, nil)
+	expectUserData(mockDB)
+
+	var archive []byte
+	mockDB.On("CompleteDataExport", 7, mock.Anything).Run(func(args mock.Arguments) {
+		archive = args.Get(1).([]byte)
+	}).Return(nil)
+	done := export
+	done.Status = DatabaseAbstraction.DataExportDone
+	mockDB.On("GetDataExport", 1, 7).Return(done, nil)
+	mockDB.On("GetDataExportArchive", 1, 7).Return(func(int, int) []byte { return archive }, nil)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/api/auth/me/export", nil)
+	newRouter(DataExportService.DataExportService{DB: mockDB}).ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/zip", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Header().Get("Content-Disposition"), "attachment")
+
+	files := readArchive(t, w.Body.Bytes())
+	assert.Len(t, files, 9)
+
+	var profile map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(files["profile.json"]), &profile))
+	assert.Equal(t, "alice@example.com", profile["email"])
+	assert.Equal(t, true, profile["two_factor_enabled"])
+	assert.Contains(t, files["purchases.json"], "Python Grundlagen")
+	assert.Contains(t, files["comments.json"], "Great course")
+	assert.Contains(t, files["watch_history.json"], "Variablen")
+	assert.Contains(t, files["wallet.json"], "purchase of product 2")
+	assert.Contains(t, files["invoices.json"], "INV-2026-000001")
+
+	// credentials are not personal data and must never leave the server
+	for name, content := range files {
+		assert.NotContains(t, content, "secret-hash", name)
+		assert.NotContains(t, content, "JBSWY3DPEHPK3PXP", name)
+		assert.NotContains(t, content, "session-token-hash", name)
+	}
+	mockDB.AssertExpectations(t)
+}
+
+func TestExportReturnsStatusWhenSlow(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	export := DatabaseAbstraction.DataExport{IndexID: 7, UserID: 1, Status: DatabaseAbstraction.DataExportPending}
+	mockDB.On("DeleteExpiredDataExports").Return(nil)
+	mockDB.On("GetPendingDataExport", 1).Return(DatabaseAbstraction.DataExport{}, pgx.ErrNoRows)
+	mockDB.On("AddDataExport", 1, mock.Anything).Return(export, nil)
+	// hold the export back until the request has given up waiting
+	release := make(chan time.Time)
+	expectUserData(mockDB).WaitUntil(release)
+	finished := make(chan struct{})
+	mockDB.On("CompleteDataExport", 7, mock.Anything).Run(func(mock.Arguments) { close(finished) }).Return(nil)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/api/auth/me/export", nil)
+	newRouter(DataExportService.DataExportService{DB: mockDB, SyncTimeout: 10 * time.Millisecond}).ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, "/api/auth/me/export/7", w.Header().Get("Location"))
+	assert.Contains(t, w.Body.String(), `"status":"pending"`)
+
+	close(release)
+	<-finished
+}
+
+func TestExportReusesPendingExport(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	pending := DatabaseAbstraction.DataExport{IndexID: 7, UserID: 1, Status: DatabaseAbstraction.DataExportPending, CreatedAt: time.Now()}
+	mockDB.On("DeleteExpiredDataExports").Return(nil)
+	mockDB.On("GetPendingDataExport", 1).Return(pending, nil)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/api/auth/me/export", nil)
+	newRouter(DataExportService.DataExportService{DB: mockDB}).ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	mockDB.AssertNotCalled(t, "AddDataExport", mock.Anything, mock.Anything)
+}
+
+func TestGetExportStatus(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	mockDB.On("GetDataExport", 1, 7).Return(DatabaseAbstraction.DataExport{IndexID: 7, UserID: 1, Status: DatabaseAbstraction.DataExportDone}, nil)
+	// exports of other users are not found, GetDataExport filters by the user
+	mockDB.On("GetDataExport", 1, 8).Return(DatabaseAbstraction.DataExport{}, pgx.ErrNoRows)
+	r := newRouter(DataExportService.DataExportService{DB: mockDB})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/api/auth/me/export/7", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"download_url":"/api/auth/me/export/7/download"`)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest(http.MethodGet, "/api/auth/me/export/8", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,222 @@
+package DataExportService
+
+import (
+	"EntitlementServer/DatabaseAbstraction"
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"time"
+)
+
+// The archive contains one JSON file per kind of data, the types below define what is exported
+// Password hashes, TOTP secrets and token or key hashes are credentials, not personal data, and are left out
+
+type exportProfile struct {
+	ID               int       `json:"id"`
+	Username         string    `json:"username"`
+	DisplayName      string    `json:"display_name"`
+	AvatarURL        string    `json:"avatar_url"`
+	Locale           string    `json:"locale"`
+	Country          string    `json:"country"`
+	Email            string    `json:"email"`
+	EmailVerified    bool      `json:"email_verified"`
+	Role             string    `json:"role"`
+	TwoFactorEnabled bool      `json:"two_factor_enabled"`
+	Balance          int       `json:"balance"`
+	Points           int       `json:"points"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+type exportPurchase struct {
+	ProductID   int       `json:"product_id"`
+	ProductName string    `json:"product_name"`
+	PurchasedAt time.Time `json:"purchased_at"`
+}
+
+type exportComment struct {
+	ID        int       `json:"id"`
+	ProductID int       `json:"product_id"`
+	Comment   string    `json:"comment"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type exportWatchedVideo struct {
+	VideoID   int       `json:"video_id"`
+	VideoName string    `json:"video_name"`
+	WatchedAt time.Time `json:"watched_at"`
+}
+
+type exportSession struct {
+	ID        int       `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type exportAPIKey struct {
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+type exportIdentity struct {
+	Provider  string    `json:"provider"`
+	Subject   string    `json:"subject"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type exportTransaction struct {
+	Amount    int       `json:"amount"`
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type exportInvoice struct {
+	Number      string    `json:"number"`
+	Kind        string    `json:"kind"`
+	BillingName string    `json:"billing_name"`
+	Email       string    `json:"billing_email"`
+	Country     string    `json:"country"`
+	Gross       int       `json:"gross"`
+	VAT         int       `json:"vat"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// BuildArchive collects everything stored about the user and returns it as a ZIP archive of JSON files
+func (s DataExportService) BuildArchive(user DatabaseAbstraction.User) ([]byte, error) {
+	files := map[string]interface{}{
+		"profile.json": exportProfile{
+			ID:               user.IndexID,
+			Username:         user.Username,
+			DisplayName:      user.DisplayName,
+			AvatarURL:        user.AvatarURL,
+			Locale:           user.Locale,
+			Country:          user.Country,
+			Email:            user.Email,
+			EmailVerified:    user.EmailVerified,
+			Role:             user.Role,
+			TwoFactorEnabled: user.TOTPEnabled,
+			Balance:          user.Balance,
+			Points:           user.Points,
+			CreatedAt:        user.CreatedAt,
+			UpdatedAt:        user.UpdatedAt,
+		},
+	}
+
+	purchases, err := s.DB.GetPurchasesByUserID(user.IndexID)
+	if err != nil {
+		return nil, err
+	}
+	exportPurchases := []exportPurchase{}
+	for _, purchase := range purchases {
+		exportPurchases = append(exportPurchases, exportPurchase{purchase.ProductID, purchase.ProductName, purchase.CreatedAt})
+	}
+	files["purchases.json"] = exportPurchases
+
+	comments, err := s.DB.GetCommentsByUserID(user.IndexID)
+	if err != nil {
+		return nil, err
+	}
+	exportComments := []exportComment{}
+	for _, comment := range comments {
+		exportComments = append(exportComments, exportComment{comment.IndexID, comment.ProductID, comment.Comment, comment.CreatedAt})
+	}
+	files["comments.json"] = exportComments
+
+	history, err := s.DB.GetWatchHistoryByUserID(user.IndexID)
+	if err != nil {
+		return nil, err
+	}
+	exportHistory := []exportWatchedVideo{}
+	for _, watched := range history {
+		exportHistory = append(exportHistory, exportWatchedVideo{watched.VideoID, watched.VideoName, watched.WatchedAt})
+	}
+	files["watch_history.json"] = exportHistory
+
+	tokens, err := s.DB.GetTokensByUserID(user.IndexID)
+	if err != nil {
+		return nil, err
+	}
+	exportSessions := []exportSession{}
+	for _, token := range tokens {
+		exportSessions = append(exportSessions, exportSession{token.IndexID, token.CreatedAt, token.Expiry})
+	}
+	files["sessions.json"] = exportSessions
+
+	apiKeys, err := s.DB.GetAPIKeysByUserID(user.IndexID)
+	if err != nil {
+		return nil, err
+	}
+	exportAPIKeys := []exportAPIKey{}
+	for _, apiKey := range apiKeys {
+		exportAPIKeys = append(exportAPIKeys, exportAPIKey{apiKey.Name, apiKey.Prefix, apiKey.Scopes, apiKey.Expiry, apiKey.LastUsedAt, apiKey.CreatedAt})
+	}
+	files["api_keys.json"] = exportAPIKeys
+
+	identities, err := s.DB.GetUserIdentitiesByUserID(user.IndexID)
+	if err != nil {
+		return nil, err
+	}
+	exportIdentities := []exportIdentity{}
+	for _, identity := range identities {
+		exportIdentities = append(exportIdentities, exportIdentity{identity.Provider, identity.Subject, identity.Email, identity.CreatedAt})
+	}
+	files["identities.json"] = exportIdentities
+
+	transactions, err := s.DB.GetBalanceTransactionsByUserID(user.IndexID)
+	if err != nil {
+		return nil, err
+	}
+	exportTransactions := []exportTransaction{}
+	for _, transaction := range transactions {
+		exportTransactions = append(exportTransactions, exportTransaction{transaction.Amount, transaction.Reason, transaction.CreatedAt})
+	}
+	files["wallet.json"] = exportTransactions
+
+	invoices, err := s.DB.GetInvoicesByUserID(user.IndexID)
+	if err != nil {
+		return nil, err
+	}
+	exportInvoices := []exportInvoice{}
+	for _, invoice := range invoices {
+		exportInvoices = append(exportInvoices, exportInvoice{invoice.Number, invoice.Kind, invoice.BillingName, invoice.BillingEmail, invoice.Country,
+			invoice.Gross, invoice.VAT, invoice.CreatedAt})
+	}
+	files["invoices.json"] = exportInvoices
+
+	return writeArchive(files)
+}
+
+// archiveFiles are the files of an export in archive order
+var archiveFiles = []string{"profile.json", "purchases.json", "comments.json", "watch_history.json", "sessions.json", "api_keys.json", "identities.json", "wallet.json", "invoices.json"}
+
+// writeArchive writes the files as indented JSON into a ZIP archive, in a fixed order so exports are easy to compare
+func writeArchive(files map[string]interface{}) ([]byte, error) {
+	var buffer bytes.Buffer
+	archive := zip.NewWriter(&buffer)
+
+	for _, name := range archiveFiles {
+		file, err := archive.Create(name)
+		if err != nil {
+			return nil, err
+		}
+
+		encoder := json.NewEncoder(file)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(files[name])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := archive.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
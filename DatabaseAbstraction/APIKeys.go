@@ -0,0 +1,102 @@
+package DatabaseAbstraction
+
+import (
+	"context"
+	"github.com/jackc/pgx/v5"
+	"time"
+)
+
+// APIKey is a personal access key for scripts, only the hash of the key itself is stored
+type APIKey struct {
+	IndexID    int
+	UserID     int
+	Name       string
+	Prefix     string // the first characters of the key, so users can tell their keys apart
+	Scopes     []string
+	Expiry     *time.Time // nil if the key doesn't expire
+	LastUsedAt *time.Time
+	CreatedAt  time.Time
+}
+
+const apiKeyColumns = "id, user_id, name, prefix, scopes, expiry, last_used_at, created_at"
+
+func scanAPIKey(row pgx.Row) (APIKey, error) {
+	var apiKey APIKey
+	err := row.Scan(&apiKey.IndexID, &apiKey.UserID, &apiKey.Name, &apiKey.Prefix, &apiKey.Scopes, &apiKey.Expiry, &apiKey.LastUsedAt, &apiKey.CreatedAt)
+	if err != nil {
+		return APIKey{}, err
+	}
+
+	return apiKey, nil
+}
+
+func (dbc DBConnector) AddAPIKey(apiKey APIKey, keyHash string) (int, error) {
+	row := dbc.DB.QueryRow(context.Background(), "INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, expiry) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id",
+		apiKey.UserID, apiKey.Name, apiKey.Prefix, keyHash, apiKey.Scopes, apiKey.Expiry)
+
+	var id int
+	err := row.Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// GetAPIKeyByHash only returns keys that have not expired
+func (dbc DBConnector) GetAPIKeyByHash(keyHash string) (APIKey, error) {
+	row := dbc.DB.QueryRow(context.Background(), "SELECT "+apiKeyColumns+" FROM api_keys WHERE key_hash = $1 AND (expiry IS NULL OR expiry > now())", keyHash)
+	return scanAPIKey(row)
+}
+
+func (dbc DBConnector) GetAPIKeysByUserID(userID int) ([]APIKey, error) {
+	rows, err := dbc.DB.Query(context.Background(), "SELECT "+apiKeyColumns+" FROM api_keys WHERE user_id = $1 ORDER BY id", userID)
+	if err != nil {
+		return []APIKey{}, err
+	}
+	defer rows.Close()
+
+	var apiKeys []APIKey
+	for rows.Next() {
+		apiKey, err := scanAPIKey(rows)
+		if err != nil {
+			return []APIKey{}, err
+		}
+		apiKeys = append(apiKeys, apiKey)
+	}
+
+	return apiKeys, rows.Err()
+}
+
+// DeleteAPIKey revokes a key of the user, pgx.ErrNoRows is returned if the user has no such key
+func (dbc DBConnector) DeleteAPIKey(userID int, keyID int) error {
+	result, err := dbc.DB.Exec(context.Background(), "DELETE FROM api_keys WHERE id = $1 AND user_id = $2", keyID, userID)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+
+	return nil
+}
+
+// DeleteAPIKeysByUserID revokes every key of a user, e.g. after a password change
+func (dbc DBConnector) DeleteAPIKeysByUserID(userID int) error {
+	_, err := dbc.DB.Exec(context.Background(), "DELETE FROM api_keys WHERE user_id = $1", userID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// TouchAPIKey records that the key was used, at most once per minute to keep scripts from causing a write per request
+func (dbc DBConnector) TouchAPIKey(keyID int) error {
+	_, err := dbc.DB.Exec(context.Background(), "UPDATE api_keys SET last_used_at = now() WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < now() - INTERVAL '1 minute')", keyID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
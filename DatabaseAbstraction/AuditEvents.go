@@ -0,0 +1,108 @@
+package DatabaseAbstraction
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// AuditEvent is an entry of the append-only audit log, user IDs are 0 if they don't apply
+type AuditEvent struct {
+	IndexID        int
+	CreatedAt      time.Time
+	Action         string // dotted, e.g. auth.login
+	Outcome        string // success or failure
+	ActorID        int    // the user who acted
+	ImpersonatorID int    // the admin behind the actor in an impersonated session
+	TargetUserID   int    // the user who was acted upon
+	IP             string
+	Details        map[string]interface{}
+}
+
+// AuditFilter selects audit events, zero values don't filter
+type AuditFilter struct {
+	Action       string // an action or a prefix ending before a dot, "auth" matches "auth.login"
+	Outcome      string
+	ActorID      int
+	TargetUserID int // matches the actor as well, so a user's own actions show up too
+	From         time.Time
+	To           time.Time
+}
+
+// where builds the WHERE clause of the filter, with the placeholders starting at $1
+func (filter AuditFilter) where() (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	add := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, strings.ReplaceAll(condition, "?", fmt.Sprintf("$%d", len(args))))
+	}
+
+	if filter.Action != "" {
+		add("(action = ? OR left(action, length(?) + 1) = ? || '.')", filter.Action)
+	}
+	if filter.Outcome != "" {
+		add("outcome = ?", filter.Outcome)
+	}
+	if filter.ActorID != 0 {
+		add("actor_id = ?", filter.ActorID)
+	}
+	if filter.TargetUserID != 0 {
+		add("(target_user_id = ? OR actor_id = ?)", filter.TargetUserID)
+	}
+	if !filter.From.IsZero() {
+		add("created_at >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		add("created_at < ?", filter.To)
+	}
+
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// AddAuditEvent appends an event, the table doesn't allow changing or deleting them
+func (dbc DBConnector) AddAuditEvent(event AuditEvent) error {
+	_, err := dbc.DB.Exec(context.Background(), "INSERT INTO audit_events (action, outcome, actor_id, impersonator_id, target_user_id, ip, details) "+
+		"VALUES ($1, $2, NULLIF($3, 0), NULLIF($4, 0), NULLIF($5, 0), NULLIF($6, ''), $7)",
+		event.Action, event.Outcome, event.ActorID, event.ImpersonatorID, event.TargetUserID, event.IP, event.Details)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetAuditEvents returns a page of the matching events, newest first, and the number of all matches
+func (dbc DBConnector) GetAuditEvents(filter AuditFilter, limit int, offset int) ([]AuditEvent, int, error) {
+	where, args := filter.where()
+
+	var total int
+	err := dbc.DB.QueryRow(context.Background(), "SELECT COUNT(*) FROM audit_events"+where, args...).Scan(&total)
+	if err != nil {
+		return []AuditEvent{}, 0, err
+	}
+
+	args = append(args, limit, offset)
+	rows, err := dbc.DB.Query(context.Background(), fmt.Sprintf("SELECT id, created_at, action, outcome, COALESCE(actor_id, 0), COALESCE(impersonator_id, 0), "+
+		"COALESCE(target_user_id, 0), COALESCE(ip, ''), details FROM audit_events%s ORDER BY id DESC LIMIT $%d OFFSET $%d", where, len(args)-1, len(args)), args...)
+	if err != nil {
+		return []AuditEvent{}, 0, err
+	}
+	defer rows.Close()
+
+	var events []AuditEvent
+	for rows.Next() {
+		var event AuditEvent
+		err := rows.Scan(&event.IndexID, &event.CreatedAt, &event.Action, &event.Outcome, &event.ActorID, &event.ImpersonatorID, &event.TargetUserID, &event.IP, &event.Details)
+		if err != nil {
+			return []AuditEvent{}, 0, err
+		}
+		events = append(events, event)
+	}
+
+	return events, total, rows.Err()
+}
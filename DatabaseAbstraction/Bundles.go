@@ -0,0 +1,119 @@
+package DatabaseAbstraction
+
+import (
+	"context"
+	"github.com/jackc/pgx/v5"
+	"time"
+)
+
+// Bundle groups products into a learning path sold for one price, ProductIDs are in the recommended order
+type Bundle struct {
+	IndexID     int
+	Name        string
+	Description string
+	Price       int
+	Image       string
+	ProductIDs  []int
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+const bundleColumns = "bundles.id, bundles.name, bundles.description, bundles.price, bundles.image, bundles.created_at, bundles.updated_at, " +
+	"COALESCE((SELECT array_agg(product_id ORDER BY position) FROM bundle_products WHERE bundle_id = bundles.id), '{}')"
+
+func scanBundle(row pgx.Row) (Bundle, error) {
+	var bundle Bundle
+	err := row.Scan(&bundle.IndexID, &bundle.Name, &bundle.Description, &bundle.Price, &bundle.Image, &bundle.CreatedAt, &bundle.UpdatedAt, &bundle.ProductIDs)
+	return bundle, err
+}
+
+func (dbc DBConnector) GetBundles() ([]Bundle, error) {
+	rows, err := dbc.DB.Query(context.Background(), "SELECT "+bundleColumns+" FROM bundles ORDER BY bundles.id")
+	if err != nil {
+		return []Bundle{}, err
+	}
+	defer rows.Close()
+
+	var bundles []Bundle
+	for rows.Next() {
+		bundle, err := scanBundle(rows)
+		if err != nil {
+			return []Bundle{}, err
+		}
+		bundles = append(bundles, bundle)
+	}
+
+	return bundles, rows.Err()
+}
+
+func (dbc DBConnector) GetBundleByIndexID(indexID int) (Bundle, error) {
+	return scanBundle(dbc.DB.QueryRow(context.Background(), "SELECT "+bundleColumns+" FROM bundles WHERE bundles.id = $1", indexID))
+}
+
+func (dbc DBConnector) AddBundle(bundle Bundle) (int, error) {
+	tx, err := dbc.DB.Begin(context.Background())
+	if err != nil {
+		return -1, err
+	}
+	defer tx.Rollback(context.Background())
+
+	var indexID int
+	err = tx.QueryRow(context.Background(), "INSERT INTO bundles (name, description, price, image) VALUES ($1, $2, $3, $4) RETURNING id",
+		bundle.Name, bundle.Description, bundle.Price, bundle.Image).Scan(&indexID)
+	if err != nil {
+		return -1, err
+	}
+
+	err = setBundleProducts(tx, indexID, bundle.ProductIDs)
+	if err != nil {
+		return -1, err
+	}
+
+	return indexID, tx.Commit(context.Background())
+}
+
+// UpdateBundle changes a bundle and replaces its products, purchases made before keep their products
+func (dbc DBConnector) UpdateBundle(bundle Bundle) error {
+	tx, err := dbc.DB.Begin(context.Background())
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.Background())
+
+	result, err := tx.Exec(context.Background(), "UPDATE bundles SET name = $1, description = $2, price = $3, image = $4, updated_at = now() WHERE id = $5",
+		bundle.Name, bundle.Description, bundle.Price, bundle.Image, bundle.IndexID)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+
+	_, err = tx.Exec(context.Background(), "DELETE FROM bundle_products WHERE bundle_id = $1", bundle.IndexID)
+	if err != nil {
+		return err
+	}
+	err = setBundleProducts(tx, bundle.IndexID, bundle.ProductIDs)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(context.Background())
+}
+
+func setBundleProducts(tx pgx.Tx, bundleID int, productIDs []int) error {
+	_, err := tx.Exec(context.Background(), "INSERT INTO bundle_products (bundle_id, product_id, position) "+
+		"SELECT $1, product_id, position FROM unnest($2::integer[]) WITH ORDINALITY AS members (product_id, position)", bundleID, productIDs)
+	return err
+}
+
+func (dbc DBConnector) DeleteBundle(indexID int) error {
+	result, err := dbc.DB.Exec(context.Background(), "DELETE FROM bundles WHERE id = $1", indexID)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
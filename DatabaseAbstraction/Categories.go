@@ -0,0 +1,82 @@
+package DatabaseAbstraction
+
+import (
+	"context"
+	"github.com/jackc/pgx/v5"
+)
+
+// Category groups products, categories form a tree through ParentID (0 for top-level categories)
+type Category struct {
+	IndexID  int
+	Name     string
+	Slug     string
+	ParentID int
+}
+
+// GetCategories returns all categories ordered by name, callers build the tree
+func (dbc DBConnector) GetCategories() ([]Category, error) {
+	rows, err := dbc.DB.Query(context.Background(), "SELECT id, name, slug, COALESCE(parent_id, 0) FROM categories ORDER BY name, id")
+	if err != nil {
+		return []Category{}, err
+	}
+	defer rows.Close()
+
+	var categories []Category
+	for rows.Next() {
+		var category Category
+		err := rows.Scan(&category.IndexID, &category.Name, &category.Slug, &category.ParentID)
+		if err != nil {
+			return []Category{}, err
+		}
+		categories = append(categories, category)
+	}
+
+	return categories, rows.Err()
+}
+
+func (dbc DBConnector) AddCategory(category Category) (int, error) {
+	var indexID int
+	err := dbc.DB.QueryRow(context.Background(), "INSERT INTO categories (name, slug, parent_id) VALUES ($1, $2, NULLIF($3, 0)) RETURNING id",
+		category.Name, category.Slug, category.ParentID).Scan(&indexID)
+	if err != nil {
+		return -1, err
+	}
+
+	return indexID, nil
+}
+
+func (dbc DBConnector) UpdateCategory(category Category) error {
+	result, err := dbc.DB.Exec(context.Background(), "UPDATE categories SET name = $1, slug = $2, parent_id = NULLIF($3, 0) WHERE id = $4",
+		category.Name, category.Slug, category.ParentID, category.IndexID)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteCategory deletes a category with its subcategories, their products become uncategorized
+func (dbc DBConnector) DeleteCategory(categoryID int) error {
+	result, err := dbc.DB.Exec(context.Background(), "DELETE FROM categories WHERE id = $1", categoryID)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// SetProductCategory moves a product into a category, 0 removes it from its category
+func (dbc DBConnector) SetProductCategory(productID int, categoryID int) error {
+	result, err := dbc.DB.Exec(context.Background(), "UPDATE products SET category_id = NULLIF($1, 0), updated_at = now() WHERE id = $2", categoryID, productID)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
@@ -7,6 +7,7 @@ import (
 
 type Comment struct {
 	IndexID   int
+	UserID    int
 	Username  string
 	ProductID int
 	Comment   string
@@ -16,7 +17,7 @@ type Comment struct {
 // Get comments of a product
 func (dbc DBConnector) GetCommentsByProductID(productID int) ([]Comment, error) {
 	// Get the comments from the database
-	rows, err := dbc.DB.Query(context.Background(), "SELECT product_comments.id, username, product_comments.course_id, comment, product_comments.created_at FROM product_comments JOIN users ON users.id = user_id WHERE course_id = $1 ORDER BY product_comments.id DESC", productID)
+	rows, err := dbc.DB.Query(context.Background(), "SELECT product_comments.id, user_id, CASE WHEN users.deleted_at IS NULL THEN username ELSE 'Deleted user' END, product_comments.course_id, comment, product_comments.created_at FROM product_comments JOIN users ON users.id = user_id WHERE course_id = $1 ORDER BY product_comments.id DESC", productID)
 	if err != nil {
 		return []Comment{}, err
 	}
@@ -26,7 +27,7 @@ func (dbc DBConnector) GetCommentsByProductID(productID int) ([]Comment, error)
 
 	for rows.Next() {
 		var comment Comment
-		err := rows.Scan(&comment.IndexID, &comment.Username, &comment.ProductID, &comment.Comment, &comment.CreatedAt)
+		err := rows.Scan(&comment.IndexID, &comment.UserID, &comment.Username, &comment.ProductID, &comment.Comment, &comment.CreatedAt)
 		if err != nil {
 			return []Comment{}, err
 		}
@@ -46,3 +47,36 @@ func (dbc DBConnector) AddComment(userID int, productID int, comment string) err
 
 	return nil
 }
+
+// GetCommentsByUserID returns the comments written by a user, oldest first
+func (dbc DBConnector) GetCommentsByUserID(userID int) ([]Comment, error) {
+	rows, err := dbc.DB.Query(context.Background(), "SELECT product_comments.id, user_id, users.username, product_comments.course_id, comment, product_comments.created_at FROM product_comments JOIN users ON users.id = user_id WHERE user_id = $1 ORDER BY product_comments.id", userID)
+	if err != nil {
+		return []Comment{}, err
+	}
+	defer rows.Close()
+
+	var comments []Comment
+	for rows.Next() {
+		var comment Comment
+		err := rows.Scan(&comment.IndexID, &comment.UserID, &comment.Username, &comment.ProductID, &comment.Comment, &comment.CreatedAt)
+		if err != nil {
+			return []Comment{}, err
+		}
+		comments = append(comments, comment)
+	}
+
+	return comments, rows.Err()
+}
+
+// DeleteComment removes a comment and returns it, so moderation can record what was removed
+func (dbc DBConnector) DeleteComment(commentID int) (Comment, error) {
+	var comment Comment
+	err := dbc.DB.QueryRow(context.Background(), "DELETE FROM product_comments WHERE id = $1 RETURNING id, user_id, course_id, comment, created_at", commentID).
+		Scan(&comment.IndexID, &comment.UserID, &comment.ProductID, &comment.Comment, &comment.CreatedAt)
+	if err != nil {
+		return Comment{}, err
+	}
+
+	return comment, nil
+}
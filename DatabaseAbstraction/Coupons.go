@@ -0,0 +1,115 @@
+package DatabaseAbstraction
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgx/v5"
+	"strings"
+	"time"
+)
+
+const (
+	CouponPercent = "percent" // Value is the discount in percent
+	CouponAmount  = "amount"  // Value is subtracted from the price
+)
+
+var ErrCouponUnavailable = errors.New("the coupon is no longer available")
+
+// Coupon is a discount code, zero values mean no restriction: all products, unlimited uses and no validity window
+type Coupon struct {
+	IndexID        int
+	Code           string
+	Kind           string
+	Value          int
+	ProductID      int
+	MaxUses        int
+	MaxUsesPerUser int
+	Uses           int
+	ValidFrom      *time.Time
+	ValidUntil     *time.Time // exclusive
+	CreatedAt      time.Time
+}
+
+const couponColumns = "id, code, kind, value, COALESCE(product_id, 0), COALESCE(max_uses, 0), COALESCE(max_uses_per_user, 0), uses, " +
+	"valid_from, valid_until, created_at"
+
+func scanCoupon(row pgx.Row) (Coupon, error) {
+	var coupon Coupon
+	err := row.Scan(&coupon.IndexID, &coupon.Code, &coupon.Kind, &coupon.Value, &coupon.ProductID, &coupon.MaxUses, &coupon.MaxUsesPerUser, &coupon.Uses,
+		&coupon.ValidFrom, &coupon.ValidUntil, &coupon.CreatedAt)
+	return coupon, err
+}
+
+// GetCouponByCode finds a coupon, codes are case-insensitive
+func (dbc DBConnector) GetCouponByCode(code string) (Coupon, error) {
+	return scanCoupon(dbc.DB.QueryRow(context.Background(), "SELECT "+couponColumns+" FROM coupons WHERE code = $1", strings.ToUpper(code)))
+}
+
+// GetCoupons returns all coupons, newest first
+func (dbc DBConnector) GetCoupons() ([]Coupon, error) {
+	rows, err := dbc.DB.Query(context.Background(), "SELECT "+couponColumns+" FROM coupons ORDER BY id DESC")
+	if err != nil {
+		return []Coupon{}, err
+	}
+	defer rows.Close()
+
+	var coupons []Coupon
+	for rows.Next() {
+		coupon, err := scanCoupon(rows)
+		if err != nil {
+			return []Coupon{}, err
+		}
+		coupons = append(coupons, coupon)
+	}
+
+	return coupons, rows.Err()
+}
+
+func (dbc DBConnector) AddCoupon(coupon Coupon) (int, error) {
+	var indexID int
+	err := dbc.DB.QueryRow(context.Background(), "INSERT INTO coupons (code, kind, value, product_id, max_uses, max_uses_per_user, valid_from, valid_until) "+
+		"VALUES ($1, $2, $3, NULLIF($4, 0), NULLIF($5, 0), NULLIF($6, 0), $7, $8) RETURNING id",
+		strings.ToUpper(coupon.Code), coupon.Kind, coupon.Value, coupon.ProductID, coupon.MaxUses, coupon.MaxUsesPerUser,
+		coupon.ValidFrom, coupon.ValidUntil).Scan(&indexID)
+	if err != nil {
+		return -1, err
+	}
+
+	return indexID, nil
+}
+
+// DisableCoupon ends the validity window of a coupon now, purchases made with it keep referring to it
+func (dbc DBConnector) DisableCoupon(indexID int) error {
+	result, err := dbc.DB.Exec(context.Background(), "UPDATE coupons SET valid_until = LEAST(COALESCE(valid_until, now()), now()) WHERE id = $1", indexID)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// CountCouponUses returns how many products the user bought with the coupon
+func (dbc DBConnector) CountCouponUses(couponID int, userID int) (int, error) {
+	var uses int
+	err := dbc.DB.QueryRow(context.Background(), "SELECT COUNT(*) FROM user_purchases WHERE coupon_id = $1 AND user_id = $2", couponID, userID).Scan(&uses)
+	return uses, err
+}
+
+// redeemCoupon counts a use of the coupon inside a purchase, the row lock of the update makes concurrent redemptions wait
+// so the limits hold. The caller has to insert the purchases with the coupon in the same transaction.
+func redeemCoupon(tx pgx.Tx, couponID int, userID int) error {
+	result, err := tx.Exec(context.Background(), "UPDATE coupons SET uses = uses + 1 WHERE id = $1 "+
+		"AND (max_uses IS NULL OR uses < max_uses) "+
+		"AND (valid_from IS NULL OR valid_from <= now()) AND (valid_until IS NULL OR valid_until > now()) "+
+		"AND (max_uses_per_user IS NULL OR (SELECT COUNT(*) FROM user_purchases WHERE coupon_id = $1 AND user_id = $2) < max_uses_per_user)",
+		couponID, userID)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrCouponUnavailable
+	}
+	return nil
+}
@@ -17,37 +17,178 @@ type DBConnector struct {
 //go:generate mockery --name DBOrm
 type DBOrm interface {
 	GetAllProducts() ([]Product, error)
+	GetCatalog(query ProductQuery) ([]CatalogProduct, error)
+	Search(query string, limit int) ([]SearchHit, error)
 	GetProductByIndexID(indexID int) (Product, error)
 	AddProduct(NewProduct Product) (int, error)
+	GetProductTags(productID int) ([]string, error)
+	SetProductTags(productID int, tags []string) error
+	SetProductCategory(productID int, categoryID int) error
+	GetRelatedProducts(productID int, limit int) ([]RelatedProduct, error)
+
+	GetCategories() ([]Category, error)
+	AddCategory(category Category) (int, error)
+	UpdateCategory(category Category) error
+	DeleteCategory(categoryID int) error
+
+	GetBundles() ([]Bundle, error)
+	GetBundleByIndexID(indexID int) (Bundle, error)
+	AddBundle(bundle Bundle) (int, error)
+	UpdateBundle(bundle Bundle) error
+	DeleteBundle(indexID int) error
+
+	GetCouponByCode(code string) (Coupon, error)
+	GetCoupons() ([]Coupon, error)
+	AddCoupon(coupon Coupon) (int, error)
+	DisableCoupon(indexID int) error
+	CountCouponUses(couponID int, userID int) (int, error)
 
 	GetTokenByTokenID(tokenID string) (Token, error)
 	GetTokenByHash(token string) (Token, error)
 	AddToken(userID int, token string, expiry time.Time) error
+	AddImpersonationToken(userID int, impersonatorID int, token string, expiry time.Time) error
 	DeleteToken(tokenID int) error
 	DeleteTokenByHash(token string) error
-
-	GetAllUsers() ([]User, error)
-	GetUserByUsername(username string) (User, error)
+	DeleteTokensByUserID(userID int) error
+	GetTokensByUserID(userID int) ([]Token, error)
+	AddRevokedToken(revoked RevokedToken) error
+	GetRevokedTokens() ([]RevokedToken, error)
+
+	AddAPIKey(apiKey APIKey, keyHash string) (int, error)
+	GetAPIKeyByHash(keyHash string) (APIKey, error)
+	GetAPIKeysByUserID(userID int) ([]APIKey, error)
+	DeleteAPIKey(userID int, keyID int) error
+	DeleteAPIKeysByUserID(userID int) error
+	TouchAPIKey(keyID int) error
+
+	AddPasswordResetToken(userID int, tokenHash string, expiry time.Time) error
+	GetPasswordResetToken(tokenHash string) (PasswordResetToken, error)
+	ConsumePasswordResetToken(tokenHash string) (PasswordResetToken, error)
+	DeletePasswordResetTokensByUserID(userID int) error
+
+	SearchUsers(query string, limit int, offset int) ([]User, int, error)
+	GetUserByUsername(username string) (User, error) // case-insensitive
+	GetUserByEmail(email string) (User, error)
 	GetUserByIndexID(indexID int) (User, error)
-	AddUser(username string, password string) error
+	AddUser(username string, password string, email string) error
 	DeleteUser(indexID int) error
 	UpdateUserPassword(indexID int, newPassword string) error
 	UpdateUserUsername(indexID int, newUsername string) error
+	UpdateUserEmail(indexID int, email string) error
+	UpdateUserProfile(indexID int, displayName string, avatarURL string, locale string, country string) error
+	AnonymizeUser(indexID int) error
+	VerifyUserEmail(indexID int, email string) error
+	SuspendUser(indexID int, reason string) error
+	UnsuspendUser(indexID int) error
+
+	SetUserTOTPSecret(userID int, secret string) error
+	EnableUserTOTP(userID int) error
+	DisableUserTOTP(userID int) error
+	AdvanceUserTOTPStep(userID int, step int64) error
+	ReplaceRecoveryCodes(userID int, codeHashes []string) error
+	ConsumeRecoveryCode(userID int, codeHash string) error
+	AddTwoFactorChallenge(userID int, challengeHash string, expiry time.Time) error
+	UseTwoFactorChallenge(challengeHash string, maxAttempts int) error
+	DeleteTwoFactorChallenge(challengeHash string) error
+
+	GetUserIdentity(provider string, subject string) (UserIdentity, error)
+	GetUserIdentitiesByUserID(userID int) ([]UserIdentity, error)
+	AddUserIdentity(userID int, provider string, subject string, email string) error
+	DeleteUserIdentity(userID int, provider string) error
+	AddOIDCLoginFlow(flow OIDCLoginFlow) error
+	ConsumeOIDCLoginFlow(state string) (OIDCLoginFlow, error)
+
 	GetOwnedProducts(indexID int) ([]Product, error)
-	IncreaseUserBalance(indexID int, amount int) error
-	DecreaseUserBalance(indexID int, amount int) error
+	IncreaseUserBalance(indexID int, amount int, reason string) error
+	DecreaseUserBalance(indexID int, amount int, reason string) error
 	AddOwnedProduct(indexID int, productID int) error
+	RemoveOwnedProduct(indexID int, productID int) error
+	GetBalanceTransactionsByUserID(userID int) ([]BalanceTransaction, error)
+	GetPurchasesByUserID(userID int) ([]Purchase, error)
+	PurchaseProducts(order PurchaseOrder) error
+	GetPurchaseByIndexID(indexID int) (Purchase, error)
+
+	AddRefundRequest(refund Refund) (int, error)
+	GetRefundByIndexID(indexID int) (Refund, error)
+	GetRefundsByUserID(userID int) ([]Refund, error)
+	GetRefunds(status string, limit int, offset int) ([]Refund, int, error)
+	ApproveRefund(refundID int, adminID int, note string, maxWatchedPercent int) error
+	RejectRefund(refundID int, adminID int, note string) error
+
+	CreateLicenseKeys(batch LicenseKeyBatch) ([]LicenseKey, error)
+	GetLicenseKeyByIndexID(indexID int) (LicenseKey, error)
+	GetLicenseKeyByCode(code string) (LicenseKey, error)
+	GetLicenseKeys(filter LicenseKeyFilter, limit int, offset int) ([]LicenseKey, int, error)
+	RedeemLicenseKey(keyID int, userID int) error
+	RevokeLicenseKey(keyID int) error
+
+	GetEntitlements(userID int) ([]Entitlement, error)
+	ExpireRentals() ([]Purchase, error)
+	GetRentalOffer(productID int) (RentalOffer, error)
+	SetRentalOffer(offer RentalOffer) error
+	DeleteRentalOffer(productID int) error
+	GetSubscriptionPlans(includeInactive bool) ([]SubscriptionPlan, error)
+	GetSubscriptionPlanByIndexID(indexID int) (SubscriptionPlan, error)
+	AddSubscriptionPlan(plan SubscriptionPlan) (int, error)
+	DeactivateSubscriptionPlan(indexID int) error
+	GetSubscriptionByIndexID(indexID int) (Subscription, error)
+	GetSubscriptionsByUserID(userID int) ([]Subscription, error)
+	GetLapsedSubscriptions() ([]Subscription, error)
+	StartSubscription(subscription Subscription, price int, reason string) (int, error)
+	CountSubscriptions(userID int, planID int) (int, error)
+	CancelSubscription(indexID int) error
+	RenewSubscription(subscription Subscription, price int, periodEnd time.Time, reason string) error
+	ExpireSubscription(indexID int) error
+
+	CreateOrganization(name string, adminID int) (int, error)
+	GetOrganizationByIndexID(indexID int) (Organization, error)
+	GetOrganizationsByUserID(userID int) ([]Organization, error)
+	GetOrganizationMember(organizationID int, userID int) (OrganizationMember, error)
+	GetOrganizationMembers(organizationID int) ([]OrganizationMember, error)
+	RemoveOrganizationMember(organizationID int, userID int) error
+	AddOrganizationInvite(invite OrganizationInvite) (int, error)
+	GetOrganizationInviteByCode(code string) (OrganizationInvite, error)
+	GetOrganizationInvites(organizationID int) ([]OrganizationInvite, error)
+	DeleteOrganizationInvite(organizationID int, inviteID int) error
+	AcceptOrganizationInvite(invite OrganizationInvite, userID int) error
+	AddOrganizationSeats(purchase SeatPurchase) error
+	GetOrganizationSeats(organizationID int) ([]OrganizationSeats, error)
+	AssignSeat(organizationID int, productID int, userID int) error
+	ReclaimSeat(organizationID int, productID int, userID int) error
+	GetOrganizationProgress(organizationID int) ([]SeatProgress, error)
+	TopUpBalance(userID int, amount int) (int, error)
+	GetInvoiceByIndexID(indexID int) (Invoice, error)
+	GetInvoicesByUserID(userID int) ([]Invoice, error)
+	GetVATRates() ([]VATRate, error)
+	SetVATRate(rate VATRate) error
+	DeleteVATRate(country string) error
 
 	MarkVideoAsWatched(indexID int, user User) error
 	GetWatchedVideosByUser(user User) ([]Video, error)
+	GetWatchHistoryByUserID(userID int) ([]WatchedVideo, error)
+	GetProgressByUserID(userID int) ([]ProductProgress, error)
 
 	GetAllVideos() ([]Video, error)
 	GetVideosByProductIndexID(productID int) ([]Video, error)
+	GetVideosByProductIndexIDs(productIDs []int) (map[int][]Video, error)
 	GetVideoByIndexID(indexID int) (Video, error)
 	GetProductByVideoIndexID(indexID int) (Product, error)
 
 	GetCommentsByProductID(productID int) ([]Comment, error)
 	AddComment(userID int, productID int, comment string) error
+	GetCommentsByUserID(userID int) ([]Comment, error)
+	DeleteComment(commentID int) (Comment, error)
+
+	AddDataExport(userID int, expiry time.Time) (DataExport, error)
+	GetDataExport(userID int, exportID int) (DataExport, error)
+	GetPendingDataExport(userID int) (DataExport, error)
+	GetDataExportArchive(userID int, exportID int) ([]byte, error)
+	CompleteDataExport(exportID int, archive []byte) error
+	FailDataExport(exportID int, reason string) error
+	DeleteExpiredDataExports() error
+
+	AddAuditEvent(event AuditEvent) error
+	GetAuditEvents(filter AuditFilter, limit int, offset int) ([]AuditEvent, int, error)
 }
 
 const (
@@ -0,0 +1,95 @@
+package DatabaseAbstraction
+
+import (
+	"context"
+	"github.com/jackc/pgx/v5"
+	"time"
+)
+
+const (
+	DataExportPending = "pending"
+	DataExportDone    = "done"
+	DataExportFailed  = "failed"
+)
+
+// DataExport is an export of the personal data of a user, the archive itself is only loaded by GetDataExportArchive
+type DataExport struct {
+	IndexID     int
+	UserID      int
+	Status      string
+	Error       string
+	CreatedAt   time.Time
+	CompletedAt *time.Time
+	Expiry      time.Time // the export and its archive are deleted afterwards
+}
+
+const dataExportColumns = "id, user_id, status, COALESCE(error, ''), created_at, completed_at, expiry"
+
+func scanDataExport(row pgx.Row) (DataExport, error) {
+	var export DataExport
+	err := row.Scan(&export.IndexID, &export.UserID, &export.Status, &export.Error, &export.CreatedAt, &export.CompletedAt, &export.Expiry)
+	if err != nil {
+		return DataExport{}, err
+	}
+
+	return export, nil
+}
+
+// AddDataExport creates a pending export
+func (dbc DBConnector) AddDataExport(userID int, expiry time.Time) (DataExport, error) {
+	row := dbc.DB.QueryRow(context.Background(), "INSERT INTO data_exports (user_id, status, expiry) VALUES ($1, $2, $3) RETURNING "+dataExportColumns, userID, DataExportPending, expiry)
+	return scanDataExport(row)
+}
+
+// GetDataExport only returns exports of the given user that have not expired
+func (dbc DBConnector) GetDataExport(userID int, exportID int) (DataExport, error) {
+	row := dbc.DB.QueryRow(context.Background(), "SELECT "+dataExportColumns+" FROM data_exports WHERE id = $1 AND user_id = $2 AND expiry > now()", exportID, userID)
+	return scanDataExport(row)
+}
+
+// GetPendingDataExport returns the export of the user that is still being generated
+func (dbc DBConnector) GetPendingDataExport(userID int) (DataExport, error) {
+	row := dbc.DB.QueryRow(context.Background(), "SELECT "+dataExportColumns+" FROM data_exports WHERE user_id = $1 AND status = $2 AND expiry > now() ORDER BY id DESC LIMIT 1", userID, DataExportPending)
+	return scanDataExport(row)
+}
+
+// GetDataExportArchive returns the ZIP archive of a finished export of the given user
+func (dbc DBConnector) GetDataExportArchive(userID int, exportID int) ([]byte, error) {
+	row := dbc.DB.QueryRow(context.Background(), "SELECT archive FROM data_exports WHERE id = $1 AND user_id = $2 AND status = $3 AND expiry > now()", exportID, userID, DataExportDone)
+
+	var archive []byte
+	err := row.Scan(&archive)
+	if err != nil {
+		return nil, err
+	}
+
+	return archive, nil
+}
+
+func (dbc DBConnector) CompleteDataExport(exportID int, archive []byte) error {
+	_, err := dbc.DB.Exec(context.Background(), "UPDATE data_exports SET status = $1, archive = $2, completed_at = now() WHERE id = $3", DataExportDone, archive, exportID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (dbc DBConnector) FailDataExport(exportID int, reason string) error {
+	_, err := dbc.DB.Exec(context.Background(), "UPDATE data_exports SET status = $1, error = $2, completed_at = now() WHERE id = $3", DataExportFailed, reason, exportID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DeleteExpiredDataExports removes exports past their expiry together with their archives
+func (dbc DBConnector) DeleteExpiredDataExports() error {
+	_, err := dbc.DB.Exec(context.Background(), "DELETE FROM data_exports WHERE expiry <= now()")
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
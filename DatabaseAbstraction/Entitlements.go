@@ -0,0 +1,115 @@
+package DatabaseAbstraction
+
+import (
+	"context"
+	"github.com/jackc/pgx/v5"
+	"time"
+)
+
+const (
+	EntitlementPurchase     = "purchase"     // a purchase row without end, bought, granted or redeemed
+	EntitlementRental       = "rental"       // a purchase row with an end
+	EntitlementSubscription = "subscription" // unlocks the whole catalog until the end of the period
+	EntitlementSeat         = "seat"         // a seat of an organization, it lasts until the seat is reclaimed
+)
+
+// Entitlement is a current right to watch a product, ProductID is 0 for subscriptions because they cover every product
+type Entitlement struct {
+	Source         string
+	ProductID      int
+	PurchaseID     int
+	SubscriptionID int
+	OrganizationID int
+	StartsAt       time.Time
+	ExpiresAt      *time.Time // nil if it doesn't end
+}
+
+// Permanent reports whether the entitlement has no end date, a seat can still be reclaimed
+func (entitlement Entitlement) Permanent() bool {
+	return entitlement.ExpiresAt == nil
+}
+
+// Covers reports whether the entitlement includes the product
+func (entitlement Entitlement) Covers(productID int) bool {
+	return entitlement.ProductID == 0 || entitlement.ProductID == productID
+}
+
+// RentalOffer makes a product rentable for a number of days
+type RentalOffer struct {
+	ProductID int
+	Price     int
+	Days      int
+}
+
+// GetEntitlements returns the entitlements of a user that are valid right now
+// Lapsed ones are left out even before the expiry job marked them, so access ends on time
+func (dbc DBConnector) GetEntitlements(userID int) ([]Entitlement, error) {
+	rows, err := dbc.DB.Query(context.Background(), "SELECT CASE WHEN expires_at IS NULL THEN $2 ELSE $3 END, product_id, id, 0, 0, created_at, expires_at "+
+		"FROM user_purchases WHERE user_id = $1 AND (expires_at IS NULL OR expires_at > now()) "+
+		"UNION ALL SELECT $4, 0, 0, id, 0, started_at, current_period_end FROM subscriptions "+
+		"WHERE user_id = $1 AND status IN ($5, $6) AND current_period_end > now() "+
+		"UNION ALL SELECT $7, product_id, 0, 0, organization_id, assigned_at, NULL FROM seat_assignments WHERE user_id = $1",
+		userID, EntitlementPurchase, EntitlementRental, EntitlementSubscription, SubscriptionActive, SubscriptionCancelled, EntitlementSeat)
+	if err != nil {
+		return []Entitlement{}, err
+	}
+	defer rows.Close()
+
+	var entitlements []Entitlement
+	for rows.Next() {
+		var entitlement Entitlement
+		err := rows.Scan(&entitlement.Source, &entitlement.ProductID, &entitlement.PurchaseID, &entitlement.SubscriptionID, &entitlement.OrganizationID, &entitlement.StartsAt, &entitlement.ExpiresAt)
+		if err != nil {
+			return []Entitlement{}, err
+		}
+		entitlements = append(entitlements, entitlement)
+	}
+
+	return entitlements, rows.Err()
+}
+
+// ExpireRentals marks the lapsed rentals as expired and returns them, every rental is returned only once
+func (dbc DBConnector) ExpireRentals() ([]Purchase, error) {
+	rows, err := dbc.DB.Query(context.Background(), "UPDATE user_purchases SET expired = true WHERE expires_at <= now() AND NOT expired RETURNING id, user_id, product_id, expires_at")
+	if err != nil {
+		return []Purchase{}, err
+	}
+	defer rows.Close()
+
+	var rentals []Purchase
+	for rows.Next() {
+		var rental Purchase
+		err := rows.Scan(&rental.IndexID, &rental.UserID, &rental.ProductID, &rental.ExpiresAt)
+		if err != nil {
+			return []Purchase{}, err
+		}
+		rentals = append(rentals, rental)
+	}
+
+	return rentals, rows.Err()
+}
+
+func (dbc DBConnector) GetRentalOffer(productID int) (RentalOffer, error) {
+	var offer RentalOffer
+	err := dbc.DB.QueryRow(context.Background(), "SELECT product_id, price, days FROM rental_offers WHERE product_id = $1", productID).Scan(&offer.ProductID, &offer.Price, &offer.Days)
+	return offer, err
+}
+
+// SetRentalOffer creates or replaces the rental offer of a product
+func (dbc DBConnector) SetRentalOffer(offer RentalOffer) error {
+	_, err := dbc.DB.Exec(context.Background(), "INSERT INTO rental_offers (product_id, price, days) VALUES ($1, $2, $3) "+
+		"ON CONFLICT (product_id) DO UPDATE SET price = EXCLUDED.price, days = EXCLUDED.days", offer.ProductID, offer.Price, offer.Days)
+	return err
+}
+
+// DeleteRentalOffer stops renting out the product, running rentals continue
+func (dbc DBConnector) DeleteRentalOffer(productID int) error {
+	result, err := dbc.DB.Exec(context.Background(), "DELETE FROM rental_offers WHERE product_id = $1", productID)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package DatabaseAbstraction
+
+import (
+	"context"
+	"time"
+)
+
+// UserIdentity links an account at an external identity provider to a user
+type UserIdentity struct {
+	IndexID   int
+	UserID    int
+	Provider  string
+	Subject   string // the provider's stable user ID, never the email address
+	Email     string
+	CreatedAt time.Time
+}
+
+// OIDCLoginFlow is the state of a login between the redirect to the provider and the callback
+type OIDCLoginFlow struct {
+	State        string
+	Provider     string
+	Nonce        string
+	CodeVerifier string
+	UserID       int // set if a signed-in user links a new identity, 0 for logins
+	Expiry       time.Time
+}
+
+func (dbc DBConnector) GetUserIdentity(provider string, subject string) (UserIdentity, error) {
+	row := dbc.DB.QueryRow(context.Background(), "SELECT id, user_id, provider, subject, email, created_at FROM user_identities WHERE provider = $1 AND subject = $2", provider, subject)
+
+	var identity UserIdentity
+	err := row.Scan(&identity.IndexID, &identity.UserID, &identity.Provider, &identity.Subject, &identity.Email, &identity.CreatedAt)
+	if err != nil {
+		return UserIdentity{}, err
+	}
+
+	return identity, nil
+}
+
+func (dbc DBConnector) GetUserIdentitiesByUserID(userID int) ([]UserIdentity, error) {
+	rows, err := dbc.DB.Query(context.Background(), "SELECT id, user_id, provider, subject, email, created_at FROM user_identities WHERE user_id = $1 ORDER BY id", userID)
+	if err != nil {
+		return []UserIdentity{}, err
+	}
+
+	var identities []UserIdentity
+	for rows.Next() {
+		var identity UserIdentity
+		err := rows.Scan(&identity.IndexID, &identity.UserID, &identity.Provider, &identity.Subject, &identity.Email, &identity.CreatedAt)
+		if err != nil {
+			return []UserIdentity{}, err
+		}
+		identities = append(identities, identity)
+	}
+
+	return identities, nil
+}
+
+func (dbc DBConnector) AddUserIdentity(userID int, provider string, subject string, email string) error {
+	_, err := dbc.DB.Exec(context.Background(), "INSERT INTO user_identities (user_id, provider, subject, email) VALUES ($1, $2, $3, $4)", userID, provider, subject, email)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (dbc DBConnector) DeleteUserIdentity(userID int, provider string) error {
+	_, err := dbc.DB.Exec(context.Background(), "DELETE FROM user_identities WHERE user_id = $1 AND provider = $2", userID, provider)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (dbc DBConnector) AddOIDCLoginFlow(flow OIDCLoginFlow) error {
+	_, err := dbc.DB.Exec(context.Background(), "INSERT INTO oidc_login_flows (state, provider, nonce, code_verifier, user_id, expiry) VALUES ($1, $2, $3, $4, NULLIF($5, 0), $6)", flow.State, flow.Provider, flow.Nonce, flow.CodeVerifier, flow.UserID, flow.Expiry)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ConsumeOIDCLoginFlow deletes and returns an unexpired flow, so every state can only be used for one callback
+func (dbc DBConnector) ConsumeOIDCLoginFlow(state string) (OIDCLoginFlow, error) {
+	row := dbc.DB.QueryRow(context.Background(), "DELETE FROM oidc_login_flows WHERE state = $1 AND expiry > now() RETURNING state, provider, nonce, code_verifier, COALESCE(user_id, 0), expiry", state)
+
+	var flow OIDCLoginFlow
+	err := row.Scan(&flow.State, &flow.Provider, &flow.Nonce, &flow.CodeVerifier, &flow.UserID, &flow.Expiry)
+	if err != nil {
+		return OIDCLoginFlow{}, err
+	}
+
+	return flow, nil
+}
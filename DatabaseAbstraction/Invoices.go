@@ -0,0 +1,327 @@
+package DatabaseAbstraction
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"time"
+)
+
+const (
+	InvoicePurchase   = "purchase"
+	InvoiceTopUp      = "top_up"
+	InvoiceCreditNote = "credit_note" // cancels (part of) a purchase invoice when a refund is approved, its amounts are negative
+)
+
+// Invoice is issued for every payment, its billing details and lines are copied so later changes don't alter it
+// Prices include VAT, Net and VAT are the split of Gross at the rate of the buyer's country
+type Invoice struct {
+	IndexID      int
+	Number       string
+	UserID       int // 0 if the user was deleted
+	Kind         string
+	BillingName  string
+	BillingEmail string
+	Country      string
+	VATRate      int // in hundredths of a percent, 1900 is 19 %
+	Gross        int
+	Net          int
+	VAT          int
+	Items        []InvoiceItem // only loaded for a single invoice
+	Cancels      string        // number of the invoice a credit note cancels
+	CreatedAt    time.Time
+}
+
+// InvoiceItem is a line of an invoice, Amount is what was paid for all of its units
+type InvoiceItem struct {
+	Description string
+	Quantity    int
+	Amount      int
+	Discount    int
+}
+
+// VATRate is the rate charged to buyers from the country, the default one also applies to users without country
+type VATRate struct {
+	Country string // ISO 3166 code
+	Rate    int    // in hundredths of a percent, 1900 is 19 %
+	Default bool
+}
+
+// SplitVAT splits a price that includes VAT into the net amount and the VAT, rounded to the nearest cent
+// Negative amounts of credit notes are split like the amount they cancel
+func SplitVAT(gross int, rate int) (int, int) {
+	if gross < 0 {
+		net, vat := SplitVAT(-gross, rate)
+		return -net, -vat
+	}
+	net := (2*gross*10000 + 10000 + rate) / (2 * (10000 + rate))
+	return net, gross - net
+}
+
+// FormatInvoiceNumber returns the number printed on the invoice, numbers start at 1 every year
+func FormatInvoiceNumber(year int, number int) string {
+	return fmt.Sprintf("INV-%d-%06d", year, number)
+}
+
+// vatRate returns the billing country and its rate. Users choose their country themselves, so countries without a
+// configured rate are billed at the default rate, a country is only billed without VAT if an admin set its rate to 0.
+// Users without country are billed as the default country
+func vatRate(tx pgx.Tx, country string) (string, int, error) {
+	var rateCountry string
+	var rate int
+	err := tx.QueryRow(context.Background(), "SELECT country, rate FROM vat_rates WHERE country = $1 OR is_default ORDER BY country = $1 DESC LIMIT 1",
+		country).Scan(&rateCountry, &rate)
+	if errors.Is(err, pgx.ErrNoRows) {
+		// without a default rate only the configured countries are charged VAT
+		return country, 0, nil
+	}
+	if country == "" {
+		country = rateCountry
+	}
+	return country, rate, err
+}
+
+// nextInvoiceNumber takes the next number of the year from its counter row, which stays locked until the transaction
+// ends and keeps the numbers free of gaps
+func nextInvoiceNumber(tx pgx.Tx) (string, error) {
+	var year, number int
+	err := tx.QueryRow(context.Background(), "INSERT INTO invoice_counters (year, last_number) VALUES (EXTRACT(YEAR FROM CURRENT_TIMESTAMP)::integer, 1) "+
+		"ON CONFLICT (year) DO UPDATE SET last_number = invoice_counters.last_number + 1 RETURNING year, last_number").Scan(&year, &number)
+	if err != nil {
+		return "", err
+	}
+	return FormatInvoiceNumber(year, number), nil
+}
+
+// insertInvoice numbers the invoice and stores it with its lines, Gross, Net and VAT are computed from the lines
+func insertInvoice(tx pgx.Tx, invoice Invoice, cancelsInvoiceID int) (int, error) {
+	descriptions := make([]string, len(invoice.Items))
+	quantities := make([]int, len(invoice.Items))
+	amounts := make([]int, len(invoice.Items))
+	discounts := make([]int, len(invoice.Items))
+	invoice.Gross = 0
+	for i, item := range invoice.Items {
+		descriptions[i], quantities[i], amounts[i], discounts[i] = item.Description, item.Quantity, item.Amount, item.Discount
+		invoice.Gross += item.Amount
+	}
+	invoice.Net, invoice.VAT = SplitVAT(invoice.Gross, invoice.VATRate)
+
+	number, err := nextInvoiceNumber(tx)
+	if err != nil {
+		return -1, err
+	}
+
+	err = tx.QueryRow(context.Background(), "INSERT INTO invoices (number, user_id, kind, billing_name, billing_email, country, vat_rate, gross, net, vat, cancels_invoice_id) "+
+		"VALUES ($1, NULLIF($2, 0), $3, $4, $5, $6, $7, $8, $9, $10, NULLIF($11, 0)) RETURNING id",
+		number, invoice.UserID, invoice.Kind, invoice.BillingName, invoice.BillingEmail, invoice.Country, invoice.VATRate,
+		invoice.Gross, invoice.Net, invoice.VAT, cancelsInvoiceID).Scan(&invoice.IndexID)
+	if err != nil {
+		return -1, err
+	}
+
+	_, err = tx.Exec(context.Background(), "INSERT INTO invoice_items (invoice_id, description, quantity, amount, discount) "+
+		"SELECT $1, description, quantity, amount, discount FROM unnest($2::varchar[], $3::integer[], $4::integer[], $5::integer[]) "+
+		"WITH ORDINALITY AS items (description, quantity, amount, discount, position) ORDER BY position",
+		invoice.IndexID, descriptions, quantities, amounts, discounts)
+	if err != nil {
+		return -1, err
+	}
+
+	return invoice.IndexID, nil
+}
+
+// issueInvoice stores the invoice in the transaction of the payment, so a payment can't happen without it
+func issueInvoice(tx pgx.Tx, userID int, kind string, items []InvoiceItem) (int, error) {
+	invoice := Invoice{UserID: userID, Kind: kind, Items: items}
+	err := tx.QueryRow(context.Background(), "SELECT COALESCE(NULLIF(display_name, ''), username), COALESCE(email, ''), COALESCE(country, '') FROM users WHERE id = $1",
+		userID).Scan(&invoice.BillingName, &invoice.BillingEmail, &invoice.Country)
+	if err != nil {
+		return -1, err
+	}
+
+	invoice.Country, invoice.VATRate, err = vatRate(tx, invoice.Country)
+	if err != nil {
+		return -1, err
+	}
+	// the balance is a voucher for any product, VAT is charged when it is spent
+	if kind == InvoiceTopUp {
+		invoice.VATRate = 0
+	}
+
+	return insertInvoice(tx, invoice, 0)
+}
+
+// issueCreditNote cancels the amount of the invoice that is refunded, with the billing details and VAT rate of the invoice
+func issueCreditNote(tx pgx.Tx, invoiceID int, description string, amount int) (int, error) {
+	invoice, err := scanInvoice(tx.QueryRow(context.Background(), "SELECT "+invoiceColumns+" FROM invoices WHERE id = $1", invoiceID))
+	if err != nil {
+		return -1, err
+	}
+
+	invoice.Kind = InvoiceCreditNote
+	invoice.Items = []InvoiceItem{{Description: description, Quantity: 1, Amount: -amount}}
+	return insertInvoice(tx, invoice, invoiceID)
+}
+
+// chargeUser debits the user, records the debit in the wallet history and issues the invoice for it
+// It fails on the balance check constraint if the user can't afford it
+func chargeUser(tx pgx.Tx, userID int, reason string, items []InvoiceItem) (int, error) {
+	total := 0
+	for _, item := range items {
+		total += item.Amount
+	}
+
+	_, err := tx.Exec(context.Background(), "UPDATE users SET balance = balance - $1 WHERE id = $2", total, userID)
+	if err != nil {
+		return -1, err
+	}
+
+	_, err = tx.Exec(context.Background(), "INSERT INTO balance_transactions (user_id, amount, reason) VALUES ($1, $2, $3)", userID, -total, reason)
+	if err != nil {
+		return -1, err
+	}
+
+	return issueInvoice(tx, userID, InvoicePurchase, items)
+}
+
+// TopUpBalance credits the balance and issues a receipt for it, it returns the ID of the invoice
+func (dbc DBConnector) TopUpBalance(userID int, amount int) (int, error) {
+	tx, err := dbc.DB.Begin(context.Background())
+	if err != nil {
+		return -1, err
+	}
+	defer tx.Rollback(context.Background())
+
+	_, err = tx.Exec(context.Background(), "UPDATE users SET balance = balance + $1 WHERE id = $2", amount, userID)
+	if err != nil {
+		return -1, err
+	}
+
+	_, err = tx.Exec(context.Background(), "INSERT INTO balance_transactions (user_id, amount, reason) VALUES ($1, $2, $3)", userID, amount, "top-up")
+	if err != nil {
+		return -1, err
+	}
+
+	invoiceID, err := issueInvoice(tx, userID, InvoiceTopUp, []InvoiceItem{{Description: "Balance top-up", Quantity: 1, Amount: amount}})
+	if err != nil {
+		return -1, err
+	}
+
+	return invoiceID, tx.Commit(context.Background())
+}
+
+const invoiceColumns = "invoices.id, invoices.number, COALESCE(invoices.user_id, 0), invoices.kind, invoices.billing_name, invoices.billing_email, " +
+	"invoices.country, invoices.vat_rate, invoices.gross, invoices.net, invoices.vat, " +
+	"COALESCE((SELECT cancelled.number FROM invoices cancelled WHERE cancelled.id = invoices.cancels_invoice_id), ''), invoices.created_at"
+
+func scanInvoice(row pgx.Row) (Invoice, error) {
+	var invoice Invoice
+	err := row.Scan(&invoice.IndexID, &invoice.Number, &invoice.UserID, &invoice.Kind, &invoice.BillingName, &invoice.BillingEmail, &invoice.Country,
+		&invoice.VATRate, &invoice.Gross, &invoice.Net, &invoice.VAT, &invoice.Cancels, &invoice.CreatedAt)
+	return invoice, err
+}
+
+// GetInvoiceByIndexID returns the invoice with its lines
+func (dbc DBConnector) GetInvoiceByIndexID(indexID int) (Invoice, error) {
+	invoice, err := scanInvoice(dbc.DB.QueryRow(context.Background(), "SELECT "+invoiceColumns+" FROM invoices WHERE id = $1", indexID))
+	if err != nil {
+		return Invoice{}, err
+	}
+
+	rows, err := dbc.DB.Query(context.Background(), "SELECT description, quantity, amount, discount FROM invoice_items WHERE invoice_id = $1 ORDER BY id", indexID)
+	if err != nil {
+		return Invoice{}, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var item InvoiceItem
+		err := rows.Scan(&item.Description, &item.Quantity, &item.Amount, &item.Discount)
+		if err != nil {
+			return Invoice{}, err
+		}
+		invoice.Items = append(invoice.Items, item)
+	}
+
+	return invoice, rows.Err()
+}
+
+// GetInvoicesByUserID returns the invoices of a user without their lines, newest first
+func (dbc DBConnector) GetInvoicesByUserID(userID int) ([]Invoice, error) {
+	rows, err := dbc.DB.Query(context.Background(), "SELECT "+invoiceColumns+" FROM invoices WHERE user_id = $1 ORDER BY id DESC", userID)
+	if err != nil {
+		return []Invoice{}, err
+	}
+	defer rows.Close()
+
+	var invoices []Invoice
+	for rows.Next() {
+		invoice, err := scanInvoice(rows)
+		if err != nil {
+			return []Invoice{}, err
+		}
+		invoices = append(invoices, invoice)
+	}
+
+	return invoices, rows.Err()
+}
+
+// GetVATRates returns the configured rates ordered by country
+func (dbc DBConnector) GetVATRates() ([]VATRate, error) {
+	rows, err := dbc.DB.Query(context.Background(), "SELECT country, rate, is_default FROM vat_rates ORDER BY country")
+	if err != nil {
+		return []VATRate{}, err
+	}
+	defer rows.Close()
+
+	var rates []VATRate
+	for rows.Next() {
+		var rate VATRate
+		err := rows.Scan(&rate.Country, &rate.Rate, &rate.Default)
+		if err != nil {
+			return []VATRate{}, err
+		}
+		rates = append(rates, rate)
+	}
+
+	return rates, rows.Err()
+}
+
+// SetVATRate adds or changes the rate of a country, making it the default replaces the previous default
+// Invoices that were already issued keep their rate
+func (dbc DBConnector) SetVATRate(rate VATRate) error {
+	tx, err := dbc.DB.Begin(context.Background())
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.Background())
+
+	if rate.Default {
+		_, err = tx.Exec(context.Background(), "UPDATE vat_rates SET is_default = false WHERE is_default AND country <> $1", rate.Country)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(context.Background(), "INSERT INTO vat_rates (country, rate, is_default) VALUES ($1, $2, $3) "+
+		"ON CONFLICT (country) DO UPDATE SET rate = EXCLUDED.rate, is_default = EXCLUDED.is_default", rate.Country, rate.Rate, rate.Default)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(context.Background())
+}
+
+// DeleteVATRate removes the rate of a country, buyers from there are billed at the default rate afterwards
+// It returns pgx.ErrNoRows if the country has no rate
+func (dbc DBConnector) DeleteVATRate(country string) error {
+	result, err := dbc.DB.Exec(context.Background(), "DELETE FROM vat_rates WHERE country = $1", country)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
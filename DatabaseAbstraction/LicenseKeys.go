@@ -0,0 +1,246 @@
+package DatabaseAbstraction
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"github.com/jackc/pgx/v5"
+	"strings"
+	"time"
+)
+
+const (
+	LicenseKeyUnused   = "unused"
+	LicenseKeyRedeemed = "redeemed"
+	LicenseKeyRevoked  = "revoked"
+)
+
+var ErrLicenseKeyUnavailable = errors.New("the license key has already been redeemed or was revoked")
+
+// license keys are typed by hand, the alphabet has no 0, 1, 8 or 9 that could be mistaken for letters
+var licenseKeyEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// LicenseKey grants a product to whoever redeems it, keys are bought by users to give away or generated by admins
+type LicenseKey struct {
+	IndexID     int
+	Code        string
+	ProductID   int
+	ProductName string
+	CreatedBy   int    // 0 if the buyer was deleted
+	Label       string // groups a batch, e.g. the school class it was bought for
+	Price       int    // paid per key, 0 for keys generated by admins
+	Status      string
+	RedeemedBy  int
+	CreatedAt   time.Time
+	RedeemedAt  *time.Time
+	RevokedAt   *time.Time
+}
+
+// LicenseKeyBatch describes keys to create, a Price above 0 is debited from the creator for every key
+type LicenseKeyBatch struct {
+	ProductID int
+	CreatedBy int
+	Quantity  int
+	Label     string
+	Price     int
+	Reason    string // shown in the wallet history if the keys are paid
+}
+
+// LicenseKeyFilter selects keys for the admin list, zero values match all keys
+type LicenseKeyFilter struct {
+	ProductID int
+	CreatedBy int
+	Status    string
+	Label     string
+}
+
+// NormalizeLicenseKey makes typed keys comparable, case, spaces and dashes don't matter
+func NormalizeLicenseKey(code string) string {
+	normalized := strings.ToUpper(strings.TrimSpace(code))
+	normalized = strings.ReplaceAll(normalized, "-", "")
+	normalized = strings.ReplaceAll(normalized, " ", "")
+	if len(normalized) != 20 {
+		return normalized
+	}
+	return normalized[:5] + "-" + normalized[5:10] + "-" + normalized[10:15] + "-" + normalized[15:]
+}
+
+// newLicenseKeyCode returns 100 random bits as XXXXX-XXXXX-XXXXX-XXXXX
+func newLicenseKeyCode() (string, error) {
+	randomBytes := make([]byte, 13)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+	return NormalizeLicenseKey(licenseKeyEncoding.EncodeToString(randomBytes)[:20]), nil
+}
+
+const licenseKeyColumns = "license_keys.id, license_keys.code, license_keys.product_id, products.name, COALESCE(license_keys.created_by, 0), " +
+	"license_keys.label, license_keys.price, license_keys.status, COALESCE(license_keys.redeemed_by, 0), license_keys.created_at, " +
+	"license_keys.redeemed_at, license_keys.revoked_at"
+
+func scanLicenseKey(row pgx.Row) (LicenseKey, error) {
+	var key LicenseKey
+	err := row.Scan(&key.IndexID, &key.Code, &key.ProductID, &key.ProductName, &key.CreatedBy, &key.Label, &key.Price, &key.Status,
+		&key.RedeemedBy, &key.CreatedAt, &key.RedeemedAt, &key.RevokedAt)
+	return key, err
+}
+
+func (dbc DBConnector) queryLicenseKeys(query string, args ...interface{}) ([]LicenseKey, error) {
+	rows, err := dbc.DB.Query(context.Background(), "SELECT "+licenseKeyColumns+" FROM license_keys JOIN products ON products.id = license_keys.product_id"+query, args...)
+	if err != nil {
+		return []LicenseKey{}, err
+	}
+	defer rows.Close()
+
+	var keys []LicenseKey
+	for rows.Next() {
+		key, err := scanLicenseKey(rows)
+		if err != nil {
+			return []LicenseKey{}, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+// CreateLicenseKeys generates the keys of the batch and debits their price with an invoice in one transaction
+// It fails on the balance check constraint if the creator can't afford them
+func (dbc DBConnector) CreateLicenseKeys(batch LicenseKeyBatch) ([]LicenseKey, error) {
+	codes := make([]string, batch.Quantity)
+	for i := range codes {
+		code, err := newLicenseKeyCode()
+		if err != nil {
+			return []LicenseKey{}, err
+		}
+		codes[i] = code
+	}
+
+	tx, err := dbc.DB.Begin(context.Background())
+	if err != nil {
+		return []LicenseKey{}, err
+	}
+	defer tx.Rollback(context.Background())
+
+	if batch.Price > 0 {
+		var productName string
+		err = tx.QueryRow(context.Background(), "SELECT name FROM products WHERE id = $1", batch.ProductID).Scan(&productName)
+		if err != nil {
+			return []LicenseKey{}, err
+		}
+
+		_, err = chargeUser(tx, batch.CreatedBy, batch.Reason, []InvoiceItem{{
+			Description: "License keys for " + productName,
+			Quantity:    batch.Quantity,
+			Amount:      batch.Price * batch.Quantity,
+		}})
+		if err != nil {
+			return []LicenseKey{}, err
+		}
+	}
+
+	rows, err := tx.Query(context.Background(), "INSERT INTO license_keys (code, product_id, created_by, label, price, status) "+
+		"SELECT code, $2, $3, $4, $5, $6 FROM unnest($1::varchar[]) AS codes (code) RETURNING id",
+		codes, batch.ProductID, batch.CreatedBy, batch.Label, batch.Price, LicenseKeyUnused)
+	if err != nil {
+		return []LicenseKey{}, err
+	}
+	var keyIDs []int
+	for rows.Next() {
+		var keyID int
+		err = rows.Scan(&keyID)
+		if err != nil {
+			rows.Close()
+			return []LicenseKey{}, err
+		}
+		keyIDs = append(keyIDs, keyID)
+	}
+	rows.Close()
+	if rows.Err() != nil {
+		return []LicenseKey{}, rows.Err()
+	}
+
+	err = tx.Commit(context.Background())
+	if err != nil {
+		return []LicenseKey{}, err
+	}
+
+	return dbc.queryLicenseKeys(" WHERE license_keys.id = ANY($1) ORDER BY license_keys.id", keyIDs)
+}
+
+func (dbc DBConnector) GetLicenseKeyByIndexID(indexID int) (LicenseKey, error) {
+	return scanLicenseKey(dbc.DB.QueryRow(context.Background(), "SELECT "+licenseKeyColumns+" FROM license_keys JOIN products ON products.id = license_keys.product_id WHERE license_keys.id = $1", indexID))
+}
+
+// GetLicenseKeyByCode finds a key however it was typed
+func (dbc DBConnector) GetLicenseKeyByCode(code string) (LicenseKey, error) {
+	return scanLicenseKey(dbc.DB.QueryRow(context.Background(), "SELECT "+licenseKeyColumns+" FROM license_keys JOIN products ON products.id = license_keys.product_id WHERE license_keys.code = $1", NormalizeLicenseKey(code)))
+}
+
+// GetLicenseKeys returns a page of the keys matching the filter, newest first
+func (dbc DBConnector) GetLicenseKeys(filter LicenseKeyFilter, limit int, offset int) ([]LicenseKey, int, error) {
+	const condition = " WHERE ($1 = 0 OR license_keys.product_id = $1) AND ($2 = 0 OR license_keys.created_by = $2) AND ($3 = '' OR license_keys.status = $3) AND ($4 = '' OR license_keys.label = $4)"
+
+	var total int
+	err := dbc.DB.QueryRow(context.Background(), "SELECT COUNT(*) FROM license_keys"+condition, filter.ProductID, filter.CreatedBy, filter.Status, filter.Label).Scan(&total)
+	if err != nil {
+		return []LicenseKey{}, 0, err
+	}
+
+	keys, err := dbc.queryLicenseKeys(condition+" ORDER BY license_keys.id DESC LIMIT $5 OFFSET $6", filter.ProductID, filter.CreatedBy, filter.Status, filter.Label, limit, offset)
+	return keys, total, err
+}
+
+// RedeemLicenseKey marks the key as redeemed and grants its product to the user in one transaction
+// It fails with ErrLicenseKeyUnavailable if the key was redeemed or revoked in the meantime
+func (dbc DBConnector) RedeemLicenseKey(keyID int, userID int) error {
+	tx, err := dbc.DB.Begin(context.Background())
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.Background())
+
+	var productID int
+	err = tx.QueryRow(context.Background(), "UPDATE license_keys SET status = $1, redeemed_by = $2, redeemed_at = now() WHERE id = $3 AND status = $4 RETURNING product_id",
+		LicenseKeyRedeemed, userID, keyID, LicenseKeyUnused).Scan(&productID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrLicenseKeyUnavailable
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(context.Background(), "INSERT INTO user_purchases (user_id, product_id, license_key_id) VALUES ($1, $2, $3)", userID, productID, keyID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(context.Background())
+}
+
+// RevokeLicenseKey invalidates the key, if it was redeemed the product is taken from the user as well
+// It fails with ErrLicenseKeyUnavailable if the key was revoked before
+func (dbc DBConnector) RevokeLicenseKey(keyID int) error {
+	tx, err := dbc.DB.Begin(context.Background())
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.Background())
+
+	result, err := tx.Exec(context.Background(), "UPDATE license_keys SET status = $1, revoked_at = now() WHERE id = $2 AND status <> $1", LicenseKeyRevoked, keyID)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrLicenseKeyUnavailable
+	}
+
+	_, err = tx.Exec(context.Background(), "DELETE FROM user_purchases WHERE license_key_id = $1", keyID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(context.Background())
+}
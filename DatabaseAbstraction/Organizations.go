@@ -0,0 +1,417 @@
+package DatabaseAbstraction
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"time"
+)
+
+const (
+	OrganizationRoleAdmin  = "admin"  // buys and assigns seats, invites and removes members
+	OrganizationRoleMember = "member" // uses the seats assigned to them
+)
+
+var (
+	ErrAlreadyMember     = errors.New("the user is already a member of the organization")
+	ErrInviteUnavailable = errors.New("the invite was already accepted")
+	ErrNoSeatsLeft       = errors.New("all seats for the product are assigned")
+	ErrSeatAssigned      = errors.New("the member already has a seat for the product")
+)
+
+// Organization is a school or company, Role is the role of the user it was looked up for
+type Organization struct {
+	IndexID   int
+	Name      string
+	Role      string
+	CreatedAt time.Time
+}
+
+type OrganizationMember struct {
+	OrganizationID int
+	UserID         int
+	Username       string
+	Email          string
+	Role           string
+	JoinedAt       time.Time
+}
+
+// OrganizationInvite lets a user join an organization, an invite without email is a join code that can be used by many
+type OrganizationInvite struct {
+	IndexID          int
+	OrganizationID   int
+	OrganizationName string
+	Code             string
+	Email            string
+	CreatedBy        int
+	CreatedAt        time.Time
+	AcceptedBy       int
+	AcceptedAt       *time.Time
+}
+
+// OrganizationSeats are the seats of an organization for one product
+type OrganizationSeats struct {
+	ProductID   int
+	ProductName string
+	Seats       int
+	Assigned    int
+}
+
+// SeatPurchase buys seats for an organization, Price is paid per seat by the buyer
+type SeatPurchase struct {
+	OrganizationID int
+	ProductID      int
+	BuyerID        int
+	Seats          int
+	Price          int
+	Reason         string
+}
+
+// SeatProgress is the progress of a member in a product they have a seat for
+type SeatProgress struct {
+	UserID        int
+	Username      string
+	ProductID     int
+	ProductName   string
+	WatchedVideos int
+	TotalVideos   int
+	AssignedAt    time.Time
+}
+
+// CreateOrganization creates the organization with the user as its first admin
+func (dbc DBConnector) CreateOrganization(name string, adminID int) (int, error) {
+	tx, err := dbc.DB.Begin(context.Background())
+	if err != nil {
+		return -1, err
+	}
+	defer tx.Rollback(context.Background())
+
+	var indexID int
+	err = tx.QueryRow(context.Background(), "INSERT INTO organizations (name) VALUES ($1) RETURNING id", name).Scan(&indexID)
+	if err != nil {
+		return -1, err
+	}
+
+	_, err = tx.Exec(context.Background(), "INSERT INTO organization_members (organization_id, user_id, role) VALUES ($1, $2, $3)", indexID, adminID, OrganizationRoleAdmin)
+	if err != nil {
+		return -1, err
+	}
+
+	return indexID, tx.Commit(context.Background())
+}
+
+func (dbc DBConnector) GetOrganizationByIndexID(indexID int) (Organization, error) {
+	var organization Organization
+	err := dbc.DB.QueryRow(context.Background(), "SELECT id, name, created_at FROM organizations WHERE id = $1", indexID).
+		Scan(&organization.IndexID, &organization.Name, &organization.CreatedAt)
+	return organization, err
+}
+
+// GetOrganizationsByUserID returns the organizations the user is a member of with their role
+func (dbc DBConnector) GetOrganizationsByUserID(userID int) ([]Organization, error) {
+	rows, err := dbc.DB.Query(context.Background(), "SELECT organizations.id, organizations.name, organization_members.role, organizations.created_at "+
+		"FROM organization_members JOIN organizations ON organizations.id = organization_members.organization_id "+
+		"WHERE organization_members.user_id = $1 ORDER BY organizations.name", userID)
+	if err != nil {
+		return []Organization{}, err
+	}
+	defer rows.Close()
+
+	var organizations []Organization
+	for rows.Next() {
+		var organization Organization
+		err := rows.Scan(&organization.IndexID, &organization.Name, &organization.Role, &organization.CreatedAt)
+		if err != nil {
+			return []Organization{}, err
+		}
+		organizations = append(organizations, organization)
+	}
+
+	return organizations, rows.Err()
+}
+
+const organizationMemberColumns = "organization_members.organization_id, organization_members.user_id, users.username, COALESCE(users.email, ''), " +
+	"organization_members.role, organization_members.joined_at"
+
+func scanOrganizationMember(row pgx.Row) (OrganizationMember, error) {
+	var member OrganizationMember
+	err := row.Scan(&member.OrganizationID, &member.UserID, &member.Username, &member.Email, &member.Role, &member.JoinedAt)
+	return member, err
+}
+
+// GetOrganizationMember returns pgx.ErrNoRows if the user is not a member of the organization
+func (dbc DBConnector) GetOrganizationMember(organizationID int, userID int) (OrganizationMember, error) {
+	return scanOrganizationMember(dbc.DB.QueryRow(context.Background(), "SELECT "+organizationMemberColumns+" FROM organization_members "+
+		"JOIN users ON users.id = organization_members.user_id WHERE organization_members.organization_id = $1 AND organization_members.user_id = $2",
+		organizationID, userID))
+}
+
+func (dbc DBConnector) GetOrganizationMembers(organizationID int) ([]OrganizationMember, error) {
+	rows, err := dbc.DB.Query(context.Background(), "SELECT "+organizationMemberColumns+" FROM organization_members "+
+		"JOIN users ON users.id = organization_members.user_id WHERE organization_members.organization_id = $1 ORDER BY users.username", organizationID)
+	if err != nil {
+		return []OrganizationMember{}, err
+	}
+	defer rows.Close()
+
+	var members []OrganizationMember
+	for rows.Next() {
+		member, err := scanOrganizationMember(rows)
+		if err != nil {
+			return []OrganizationMember{}, err
+		}
+		members = append(members, member)
+	}
+
+	return members, rows.Err()
+}
+
+// RemoveOrganizationMember removes the user from the organization, their seats are reclaimed with them
+func (dbc DBConnector) RemoveOrganizationMember(organizationID int, userID int) error {
+	result, err := dbc.DB.Exec(context.Background(), "DELETE FROM organization_members WHERE organization_id = $1 AND user_id = $2", organizationID, userID)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// NewInviteCode returns a random code in the format of license keys
+func NewInviteCode() (string, error) {
+	return newLicenseKeyCode()
+}
+
+// AddOrganizationInvite stores the invite and returns its ID
+func (dbc DBConnector) AddOrganizationInvite(invite OrganizationInvite) (int, error) {
+	var indexID int
+	err := dbc.DB.QueryRow(context.Background(), "INSERT INTO organization_invites (organization_id, code, email, created_by) VALUES ($1, $2, NULLIF($3, ''), $4) RETURNING id",
+		invite.OrganizationID, invite.Code, invite.Email, invite.CreatedBy).Scan(&indexID)
+	if err != nil {
+		return -1, err
+	}
+
+	return indexID, nil
+}
+
+const organizationInviteColumns = "organization_invites.id, organization_invites.organization_id, organizations.name, organization_invites.code, " +
+	"COALESCE(organization_invites.email, ''), COALESCE(organization_invites.created_by, 0), organization_invites.created_at, " +
+	"COALESCE(organization_invites.accepted_by, 0), organization_invites.accepted_at"
+
+func scanOrganizationInvite(row pgx.Row) (OrganizationInvite, error) {
+	var invite OrganizationInvite
+	err := row.Scan(&invite.IndexID, &invite.OrganizationID, &invite.OrganizationName, &invite.Code, &invite.Email, &invite.CreatedBy, &invite.CreatedAt,
+		&invite.AcceptedBy, &invite.AcceptedAt)
+	return invite, err
+}
+
+// GetOrganizationInviteByCode looks the invite up by its normalized code
+func (dbc DBConnector) GetOrganizationInviteByCode(code string) (OrganizationInvite, error) {
+	return scanOrganizationInvite(dbc.DB.QueryRow(context.Background(), "SELECT "+organizationInviteColumns+" FROM organization_invites "+
+		"JOIN organizations ON organizations.id = organization_invites.organization_id WHERE organization_invites.code = $1", NormalizeLicenseKey(code)))
+}
+
+// GetOrganizationInvites returns the invites of the organization, newest first
+func (dbc DBConnector) GetOrganizationInvites(organizationID int) ([]OrganizationInvite, error) {
+	rows, err := dbc.DB.Query(context.Background(), "SELECT "+organizationInviteColumns+" FROM organization_invites "+
+		"JOIN organizations ON organizations.id = organization_invites.organization_id WHERE organization_invites.organization_id = $1 "+
+		"ORDER BY organization_invites.id DESC", organizationID)
+	if err != nil {
+		return []OrganizationInvite{}, err
+	}
+	defer rows.Close()
+
+	var invites []OrganizationInvite
+	for rows.Next() {
+		invite, err := scanOrganizationInvite(rows)
+		if err != nil {
+			return []OrganizationInvite{}, err
+		}
+		invites = append(invites, invite)
+	}
+
+	return invites, rows.Err()
+}
+
+// DeleteOrganizationInvite makes the code unusable, members who joined with it stay
+func (dbc DBConnector) DeleteOrganizationInvite(organizationID int, inviteID int) error {
+	result, err := dbc.DB.Exec(context.Background(), "DELETE FROM organization_invites WHERE organization_id = $1 AND id = $2", organizationID, inviteID)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// AcceptOrganizationInvite adds the user to the organization as member
+// An invite with email can only be accepted once, a second attempt fails with ErrInviteUnavailable
+func (dbc DBConnector) AcceptOrganizationInvite(invite OrganizationInvite, userID int) error {
+	tx, err := dbc.DB.Begin(context.Background())
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.Background())
+
+	if invite.Email != "" {
+		result, err := tx.Exec(context.Background(), "UPDATE organization_invites SET accepted_by = $1, accepted_at = now() WHERE id = $2 AND accepted_at IS NULL",
+			userID, invite.IndexID)
+		if err != nil {
+			return err
+		}
+		if result.RowsAffected() == 0 {
+			return ErrInviteUnavailable
+		}
+	}
+
+	result, err := tx.Exec(context.Background(), "INSERT INTO organization_members (organization_id, user_id, role) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING",
+		invite.OrganizationID, userID, OrganizationRoleMember)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrAlreadyMember
+	}
+
+	return tx.Commit(context.Background())
+}
+
+// AddOrganizationSeats debits the buyer with an invoice and adds the seats to the ones the organization has for the product
+func (dbc DBConnector) AddOrganizationSeats(purchase SeatPurchase) error {
+	tx, err := dbc.DB.Begin(context.Background())
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.Background())
+
+	if purchase.Price > 0 {
+		var productName, organizationName string
+		err = tx.QueryRow(context.Background(), "SELECT products.name, organizations.name FROM products, organizations WHERE products.id = $1 AND organizations.id = $2",
+			purchase.ProductID, purchase.OrganizationID).Scan(&productName, &organizationName)
+		if err != nil {
+			return err
+		}
+
+		_, err = chargeUser(tx, purchase.BuyerID, purchase.Reason, []InvoiceItem{{
+			Description: fmt.Sprintf("Seats for %s (%s)", productName, organizationName),
+			Quantity:    purchase.Seats,
+			Amount:      purchase.Price * purchase.Seats,
+		}})
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(context.Background(), "INSERT INTO organization_seats (organization_id, product_id, seats) VALUES ($1, $2, $3) "+
+		"ON CONFLICT (organization_id, product_id) DO UPDATE SET seats = organization_seats.seats + EXCLUDED.seats",
+		purchase.OrganizationID, purchase.ProductID, purchase.Seats)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(context.Background())
+}
+
+// GetOrganizationSeats returns the seats of the organization per product with the number of assigned ones
+func (dbc DBConnector) GetOrganizationSeats(organizationID int) ([]OrganizationSeats, error) {
+	rows, err := dbc.DB.Query(context.Background(), "SELECT products.id, products.name, organization_seats.seats, "+
+		"(SELECT COUNT(*) FROM seat_assignments WHERE seat_assignments.organization_id = organization_seats.organization_id AND seat_assignments.product_id = products.id) "+
+		"FROM organization_seats JOIN products ON products.id = organization_seats.product_id WHERE organization_seats.organization_id = $1 ORDER BY products.name",
+		organizationID)
+	if err != nil {
+		return []OrganizationSeats{}, err
+	}
+	defer rows.Close()
+
+	var seats []OrganizationSeats
+	for rows.Next() {
+		var productSeats OrganizationSeats
+		err := rows.Scan(&productSeats.ProductID, &productSeats.ProductName, &productSeats.Seats, &productSeats.Assigned)
+		if err != nil {
+			return []OrganizationSeats{}, err
+		}
+		seats = append(seats, productSeats)
+	}
+
+	return seats, rows.Err()
+}
+
+// AssignSeat gives a member one of the organization's seats for the product
+// It returns pgx.ErrNoRows if the organization has no seats for the product and ErrNoSeatsLeft if all are taken
+func (dbc DBConnector) AssignSeat(organizationID int, productID int, userID int) error {
+	tx, err := dbc.DB.Begin(context.Background())
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.Background())
+
+	// locking the seats keeps two admins from assigning the last seat twice
+	var seats, assigned int
+	err = tx.QueryRow(context.Background(), "SELECT seats FROM organization_seats WHERE organization_id = $1 AND product_id = $2 FOR UPDATE",
+		organizationID, productID).Scan(&seats)
+	if err != nil {
+		return err
+	}
+	err = tx.QueryRow(context.Background(), "SELECT COUNT(*) FROM seat_assignments WHERE organization_id = $1 AND product_id = $2",
+		organizationID, productID).Scan(&assigned)
+	if err != nil {
+		return err
+	}
+	if assigned >= seats {
+		return ErrNoSeatsLeft
+	}
+
+	result, err := tx.Exec(context.Background(), "INSERT INTO seat_assignments (organization_id, product_id, user_id) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING",
+		organizationID, productID, userID)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrSeatAssigned
+	}
+
+	return tx.Commit(context.Background())
+}
+
+// ReclaimSeat frees the seat of the member for the product so it can be assigned to somebody else
+func (dbc DBConnector) ReclaimSeat(organizationID int, productID int, userID int) error {
+	result, err := dbc.DB.Exec(context.Background(), "DELETE FROM seat_assignments WHERE organization_id = $1 AND product_id = $2 AND user_id = $3",
+		organizationID, productID, userID)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// GetOrganizationProgress returns the progress of every assigned seat, ordered by member
+func (dbc DBConnector) GetOrganizationProgress(organizationID int) ([]SeatProgress, error) {
+	rows, err := dbc.DB.Query(context.Background(), "SELECT users.id, users.username, products.id, products.name, "+
+		"(SELECT COUNT(DISTINCT user_watched_videos.video_id) FROM user_watched_videos JOIN video ON video.id = user_watched_videos.video_id "+
+		"WHERE user_watched_videos.user_id = users.id AND video.parent_product_id = products.id), "+
+		"(SELECT COUNT(*) FROM video WHERE video.parent_product_id = products.id), seat_assignments.assigned_at "+
+		"FROM seat_assignments JOIN users ON users.id = seat_assignments.user_id JOIN products ON products.id = seat_assignments.product_id "+
+		"WHERE seat_assignments.organization_id = $1 ORDER BY users.username, products.name", organizationID)
+	if err != nil {
+		return []SeatProgress{}, err
+	}
+	defer rows.Close()
+
+	var progress []SeatProgress
+	for rows.Next() {
+		var seatProgress SeatProgress
+		err := rows.Scan(&seatProgress.UserID, &seatProgress.Username, &seatProgress.ProductID, &seatProgress.ProductName,
+			&seatProgress.WatchedVideos, &seatProgress.TotalVideos, &seatProgress.AssignedAt)
+		if err != nil {
+			return []SeatProgress{}, err
+		}
+		progress = append(progress, seatProgress)
+	}
+
+	return progress, rows.Err()
+}
@@ -0,0 +1,60 @@
+package DatabaseAbstraction
+
+import (
+	"context"
+	"time"
+)
+
+type PasswordResetToken struct {
+	IndexID   int
+	UserID    int
+	TokenHash string
+	Expiry    time.Time
+}
+
+// AddPasswordResetToken stores the hash of a reset token, the plaintext token is only ever sent to the user
+func (dbc DBConnector) AddPasswordResetToken(userID int, tokenHash string, expiry time.Time) error {
+	_, err := dbc.DB.Exec(context.Background(), "INSERT INTO password_reset_tokens (user_id, token_hash, expiry) VALUES ($1, $2, $3)", userID, tokenHash, expiry)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetPasswordResetToken returns an unused, unexpired reset token without redeeming it
+func (dbc DBConnector) GetPasswordResetToken(tokenHash string) (PasswordResetToken, error) {
+	row := dbc.DB.QueryRow(context.Background(), "SELECT id, user_id, token_hash, expiry FROM password_reset_tokens WHERE token_hash = $1 AND used_at IS NULL AND expiry > now()", tokenHash)
+
+	var resetToken PasswordResetToken
+	err := row.Scan(&resetToken.IndexID, &resetToken.UserID, &resetToken.TokenHash, &resetToken.Expiry)
+	if err != nil {
+		return PasswordResetToken{}, err
+	}
+
+	return resetToken, nil
+}
+
+// ConsumePasswordResetToken marks an unused, unexpired reset token as used and returns it
+// Doing both in one statement guarantees that a token can only be redeemed once, even with concurrent requests
+func (dbc DBConnector) ConsumePasswordResetToken(tokenHash string) (PasswordResetToken, error) {
+	row := dbc.DB.QueryRow(context.Background(), "UPDATE password_reset_tokens SET used_at = now() WHERE token_hash = $1 AND used_at IS NULL AND expiry > now() RETURNING id, user_id, token_hash, expiry", tokenHash)
+
+	var resetToken PasswordResetToken
+	err := row.Scan(&resetToken.IndexID, &resetToken.UserID, &resetToken.TokenHash, &resetToken.Expiry)
+	if err != nil {
+		return PasswordResetToken{}, err
+	}
+
+	return resetToken, nil
+}
+
+// DeletePasswordResetTokensByUserID invalidates all outstanding reset tokens of a user
+func (dbc DBConnector) DeletePasswordResetTokensByUserID(userID int) error {
+	_, err := dbc.DB.Exec(context.Background(), "DELETE FROM password_reset_tokens WHERE user_id = $1", userID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -2,6 +2,8 @@ package DatabaseAbstraction
 
 import (
 	"context"
+	"fmt"
+	"strings"
 	"time"
 )
 
@@ -84,3 +86,228 @@ func (dbc DBConnector) GetProductVideos(indexID int) ([]Video, error) {
 
 	return videos, nil
 }
+
+// Sort orders of the catalog, every order ends with the product ID so pages never overlap
+const (
+	ProductSortNewest    = "newest"
+	ProductSortPriceAsc  = "price_asc"
+	ProductSortPriceDesc = "price_desc"
+	ProductSortPopular   = "popular"
+)
+
+// ProductQuery selects a page of the catalog, zero values don't filter
+type ProductQuery struct {
+	Difficulties []int
+	MinPrice     *int
+	MaxPrice     *int
+	Tags         []string // products need all of them
+	CategoryID   int      // includes the subcategories, 0 for all categories
+	UserID       int      // whose ownership Owned refers to
+	Owned        *bool    // bought for good, rentals don't count just like in Entitlements.Manager.Owns
+	Sort         string   // one of the ProductSort constants, newest if empty
+	After        *ProductCursor
+	Limit        int
+}
+
+// ProductCursor is the position of the last product of the previous page, only the field of the sort order is used
+type ProductCursor struct {
+	ID        int
+	CreatedAt time.Time
+	Price     int
+	Purchases int
+}
+
+// CatalogProduct is a product with the aggregates the catalog sorts and filters by
+type CatalogProduct struct {
+	Product
+	CategoryID int // 0 for uncategorized products
+	Tags       []string
+	Purchases  int
+}
+
+// Cursor returns the position to continue after this product
+func (product CatalogProduct) Cursor() ProductCursor {
+	return ProductCursor{product.IndexID, product.CreatedAt, product.Price, product.Purchases}
+}
+
+// productSortColumns maps the sort orders to their column and direction
+var productSortColumns = map[string]struct {
+	column     string
+	descending bool
+}{
+	ProductSortNewest:    {"created_at", true},
+	ProductSortPriceAsc:  {"price", false},
+	ProductSortPriceDesc: {"price", true},
+	ProductSortPopular:   {"purchases", true},
+}
+
+// GetCatalog returns a page of products with filtering and sorting done in the query
+func (dbc DBConnector) GetCatalog(query ProductQuery) ([]CatalogProduct, error) {
+	sort, found := productSortColumns[query.Sort]
+	if !found {
+		sort = productSortColumns[ProductSortNewest]
+	}
+
+	var conditions []string
+	var args []interface{}
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if len(query.Difficulties) > 0 {
+		conditions = append(conditions, "difficulty = ANY("+arg(query.Difficulties)+")")
+	}
+	if query.MinPrice != nil {
+		conditions = append(conditions, "price >= "+arg(*query.MinPrice))
+	}
+	if query.MaxPrice != nil {
+		conditions = append(conditions, "price <= "+arg(*query.MaxPrice))
+	}
+	if len(query.Tags) > 0 {
+		conditions = append(conditions, "tags @> "+arg(query.Tags)+"::varchar[]")
+	}
+	if query.CategoryID != 0 {
+		conditions = append(conditions, "category_id IN (WITH RECURSIVE subtree AS (SELECT id FROM categories WHERE id = "+arg(query.CategoryID)+
+			" UNION ALL SELECT categories.id FROM categories JOIN subtree ON categories.parent_id = subtree.id) SELECT id FROM subtree)")
+	}
+	if query.Owned != nil {
+		owned := "EXISTS (SELECT 1 FROM user_purchases WHERE user_purchases.product_id = catalog.id AND user_purchases.user_id = " + arg(query.UserID) +
+			" AND user_purchases.expires_at IS NULL)"
+		if !*query.Owned {
+			owned = "NOT " + owned
+		}
+		conditions = append(conditions, owned)
+	}
+	if query.After != nil {
+		var value interface{}
+		switch sort.column {
+		case "created_at":
+			value = query.After.CreatedAt
+		case "price":
+			value = query.After.Price
+		case "purchases":
+			value = query.After.Purchases
+		}
+		comparison := ">"
+		if sort.descending {
+			comparison = "<"
+		}
+		conditions = append(conditions, fmt.Sprintf("(%s, id) %s (%s, %s)", sort.column, comparison, arg(value), arg(query.After.ID)))
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = " WHERE " + strings.Join(conditions, " AND ")
+	}
+	direction := "ASC"
+	if sort.descending {
+		direction = "DESC"
+	}
+
+	rows, err := dbc.DB.Query(context.Background(), "WITH catalog AS ("+
+		"SELECT id, name, description, price, image, created_at, updated_at, difficulty, preview_url, COALESCE(category_id, 0) AS category_id, "+
+		"COALESCE((SELECT array_agg(tag ORDER BY tag) FROM product_tags WHERE product_id = products.id), '{}') AS tags, "+
+		"(SELECT COUNT(*) FROM user_purchases WHERE product_id = products.id) AS purchases FROM products) "+
+		"SELECT id, name, description, price, image, created_at, updated_at, difficulty, preview_url, category_id, tags, purchases FROM catalog"+where+
+		fmt.Sprintf(" ORDER BY %s %s, id %s LIMIT %s", sort.column, direction, direction, arg(query.Limit)), args...)
+	if err != nil {
+		return []CatalogProduct{}, err
+	}
+	defer rows.Close()
+
+	var products []CatalogProduct
+	for rows.Next() {
+		var product CatalogProduct
+		err := rows.Scan(&product.IndexID, &product.Name, &product.Description, &product.Price, &product.Image, &product.CreatedAt, &product.UpdatedAt,
+			&product.Difficulty, &product.PreviewURL, &product.CategoryID, &product.Tags, &product.Purchases)
+		if err != nil {
+			return []CatalogProduct{}, err
+		}
+		products = append(products, product)
+	}
+
+	return products, rows.Err()
+}
+
+// GetProductTags returns the tags of a product in alphabetical order
+func (dbc DBConnector) GetProductTags(productID int) ([]string, error) {
+	rows, err := dbc.DB.Query(context.Background(), "SELECT tag FROM product_tags WHERE product_id = $1 ORDER BY tag", productID)
+	if err != nil {
+		return []string{}, err
+	}
+	defer rows.Close()
+
+	tags := []string{}
+	for rows.Next() {
+		var tag string
+		err := rows.Scan(&tag)
+		if err != nil {
+			return []string{}, err
+		}
+		tags = append(tags, tag)
+	}
+
+	return tags, rows.Err()
+}
+
+// SetProductTags replaces the tags of a product
+func (dbc DBConnector) SetProductTags(productID int, tags []string) error {
+	tx, err := dbc.DB.Begin(context.Background())
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.Background())
+
+	_, err = tx.Exec(context.Background(), "DELETE FROM product_tags WHERE product_id = $1", productID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(context.Background(), "INSERT INTO product_tags (product_id, tag) SELECT $1, unnest($2::varchar[]) ON CONFLICT DO NOTHING", productID, tags)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(context.Background())
+}
+
+// RelatedProduct is a product recommended next to another one
+type RelatedProduct struct {
+	Product
+	SharedTags  int // tags both products have
+	CoPurchases int // users who bought both products
+}
+
+// GetRelatedProducts returns products sharing tags with or bought together with a product, most related first
+// Both signals are scaled to the product's own tag and buyer count, so a popular course doesn't drown out the tags
+func (dbc DBConnector) GetRelatedProducts(productID int, limit int) ([]RelatedProduct, error) {
+	rows, err := dbc.DB.Query(context.Background(), "WITH "+
+		"shared AS (SELECT other.product_id, COUNT(*) AS shared_tags FROM product_tags mine "+
+		"JOIN product_tags other ON other.tag = mine.tag AND other.product_id <> mine.product_id WHERE mine.product_id = $1 GROUP BY other.product_id), "+
+		"co AS (SELECT other.product_id, COUNT(DISTINCT other.user_id) AS co_purchases FROM user_purchases mine "+
+		"JOIN user_purchases other ON other.user_id = mine.user_id AND other.product_id <> mine.product_id WHERE mine.product_id = $1 GROUP BY other.product_id), "+
+		"own AS (SELECT (SELECT COUNT(*) FROM product_tags WHERE product_id = $1) AS tags, (SELECT COUNT(DISTINCT user_id) FROM user_purchases WHERE product_id = $1) AS buyers) "+
+		"SELECT products.id, products.name, products.description, products.price, products.image, products.created_at, products.updated_at, products.difficulty, products.preview_url, "+
+		"COALESCE(shared.shared_tags, 0), COALESCE(co.co_purchases, 0) "+
+		"FROM products LEFT JOIN shared ON shared.product_id = products.id LEFT JOIN co ON co.product_id = products.id, own "+
+		"WHERE shared.product_id IS NOT NULL OR co.product_id IS NOT NULL "+
+		"ORDER BY COALESCE(shared.shared_tags, 0)::float / GREATEST(own.tags, 1) + COALESCE(co.co_purchases, 0)::float / GREATEST(own.buyers, 1) DESC, products.id "+
+		"LIMIT $2", productID, limit)
+	if err != nil {
+		return []RelatedProduct{}, err
+	}
+	defer rows.Close()
+
+	var products []RelatedProduct
+	for rows.Next() {
+		var product RelatedProduct
+		err := rows.Scan(&product.IndexID, &product.Name, &product.Description, &product.Price, &product.Image, &product.CreatedAt, &product.UpdatedAt,
+			&product.Difficulty, &product.PreviewURL, &product.SharedTags, &product.CoPurchases)
+		if err != nil {
+			return []RelatedProduct{}, err
+		}
+		products = append(products, product)
+	}
+
+	return products, rows.Err()
+}
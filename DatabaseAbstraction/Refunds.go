@@ -0,0 +1,211 @@
+package DatabaseAbstraction
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"time"
+)
+
+const (
+	RefundPending  = "pending"
+	RefundApproved = "approved"
+	RefundRejected = "rejected"
+)
+
+var (
+	ErrRefundExists  = errors.New("a refund has already been requested for this purchase")
+	ErrRefundDecided = errors.New("the refund has already been decided")
+	// the user kept watching while the refund was pending, ApproveRefund rejects it instead
+	ErrRefundTooMuchWatched = errors.New("too much of the product has been watched since the refund was requested")
+)
+
+// Refund is a request to undo a purchase, the product and amount are copied because an approved refund deletes the purchase
+type Refund struct {
+	IndexID        int
+	UserID         int
+	PurchaseID     int // 0 once the refund is approved
+	ProductID      int
+	ProductName    string
+	Amount         int
+	Reason         string
+	WatchedPercent int // of the product's videos when the refund was requested
+	Status         string
+	DecidedBy      int
+	DecisionNote   string
+	CreatedAt      time.Time
+	DecidedAt      *time.Time
+}
+
+const refundColumns = "refund_requests.id, refund_requests.user_id, COALESCE(refund_requests.purchase_id, 0), refund_requests.product_id, products.name, " +
+	"refund_requests.amount, refund_requests.reason, refund_requests.watched_percent, refund_requests.status, COALESCE(refund_requests.decided_by, 0), " +
+	"refund_requests.decision_note, refund_requests.created_at, refund_requests.decided_at"
+
+func scanRefund(row pgx.Row) (Refund, error) {
+	var refund Refund
+	err := row.Scan(&refund.IndexID, &refund.UserID, &refund.PurchaseID, &refund.ProductID, &refund.ProductName, &refund.Amount, &refund.Reason,
+		&refund.WatchedPercent, &refund.Status, &refund.DecidedBy, &refund.DecisionNote, &refund.CreatedAt, &refund.DecidedAt)
+	return refund, err
+}
+
+func (dbc DBConnector) queryRefunds(query string, args ...interface{}) ([]Refund, error) {
+	rows, err := dbc.DB.Query(context.Background(), "SELECT "+refundColumns+" FROM refund_requests JOIN products ON products.id = refund_requests.product_id"+query, args...)
+	if err != nil {
+		return []Refund{}, err
+	}
+	defer rows.Close()
+
+	var refunds []Refund
+	for rows.Next() {
+		refund, err := scanRefund(rows)
+		if err != nil {
+			return []Refund{}, err
+		}
+		refunds = append(refunds, refund)
+	}
+
+	return refunds, rows.Err()
+}
+
+// AddRefundRequest stores a pending refund, it fails with ErrRefundExists if a refund was requested for the purchase before
+// A rejected refund can't be requested again
+func (dbc DBConnector) AddRefundRequest(refund Refund) (int, error) {
+	var indexID int
+	err := dbc.DB.QueryRow(context.Background(), "INSERT INTO refund_requests (user_id, purchase_id, product_id, amount, reason, watched_percent, status) "+
+		"SELECT $1, $2, $3, $4, $5, $6, $7 WHERE NOT EXISTS (SELECT 1 FROM refund_requests WHERE purchase_id = $2) RETURNING id",
+		refund.UserID, refund.PurchaseID, refund.ProductID, refund.Amount, refund.Reason, refund.WatchedPercent, RefundPending).Scan(&indexID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return -1, ErrRefundExists
+	}
+	if err != nil {
+		return -1, err
+	}
+
+	return indexID, nil
+}
+
+func (dbc DBConnector) GetRefundByIndexID(indexID int) (Refund, error) {
+	return scanRefund(dbc.DB.QueryRow(context.Background(), "SELECT "+refundColumns+" FROM refund_requests JOIN products ON products.id = refund_requests.product_id WHERE refund_requests.id = $1", indexID))
+}
+
+// GetRefundsByUserID returns the refunds of a user, newest first
+func (dbc DBConnector) GetRefundsByUserID(userID int) ([]Refund, error) {
+	return dbc.queryRefunds(" WHERE refund_requests.user_id = $1 ORDER BY refund_requests.id DESC", userID)
+}
+
+// GetRefunds returns a page of refunds with the given status, all refunds if it is empty, oldest first so pending ones are handled in order
+func (dbc DBConnector) GetRefunds(status string, limit int, offset int) ([]Refund, int, error) {
+	var total int
+	err := dbc.DB.QueryRow(context.Background(), "SELECT COUNT(*) FROM refund_requests WHERE $1 = '' OR status = $1", status).Scan(&total)
+	if err != nil {
+		return []Refund{}, 0, err
+	}
+
+	refunds, err := dbc.queryRefunds(" WHERE $1 = '' OR refund_requests.status = $1 ORDER BY refund_requests.id LIMIT $2 OFFSET $3", status, limit, offset)
+	return refunds, total, err
+}
+
+// ApproveRefund credits the amount to the user, gives back the coupon use, cancels the amount on a credit note and deletes the purchase,
+// which revokes the product, in one transaction
+// The user keeps the product while the refund is pending, so the share of watched videos is checked again: above maxWatchedPercent
+// the refund is rejected and ErrRefundTooMuchWatched returned. It fails with ErrRefundDecided if the refund isn't pending anymore
+func (dbc DBConnector) ApproveRefund(refundID int, adminID int, note string, maxWatchedPercent int) error {
+	tx, err := dbc.DB.Begin(context.Background())
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.Background())
+
+	var userID, purchaseID, productID, amount int
+	err = tx.QueryRow(context.Background(), "SELECT user_id, purchase_id, product_id, amount FROM refund_requests WHERE id = $1 AND status = $2 FOR UPDATE",
+		refundID, RefundPending).Scan(&userID, &purchaseID, &productID, &amount)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrRefundDecided
+	}
+	if err != nil {
+		return err
+	}
+
+	var watched int
+	err = tx.QueryRow(context.Background(), "SELECT COALESCE((SELECT COUNT(DISTINCT user_watched_videos.video_id) FROM user_watched_videos JOIN video ON video.id = user_watched_videos.video_id "+
+		"WHERE user_watched_videos.user_id = $1 AND video.parent_product_id = $2) * 100 / NULLIF((SELECT COUNT(*) FROM video WHERE video.parent_product_id = $2), 0), 0)",
+		userID, productID).Scan(&watched)
+	if err != nil {
+		return err
+	}
+
+	if watched > maxWatchedPercent {
+		_, err = tx.Exec(context.Background(), "UPDATE refund_requests SET status = $1, decided_by = $2, decision_note = $3, watched_percent = $4, decided_at = now() WHERE id = $5",
+			RefundRejected, adminID, fmt.Sprintf("%d %% of the product was watched while the refund was pending", watched), watched, refundID)
+		if err != nil {
+			return err
+		}
+		err = tx.Commit(context.Background())
+		if err != nil {
+			return err
+		}
+		return ErrRefundTooMuchWatched
+	}
+
+	_, err = tx.Exec(context.Background(), "UPDATE refund_requests SET status = $1, decided_by = $2, decision_note = $3, watched_percent = $4, decided_at = now() WHERE id = $5",
+		RefundApproved, adminID, note, watched, refundID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(context.Background(), "UPDATE users SET balance = balance + $1 WHERE id = $2", amount, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(context.Background(), "INSERT INTO balance_transactions (user_id, amount, reason) VALUES ($1, $2, $3)",
+		userID, amount, fmt.Sprintf("refund of purchase %d", purchaseID))
+	if err != nil {
+		return err
+	}
+
+	// a coupon is used once per order, the products of a bundle give the use back with the last of them
+	_, err = tx.Exec(context.Background(), "UPDATE coupons SET uses = uses - 1 FROM user_purchases "+
+		"WHERE user_purchases.id = $1 AND coupons.id = user_purchases.coupon_id AND coupons.uses > 0 "+
+		"AND (user_purchases.bundle_id IS NULL OR NOT EXISTS (SELECT 1 FROM user_purchases other WHERE other.user_id = user_purchases.user_id "+
+		"AND other.bundle_id = user_purchases.bundle_id AND other.coupon_id = user_purchases.coupon_id AND other.id <> user_purchases.id))", purchaseID)
+	if err != nil {
+		return err
+	}
+
+	// the refunded amount is cancelled by a credit note, purchases made before invoices were issued have nothing to cancel
+	var invoiceID int
+	var productName string
+	err = tx.QueryRow(context.Background(), "SELECT COALESCE(user_purchases.invoice_id, 0), products.name FROM user_purchases "+
+		"JOIN products ON products.id = user_purchases.product_id WHERE user_purchases.id = $1", purchaseID).Scan(&invoiceID, &productName)
+	if err != nil {
+		return err
+	}
+	if invoiceID != 0 {
+		_, err = issueCreditNote(tx, invoiceID, "Refund of "+productName, amount)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(context.Background(), "DELETE FROM user_purchases WHERE id = $1", purchaseID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(context.Background())
+}
+
+// RejectRefund keeps the purchase, it fails with ErrRefundDecided if the refund isn't pending anymore
+func (dbc DBConnector) RejectRefund(refundID int, adminID int, note string) error {
+	result, err := dbc.DB.Exec(context.Background(), "UPDATE refund_requests SET status = $1, decided_by = $2, decision_note = $3, decided_at = now() "+
+		"WHERE id = $4 AND status = $5", RefundRejected, adminID, note, refundID, RefundPending)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrRefundDecided
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package DatabaseAbstraction
+
+import (
+	"context"
+	"time"
+)
+
+// RevokedToken is an entry of the revocation list for stateless access tokens
+// With a TokenID it revokes a single token, without one it revokes every token of UserID issued before RevokedAt
+// Entries are only needed until the tokens they cover have expired
+type RevokedToken struct {
+	TokenID   string
+	UserID    int
+	RevokedAt time.Time
+	Expiry    time.Time
+}
+
+func (dbc DBConnector) AddRevokedToken(revoked RevokedToken) error {
+	_, err := dbc.DB.Exec(context.Background(), "INSERT INTO revoked_tokens (token_id, user_id, revoked_at, expiry) VALUES (NULLIF($1, ''), $2, $3, $4)",
+		revoked.TokenID, revoked.UserID, revoked.RevokedAt, revoked.Expiry)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetRevokedTokens returns all entries that still cover unexpired tokens
+func (dbc DBConnector) GetRevokedTokens() ([]RevokedToken, error) {
+	rows, err := dbc.DB.Query(context.Background(), "SELECT COALESCE(token_id, ''), user_id, revoked_at, expiry FROM revoked_tokens WHERE expiry > now()")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revokedTokens []RevokedToken
+	for rows.Next() {
+		var revoked RevokedToken
+		err = rows.Scan(&revoked.TokenID, &revoked.UserID, &revoked.RevokedAt, &revoked.Expiry)
+		if err != nil {
+			return nil, err
+		}
+		revokedTokens = append(revokedTokens, revoked)
+	}
+
+	return revokedTokens, rows.Err()
+}
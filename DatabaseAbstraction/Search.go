@@ -0,0 +1,54 @@
+package DatabaseAbstraction
+
+import (
+	"context"
+	"fmt"
+)
+
+// Snippets mark the matched words with these control characters, callers replace them after escaping the text
+const (
+	SearchMatchStart = "\x02"
+	SearchMatchEnd   = "\x03"
+)
+
+// SearchHit is a product or a video matching a search, VideoID is 0 for the product itself
+type SearchHit struct {
+	ProductID   int
+	ProductName string
+	VideoID     int
+	Name        string
+	Snippet     string // the best matching part of the description
+	Rank        float64
+}
+
+// Search finds products and videos by name and description, best matches first
+// Words are matched with German and English stemming, names also match with typos through trigram similarity
+func (dbc DBConnector) Search(query string, limit int) ([]SearchHit, error) {
+	headlineOptions := fmt.Sprintf("StartSel=%s, StopSel=%s, MaxWords=30, MinWords=10, MaxFragments=2, FragmentDelimiter=\" … \"", SearchMatchStart, SearchMatchEnd)
+
+	rows, err := dbc.DB.Query(context.Background(), "WITH q AS (SELECT websearch_to_tsquery('german', $1) || websearch_to_tsquery('english', $1) AS query) "+
+		"SELECT products.id, products.name, 0, products.name, ts_headline('german', products.description, q.query, $3), "+
+		"ts_rank(products.search_vector, q.query) + word_similarity($1, products.name) AS rank "+
+		"FROM products, q WHERE products.search_vector @@ q.query OR $1 <% products.name "+
+		"UNION ALL "+
+		"SELECT products.id, products.name, video.id, video.name, ts_headline('german', video.description, q.query, $3), "+
+		"ts_rank(video.search_vector, q.query) + word_similarity($1, video.name) AS rank "+
+		"FROM video JOIN products ON products.id = video.parent_product_id, q WHERE video.search_vector @@ q.query OR $1 <% video.name "+
+		"ORDER BY rank DESC LIMIT $2", query, limit, headlineOptions)
+	if err != nil {
+		return []SearchHit{}, err
+	}
+	defer rows.Close()
+
+	var hits []SearchHit
+	for rows.Next() {
+		var hit SearchHit
+		err := rows.Scan(&hit.ProductID, &hit.ProductName, &hit.VideoID, &hit.Name, &hit.Snippet, &hit.Rank)
+		if err != nil {
+			return []SearchHit{}, err
+		}
+		hits = append(hits, hit)
+	}
+
+	return hits, rows.Err()
+}
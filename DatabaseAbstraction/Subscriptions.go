@@ -0,0 +1,252 @@
+package DatabaseAbstraction
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"time"
+)
+
+const (
+	SubscriptionActive    = "active"    // renews at the end of the period
+	SubscriptionCancelled = "cancelled" // keeps access until the end of the period
+	SubscriptionExpired   = "expired"
+)
+
+var (
+	ErrSubscriptionExists  = errors.New("the user already has a subscription of this plan")
+	ErrSubscriptionChanged = errors.New("the subscription was changed in the meantime")
+)
+
+// SubscriptionPlan unlocks the whole catalog for a period, the first subscription of a plan can start with a free trial
+type SubscriptionPlan struct {
+	IndexID     int
+	Name        string
+	Description string
+	Price       int // per period
+	PeriodDays  int
+	TrialDays   int // 0 if the plan has no trial
+	Active      bool
+	CreatedAt   time.Time
+}
+
+type Subscription struct {
+	IndexID          int
+	UserID           int
+	PlanID           int
+	PlanName         string
+	Status           string
+	Trial            bool // the current period is the free trial
+	StartedAt        time.Time
+	CurrentPeriodEnd time.Time
+}
+
+const subscriptionPlanColumns = "id, name, description, price, period_days, trial_days, active, created_at"
+
+func scanSubscriptionPlan(row pgx.Row) (SubscriptionPlan, error) {
+	var plan SubscriptionPlan
+	err := row.Scan(&plan.IndexID, &plan.Name, &plan.Description, &plan.Price, &plan.PeriodDays, &plan.TrialDays, &plan.Active, &plan.CreatedAt)
+	return plan, err
+}
+
+// GetSubscriptionPlans returns the plans ordered by price, inactive ones only if includeInactive is set
+func (dbc DBConnector) GetSubscriptionPlans(includeInactive bool) ([]SubscriptionPlan, error) {
+	rows, err := dbc.DB.Query(context.Background(), "SELECT "+subscriptionPlanColumns+" FROM subscription_plans WHERE active OR $1 ORDER BY price, id", includeInactive)
+	if err != nil {
+		return []SubscriptionPlan{}, err
+	}
+	defer rows.Close()
+
+	var plans []SubscriptionPlan
+	for rows.Next() {
+		plan, err := scanSubscriptionPlan(rows)
+		if err != nil {
+			return []SubscriptionPlan{}, err
+		}
+		plans = append(plans, plan)
+	}
+
+	return plans, rows.Err()
+}
+
+func (dbc DBConnector) GetSubscriptionPlanByIndexID(indexID int) (SubscriptionPlan, error) {
+	return scanSubscriptionPlan(dbc.DB.QueryRow(context.Background(), "SELECT "+subscriptionPlanColumns+" FROM subscription_plans WHERE id = $1", indexID))
+}
+
+func (dbc DBConnector) AddSubscriptionPlan(plan SubscriptionPlan) (int, error) {
+	var indexID int
+	err := dbc.DB.QueryRow(context.Background(), "INSERT INTO subscription_plans (name, description, price, period_days, trial_days) VALUES ($1, $2, $3, $4, $5) RETURNING id",
+		plan.Name, plan.Description, plan.Price, plan.PeriodDays, plan.TrialDays).Scan(&indexID)
+	if err != nil {
+		return -1, err
+	}
+
+	return indexID, nil
+}
+
+// DeactivateSubscriptionPlan stops new subscriptions, running ones expire at the end of their period instead of renewing
+func (dbc DBConnector) DeactivateSubscriptionPlan(indexID int) error {
+	result, err := dbc.DB.Exec(context.Background(), "UPDATE subscription_plans SET active = false WHERE id = $1", indexID)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+const subscriptionColumns = "subscriptions.id, subscriptions.user_id, subscriptions.plan_id, subscription_plans.name, subscriptions.status, " +
+	"subscriptions.trial, subscriptions.started_at, subscriptions.current_period_end"
+
+func scanSubscription(row pgx.Row) (Subscription, error) {
+	var subscription Subscription
+	err := row.Scan(&subscription.IndexID, &subscription.UserID, &subscription.PlanID, &subscription.PlanName, &subscription.Status,
+		&subscription.Trial, &subscription.StartedAt, &subscription.CurrentPeriodEnd)
+	return subscription, err
+}
+
+func (dbc DBConnector) querySubscriptions(query string, args ...interface{}) ([]Subscription, error) {
+	rows, err := dbc.DB.Query(context.Background(), "SELECT "+subscriptionColumns+" FROM subscriptions JOIN subscription_plans ON subscription_plans.id = subscriptions.plan_id"+query, args...)
+	if err != nil {
+		return []Subscription{}, err
+	}
+	defer rows.Close()
+
+	var subscriptions []Subscription
+	for rows.Next() {
+		subscription, err := scanSubscription(rows)
+		if err != nil {
+			return []Subscription{}, err
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+
+	return subscriptions, rows.Err()
+}
+
+func (dbc DBConnector) GetSubscriptionByIndexID(indexID int) (Subscription, error) {
+	return scanSubscription(dbc.DB.QueryRow(context.Background(), "SELECT "+subscriptionColumns+" FROM subscriptions JOIN subscription_plans ON subscription_plans.id = subscriptions.plan_id WHERE subscriptions.id = $1", indexID))
+}
+
+// GetSubscriptionsByUserID returns all subscriptions of a user including expired ones, newest first
+func (dbc DBConnector) GetSubscriptionsByUserID(userID int) ([]Subscription, error) {
+	return dbc.querySubscriptions(" WHERE subscriptions.user_id = $1 ORDER BY subscriptions.id DESC", userID)
+}
+
+// GetLapsedSubscriptions returns the subscriptions whose period ended and that haven't been renewed or expired yet
+func (dbc DBConnector) GetLapsedSubscriptions() ([]Subscription, error) {
+	return dbc.querySubscriptions(" WHERE subscriptions.status IN ($1, $2) AND subscriptions.current_period_end <= now() ORDER BY subscriptions.current_period_end",
+		SubscriptionActive, SubscriptionCancelled)
+}
+
+// StartSubscription debits the price with an invoice and stores the subscription in one transaction, a trial passes a price of 0
+// It fails with ErrSubscriptionExists if the user has a subscription of the plan that hasn't expired
+// and on the balance check constraint if the user can't afford it
+func (dbc DBConnector) StartSubscription(subscription Subscription, price int, reason string) (int, error) {
+	tx, err := dbc.DB.Begin(context.Background())
+	if err != nil {
+		return -1, err
+	}
+	defer tx.Rollback(context.Background())
+
+	if price > 0 {
+		_, err = chargeSubscription(tx, subscription, price, reason)
+		if err != nil {
+			return -1, err
+		}
+	}
+
+	var indexID int
+	err = tx.QueryRow(context.Background(), "INSERT INTO subscriptions (user_id, plan_id, status, trial, current_period_end) SELECT $1, $2, $3, $4, $5 "+
+		"WHERE NOT EXISTS (SELECT 1 FROM subscriptions WHERE user_id = $1 AND plan_id = $2 AND status <> $6) RETURNING id",
+		subscription.UserID, subscription.PlanID, SubscriptionActive, subscription.Trial, subscription.CurrentPeriodEnd, SubscriptionExpired).Scan(&indexID)
+	// a concurrent trial takes no lock on the user, the unique index catches the second insert
+	var pgErr *pgconn.PgError
+	if errors.Is(err, pgx.ErrNoRows) || errors.As(err, &pgErr) && pgErr.ConstraintName == "idx_subscriptions_user_plan_unexpired" {
+		return -1, ErrSubscriptionExists
+	}
+	if err != nil {
+		return -1, err
+	}
+
+	return indexID, tx.Commit(context.Background())
+}
+
+// chargeSubscription debits a period of the subscription, the invoice names the plan and the end of the period
+func chargeSubscription(tx pgx.Tx, subscription Subscription, price int, reason string) (int, error) {
+	var planName string
+	err := tx.QueryRow(context.Background(), "SELECT name FROM subscription_plans WHERE id = $1", subscription.PlanID).Scan(&planName)
+	if err != nil {
+		return -1, err
+	}
+
+	return chargeUser(tx, subscription.UserID, reason, []InvoiceItem{{
+		Description: fmt.Sprintf("Subscription %s until %s", planName, subscription.CurrentPeriodEnd.Format("2006-01-02")),
+		Quantity:    1,
+		Amount:      price,
+	}})
+}
+
+// CountSubscriptions counts the subscriptions a user ever had of a plan, a trial is only granted for the first one
+func (dbc DBConnector) CountSubscriptions(userID int, planID int) (int, error) {
+	var count int
+	err := dbc.DB.QueryRow(context.Background(), "SELECT COUNT(*) FROM subscriptions WHERE user_id = $1 AND plan_id = $2", userID, planID).Scan(&count)
+	return count, err
+}
+
+// CancelSubscription stops the renewal, the user keeps access until the end of the period
+// It returns pgx.ErrNoRows if the subscription isn't active
+func (dbc DBConnector) CancelSubscription(indexID int) error {
+	result, err := dbc.DB.Exec(context.Background(), "UPDATE subscriptions SET status = $1 WHERE id = $2 AND status = $3", SubscriptionCancelled, indexID, SubscriptionActive)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// RenewSubscription debits the price with an invoice and starts the next period in one transaction
+// It fails with ErrSubscriptionChanged if the subscription was cancelled or renewed in the meantime
+// and on the balance check constraint if the user can't afford it
+func (dbc DBConnector) RenewSubscription(subscription Subscription, price int, periodEnd time.Time, reason string) error {
+	tx, err := dbc.DB.Begin(context.Background())
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.Background())
+
+	result, err := tx.Exec(context.Background(), "UPDATE subscriptions SET current_period_end = $1, trial = false WHERE id = $2 AND status = $3 AND current_period_end = $4",
+		periodEnd, subscription.IndexID, SubscriptionActive, subscription.CurrentPeriodEnd)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrSubscriptionChanged
+	}
+
+	subscription.CurrentPeriodEnd = periodEnd
+	_, err = chargeSubscription(tx, subscription, price, reason)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(context.Background())
+}
+
+// ExpireSubscription ends a lapsed subscription, it fails with ErrSubscriptionChanged if it was renewed in the meantime
+func (dbc DBConnector) ExpireSubscription(indexID int) error {
+	result, err := dbc.DB.Exec(context.Background(), "UPDATE subscriptions SET status = $1 WHERE id = $2 AND status IN ($3, $4) AND current_period_end <= now()",
+		SubscriptionExpired, indexID, SubscriptionActive, SubscriptionCancelled)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrSubscriptionChanged
+	}
+	return nil
+}
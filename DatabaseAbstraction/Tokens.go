@@ -6,18 +6,20 @@ import (
 )
 
 type Token struct {
-	IndexID int
-	UserID  int
-	Token   string
-	Expiry  time.Time
+	IndexID        int
+	UserID         int
+	Token          string
+	Expiry         time.Time
+	CreatedAt      time.Time
+	ImpersonatorID int // the admin who acts as the user, 0 for the user's own sessions
 }
 
 func (dbc DBConnector) GetTokenByTokenID(tokenID string) (Token, error) {
 	// Get the token from the database
-	row := dbc.DB.QueryRow(context.Background(), "SELECT id, user_id, token, expiry FROM user_tokens WHERE id = $1 AND expiry > now()", tokenID)
+	row := dbc.DB.QueryRow(context.Background(), "SELECT id, user_id, token, expiry, COALESCE(impersonator_id, 0) FROM user_tokens WHERE id = $1 AND expiry > now()", tokenID)
 
 	var userToken Token
-	err := row.Scan(&userToken.IndexID, &userToken.UserID, &userToken.Token, &userToken.Expiry)
+	err := row.Scan(&userToken.IndexID, &userToken.UserID, &userToken.Token, &userToken.Expiry, &userToken.ImpersonatorID)
 	if err != nil {
 		return Token{}, err
 	}
@@ -27,10 +29,10 @@ func (dbc DBConnector) GetTokenByTokenID(tokenID string) (Token, error) {
 
 func (dbc DBConnector) GetTokenByHash(token string) (Token, error) {
 	// Get the token from the database
-	row := dbc.DB.QueryRow(context.Background(), "SELECT id, user_id, token, expiry FROM user_tokens WHERE token = $1 AND expiry > now()", token)
+	row := dbc.DB.QueryRow(context.Background(), "SELECT id, user_id, token, expiry, COALESCE(impersonator_id, 0) FROM user_tokens WHERE token = $1 AND expiry > now()", token)
 
 	var userToken Token
-	err := row.Scan(&userToken.IndexID, &userToken.UserID, &userToken.Token, &userToken.Expiry)
+	err := row.Scan(&userToken.IndexID, &userToken.UserID, &userToken.Token, &userToken.Expiry, &userToken.ImpersonatorID)
 	if err != nil {
 		return Token{}, err
 	}
@@ -48,6 +50,16 @@ func (dbc DBConnector) AddToken(userID int, token string, expiry time.Time) erro
 	return nil
 }
 
+// AddImpersonationToken stores a session of impersonatorID acting as userID
+func (dbc DBConnector) AddImpersonationToken(userID int, impersonatorID int, token string, expiry time.Time) error {
+	_, err := dbc.DB.Exec(context.Background(), "INSERT INTO user_tokens (user_id, impersonator_id, token, expiry) VALUES ($1, $2, $3, $4)", userID, impersonatorID, token, expiry)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
 func (dbc DBConnector) DeleteToken(tokenID int) error {
 	// Delete the token from the database
 	_, err := dbc.DB.Exec(context.Background(), "DELETE FROM user_tokens WHERE id = $1", tokenID)
@@ -67,3 +79,34 @@ func (dbc DBConnector) DeleteTokenByHash(token string) error {
 
 	return nil
 }
+
+// DeleteTokensByUserID revokes every session of a user, e.g. after a password change
+func (dbc DBConnector) DeleteTokensByUserID(userID int) error {
+	_, err := dbc.DB.Exec(context.Background(), "DELETE FROM user_tokens WHERE user_id = $1", userID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetTokensByUserID returns the active sessions of a user
+func (dbc DBConnector) GetTokensByUserID(userID int) ([]Token, error) {
+	rows, err := dbc.DB.Query(context.Background(), "SELECT id, user_id, token, expiry, created_at, COALESCE(impersonator_id, 0) FROM user_tokens WHERE user_id = $1 AND expiry > now() ORDER BY id", userID)
+	if err != nil {
+		return []Token{}, err
+	}
+	defer rows.Close()
+
+	var tokens []Token
+	for rows.Next() {
+		var userToken Token
+		err := rows.Scan(&userToken.IndexID, &userToken.UserID, &userToken.Token, &userToken.Expiry, &userToken.CreatedAt, &userToken.ImpersonatorID)
+		if err != nil {
+			return []Token{}, err
+		}
+		tokens = append(tokens, userToken)
+	}
+
+	return tokens, rows.Err()
+}
@@ -0,0 +1,127 @@
+package DatabaseAbstraction
+
+import (
+	"context"
+	"github.com/jackc/pgx/v5"
+	"time"
+)
+
+// SetUserTOTPSecret stores a new secret during enrollment, two-factor authentication stays disabled until it is activated
+func (dbc DBConnector) SetUserTOTPSecret(userID int, secret string) error {
+	_, err := dbc.DB.Exec(context.Background(), "UPDATE users SET totp_secret = $1, totp_enabled = false, totp_last_step = 0 WHERE id = $2", secret, userID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (dbc DBConnector) EnableUserTOTP(userID int) error {
+	_, err := dbc.DB.Exec(context.Background(), "UPDATE users SET totp_enabled = true WHERE id = $1 AND totp_secret IS NOT NULL", userID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DisableUserTOTP removes the secret and all recovery codes of a user
+func (dbc DBConnector) DisableUserTOTP(userID int) error {
+	tx, err := dbc.DB.Begin(context.Background())
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.Background())
+
+	_, err = tx.Exec(context.Background(), "UPDATE users SET totp_secret = NULL, totp_enabled = false, totp_last_step = 0 WHERE id = $1", userID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(context.Background(), "DELETE FROM user_recovery_codes WHERE user_id = $1", userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(context.Background())
+}
+
+// AdvanceUserTOTPStep records the time step of an accepted code
+// It fails with pgx.ErrNoRows if the step was already used, so every code can only be used once
+func (dbc DBConnector) AdvanceUserTOTPStep(userID int, step int64) error {
+	result, err := dbc.DB.Exec(context.Background(), "UPDATE users SET totp_last_step = $1 WHERE id = $2 AND totp_last_step < $1", step, userID)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+
+	return nil
+}
+
+// ReplaceRecoveryCodes invalidates all previous recovery codes of a user and stores the new hashes
+func (dbc DBConnector) ReplaceRecoveryCodes(userID int, codeHashes []string) error {
+	tx, err := dbc.DB.Begin(context.Background())
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.Background())
+
+	_, err = tx.Exec(context.Background(), "DELETE FROM user_recovery_codes WHERE user_id = $1", userID)
+	if err != nil {
+		return err
+	}
+	for _, codeHash := range codeHashes {
+		_, err = tx.Exec(context.Background(), "INSERT INTO user_recovery_codes (user_id, code_hash) VALUES ($1, $2)", userID, codeHash)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(context.Background())
+}
+
+// ConsumeRecoveryCode marks an unused recovery code as used, it fails with pgx.ErrNoRows if there is none
+func (dbc DBConnector) ConsumeRecoveryCode(userID int, codeHash string) error {
+	result, err := dbc.DB.Exec(context.Background(), "UPDATE user_recovery_codes SET used_at = now() WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL", userID, codeHash)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+
+	return nil
+}
+
+// AddTwoFactorChallenge stores a login that waits for the second factor, expired challenges are removed on the way
+func (dbc DBConnector) AddTwoFactorChallenge(userID int, challengeHash string, expiry time.Time) error {
+	_, err := dbc.DB.Exec(context.Background(), "DELETE FROM two_factor_challenges WHERE expiry <= now()")
+	if err != nil {
+		return err
+	}
+
+	_, err = dbc.DB.Exec(context.Background(), "INSERT INTO two_factor_challenges (challenge_hash, user_id, expiry) VALUES ($1, $2, $3)", challengeHash, userID, expiry)
+	return err
+}
+
+// UseTwoFactorChallenge counts an attempt to complete the challenge before the code is checked
+// It fails with pgx.ErrNoRows if the challenge expired, was completed or has no attempts left
+func (dbc DBConnector) UseTwoFactorChallenge(challengeHash string, maxAttempts int) error {
+	result, err := dbc.DB.Exec(context.Background(), "UPDATE two_factor_challenges SET attempts = attempts + 1 WHERE challenge_hash = $1 AND expiry > now() AND attempts < $2",
+		challengeHash, maxAttempts)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+
+	return nil
+}
+
+// DeleteTwoFactorChallenge removes a completed challenge, so it can't be used for another login
+func (dbc DBConnector) DeleteTwoFactorChallenge(challengeHash string) error {
+	_, err := dbc.DB.Exec(context.Background(), "DELETE FROM two_factor_challenges WHERE challenge_hash = $1", challengeHash)
+	return err
+}
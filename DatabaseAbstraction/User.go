@@ -2,69 +2,109 @@ package DatabaseAbstraction
 
 import (
 	"context"
+	"github.com/jackc/pgx/v5"
+	"strings"
 	"time"
 )
 
+const (
+	RoleStudent    = "student"
+	RoleInstructor = "instructor"
+	RoleAdmin      = "admin"
+)
+
 type User struct {
-	IndexID   int
-	Username  string
-	Password  string
-	Balance   int
-	CreatedAt time.Time
-	UpdatedAt time.Time
-	Points    int
+	IndexID          int
+	Username         string
+	Password         string
+	Email            string // empty if the user never provided one, always stored normalized
+	EmailVerified    bool
+	Role             string
+	TOTPSecret       string // base32, set during enrollment before TOTPEnabled
+	TOTPEnabled      bool
+	DisplayName      string
+	AvatarURL        string
+	Locale           string // BCP 47 language tag, empty for the default language
+	Country          string // ISO 3166 code of the billing country, decides the VAT rate on invoices
+	Deleted          bool   // the account was deleted and anonymized, the row is only kept for purchase records
+	Suspended        bool   // an admin locked the account, it can't log in or use existing tokens
+	SuspensionReason string
+	Balance          int
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+	Points           int
 }
 
-func (dbc DBConnector) GetAllUsers() ([]User, error) {
-	// Get all the users from the database
-	rows, err := dbc.DB.Query(context.Background(), "SELECT id, username, password, balance, created_at, updated_at, points FROM users")
+// userColumns is the column list matching scanUser
+const userColumns = "id, username, password, COALESCE(email, ''), email_verified_at IS NOT NULL, role, COALESCE(totp_secret, ''), totp_enabled, " +
+	"COALESCE(display_name, ''), COALESCE(avatar_url, ''), COALESCE(locale, ''), COALESCE(country, ''), deleted_at IS NOT NULL, suspended_at IS NOT NULL, COALESCE(suspension_reason, ''), balance, created_at, updated_at, points"
+
+func scanUser(row pgx.Row) (User, error) {
+	var user User
+	err := row.Scan(&user.IndexID, &user.Username, &user.Password, &user.Email, &user.EmailVerified, &user.Role, &user.TOTPSecret, &user.TOTPEnabled,
+		&user.DisplayName, &user.AvatarURL, &user.Locale, &user.Country, &user.Deleted, &user.Suspended, &user.SuspensionReason, &user.Balance, &user.CreatedAt, &user.UpdatedAt, &user.Points)
 	if err != nil {
-		return []User{}, err
+		return User{}, err
 	}
+	return user, nil
+}
+
+// SearchUsers returns a page of users whose username, display name or email contains the query, and the number of all matches
+// An empty query matches every user
+func (dbc DBConnector) SearchUsers(query string, limit int, offset int) ([]User, int, error) {
+	// the query is matched literally, LIKE wildcards typed by the admin are escaped
+	pattern := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(query) + "%"
+	where := " FROM users WHERE username ILIKE $1 OR display_name ILIKE $1 OR email ILIKE $1"
+
+	var total int
+	err := dbc.DB.QueryRow(context.Background(), "SELECT COUNT(*)"+where, pattern).Scan(&total)
+	if err != nil {
+		return []User{}, 0, err
+	}
+
+	rows, err := dbc.DB.Query(context.Background(), "SELECT "+userColumns+where+" ORDER BY id LIMIT $2 OFFSET $3", pattern, limit, offset)
+	if err != nil {
+		return []User{}, 0, err
+	}
+	defer rows.Close()
 
 	// Iterate over the rows and add them to the slice
 	var users []User
 	for rows.Next() {
-		var user User
-		err := rows.Scan(&user.IndexID, &user.Username, &user.Password, &user.Balance, &user.CreatedAt, &user.UpdatedAt, &user.Points)
+		user, err := scanUser(rows)
 		if err != nil {
-			return []User{}, err
+			return []User{}, 0, err
 		}
 		users = append(users, user)
 	}
 
-	return users, nil
+	return users, total, rows.Err()
 }
 
 func (dbc DBConnector) GetUserByUsername(username string) (User, error) {
 	// Get the user from the database
-	row := dbc.DB.QueryRow(context.Background(), "SELECT id, username, password, balance, created_at, updated_at, points FROM users WHERE username = $1", username)
+	row := dbc.DB.QueryRow(context.Background(), "SELECT "+userColumns+" FROM users WHERE lower(username) = lower($1)", username)
 
-	var user User
-	err := row.Scan(&user.IndexID, &user.Username, &user.Password, &user.Balance, &user.CreatedAt, &user.UpdatedAt, &user.Points)
-	if err != nil {
-		return User{}, err
-	}
+	return scanUser(row)
+}
 
-	return user, nil
+// GetUserByEmail looks up a user by email address, case-insensitively
+func (dbc DBConnector) GetUserByEmail(email string) (User, error) {
+	row := dbc.DB.QueryRow(context.Background(), "SELECT "+userColumns+" FROM users WHERE lower(email) = lower($1)", email)
+
+	return scanUser(row)
 }
 
 func (dbc DBConnector) GetUserByIndexID(indexID int) (User, error) {
 	// Get the user from the database
-	row := dbc.DB.QueryRow(context.Background(), "SELECT id, username, password, balance, created_at, updated_at, points FROM users WHERE id = $1", indexID)
-
-	var user User
-	err := row.Scan(&user.IndexID, &user.Username, &user.Password, &user.Balance, &user.CreatedAt, &user.UpdatedAt, &user.Points)
-	if err != nil {
-		return User{}, err
-	}
+	row := dbc.DB.QueryRow(context.Background(), "SELECT "+userColumns+" FROM users WHERE id = $1", indexID)
 
-	return user, nil
+	return scanUser(row)
 }
 
-func (dbc DBConnector) AddUser(username string, password string) error {
-	// Add the user to the database
-	_, err := dbc.DB.Exec(context.Background(), "INSERT INTO users (username, password) VALUES ($1, $2)", username, password)
+func (dbc DBConnector) AddUser(username string, password string, email string) error {
+	// Add the user to the database, an empty email is stored as NULL
+	_, err := dbc.DB.Exec(context.Background(), "INSERT INTO users (username, password, email) VALUES ($1, $2, NULLIF($3, ''))", username, password, email)
 	if err != nil {
 		return err
 	}
@@ -101,16 +141,112 @@ func (dbc DBConnector) UpdateUserPassword(indexID int, password string) error {
 }
 
 func (dbc DBConnector) UpdateUserUsername(indexID int, username string) error {
-	_, err := dbc.DB.Exec(context.Background(), "UPDATE users SET username = $1 WHERE id = $2", username, indexID)
+	_, err := dbc.DB.Exec(context.Background(), "UPDATE users SET username = $1, updated_at = now() WHERE id = $2", username, indexID)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+// UpdateUserProfile sets the optional profile fields, empty values are stored as NULL
+func (dbc DBConnector) UpdateUserProfile(indexID int, displayName string, avatarURL string, locale string, country string) error {
+	_, err := dbc.DB.Exec(context.Background(), "UPDATE users SET display_name = NULLIF($1, ''), avatar_url = NULLIF($2, ''), locale = NULLIF($3, ''), country = NULLIF($4, ''), updated_at = now() WHERE id = $5",
+		displayName, avatarURL, locale, country, indexID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// anonymizedUserTables hold the rows AnonymizeUser deletes along with the personal data
+// Leaving the organizations frees the seats, the organization already paid for them and can assign them to someone else
+var anonymizedUserTables = []string{"user_tokens", "api_keys", "user_identities", "user_recovery_codes", "two_factor_challenges", "password_reset_tokens",
+	"user_watched_videos", "data_exports", "seat_assignments", "organization_members"}
+
+// AnonymizeUser deletes an account without deleting its purchases and invoices, which have to be kept for accounting
+// All personal data and credentials are removed, comments stay but are shown without the author's name
+// The username is replaced by one registration can't produce, so it stays unique without being reusable
+func (dbc DBConnector) AnonymizeUser(indexID int) error {
+	tx, err := dbc.DB.Begin(context.Background())
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.Background())
+
+	result, err := tx.Exec(context.Background(), "UPDATE users SET username = '~deleted-' || id, password = '', email = NULL, email_verified_at = NULL, "+
+		"totp_secret = NULL, totp_enabled = false, display_name = NULL, avatar_url = NULL, locale = NULL, country = NULL, "+
+		"deleted_at = now(), updated_at = now() WHERE id = $1 AND deleted_at IS NULL", indexID)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+
+	for _, table := range anonymizedUserTables {
+		_, err = tx.Exec(context.Background(), "DELETE FROM "+table+" WHERE user_id = $1", indexID)
+		if err != nil {
+			return err
+		}
+	}
+
+	// subscriptions end with the account, otherwise the renewal job would keep charging the remaining balance
+	_, err = tx.Exec(context.Background(), "UPDATE subscriptions SET status = $1 WHERE user_id = $2 AND status <> $1", SubscriptionExpired, indexID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(context.Background())
+}
+
+// SuspendUser locks an account until UnsuspendUser is called
+func (dbc DBConnector) SuspendUser(indexID int, reason string) error {
+	result, err := dbc.DB.Exec(context.Background(), "UPDATE users SET suspended_at = now(), suspension_reason = NULLIF($1, ''), updated_at = now() WHERE id = $2", reason, indexID)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+func (dbc DBConnector) UnsuspendUser(indexID int) error {
+	result, err := dbc.DB.Exec(context.Background(), "UPDATE users SET suspended_at = NULL, suspension_reason = NULL, updated_at = now() WHERE id = $1", indexID)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// UpdateUserEmail changes the email address, the new address has to be verified again
+func (dbc DBConnector) UpdateUserEmail(indexID int, email string) error {
+	_, err := dbc.DB.Exec(context.Background(), "UPDATE users SET email = NULLIF($1, ''), email_verified_at = NULL, updated_at = now() WHERE id = $2", email, indexID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// VerifyUserEmail marks the email address as verified, but only if it is still the address the verification was sent to
+func (dbc DBConnector) VerifyUserEmail(indexID int, email string) error {
+	result, err := dbc.DB.Exec(context.Background(), "UPDATE users SET email_verified_at = now() WHERE id = $1 AND lower(email) = lower($2) AND email_verified_at IS NULL", indexID, email)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// GetOwnedProducts returns the products the user owns permanently, rentals and subscriptions are entitlements that end
 func (dbc DBConnector) GetOwnedProducts(indexID int) ([]Product, error) {
 	// Get all the products from the database
-	rows, err := dbc.DB.Query(context.Background(), "SELECT products.id, products.name, products.description, products.price, products.image, products.difficulty, products.created_at, products.updated_at FROM products INNER JOIN user_purchases ON products.id = user_purchases.product_id WHERE user_purchases.user_id = $1", indexID)
+	rows, err := dbc.DB.Query(context.Background(), "SELECT products.id, products.name, products.description, products.price, products.image, products.difficulty, products.created_at, products.updated_at FROM products INNER JOIN user_purchases ON products.id = user_purchases.product_id WHERE user_purchases.user_id = $1 AND user_purchases.expires_at IS NULL", indexID)
 	if err != nil {
 		return []Product{}, err
 	}
@@ -129,21 +265,35 @@ func (dbc DBConnector) GetOwnedProducts(indexID int) ([]Product, error) {
 	return products, nil
 }
 
-func (dbc DBConnector) IncreaseUserBalance(indexID int, amount int) error {
-	_, err := dbc.DB.Exec(context.Background(), "UPDATE users SET balance = balance + $1 WHERE id = $2", amount, indexID)
+// IncreaseUserBalance credits the balance and records the reason in the wallet history
+func (dbc DBConnector) IncreaseUserBalance(indexID int, amount int, reason string) error {
+	return dbc.changeUserBalance(indexID, amount, reason)
+}
+
+// DecreaseUserBalance debits the balance and records the reason in the wallet history
+// It fails on the balance check constraint if the user can't afford it
+func (dbc DBConnector) DecreaseUserBalance(indexID int, amount int, reason string) error {
+	return dbc.changeUserBalance(indexID, -amount, reason)
+}
+
+func (dbc DBConnector) changeUserBalance(indexID int, amount int, reason string) error {
+	tx, err := dbc.DB.Begin(context.Background())
 	if err != nil {
 		return err
 	}
-	return nil
-}
+	defer tx.Rollback(context.Background())
 
-func (dbc DBConnector) DecreaseUserBalance(indexID int, amount int) error {
-	_, err := dbc.DB.Exec(context.Background(), "UPDATE users SET balance = balance - $1 WHERE id = $2", amount, indexID)
+	_, err = tx.Exec(context.Background(), "UPDATE users SET balance = balance + $1 WHERE id = $2", amount, indexID)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	_, err = tx.Exec(context.Background(), "INSERT INTO balance_transactions (user_id, amount, reason) VALUES ($1, $2, $3)", indexID, amount, reason)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(context.Background())
 }
 
 func (dbc DBConnector) AddOwnedProduct(indexID int, productID int) error {
@@ -154,3 +304,16 @@ func (dbc DBConnector) AddOwnedProduct(indexID int, productID int) error {
 
 	return nil
 }
+
+// RemoveOwnedProduct revokes a product from a user, it doesn't refund anything
+func (dbc DBConnector) RemoveOwnedProduct(indexID int, productID int) error {
+	result, err := dbc.DB.Exec(context.Background(), "DELETE FROM user_purchases WHERE user_id = $1 AND product_id = $2", indexID, productID)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+
+	return nil
+}
@@ -0,0 +1,21 @@
+package DatabaseAbstraction
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"regexp"
+	"testing"
+)
+
+func TestAnonymizeUserLeavesOrganizations(t *testing.T) {
+	// a deleted student must neither occupy a paid seat nor stay listed as a member
+	assert.Contains(t, anonymizedUserTables, "seat_assignments")
+	assert.Contains(t, anonymizedUserTables, "organization_members")
+
+	// every table AnonymizeUser deletes from has a user_id column
+	seed, err := os.ReadFile("../seed.sql")
+	assert.NoError(t, err)
+	for _, table := range anonymizedUserTables {
+		assert.Regexp(t, regexp.MustCompile(`CREATE TABLE `+table+` \([^;]*\n\s+user_id\s+INTEGER`), string(seed), table)
+	}
+}
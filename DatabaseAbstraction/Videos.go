@@ -16,6 +16,21 @@ type Video struct {
 	UpdatedAt   time.Time
 }
 
+// ProductProgress is how many videos of an owned product a user has watched
+type ProductProgress struct {
+	ProductID     int
+	ProductName   string
+	WatchedVideos int
+	TotalVideos   int
+}
+
+// WatchedVideo is an entry of the watch history of a user
+type WatchedVideo struct {
+	VideoID   int
+	VideoName string
+	WatchedAt time.Time
+}
+
 // Get a video by its indexID
 func (dbc DBConnector) GetVideoByIndexID(indexID int) (Video, error) {
 	// Get the video from the database
@@ -89,6 +104,29 @@ func (dbc DBConnector) GetVideosByProductIndexID(indexID int) ([]Video, error) {
 	return videos, nil
 }
 
+// GetVideosByProductIndexIDs loads the videos of several products in one query, keyed by product ID
+// Products without videos are missing from the map
+func (dbc DBConnector) GetVideosByProductIndexIDs(productIDs []int) (map[int][]Video, error) {
+	rows, err := dbc.DB.Query(context.Background(), "SELECT parent_product_id, id, name, description, points, thumbnail, filename FROM video WHERE parent_product_id = ANY($1) ORDER BY parent_product_id, id", productIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	videos := make(map[int][]Video)
+	for rows.Next() {
+		var productID int
+		var video Video
+		err := rows.Scan(&productID, &video.IndexID, &video.Name, &video.Description, &video.Points, &video.Thumbnail, &video.Filename)
+		if err != nil {
+			return nil, err
+		}
+		videos[productID] = append(videos[productID], video)
+	}
+
+	return videos, rows.Err()
+}
+
 func (dbc DBConnector) MarkVideoAsWatched(indexID int, user User) error {
 	_, err := dbc.DB.Exec(context.Background(), "INSERT INTO user_watched_videos (user_id, video_id) VALUES ($1, $2)", user.IndexID, indexID)
 	if err != nil {
@@ -125,3 +163,48 @@ func (dbc DBConnector) GetWatchedVideosByUser(user User) ([]Video, error) {
 
 	return videos, nil
 }
+
+// GetWatchHistoryByUserID returns every time a user watched a video, oldest first
+func (dbc DBConnector) GetWatchHistoryByUserID(userID int) ([]WatchedVideo, error) {
+	rows, err := dbc.DB.Query(context.Background(), "SELECT video.id, video.name, user_watched_videos.created_at FROM user_watched_videos JOIN video ON video.id = user_watched_videos.video_id WHERE user_watched_videos.user_id = $1 ORDER BY user_watched_videos.id", userID)
+	if err != nil {
+		return []WatchedVideo{}, err
+	}
+	defer rows.Close()
+
+	var history []WatchedVideo
+	for rows.Next() {
+		var watched WatchedVideo
+		err := rows.Scan(&watched.VideoID, &watched.VideoName, &watched.WatchedAt)
+		if err != nil {
+			return []WatchedVideo{}, err
+		}
+		history = append(history, watched)
+	}
+
+	return history, rows.Err()
+}
+
+// GetProgressByUserID returns the progress of a user in each of their products
+func (dbc DBConnector) GetProgressByUserID(userID int) ([]ProductProgress, error) {
+	rows, err := dbc.DB.Query(context.Background(), "SELECT products.id, products.name, "+
+		"(SELECT COUNT(DISTINCT user_watched_videos.video_id) FROM user_watched_videos JOIN video ON video.id = user_watched_videos.video_id WHERE user_watched_videos.user_id = $1 AND video.parent_product_id = products.id), "+
+		"(SELECT COUNT(*) FROM video WHERE video.parent_product_id = products.id) "+
+		"FROM user_purchases JOIN products ON products.id = user_purchases.product_id WHERE user_purchases.user_id = $1 ORDER BY user_purchases.id", userID)
+	if err != nil {
+		return []ProductProgress{}, err
+	}
+	defer rows.Close()
+
+	var progress []ProductProgress
+	for rows.Next() {
+		var productProgress ProductProgress
+		err := rows.Scan(&productProgress.ProductID, &productProgress.ProductName, &productProgress.WatchedVideos, &productProgress.TotalVideos)
+		if err != nil {
+			return []ProductProgress{}, err
+		}
+		progress = append(progress, productProgress)
+	}
+
+	return progress, rows.Err()
+}
@@ -0,0 +1,209 @@
+package DatabaseAbstraction
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"time"
+)
+
+var ErrProductOwned = errors.New("the user already owns one of the products")
+
+// BalanceTransaction is an entry of the wallet history, Amount is negative for debits
+type BalanceTransaction struct {
+	IndexID   int
+	UserID    int
+	Amount    int
+	Reason    string
+	CreatedAt time.Time
+}
+
+// Purchase is a row of user_purchases with the name of the product
+type Purchase struct {
+	IndexID      int
+	UserID       int
+	ProductID    int
+	ProductName  string
+	BundleID     int // 0 unless the product was bought as part of a bundle
+	CouponID     int // 0 unless a coupon was applied
+	LicenseKeyID int // 0 unless the product was granted by redeeming a license key
+	Price        int // what the user paid, 0 for granted products
+	Discount     int
+	CreatedAt    time.Time
+	ExpiresAt    *time.Time // set for rentals
+	InvoiceID    int        // 0 for granted products and purchases made before invoices were issued
+}
+
+// PurchaseOrder is a checkout of one or more products, the products of a bundle are granted together
+type PurchaseOrder struct {
+	UserID    int
+	Items     []PurchaseItem
+	Reason    string // shown in the wallet history
+	BundleID  int
+	CouponID  int        // the coupon is redeemed in the same transaction
+	ExpiresAt *time.Time // makes the order a rental, the products are granted until then
+}
+
+// PurchaseItem is a product of an order with the price paid for it
+type PurchaseItem struct {
+	ProductID int
+	Price     int
+	Discount  int
+}
+
+// Total is the amount debited for the order
+func (order PurchaseOrder) Total() int {
+	total := 0
+	for _, item := range order.Items {
+		total += item.Price
+	}
+	return total
+}
+
+// GetBalanceTransactionsByUserID returns the wallet history of a user, oldest first
+func (dbc DBConnector) GetBalanceTransactionsByUserID(userID int) ([]BalanceTransaction, error) {
+	rows, err := dbc.DB.Query(context.Background(), "SELECT id, user_id, amount, reason, created_at FROM balance_transactions WHERE user_id = $1 ORDER BY id", userID)
+	if err != nil {
+		return []BalanceTransaction{}, err
+	}
+	defer rows.Close()
+
+	var transactions []BalanceTransaction
+	for rows.Next() {
+		var transaction BalanceTransaction
+		err := rows.Scan(&transaction.IndexID, &transaction.UserID, &transaction.Amount, &transaction.Reason, &transaction.CreatedAt)
+		if err != nil {
+			return []BalanceTransaction{}, err
+		}
+		transactions = append(transactions, transaction)
+	}
+
+	return transactions, rows.Err()
+}
+
+const purchaseColumns = "user_purchases.id, user_purchases.user_id, products.id, products.name, COALESCE(user_purchases.bundle_id, 0), " +
+	"COALESCE(user_purchases.coupon_id, 0), COALESCE(user_purchases.license_key_id, 0), user_purchases.price, user_purchases.discount, " +
+	"user_purchases.created_at, user_purchases.expires_at, COALESCE(user_purchases.invoice_id, 0)"
+
+func scanPurchase(row pgx.Row) (Purchase, error) {
+	var purchase Purchase
+	err := row.Scan(&purchase.IndexID, &purchase.UserID, &purchase.ProductID, &purchase.ProductName, &purchase.BundleID, &purchase.CouponID,
+		&purchase.LicenseKeyID, &purchase.Price, &purchase.Discount, &purchase.CreatedAt, &purchase.ExpiresAt, &purchase.InvoiceID)
+	return purchase, err
+}
+
+// GetPurchasesByUserID returns the purchases of a user with their dates, oldest first
+func (dbc DBConnector) GetPurchasesByUserID(userID int) ([]Purchase, error) {
+	rows, err := dbc.DB.Query(context.Background(), "SELECT "+purchaseColumns+" FROM user_purchases JOIN products ON products.id = user_purchases.product_id WHERE user_purchases.user_id = $1 ORDER BY user_purchases.id", userID)
+	if err != nil {
+		return []Purchase{}, err
+	}
+	defer rows.Close()
+
+	var purchases []Purchase
+	for rows.Next() {
+		purchase, err := scanPurchase(rows)
+		if err != nil {
+			return []Purchase{}, err
+		}
+		purchases = append(purchases, purchase)
+	}
+
+	return purchases, rows.Err()
+}
+
+func (dbc DBConnector) GetPurchaseByIndexID(indexID int) (Purchase, error) {
+	return scanPurchase(dbc.DB.QueryRow(context.Background(), "SELECT "+purchaseColumns+" FROM user_purchases JOIN products ON products.id = user_purchases.product_id WHERE user_purchases.id = $1", indexID))
+}
+
+// PurchaseProducts debits the price, issues the invoice and grants the products in one transaction, so a failed grant can't cost money
+// It fails on the balance check constraint if the user can't afford it, with ErrCouponUnavailable if the coupon
+// expired or was used up in the meantime and with ErrProductOwned if the user got one of the products for good in the meantime.
+// The user row is updated first, which serializes the purchases of a user.
+func (dbc DBConnector) PurchaseProducts(order PurchaseOrder) error {
+	tx, err := dbc.DB.Begin(context.Background())
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.Background())
+
+	items, err := orderInvoiceItems(tx, order)
+	if err != nil {
+		return err
+	}
+	invoiceID, err := chargeUser(tx, order.UserID, order.Reason, items)
+	if err != nil {
+		return err
+	}
+
+	// the debit locked the user row, a concurrent checkout of the user waits until this one ends and finds its products here
+	productIDs := make([]int, len(order.Items))
+	prices := make([]int, len(order.Items))
+	discounts := make([]int, len(order.Items))
+	for i, item := range order.Items {
+		productIDs[i], prices[i], discounts[i] = item.ProductID, item.Price, item.Discount
+	}
+	var owned bool
+	err = tx.QueryRow(context.Background(), "SELECT EXISTS (SELECT 1 FROM user_purchases WHERE user_id = $1 AND product_id = ANY($2) AND expires_at IS NULL)",
+		order.UserID, productIDs).Scan(&owned)
+	if err != nil {
+		return err
+	}
+	if owned {
+		return ErrProductOwned
+	}
+
+	if order.CouponID != 0 {
+		err = redeemCoupon(tx, order.CouponID, order.UserID)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(context.Background(), "INSERT INTO user_purchases (user_id, product_id, bundle_id, coupon_id, price, discount, expires_at, invoice_id) "+
+		"SELECT $1, product_id, NULLIF($2, 0), NULLIF($3, 0), price, discount, $7, $8 FROM unnest($4::integer[], $5::integer[], $6::integer[]) AS items (product_id, price, discount)",
+		order.UserID, order.BundleID, order.CouponID, productIDs, prices, discounts, order.ExpiresAt, invoiceID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(context.Background())
+}
+
+// orderInvoiceItems returns a line per product of the order, rentals name the day they end
+func orderInvoiceItems(tx pgx.Tx, order PurchaseOrder) ([]InvoiceItem, error) {
+	productIDs := make([]int, len(order.Items))
+	for i, item := range order.Items {
+		productIDs[i] = item.ProductID
+	}
+	rows, err := tx.Query(context.Background(), "SELECT id, name FROM products WHERE id = ANY($1)", productIDs)
+	if err != nil {
+		return []InvoiceItem{}, err
+	}
+	defer rows.Close()
+
+	names := map[int]string{}
+	for rows.Next() {
+		var productID int
+		var name string
+		err = rows.Scan(&productID, &name)
+		if err != nil {
+			return []InvoiceItem{}, err
+		}
+		names[productID] = name
+	}
+	if rows.Err() != nil {
+		return []InvoiceItem{}, rows.Err()
+	}
+
+	items := make([]InvoiceItem, len(order.Items))
+	for i, item := range order.Items {
+		description := names[item.ProductID]
+		if order.ExpiresAt != nil {
+			description = fmt.Sprintf("Rental of %s until %s", description, order.ExpiresAt.Format("2006-01-02"))
+		}
+		items[i] = InvoiceItem{Description: description, Quantity: 1, Amount: item.Price, Discount: item.Discount}
+	}
+	return items, nil
+}
@@ -0,0 +1,59 @@
+package Entitlements
+
+import (
+	"EntitlementServer/AuditLog"
+	"EntitlementServer/DatabaseAbstraction"
+	"errors"
+)
+
+var ErrNotEntitled = errors.New("the user has no access to the product")
+
+// Manager answers whether a user may watch a product, purchases, rentals, subscriptions and organization seats all count
+// Every access decision should go through Check instead of looking at purchases directly
+type Manager struct {
+	DB    DatabaseAbstraction.DBOrm
+	Audit AuditLog.Auditor // records expired and renewed entitlements
+}
+
+// Check returns the entitlement that gives the user access to the product, or ErrNotEntitled
+// If several apply, a permanent one wins over the one that lasts longest
+func (m Manager) Check(user DatabaseAbstraction.User, productID int) (DatabaseAbstraction.Entitlement, error) {
+	entitlements, err := m.DB.GetEntitlements(user.IndexID)
+	if err != nil {
+		return DatabaseAbstraction.Entitlement{}, err
+	}
+
+	var best *DatabaseAbstraction.Entitlement
+	for i, entitlement := range entitlements {
+		if !entitlement.Covers(productID) {
+			continue
+		}
+		if entitlement.Permanent() {
+			return entitlement, nil
+		}
+		if best == nil || entitlement.ExpiresAt.After(*best.ExpiresAt) {
+			best = &entitlements[i]
+		}
+	}
+	if best == nil {
+		return DatabaseAbstraction.Entitlement{}, ErrNotEntitled
+	}
+
+	return *best, nil
+}
+
+// Owns reports whether the user bought the product for good, so buying it again makes no sense
+// A seat doesn't count, the organization can reclaim it
+func (m Manager) Owns(user DatabaseAbstraction.User, productID int) (bool, error) {
+	entitlements, err := m.DB.GetEntitlements(user.IndexID)
+	if err != nil {
+		return false, err
+	}
+
+	for _, entitlement := range entitlements {
+		if entitlement.Source == DatabaseAbstraction.EntitlementPurchase && entitlement.Covers(productID) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
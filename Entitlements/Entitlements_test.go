@@ -0,0 +1,166 @@
+package Entitlements_test
+
+import (
+	"EntitlementServer/AuditLog"
+	"EntitlementServer/DatabaseAbstraction"
+	"EntitlementServer/DatabaseAbstraction/mocks"
+	"EntitlementServer/Entitlements"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"testing"
+	"time"
+)
+
+// recordingAuditor keeps the events in memory
+type recordingAuditor struct {
+	events *[]DatabaseAbstraction.AuditEvent
+}
+
+func (a recordingAuditor) Record(event DatabaseAbstraction.AuditEvent) {
+	*a.events = append(*a.events, event)
+}
+
+func in(d time.Duration) *time.Time {
+	t := time.Now().Add(d)
+	return &t
+}
+
+func TestCheckPrefersPermanentEntitlements(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	mockDB.On("GetEntitlements", 1).Return([]DatabaseAbstraction.Entitlement{
+		{Source: DatabaseAbstraction.EntitlementSubscription, SubscriptionID: 4, ExpiresAt: in(24 * time.Hour)},
+		{Source: DatabaseAbstraction.EntitlementRental, ProductID: 2, PurchaseID: 7, ExpiresAt: in(48 * time.Hour)},
+		{Source: DatabaseAbstraction.EntitlementPurchase, ProductID: 3, PurchaseID: 8},
+	}, nil)
+	manager := Entitlements.Manager{DB: mockDB}
+	user := DatabaseAbstraction.User{IndexID: 1}
+
+	entitlement, err := manager.Check(user, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, 8, entitlement.PurchaseID)
+
+	// the rental lasts longer than the subscription period
+	entitlement, err = manager.Check(user, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 7, entitlement.PurchaseID)
+
+	// the subscription covers the whole catalog
+	entitlement, err = manager.Check(user, 9)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, entitlement.SubscriptionID)
+
+	owned, err := manager.Owns(user, 3)
+	assert.NoError(t, err)
+	assert.True(t, owned)
+	owned, err = manager.Owns(user, 2)
+	assert.NoError(t, err)
+	assert.False(t, owned)
+}
+
+func TestSeatsGrantAccessButNoOwnership(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	mockDB.On("GetEntitlements", 1).Return([]DatabaseAbstraction.Entitlement{
+		{Source: DatabaseAbstraction.EntitlementSeat, ProductID: 2, OrganizationID: 5},
+	}, nil)
+	manager := Entitlements.Manager{DB: mockDB}
+	user := DatabaseAbstraction.User{IndexID: 1}
+
+	entitlement, err := manager.Check(user, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, entitlement.OrganizationID)
+
+	// the organization can reclaim the seat, so the student may still buy the product
+	owned, err := manager.Owns(user, 2)
+	assert.NoError(t, err)
+	assert.False(t, owned)
+}
+
+func TestCheckWithoutEntitlements(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	mockDB.On("GetEntitlements", 1).Return([]DatabaseAbstraction.Entitlement{
+		{Source: DatabaseAbstraction.EntitlementRental, ProductID: 2, ExpiresAt: in(time.Hour)},
+	}, nil)
+	manager := Entitlements.Manager{DB: mockDB}
+
+	_, err := manager.Check(DatabaseAbstraction.User{IndexID: 1}, 3)
+	assert.ErrorIs(t, err, Entitlements.ErrNotEntitled)
+	owned, err := manager.Owns(DatabaseAbstraction.User{IndexID: 1}, 3)
+	assert.NoError(t, err)
+	assert.False(t, owned)
+}
+
+func TestExpireLapsed(t *testing.T) {
+	periodEnd := time.Now().Add(-time.Minute)
+	mockDB := new(mocks.DBOrm)
+	mockDB.On("GetLapsedSubscriptions").Return([]DatabaseAbstraction.Subscription{
+		{IndexID: 1, UserID: 2, PlanID: 1, Status: DatabaseAbstraction.SubscriptionActive, CurrentPeriodEnd: periodEnd},
+		{IndexID: 2, UserID: 3, PlanID: 1, Status: DatabaseAbstraction.SubscriptionActive, CurrentPeriodEnd: periodEnd},
+		{IndexID: 3, UserID: 4, PlanID: 1, Status: DatabaseAbstraction.SubscriptionCancelled, CurrentPeriodEnd: periodEnd},
+		{IndexID: 4, UserID: 5, PlanID: 2, Status: DatabaseAbstraction.SubscriptionActive, CurrentPeriodEnd: periodEnd},
+	}, nil)
+	mockDB.On("GetSubscriptionPlanByIndexID", 1).Return(DatabaseAbstraction.SubscriptionPlan{IndexID: 1, Price: 900, PeriodDays: 30, Active: true}, nil)
+	mockDB.On("GetSubscriptionPlanByIndexID", 2).Return(DatabaseAbstraction.SubscriptionPlan{IndexID: 2, Price: 900, PeriodDays: 30}, nil)
+	mockDB.On("GetUserByIndexID", 2).Return(DatabaseAbstraction.User{IndexID: 2, Balance: 1000}, nil)
+	mockDB.On("GetUserByIndexID", 3).Return(DatabaseAbstraction.User{IndexID: 3, Balance: 100}, nil)
+	// the next period follows the last one without a gap
+	mockDB.On("RenewSubscription", mock.MatchedBy(func(subscription DatabaseAbstraction.Subscription) bool { return subscription.IndexID == 1 }), 900,
+		periodEnd.AddDate(0, 0, 30), "renewal of subscription 1").Return(nil)
+	mockDB.On("ExpireSubscription", mock.Anything).Return(nil)
+	mockDB.On("ExpireRentals").Return([]DatabaseAbstraction.Purchase{{IndexID: 9, UserID: 6, ProductID: 2}}, nil)
+	events := []DatabaseAbstraction.AuditEvent{}
+
+	err := Entitlements.Manager{DB: mockDB, Audit: recordingAuditor{&events}}.ExpireLapsed()
+	assert.NoError(t, err)
+
+	mockDB.AssertNumberOfCalls(t, "RenewSubscription", 1)
+	mockDB.AssertNumberOfCalls(t, "ExpireSubscription", 3)
+	if assert.Len(t, events, 5) {
+		assert.Equal(t, AuditLog.ActionSubscriptionRenewed, events[0].Action)
+		assert.Equal(t, 2, events[0].TargetUserID)
+		assert.Equal(t, "insufficient balance", events[1].Details["reason"])
+		assert.Equal(t, "cancelled", events[2].Details["reason"])
+		assert.Equal(t, "plan discontinued", events[3].Details["reason"])
+		assert.Equal(t, AuditLog.ActionRentalExpired, events[4].Action)
+		assert.Equal(t, 6, events[4].TargetUserID)
+	}
+}
+
+func TestExpireLapsedDoesNotChargeClosedAccounts(t *testing.T) {
+	periodEnd := time.Now().Add(-time.Minute)
+	mockDB := new(mocks.DBOrm)
+	mockDB.On("GetLapsedSubscriptions").Return([]DatabaseAbstraction.Subscription{
+		{IndexID: 1, UserID: 2, PlanID: 1, Status: DatabaseAbstraction.SubscriptionActive, CurrentPeriodEnd: periodEnd},
+		{IndexID: 2, UserID: 3, PlanID: 1, Status: DatabaseAbstraction.SubscriptionActive, CurrentPeriodEnd: periodEnd},
+	}, nil)
+	mockDB.On("GetSubscriptionPlanByIndexID", 1).Return(DatabaseAbstraction.SubscriptionPlan{IndexID: 1, Price: 900, PeriodDays: 30, Active: true}, nil)
+	mockDB.On("GetUserByIndexID", 2).Return(DatabaseAbstraction.User{IndexID: 2, Username: "~deleted-2", Balance: 5000, Deleted: true}, nil)
+	mockDB.On("GetUserByIndexID", 3).Return(DatabaseAbstraction.User{IndexID: 3, Balance: 5000, Suspended: true}, nil)
+	mockDB.On("ExpireSubscription", mock.Anything).Return(nil)
+	mockDB.On("ExpireRentals").Return([]DatabaseAbstraction.Purchase{}, nil)
+	events := []DatabaseAbstraction.AuditEvent{}
+
+	err := Entitlements.Manager{DB: mockDB, Audit: recordingAuditor{&events}}.ExpireLapsed()
+	assert.NoError(t, err)
+
+	mockDB.AssertNotCalled(t, "RenewSubscription", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	mockDB.AssertNumberOfCalls(t, "ExpireSubscription", 2)
+	if assert.Len(t, events, 2) {
+		assert.Equal(t, "account deleted", events[0].Details["reason"])
+		assert.Equal(t, "account suspended", events[1].Details["reason"])
+	}
+}
+
+func TestExpireLapsedSkipsChangedSubscriptions(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	// another instance of the job expired the subscription first
+	mockDB.On("GetLapsedSubscriptions").Return([]DatabaseAbstraction.Subscription{
+		{IndexID: 1, UserID: 2, PlanID: 1, Status: DatabaseAbstraction.SubscriptionCancelled, CurrentPeriodEnd: time.Now()},
+	}, nil)
+	mockDB.On("ExpireSubscription", 1).Return(DatabaseAbstraction.ErrSubscriptionChanged)
+	mockDB.On("ExpireRentals").Return([]DatabaseAbstraction.Purchase{}, nil)
+	events := []DatabaseAbstraction.AuditEvent{}
+
+	err := Entitlements.Manager{DB: mockDB, Audit: recordingAuditor{&events}}.ExpireLapsed()
+	assert.NoError(t, err)
+	assert.Empty(t, events)
+}
@@ -0,0 +1,139 @@
+package Entitlements
+
+import (
+	"EntitlementServer/AuditLog"
+	"EntitlementServer/DatabaseAbstraction"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+// DefaultExpiryInterval is how often RunExpiry looks for lapsed entitlements
+// Access ends on time anyway, the job only renews subscriptions and records what lapsed
+var DefaultExpiryInterval = 10 * time.Minute
+
+// RunExpiry calls ExpireLapsed every interval, it doesn't return
+func (m Manager) RunExpiry(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		err := m.ExpireLapsed()
+		if err != nil {
+			logrus.Errorf("Failed to expire entitlements: %v", err)
+		}
+		<-ticker.C
+	}
+}
+
+// ExpireLapsed renews active subscriptions whose period ended if the user can pay for the next one and expires the rest,
+// including cancelled subscriptions, subscriptions of deactivated plans and lapsed rentals
+func (m Manager) ExpireLapsed() error {
+	subscriptions, err := m.DB.GetLapsedSubscriptions()
+	if err != nil {
+		return err
+	}
+	for _, subscription := range subscriptions {
+		err = m.renewOrExpire(subscription)
+		if err != nil {
+			logrus.Errorf("Failed to renew or expire subscription %d: %v", subscription.IndexID, err)
+		}
+	}
+
+	rentals, err := m.DB.ExpireRentals()
+	if err != nil {
+		return err
+	}
+	for _, rental := range rentals {
+		m.record(AuditLog.ActionRentalExpired, rental.UserID, map[string]interface{}{
+			"purchase_id": rental.IndexID,
+			"product_id":  rental.ProductID,
+		})
+	}
+
+	return nil
+}
+
+func (m Manager) renewOrExpire(subscription DatabaseAbstraction.Subscription) error {
+	reason := "cancelled"
+	if subscription.Status == DatabaseAbstraction.SubscriptionActive {
+		renewed, failure, err := m.renew(subscription)
+		if err != nil || renewed {
+			return err
+		}
+		reason = failure
+	}
+
+	err := m.DB.ExpireSubscription(subscription.IndexID)
+	if errors.Is(err, DatabaseAbstraction.ErrSubscriptionChanged) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	m.record(AuditLog.ActionSubscriptionExpired, subscription.UserID, map[string]interface{}{
+		"subscription_id": subscription.IndexID,
+		"plan_id":         subscription.PlanID,
+		"reason":          reason,
+	})
+	return nil
+}
+
+// renew starts the next period, it returns false with the reason if the subscription has to expire instead
+func (m Manager) renew(subscription DatabaseAbstraction.Subscription) (bool, string, error) {
+	plan, err := m.DB.GetSubscriptionPlanByIndexID(subscription.PlanID)
+	if err != nil {
+		return false, "", err
+	}
+	if !plan.Active {
+		return false, "plan discontinued", nil
+	}
+
+	user, err := m.DB.GetUserByIndexID(subscription.UserID)
+	if err != nil {
+		return false, "", err
+	}
+	// deleted and suspended accounts can't use the subscription, charging them would only drain the balance
+	if user.Deleted {
+		return false, "account deleted", nil
+	}
+	if user.Suspended {
+		return false, "account suspended", nil
+	}
+	if user.Balance < plan.Price {
+		return false, "insufficient balance", nil
+	}
+
+	// the next period follows the last one without a gap, unless the job was down for so long that it would be over already
+	periodEnd := subscription.CurrentPeriodEnd.AddDate(0, 0, plan.PeriodDays)
+	if periodEnd.Before(time.Now()) {
+		periodEnd = time.Now().AddDate(0, 0, plan.PeriodDays)
+	}
+	err = m.DB.RenewSubscription(subscription, plan.Price, periodEnd, fmt.Sprintf("renewal of subscription %d", subscription.IndexID))
+	if errors.Is(err, DatabaseAbstraction.ErrSubscriptionChanged) {
+		return true, "", nil
+	}
+	if err != nil {
+		return false, "", err
+	}
+
+	m.record(AuditLog.ActionSubscriptionRenewed, subscription.UserID, map[string]interface{}{
+		"subscription_id": subscription.IndexID,
+		"plan_id":         plan.IndexID,
+		"amount":          plan.Price,
+		"period_end":      periodEnd,
+	})
+	return true, "", nil
+}
+
+// record stores an event of the job, nobody is signed in so there is no actor
+func (m Manager) record(action string, userID int, details map[string]interface{}) {
+	AuditLog.OrDefault(m.Audit).Record(DatabaseAbstraction.AuditEvent{
+		Action:       action,
+		Outcome:      AuditLog.OutcomeSuccess,
+		TargetUserID: userID,
+		Details:      details,
+	})
+}
@@ -0,0 +1,215 @@
+package Invoicing
+
+import (
+	"EntitlementServer/DatabaseAbstraction"
+	"errors"
+	"fmt"
+	"golang.org/x/text/language"
+	"os"
+	"strings"
+)
+
+var ErrInvalidCountry = errors.New("must be a two-letter country code like DE")
+
+// Issuer is the seller printed on every invoice
+type Issuer struct {
+	Name     string
+	Address  []string // printed line by line below the name
+	VATID    string   // omitted if empty
+	Currency string
+}
+
+var DefaultIssuer = Issuer{
+	Name:     "BKBdemy",
+	Currency: "EUR",
+}
+
+// IssuerFromEnv reads INVOICE_ISSUER_NAME, INVOICE_ISSUER_ADDRESS with lines separated by ;, INVOICE_ISSUER_VAT_ID
+// and INVOICE_CURRENCY, falling back to DefaultIssuer
+func IssuerFromEnv() Issuer {
+	issuer := DefaultIssuer
+
+	if value := strings.TrimSpace(os.Getenv("INVOICE_ISSUER_NAME")); value != "" {
+		issuer.Name = value
+	}
+	for _, line := range strings.Split(os.Getenv("INVOICE_ISSUER_ADDRESS"), ";") {
+		if line = strings.TrimSpace(line); line != "" {
+			issuer.Address = append(issuer.Address, line)
+		}
+	}
+	issuer.VATID = strings.TrimSpace(os.Getenv("INVOICE_ISSUER_VAT_ID"))
+	if value := strings.TrimSpace(os.Getenv("INVOICE_CURRENCY")); value != "" {
+		issuer.Currency = value
+	}
+
+	return issuer
+}
+
+// NormalizeCountry validates an ISO 3166 country code and returns it uppercased
+// Groupings like EU or UN are rejected, VAT is charged per country
+func NormalizeCountry(country string) (string, error) {
+	country = strings.TrimSpace(country)
+	if len(country) != 2 {
+		return "", ErrInvalidCountry
+	}
+	region, err := language.ParseRegion(country)
+	if err != nil || !region.IsCountry() {
+		return "", ErrInvalidCountry
+	}
+	return region.String(), nil
+}
+
+// FormatAmount formats an amount in cents, e.g. 123456 as 1,234.56 EUR
+func (issuer Issuer) FormatAmount(amount int) string {
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+
+	units := fmt.Sprint(amount / 100)
+	for i := len(units) - 3; i > 0; i -= 3 {
+		units = units[:i] + "," + units[i:]
+	}
+	return fmt.Sprintf("%s%s.%02d %s", sign, units, amount%100, issuer.Currency)
+}
+
+// FormatRate formats a VAT rate in hundredths of a percent, e.g. 1900 as 19 % and 810 as 8.1 %
+func FormatRate(rate int) string {
+	if rate%100 == 0 {
+		return fmt.Sprintf("%d %%", rate/100)
+	}
+	return strings.TrimRight(fmt.Sprintf("%d.%02d", rate/100, rate%100), "0") + " %"
+}
+
+// Filename is the name the PDF of the invoice is downloaded as
+func Filename(invoice DatabaseAbstraction.Invoice) string {
+	return invoice.Number + ".pdf"
+}
+
+// column positions of the item table
+const (
+	marginLeft     = 50.0
+	marginRight    = pageWidth - 50.0
+	quantityRight  = 340.0
+	discountRight  = 440.0
+	rowHeight      = 16.0
+	marginBottom   = 90.0
+	maxDescription = quantityRight - 40 - marginLeft
+)
+
+// truncate shortens the text with an ellipsis until it fits the width
+func truncate(text string, size float64, width float64) string {
+	if textWidth(text, size) <= width {
+		return text
+	}
+	runes := []rune(text)
+	for len(runes) > 0 && textWidth(string(runes)+"...", size) > width {
+		runes = runes[:len(runes)-1]
+	}
+	return string(runes) + "..."
+}
+
+// Render returns the invoice as a PDF, items that don't fit on the first page continue on the next ones
+func Render(invoice DatabaseAbstraction.Invoice, issuer Issuer) []byte {
+	doc := &document{}
+
+	y := float64(pageHeight - 60)
+	doc.text(marginLeft, y, 16, true, issuer.Name)
+	for _, line := range issuer.Address {
+		y -= 13
+		doc.text(marginLeft, y, 10, false, line)
+	}
+	if issuer.VATID != "" {
+		y -= 13
+		doc.text(marginLeft, y, 10, false, "VAT ID: "+issuer.VATID)
+	}
+
+	title := "Invoice"
+	switch invoice.Kind {
+	case DatabaseAbstraction.InvoiceTopUp:
+		title = "Receipt"
+	case DatabaseAbstraction.InvoiceCreditNote:
+		title = "Credit note"
+	}
+	y -= 45
+	doc.text(marginLeft, y, 20, true, title)
+	doc.textRight(marginRight, y, 10, false, "Number: "+invoice.Number)
+	doc.textRight(marginRight, y-13, 10, false, "Date: "+invoice.CreatedAt.Format("2006-01-02"))
+	if invoice.Cancels != "" {
+		doc.textRight(marginRight, y-26, 10, false, "Cancels invoice: "+invoice.Cancels)
+	}
+
+	y -= 35
+	doc.text(marginLeft, y, 10, true, "Billed to")
+	for _, line := range []string{invoice.BillingName, invoice.BillingEmail, invoice.Country} {
+		if line != "" {
+			y -= 13
+			doc.text(marginLeft, y, 10, false, line)
+		}
+	}
+
+	header := func(y float64) {
+		doc.text(marginLeft, y, 10, true, "Description")
+		doc.textRight(quantityRight, y, 10, true, "Qty")
+		doc.textRight(discountRight, y, 10, true, "Discount")
+		doc.textRight(marginRight, y, 10, true, "Amount")
+		doc.line(marginLeft, y-5, marginRight, y-5)
+	}
+	y -= 40
+	header(y)
+
+	for _, item := range invoice.Items {
+		y -= rowHeight
+		if y < marginBottom {
+			doc.addPage()
+			y = pageHeight - 60
+			header(y)
+			y -= rowHeight
+		}
+		doc.text(marginLeft, y, 10, false, truncate(item.Description, 10, maxDescription))
+		doc.textRight(quantityRight, y, 10, false, fmt.Sprint(item.Quantity))
+		if item.Discount > 0 {
+			doc.textRight(discountRight, y, 10, false, issuer.FormatAmount(-item.Discount))
+		}
+		doc.textRight(marginRight, y, 10, false, issuer.FormatAmount(item.Amount))
+	}
+
+	// the totals and the notes below them stay together
+	if y-5*rowHeight < marginBottom {
+		doc.addPage()
+		y = pageHeight - 60
+	}
+	doc.line(marginLeft, y-8, marginRight, y-8)
+	y -= 8
+	if invoice.VATRate > 0 {
+		y -= rowHeight
+		doc.text(discountRight-100, y, 10, false, "Net amount")
+		doc.textRight(marginRight, y, 10, false, issuer.FormatAmount(invoice.Net))
+		y -= rowHeight
+		doc.text(discountRight-100, y, 10, false, "VAT "+FormatRate(invoice.VATRate))
+		doc.textRight(marginRight, y, 10, false, issuer.FormatAmount(invoice.VAT))
+	}
+	y -= rowHeight
+	doc.text(discountRight-100, y, 10, true, "Total")
+	doc.textRight(marginRight, y, 10, true, issuer.FormatAmount(invoice.Gross))
+
+	y -= 2 * rowHeight
+	date := invoice.CreatedAt.Format("2006-01-02")
+	switch {
+	case invoice.Kind == DatabaseAbstraction.InvoiceCreditNote:
+		doc.text(marginLeft, y, 9, false, fmt.Sprintf("The refund was credited to your %s balance on %s.", issuer.Name, date))
+		if invoice.VATRate > 0 {
+			doc.text(marginLeft, y-12, 9, false, "The VAT of the cancelled invoice is corrected by the amount above.")
+		}
+	case invoice.Kind == DatabaseAbstraction.InvoiceTopUp:
+		doc.text(marginLeft, y, 9, false, fmt.Sprintf("Your %s balance was topped up on %s.", issuer.Name, date))
+		doc.text(marginLeft, y-12, 9, false, "The balance can be spent on any product, VAT is charged on the invoices of those purchases.")
+	case invoice.VATRate == 0:
+		doc.text(marginLeft, y, 9, false, fmt.Sprintf("Paid with the %s balance on %s. No VAT is charged.", issuer.Name, date))
+	default:
+		doc.text(marginLeft, y, 9, false, fmt.Sprintf("Paid with the %s balance on %s. All prices include VAT.", issuer.Name, date))
+	}
+
+	return doc.bytes(title + " " + invoice.Number)
+}
@@ -0,0 +1,133 @@
+package Invoicing_test
+
+import (
+	"EntitlementServer/DatabaseAbstraction"
+	"EntitlementServer/Invoicing"
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSplitVAT(t *testing.T) {
+	for _, c := range []struct{ gross, rate, net, vat int }{
+		{1000, 1900, 840, 160},
+		{1190, 1900, 1000, 190},
+		{999, 810, 924, 75},
+		{500, 0, 500, 0},
+		{0, 1900, 0, 0},
+		// credit notes split like the invoice they cancel
+		{-1000, 1900, -840, -160},
+	} {
+		net, vat := DatabaseAbstraction.SplitVAT(c.gross, c.rate)
+		assert.Equal(t, c.net, net, c)
+		assert.Equal(t, c.vat, vat, c)
+	}
+}
+
+func TestFormat(t *testing.T) {
+	issuer := Invoicing.DefaultIssuer
+	assert.Equal(t, "1,234.56 EUR", issuer.FormatAmount(123456))
+	assert.Equal(t, "0.05 EUR", issuer.FormatAmount(5))
+	assert.Equal(t, "-2.00 EUR", issuer.FormatAmount(-200))
+	assert.Equal(t, "1,000,000.00 EUR", issuer.FormatAmount(100000000))
+
+	assert.Equal(t, "19 %", Invoicing.FormatRate(1900))
+	assert.Equal(t, "8.1 %", Invoicing.FormatRate(810))
+	assert.Equal(t, "2.55 %", Invoicing.FormatRate(255))
+
+	assert.Equal(t, "INV-2026-000042", DatabaseAbstraction.FormatInvoiceNumber(2026, 42))
+}
+
+func TestNormalizeCountry(t *testing.T) {
+	country, err := Invoicing.NormalizeCountry(" at ")
+	assert.NoError(t, err)
+	assert.Equal(t, "AT", country)
+
+	for _, invalid := range []string{"", "D", "DEU", "EU", "ZZ", "12"} {
+		_, err = Invoicing.NormalizeCountry(invalid)
+		assert.ErrorIs(t, err, Invoicing.ErrInvalidCountry, invalid)
+	}
+}
+
+func TestIssuerFromEnv(t *testing.T) {
+	t.Setenv("INVOICE_ISSUER_NAME", "BKB Akademie GmbH")
+	t.Setenv("INVOICE_ISSUER_ADDRESS", "Hauptstraße 1; ;12345 Berlin")
+	t.Setenv("INVOICE_ISSUER_VAT_ID", "DE123456789")
+	t.Setenv("INVOICE_CURRENCY", "")
+
+	issuer := Invoicing.IssuerFromEnv()
+	assert.Equal(t, "BKB Akademie GmbH", issuer.Name)
+	assert.Equal(t, []string{"Hauptstraße 1", "12345 Berlin"}, issuer.Address)
+	assert.Equal(t, "DE123456789", issuer.VATID)
+	assert.Equal(t, "EUR", issuer.Currency)
+}
+
+// checkPDF verifies that every entry of the cross-reference table points at its object
+func checkPDF(t *testing.T, pdf []byte) {
+	assert.True(t, bytes.HasPrefix(pdf, []byte("%PDF-1.4\n")))
+	assert.True(t, bytes.HasSuffix(pdf, []byte("%%EOF\n")))
+
+	startxref := regexp.MustCompile(`startxref\n(\d+)\n`).FindSubmatch(pdf)
+	if !assert.NotNil(t, startxref) {
+		return
+	}
+	xref, _ := strconv.Atoi(string(startxref[1]))
+	assert.True(t, bytes.HasPrefix(pdf[xref:], []byte("xref\n")))
+
+	offsets := regexp.MustCompile(`(\d{10}) 00000 n \n`).FindAllSubmatch(pdf[xref:], -1)
+	assert.NotEmpty(t, offsets)
+	for i, offset := range offsets {
+		position, _ := strconv.Atoi(string(offset[1]))
+		assert.True(t, bytes.HasPrefix(pdf[position:], []byte(fmt.Sprintf("%d 0 obj\n", i+1))), "object %d", i+1)
+	}
+}
+
+func TestRender(t *testing.T) {
+	invoice := DatabaseAbstraction.Invoice{
+		Number: "INV-2026-000001", Kind: DatabaseAbstraction.InvoicePurchase, BillingName: "Grundschule (Süd)", BillingEmail: "sekretariat@example.org",
+		Country: "DE", VATRate: 1900, Gross: 1800, Net: 1513, VAT: 287, CreatedAt: time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC),
+		Items: []DatabaseAbstraction.InvoiceItem{
+			{Description: "PHP Fundament", Quantity: 1, Amount: 800, Discount: 200},
+			{Description: "License keys for JavaScript Grundlagen", Quantity: 2, Amount: 1000},
+		},
+	}
+
+	pdf := Invoicing.Render(invoice, Invoicing.DefaultIssuer)
+	checkPDF(t, pdf)
+	assert.Contains(t, string(pdf), "/Count 1 ")
+	assert.Contains(t, string(pdf), "(Invoice)")
+	assert.Contains(t, string(pdf), "(VAT 19 %)")
+	// parentheses are escaped and umlauts encoded as WinAnsi
+	assert.Contains(t, string(pdf), "(Grundschule \\(S\xfcd\\))")
+
+	// items that don't fit continue on further pages
+	invoice.Items = nil
+	for i := 0; i < 80; i++ {
+		invoice.Items = append(invoice.Items, DatabaseAbstraction.InvoiceItem{Description: fmt.Sprint("Seats for course ", i), Quantity: 1, Amount: 100})
+	}
+	pdf = Invoicing.Render(invoice, Invoicing.DefaultIssuer)
+	checkPDF(t, pdf)
+	assert.Regexp(t, `/Count [2-9] `, string(pdf))
+
+	// top-ups are receipts without VAT
+	receipt := DatabaseAbstraction.Invoice{Number: "INV-2026-000002", Kind: DatabaseAbstraction.InvoiceTopUp, BillingName: "user", Gross: 5000, Net: 5000,
+		Items: []DatabaseAbstraction.InvoiceItem{{Description: "Balance top-up", Quantity: 1, Amount: 5000}}}
+	pdf = Invoicing.Render(receipt, Invoicing.DefaultIssuer)
+	checkPDF(t, pdf)
+	assert.Contains(t, string(pdf), "(Receipt)")
+	assert.NotContains(t, string(pdf), "(VAT ")
+
+	// refunds are cancelled by a credit note with negative amounts
+	creditNote := DatabaseAbstraction.Invoice{Number: "INV-2026-000003", Kind: DatabaseAbstraction.InvoiceCreditNote, BillingName: "user", Country: "DE",
+		VATRate: 1900, Gross: -1000, Net: -840, VAT: -160, Cancels: "INV-2026-000001",
+		Items: []DatabaseAbstraction.InvoiceItem{{Description: "Refund of PHP Fundament", Quantity: 1, Amount: -1000}}}
+	pdf = Invoicing.Render(creditNote, Invoicing.DefaultIssuer)
+	checkPDF(t, pdf)
+	assert.Contains(t, string(pdf), "(Credit note)")
+	assert.Contains(t, string(pdf), "(Cancels invoice: INV-2026-000001)")
+	assert.Contains(t, string(pdf), "(-1.60 EUR)")
+}
@@ -0,0 +1,182 @@
+package JWTManagement
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// Minimal JWS compact serialization support (RFC 7515/7519) for the algorithms we actually use
+// Only asymmetric algorithms are accepted, "none" and HMAC tokens are always rejected
+const (
+	AlgorithmRS256 = "RS256"
+	AlgorithmES256 = "ES256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+var (
+	ErrMalformedToken       = errors.New("malformed token")
+	ErrUnsupportedAlgorithm = errors.New("unsupported signing algorithm")
+	ErrInvalidSignature     = errors.New("invalid token signature")
+	ErrTokenExpired         = errors.New("token has expired")
+	ErrTokenNotYetValid     = errors.New("token is not valid yet")
+	ErrInvalidIssuer        = errors.New("unexpected token issuer")
+	ErrInvalidAudience      = errors.New("token is not intended for this audience")
+)
+
+type Header struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid,omitempty"`
+	Type      string `json:"typ,omitempty"`
+}
+
+// Audience is a string or an array of strings in JSON
+type Audience []string
+
+func (a *Audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if json.Unmarshal(data, &single) == nil {
+		*a = Audience{single}
+		return nil
+	}
+
+	var multiple []string
+	err := json.Unmarshal(data, &multiple)
+	if err != nil {
+		return err
+	}
+	*a = multiple
+	return nil
+}
+
+func (a Audience) Contains(audience string) bool {
+	for _, entry := range a {
+		if entry == audience {
+			return true
+		}
+	}
+	return false
+}
+
+// RegisteredClaims are the standard claims, embed it into token specific claim structs
+type RegisteredClaims struct {
+	Issuer    string   `json:"iss,omitempty"`
+	Subject   string   `json:"sub,omitempty"`
+	Audience  Audience `json:"aud,omitempty"`
+	ExpiresAt int64    `json:"exp,omitempty"`
+	NotBefore int64    `json:"nbf,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+	ID        string   `json:"jti,omitempty"`
+}
+
+// Validate checks issuer, audience and the validity period, leeway tolerates clock differences between servers
+func (c RegisteredClaims) Validate(issuer string, audience string, now time.Time, leeway time.Duration) error {
+	if c.Issuer != issuer {
+		return ErrInvalidIssuer
+	}
+	if !c.Audience.Contains(audience) {
+		return ErrInvalidAudience
+	}
+	if c.ExpiresAt == 0 || now.Add(-leeway).Unix() >= c.ExpiresAt {
+		return ErrTokenExpired
+	}
+	if c.NotBefore != 0 && now.Add(leeway).Unix() < c.NotBefore {
+		return ErrTokenNotYetValid
+	}
+	return nil
+}
+
+// KeyFunc selects the verification key for a token, e.g. by looking up the kid in a key set
+type KeyFunc func(header Header) (crypto.PublicKey, error)
+
+// Verify checks the signature of a compact JWS and unmarshals its payload into claims
+// The claims are not validated, call Validate on the RegisteredClaims afterwards
+func Verify(token string, keyFunc KeyFunc, claims interface{}) (Header, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Header{}, ErrMalformedToken
+	}
+
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return Header{}, ErrMalformedToken
+	}
+	var header Header
+	err = json.Unmarshal(headerJSON, &header)
+	if err != nil {
+		return Header{}, ErrMalformedToken
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Header{}, ErrMalformedToken
+	}
+
+	key, err := keyFunc(header)
+	if err != nil {
+		return Header{}, err
+	}
+
+	err = verifySignature(header.Algorithm, key, []byte(parts[0]+"."+parts[1]), signature)
+	if err != nil {
+		return Header{}, err
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return Header{}, ErrMalformedToken
+	}
+	err = json.Unmarshal(payload, claims)
+	if err != nil {
+		return Header{}, ErrMalformedToken
+	}
+
+	return header, nil
+}
+
+// verifySignature only accepts the key type belonging to the algorithm, so an RSA key can't be abused for another algorithm
+func verifySignature(algorithm string, key crypto.PublicKey, signingInput []byte, signature []byte) error {
+	switch algorithm {
+	case AlgorithmRS256:
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return ErrUnsupportedAlgorithm
+		}
+		digest := sha256.Sum256(signingInput)
+		if rsa.VerifyPKCS1v15(rsaKey, crypto.SHA256, digest[:], signature) != nil {
+			return ErrInvalidSignature
+		}
+		return nil
+	case AlgorithmES256:
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok || ecKey.Curve.Params().BitSize != 256 || len(signature) != 64 {
+			return ErrInvalidSignature
+		}
+		digest := sha256.Sum256(signingInput)
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(ecKey, digest[:], r, s) {
+			return ErrInvalidSignature
+		}
+		return nil
+	case AlgorithmEdDSA:
+		edKey, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return ErrUnsupportedAlgorithm
+		}
+		if !ed25519.Verify(edKey, signingInput, signature) {
+			return ErrInvalidSignature
+		}
+		return nil
+	default:
+		return ErrUnsupportedAlgorithm
+	}
+}
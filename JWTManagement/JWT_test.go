@@ -0,0 +1,101 @@
+package JWTManagement
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testKeys(t *testing.T) []SigningKey {
+	edKey, err := GenerateSigningKey()
+	assert.NoError(t, err)
+
+	ecPrivate, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	ecKey, err := NewSigningKey(ecPrivate)
+	assert.NoError(t, err)
+
+	rsaPrivate, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	rsaKey, err := NewSigningKey(rsaPrivate)
+	assert.NoError(t, err)
+
+	return []SigningKey{edKey, ecKey, rsaKey}
+}
+
+func TestSignAndVerify(t *testing.T) {
+	for _, key := range testKeys(t) {
+		t.Run(key.Algorithm, func(t *testing.T) {
+			ring := &KeyRing{Keys: []SigningKey{key}}
+			claims := RegisteredClaims{Issuer: "issuer", Subject: "1", Audience: Audience{"api"}, ExpiresAt: time.Now().Add(time.Minute).Unix()}
+
+			token, err := ring.Sign(claims)
+			assert.NoError(t, err)
+
+			var parsed RegisteredClaims
+			header, err := Verify(token, ring.KeyFunc, &parsed)
+			assert.NoError(t, err)
+			assert.Equal(t, key.KeyID, header.KeyID)
+			assert.Equal(t, key.Algorithm, header.Algorithm)
+			assert.NoError(t, parsed.Validate("issuer", "api", time.Now(), 0))
+
+			// the published key verifies the token as well
+			_, err = Verify(token, func(header Header) (crypto.PublicKey, error) {
+				jwk, err := ring.KeySet().Find(header.KeyID)
+				if err != nil {
+					return nil, err
+				}
+				return jwk.PublicKey()
+			}, &parsed)
+			assert.NoError(t, err)
+
+			parts := strings.Split(token, ".")
+			tampered, _ := json.Marshal(RegisteredClaims{Issuer: "issuer", Subject: "2", Audience: Audience{"api"}, ExpiresAt: claims.ExpiresAt})
+			_, err = Verify(parts[0]+"."+base64.RawURLEncoding.EncodeToString(tampered)+"."+parts[2], ring.KeyFunc, &parsed)
+			assert.ErrorIs(t, err, ErrInvalidSignature)
+		})
+	}
+}
+
+func TestVerifyRejectsAlgorithmConfusion(t *testing.T) {
+	keys := testKeys(t)
+	ring := &KeyRing{Keys: keys}
+
+	token, err := Sign(keys[2], RegisteredClaims{Subject: "1"})
+	assert.NoError(t, err)
+	parts := strings.Split(token, ".")
+
+	for _, algorithm := range []string{"none", "HS256", AlgorithmEdDSA} {
+		header, _ := json.Marshal(Header{Algorithm: algorithm, KeyID: keys[2].KeyID})
+		forged := base64.RawURLEncoding.EncodeToString(header) + "." + parts[1] + "." + parts[2]
+
+		var claims RegisteredClaims
+		_, err = Verify(forged, ring.KeyFunc, &claims)
+		assert.Error(t, err, algorithm)
+	}
+}
+
+func TestKeyRingRotation(t *testing.T) {
+	keys := testKeys(t)
+	oldRing := &KeyRing{Keys: keys[:1]}
+	newRing := &KeyRing{Keys: []SigningKey{keys[1], keys[0]}}
+
+	oldToken, err := oldRing.Sign(RegisteredClaims{Subject: "1"})
+	assert.NoError(t, err)
+
+	var claims RegisteredClaims
+	_, err = Verify(oldToken, newRing.KeyFunc, &claims)
+	assert.NoError(t, err)
+	assert.Len(t, newRing.KeySet().Keys, 2)
+
+	_, err = Verify(oldToken, (&KeyRing{Keys: keys[1:2]}).KeyFunc, &claims)
+	assert.ErrorIs(t, err, ErrUnknownKey)
+}
@@ -0,0 +1,234 @@
+package JWTManagement
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+)
+
+var ErrNoSigningKey = errors.New("no signing key configured")
+
+// SigningKey is a private key used to issue tokens, the KeyID is the RFC 7638 thumbprint of the public key
+type SigningKey struct {
+	KeyID      string
+	Algorithm  string
+	PrivateKey crypto.Signer
+}
+
+// NewSigningKey picks the algorithm matching the key type: EdDSA for Ed25519, ES256 for P-256 and RS256 for RSA keys
+func NewSigningKey(privateKey crypto.Signer) (SigningKey, error) {
+	key := SigningKey{PrivateKey: privateKey}
+	switch k := privateKey.Public().(type) {
+	case ed25519.PublicKey:
+		key.Algorithm = AlgorithmEdDSA
+	case *ecdsa.PublicKey:
+		if k.Curve != elliptic.P256() {
+			return SigningKey{}, ErrUnsupportedKeyType
+		}
+		key.Algorithm = AlgorithmES256
+	case *rsa.PublicKey:
+		if k.N.BitLen() < 2048 {
+			return SigningKey{}, ErrUnsupportedKeyType
+		}
+		key.Algorithm = AlgorithmRS256
+	default:
+		return SigningKey{}, ErrUnsupportedKeyType
+	}
+
+	thumbprint, err := key.JSONWebKey().Thumbprint()
+	if err != nil {
+		return SigningKey{}, err
+	}
+	key.KeyID = thumbprint
+
+	return key, nil
+}
+
+// GenerateSigningKey creates a new Ed25519 key
+func GenerateSigningKey() (SigningKey, error) {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return SigningKey{}, err
+	}
+	return NewSigningKey(privateKey)
+}
+
+// LoadSigningKey reads a PEM encoded private key (PKCS#8, PKCS#1 RSA or SEC 1 EC)
+func LoadSigningKey(path string) (SigningKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return SigningKey{}, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return SigningKey{}, errors.New(path + " does not contain a PEM block")
+	}
+
+	var privateKey interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		privateKey, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		privateKey, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		privateKey, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return SigningKey{}, err
+	}
+
+	signer, ok := privateKey.(crypto.Signer)
+	if !ok {
+		return SigningKey{}, ErrUnsupportedKeyType
+	}
+	return NewSigningKey(signer)
+}
+
+// JSONWebKey returns the public part of the key for publishing in a JWKS document
+func (k SigningKey) JSONWebKey() JSONWebKey {
+	jwk := JSONWebKey{KeyID: k.KeyID, Use: "sig", Algorithm: k.Algorithm}
+	switch public := k.PrivateKey.Public().(type) {
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(public)
+	case *ecdsa.PublicKey:
+		jwk.KeyType = "EC"
+		jwk.Curve = "P-256"
+		jwk.X = base64.RawURLEncoding.EncodeToString(public.X.FillBytes(make([]byte, 32)))
+		jwk.Y = base64.RawURLEncoding.EncodeToString(public.Y.FillBytes(make([]byte, 32)))
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+	}
+	return jwk
+}
+
+// Thumbprint computes the RFC 7638 thumbprint over the required members of the key
+func (k JSONWebKey) Thumbprint() (string, error) {
+	// encoding/json sorts map keys, which gives the lexicographic member order the RFC asks for
+	var members map[string]string
+	switch k.KeyType {
+	case "RSA":
+		members = map[string]string{"e": k.E, "kty": k.KeyType, "n": k.N}
+	case "EC":
+		members = map[string]string{"crv": k.Curve, "kty": k.KeyType, "x": k.X, "y": k.Y}
+	case "OKP":
+		members = map[string]string{"crv": k.Curve, "kty": k.KeyType, "x": k.X}
+	default:
+		return "", ErrUnsupportedKeyType
+	}
+
+	encoded, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+	digest := sha256.Sum256(encoded)
+	return base64.RawURLEncoding.EncodeToString(digest[:]), nil
+}
+
+// Sign creates a compact JWS of the claims
+func Sign(key SigningKey, claims interface{}) (string, error) {
+	header, err := json.Marshal(Header{Algorithm: key.Algorithm, KeyID: key.KeyID, Type: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	var signature []byte
+	switch key.Algorithm {
+	case AlgorithmEdDSA:
+		signature, err = key.PrivateKey.Sign(rand.Reader, []byte(signingInput), crypto.Hash(0))
+	case AlgorithmRS256:
+		digest := sha256.Sum256([]byte(signingInput))
+		signature, err = key.PrivateKey.Sign(rand.Reader, digest[:], crypto.SHA256)
+	case AlgorithmES256:
+		ecKey, ok := key.PrivateKey.(*ecdsa.PrivateKey)
+		if !ok {
+			return "", ErrUnsupportedKeyType
+		}
+		digest := sha256.Sum256([]byte(signingInput))
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, ecKey, digest[:])
+		if err == nil {
+			// JWS uses the fixed size r||s encoding instead of ASN.1
+			signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+		}
+	default:
+		return "", ErrUnsupportedAlgorithm
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// KeyRing holds the keys of an issuer, the first key signs new tokens
+// Older keys stay in the ring after a rotation until the tokens they signed have expired
+type KeyRing struct {
+	Keys []SigningKey
+}
+
+// LoadKeyRing reads the PEM files in order, the first one becomes the signing key
+func LoadKeyRing(paths []string) (*KeyRing, error) {
+	ring := &KeyRing{}
+	for _, path := range paths {
+		key, err := LoadSigningKey(path)
+		if err != nil {
+			return nil, err
+		}
+		ring.Keys = append(ring.Keys, key)
+	}
+	return ring, nil
+}
+
+func (r *KeyRing) Sign(claims interface{}) (string, error) {
+	if r == nil || len(r.Keys) == 0 {
+		return "", ErrNoSigningKey
+	}
+	return Sign(r.Keys[0], claims)
+}
+
+// KeySet returns the public keys of the ring
+func (r *KeyRing) KeySet() KeySet {
+	set := KeySet{Keys: []JSONWebKey{}}
+	if r == nil {
+		return set
+	}
+	for _, key := range r.Keys {
+		set.Keys = append(set.Keys, key.JSONWebKey())
+	}
+	return set
+}
+
+// KeyFunc verifies tokens with the key named in the kid header, use it with Verify
+func (r *KeyRing) KeyFunc(header Header) (crypto.PublicKey, error) {
+	if r == nil {
+		return nil, ErrUnknownKey
+	}
+	for _, key := range r.Keys {
+		if key.KeyID == header.KeyID {
+			return key.PrivateKey.Public(), nil
+		}
+	}
+	return nil, ErrUnknownKey
+}
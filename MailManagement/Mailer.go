@@ -0,0 +1,132 @@
+package MailManagement
+
+import (
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"net/smtp"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Mailer delivers plain text mails to users
+// The SMTP implementation is used in production, the log implementation during local development
+type Mailer interface {
+	SendMail(to string, subject string, body string) error
+}
+
+var ErrInvalidHeader = errors.New("mail header contains a line break")
+
+// SMTPMailer sends mails through an SMTP relay using PLAIN authentication
+type SMTPMailer struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+func (m SMTPMailer) SendMail(to string, subject string, body string) error {
+	msg, err := buildMessage(m.From, to, subject, body)
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+
+	return smtp.SendMail(fmt.Sprintf("%s:%d", m.Host, m.Port), auth, m.From, []string{to}, msg)
+}
+
+// LogMailer writes mails to a file instead of sending them, or to the log if no file is set
+type LogMailer struct {
+	Path string
+}
+
+var logMailerMutex sync.Mutex
+
+func (m LogMailer) SendMail(to string, subject string, body string) error {
+	msg, err := buildMessage("noreply@localhost", to, subject, body)
+	if err != nil {
+		return err
+	}
+
+	if m.Path == "" {
+		logrus.Infof("Mail to %s:\n%s", to, msg)
+		return nil
+	}
+
+	// Multiple requests may send mails at the same time, don't interleave them in the file
+	logMailerMutex.Lock()
+	defer logMailerMutex.Unlock()
+
+	file, err := os.OpenFile(m.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.Write(append(msg, []byte("\r\n\r\n")...))
+	return err
+}
+
+// buildMessage assembles an RFC 5322 message, rejecting header values that could inject further headers
+func buildMessage(from string, to string, subject string, body string) ([]byte, error) {
+	for _, header := range []string{from, to, subject} {
+		if strings.ContainsAny(header, "\r\n") {
+			return nil, ErrInvalidHeader
+		}
+	}
+
+	var builder strings.Builder
+	builder.WriteString("From: " + from + "\r\n")
+	builder.WriteString("To: " + to + "\r\n")
+	builder.WriteString("Subject: " + subject + "\r\n")
+	builder.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	builder.WriteString("MIME-Version: 1.0\r\n")
+	builder.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	builder.WriteString("\r\n")
+	builder.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+
+	return []byte(builder.String()), nil
+}
+
+// NewMailerFromEnv selects the mailer with MAIL_DRIVER (smtp or log, default log)
+// SMTP is configured with SMTP_HOST, SMTP_PORT, SMTP_USER, SMTP_PASSWORD and MAIL_FROM, the log mailer with MAIL_LOG_FILE
+func NewMailerFromEnv() (Mailer, error) {
+	switch os.Getenv("MAIL_DRIVER") {
+	case "", "log":
+		return LogMailer{Path: os.Getenv("MAIL_LOG_FILE")}, nil
+	case "smtp":
+		port, err := strconv.Atoi(getenvWithFallback("SMTP_PORT", "587"))
+		if err != nil {
+			return nil, errors.New("SMTP_PORT is not a valid integer " + err.Error())
+		}
+		mailer := SMTPMailer{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     port,
+			Username: os.Getenv("SMTP_USER"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     os.Getenv("MAIL_FROM"),
+		}
+		if mailer.Host == "" || mailer.From == "" {
+			return nil, errors.New("SMTP_HOST and MAIL_FROM are required for the smtp mail driver")
+		}
+		return mailer, nil
+	default:
+		return nil, fmt.Errorf("unknown MAIL_DRIVER %q", os.Getenv("MAIL_DRIVER"))
+	}
+}
+
+func getenvWithFallback(key string, fallback string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	return value
+}
@@ -0,0 +1,34 @@
+package MailManagement_test
+
+import (
+	"EntitlementServer/MailManagement"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLogMailer_SendMail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mails.log")
+	mailer := MailManagement.LogMailer{Path: path}
+
+	err := mailer.SendMail("student@example.com", "Reset your password", "Hello\nworld")
+	assert.NoError(t, err)
+
+	content, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), "To: student@example.com\r\n")
+	assert.Contains(t, string(content), "Subject: Reset your password\r\n")
+	assert.Contains(t, string(content), "Hello\r\nworld")
+}
+
+func TestLogMailer_SendMailHeaderInjection(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mails.log")
+	mailer := MailManagement.LogMailer{Path: path}
+
+	err := mailer.SendMail("student@example.com\r\nBcc: attacker@example.com", "Reset your password", "body")
+	assert.ErrorIs(t, err, MailManagement.ErrInvalidHeader)
+
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,509 @@
+package OIDCService
+
+import (
+	"EntitlementServer/AuditLog"
+	"EntitlementServer/AuthenticationManagement"
+	"EntitlementServer/DatabaseAbstraction"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// a login has to be completed at the provider within this time
+const loginFlowLifetime = 10 * time.Minute
+
+// the state cookie ties the callback to the browser that started the login
+const stateCookieName = "oidc_state"
+
+var (
+	ErrUnknownProvider    = errors.New("unknown identity provider")
+	ErrIdentityInUse      = errors.New("this external account is already linked to another user")
+	ErrLastLoginMethod    = errors.New("the account has no email address to reset its password, it can't be unlinked")
+	usernameDisallowedRun = regexp.MustCompile(`[^a-z0-9._-]+`)
+)
+
+// OIDCService is a relying party for external OpenID Connect identity providers (e.g. school SSO)
+// External identities are linked to local users, who then get regular session tokens
+type OIDCService struct {
+	DB        DatabaseAbstraction.DBOrm
+	Auth      AuthenticationManagement.AuthenticationManager
+	Providers map[string]*Provider
+	PublicURL string           // base URL for redirect URIs and the redirect after login
+	Audit     AuditLog.Auditor // records logins
+}
+
+func (s OIDCService) RegisterHandlers(r *gin.Engine, middleware ...gin.HandlerFunc) {
+	r.GET("/api/auth/oidc/providers", s.GetProvidersHandler)
+	r.GET("/api/auth/oidc/identities", middleware[0], s.GetIdentitiesHandler)
+	r.GET("/api/auth/oidc/:provider/login", s.LoginHandler)
+	r.GET("/api/auth/oidc/:provider/link", middleware[0], s.LinkHandler)
+	r.GET("/api/auth/oidc/:provider/callback", s.CallbackHandler)
+	r.DELETE("/api/auth/oidc/:provider", middleware[0], s.UnlinkHandler)
+}
+
+func (s OIDCService) GetLabel() string {
+	return "OIDC Service"
+}
+
+func (s OIDCService) publicURL() string {
+	if s.PublicURL == "" {
+		return "http://localhost:8080"
+	}
+	return strings.TrimSuffix(s.PublicURL, "/")
+}
+
+func (s OIDCService) redirectURI(providerName string) string {
+	return fmt.Sprintf("%s/api/auth/oidc/%s/callback", s.publicURL(), providerName)
+}
+
+func randomString(length int) (string, error) {
+	randomBytes := make([]byte, length)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(randomBytes), nil
+}
+
+// StartLogin creates the state, nonce and PKCE verifier of a new login and returns the provider's authorization URL and the state
+// userID is set when a signed-in user links an additional identity
+func (s OIDCService) StartLogin(providerName string, userID int) (string, string, error) {
+	provider, found := s.Providers[providerName]
+	if !found {
+		return "", "", ErrUnknownProvider
+	}
+
+	flow := DatabaseAbstraction.OIDCLoginFlow{
+		Provider: providerName,
+		UserID:   userID,
+		Expiry:   time.Now().Add(loginFlowLifetime),
+	}
+	var err error
+	for _, target := range []*string{&flow.State, &flow.Nonce, &flow.CodeVerifier} {
+		*target, err = randomString(32)
+		if err != nil {
+			return "", "", err
+		}
+	}
+
+	challenge := sha256.Sum256([]byte(flow.CodeVerifier))
+	authorizationURL, err := provider.AuthorizationURL(s.redirectURI(providerName), flow.State, flow.Nonce, base64.RawURLEncoding.EncodeToString(challenge[:]))
+	if err != nil {
+		return "", "", err
+	}
+
+	err = s.DB.AddOIDCLoginFlow(flow)
+	if err != nil {
+		return "", "", err
+	}
+
+	return authorizationURL, flow.State, nil
+}
+
+// FinishLogin handles the callback of the provider and returns the local user the identity belongs to
+func (s OIDCService) FinishLogin(providerName string, state string, code string) (DatabaseAbstraction.User, error) {
+	provider, found := s.Providers[providerName]
+	if !found {
+		return DatabaseAbstraction.User{}, ErrUnknownProvider
+	}
+
+	// consuming the flow makes the state single-use, even if the rest of the login fails
+	flow, err := s.DB.ConsumeOIDCLoginFlow(state)
+	if err != nil || flow.Provider != providerName {
+		return DatabaseAbstraction.User{}, errors.New("invalid or expired login state")
+	}
+
+	rawIDToken, err := provider.Exchange(code, s.redirectURI(providerName), flow.CodeVerifier)
+	if err != nil {
+		return DatabaseAbstraction.User{}, err
+	}
+
+	claims, err := provider.VerifyIDToken(rawIDToken, flow.Nonce)
+	if err != nil {
+		return DatabaseAbstraction.User{}, err
+	}
+
+	if flow.UserID != 0 {
+		return s.linkIdentity(flow.UserID, providerName, claims)
+	}
+
+	return s.resolveUser(providerName, claims)
+}
+
+func (s OIDCService) linkIdentity(userID int, providerName string, claims IDTokenClaims) (DatabaseAbstraction.User, error) {
+	identity, err := s.DB.GetUserIdentity(providerName, claims.Subject)
+	if err == nil && identity.UserID != userID {
+		return DatabaseAbstraction.User{}, ErrIdentityInUse
+	}
+	if err != nil {
+		err = s.DB.AddUserIdentity(userID, providerName, claims.Subject, claims.Email)
+		if err != nil {
+			return DatabaseAbstraction.User{}, err
+		}
+		logrus.Infof("User %d linked %s identity %s", userID, providerName, claims.Subject)
+	}
+
+	return s.DB.GetUserByIndexID(userID)
+}
+
+// resolveUser finds the user of an identity, links it to an existing user with the same verified email address,
+// or creates a new user
+func (s OIDCService) resolveUser(providerName string, claims IDTokenClaims) (DatabaseAbstraction.User, error) {
+	identity, err := s.DB.GetUserIdentity(providerName, claims.Subject)
+	if err == nil {
+		return s.DB.GetUserByIndexID(identity.UserID)
+	}
+
+	// Both sides have to vouch for the address, otherwise anyone could register a victim's
+	// address locally (or at a sloppy provider) and take over the other account
+	email, emailErr := AuthenticationManagement.NormalizeEmail(claims.Email)
+	if emailErr == nil && claims.EmailVerified {
+		existing, err := s.DB.GetUserByEmail(email)
+		if err == nil && existing.EmailVerified {
+			return s.linkIdentity(existing.IndexID, providerName, claims)
+		}
+	}
+
+	user, err := s.createUser(claims)
+	if err != nil {
+		return DatabaseAbstraction.User{}, err
+	}
+
+	return s.linkIdentity(user.IndexID, providerName, claims)
+}
+
+// createUser registers a user for an external identity
+// The password is random, the user can set one with the password reset flow if the account has an email address
+func (s OIDCService) createUser(claims IDTokenClaims) (DatabaseAbstraction.User, error) {
+	password, err := randomString(32)
+	if err != nil {
+		return DatabaseAbstraction.User{}, err
+	}
+
+	email, err := AuthenticationManagement.NormalizeEmail(claims.Email)
+	if err != nil {
+		email = ""
+	}
+	if email != "" {
+		if _, err := s.DB.GetUserByEmail(email); err == nil {
+			// taken by an unverified local account, the new user just doesn't get the address
+			email = ""
+		}
+	}
+
+	base := usernameCandidate(claims)
+	for attempt := 0; attempt < 10; attempt++ {
+		username := base
+		if attempt > 0 {
+			username = base + strconv.Itoa(attempt+1)
+		}
+
+		err = s.Auth.CreateUser(username, password, email)
+		var validationErr *AuthenticationManagement.ValidationError
+		if errors.As(err, &validationErr) {
+			continue
+		}
+		if err != nil {
+			return DatabaseAbstraction.User{}, err
+		}
+
+		user, err := s.DB.GetUserByUsername(username)
+		if err != nil {
+			return DatabaseAbstraction.User{}, err
+		}
+		if email != "" && claims.EmailVerified {
+			err = s.DB.VerifyUserEmail(user.IndexID, email)
+			if err != nil {
+				return DatabaseAbstraction.User{}, err
+			}
+			user.EmailVerified = true
+		}
+
+		logrus.Infof("Created user %d for external identity %s", user.IndexID, claims.Subject)
+		return user, nil
+	}
+
+	return DatabaseAbstraction.User{}, errors.New("could not find a free username")
+}
+
+// usernameCandidate derives a username matching the default registration policy from the ID token
+func usernameCandidate(claims IDTokenClaims) string {
+	candidate := claims.PreferredUsername
+	if candidate == "" {
+		candidate, _, _ = strings.Cut(claims.Email, "@")
+	}
+
+	candidate = usernameDisallowedRun.ReplaceAllString(strings.ToLower(AuthenticationManagement.NormalizeUsername(candidate)), "-")
+	candidate = strings.Trim(candidate, ".-_")
+	if len(candidate) > 24 {
+		candidate = candidate[:24]
+	}
+	if len(candidate) < 3 {
+		candidate = "user" + candidate
+	}
+	return candidate
+}
+
+type providersResponse struct {
+	Providers []string `json:"providers"`
+}
+
+// GetProvidersHandler godoc
+//
+//	@Summary		List identity providers
+//	@Description	List the external identity providers users can log in with
+//	@Tags			Authentication
+//	@Produce		json
+//	@Success		200	{object}	providersResponse
+//	@Router			/api/auth/oidc/providers [get]
+func (s OIDCService) GetProvidersHandler(c *gin.Context) {
+	names := make([]string, 0, len(s.Providers))
+	for name := range s.Providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	c.JSON(200, providersResponse{Providers: names})
+}
+
+// LoginHandler godoc
+//
+//	@Summary		Log in with an identity provider
+//	@Description	Redirects the browser to the identity provider
+//	@Tags			Authentication
+//	@Param			provider	path	string	true	"Provider name"
+//	@Success		302
+//	@Failure		404	{object}	map[string]string
+//	@Failure		500	{object}	map[string]string
+//	@Router			/api/auth/oidc/{provider}/login [get]
+func (s OIDCService) LoginHandler(c *gin.Context) {
+	s.startFlow(c, 0)
+}
+
+// LinkHandler godoc
+//
+//	@Summary		Link an identity provider
+//	@Description	Redirects the browser to the identity provider to link the external account to the current user
+//	@Tags			Authentication
+//	@Param			provider	path	string	true	"Provider name"
+//	@Success		302
+//	@Failure		404	{object}	map[string]string
+//	@Failure		500	{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/api/auth/oidc/{provider}/link [get]
+func (s OIDCService) LinkHandler(c *gin.Context) {
+	user := c.MustGet("user").(DatabaseAbstraction.User)
+	s.startFlow(c, user.IndexID)
+}
+
+// stateCookie is only sent along with the callback, an empty state removes it
+func (s OIDCService) stateCookie(providerName string, state string) *http.Cookie {
+	maxAge := int(loginFlowLifetime.Seconds())
+	if state == "" {
+		maxAge = -1
+	}
+	return &http.Cookie{
+		Name:     stateCookieName,
+		Value:    state,
+		Path:     fmt.Sprintf("/api/auth/oidc/%s/callback", providerName),
+		MaxAge:   maxAge,
+		Secure:   strings.HasPrefix(s.publicURL(), "https://"),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode, // the provider redirects back with a top-level GET
+	}
+}
+
+func (s OIDCService) startFlow(c *gin.Context, userID int) {
+	authorizationURL, state, err := s.StartLogin(c.Param("provider"), userID)
+	if errors.Is(err, ErrUnknownProvider) {
+		c.JSON(404, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, gin.H{"error": "Failed to start login"})
+		return
+	}
+
+	http.SetCookie(c.Writer, s.stateCookie(c.Param("provider"), state))
+	c.Redirect(302, authorizationURL)
+}
+
+// CallbackHandler godoc
+//
+//	@Summary		Identity provider callback
+//	@Description	Completes a login or link started with /api/auth/oidc/{provider}/login or /link in the same browser
+//	@Description	Sets the authtoken cookie and redirects to the frontend
+//	@Description	Users with two-factor authentication are redirected to /login/2fa#challenge=... instead, the challenge completes the login at /api/auth/login/2fa
+//	@Tags			Authentication
+//	@Param			provider	path	string	true	"Provider name"
+//	@Param			state		query	string	true	"State"
+//	@Param			code		query	string	true	"Authorization code"
+//	@Success		302
+//	@Failure		400	{object}	map[string]string
+//	@Failure		403	{object}	map[string]string
+//	@Failure		500	{object}	map[string]string
+//	@Router			/api/auth/oidc/{provider}/callback [get]
+func (s OIDCService) CallbackHandler(c *gin.Context) {
+	if providerError := c.Query("error"); providerError != "" {
+		c.JSON(400, gin.H{"error": "Identity provider returned an error: " + providerError})
+		return
+	}
+
+	event := AuditLog.NewEvent(c, AuditLog.ActionLogin)
+	event.Details["method"] = "oidc"
+	event.Details["provider"] = c.Param("provider")
+
+	// a callback URL started by someone else must not sign this browser in to their account
+	state, _ := c.Cookie(stateCookieName)
+	http.SetCookie(c.Writer, s.stateCookie(c.Param("provider"), ""))
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(c.Query("state"))) != 1 {
+		event.Outcome = AuditLog.OutcomeFailure
+		event.Details["reason"] = "login state doesn't belong to this browser"
+		AuditLog.OrDefault(s.Audit).Record(event)
+		c.JSON(400, gin.H{"error": "External login failed, please start it again"})
+		return
+	}
+
+	user, err := s.FinishLogin(c.Param("provider"), c.Query("state"), c.Query("code"))
+	if err != nil {
+		event.Outcome = AuditLog.OutcomeFailure
+		event.Details["reason"] = err.Error()
+		AuditLog.OrDefault(s.Audit).Record(event)
+		logrus.Errorf("External login with %s failed: %v", c.Param("provider"), err)
+		c.JSON(400, gin.H{"error": "External login failed"})
+		return
+	}
+
+	// like the password login, suspended and deleted accounts get no session
+	err = AuthenticationManagement.CheckAccount(user)
+	if err != nil {
+		event.ActorID = user.IndexID
+		event.Outcome = AuditLog.OutcomeFailure
+		event.Details["reason"] = err.Error()
+		AuditLog.OrDefault(s.Audit).Record(event)
+		c.JSON(403, gin.H{"error": err.Error()})
+		return
+	}
+
+	// the provider only replaces the password, users with two-factor authentication still have to enter a code
+	challenge, err := s.Auth.StartTwoFactorLogin(user)
+	if errors.Is(err, AuthenticationManagement.ErrTwoFactorSetupRequired) {
+		event.ActorID = user.IndexID
+		event.Outcome = AuditLog.OutcomeFailure
+		event.Details["reason"] = err.Error()
+		AuditLog.OrDefault(s.Audit).Record(event)
+		c.JSON(403, gin.H{"error": "Two-factor authentication has to be set up for this account, please log in with your password"})
+		return
+	}
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, gin.H{"error": "Failed to create login challenge"})
+		return
+	}
+	if challenge != "" {
+		// the login is audited once the code was sent to /api/auth/login/2fa, the fragment keeps the challenge out of server logs
+		c.Redirect(302, s.publicURL()+"/login/2fa#challenge="+url.QueryEscape(challenge))
+		return
+	}
+
+	token, err := s.Auth.CreateToken(user.IndexID)
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, gin.H{"error": "Failed to create token"})
+		return
+	}
+
+	s.Auth.SetAuthCookie(c, token)
+	event.ActorID = user.IndexID
+	AuditLog.OrDefault(s.Audit).Record(event)
+	c.Redirect(302, s.publicURL()+"/")
+}
+
+type identityResponse struct {
+	Provider  string    `json:"provider"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// GetIdentitiesHandler godoc
+//
+//	@Summary		List linked identities
+//	@Description	List the external accounts linked to the current user
+//	@Tags			Authentication
+//	@Produce		json
+//	@Success		200	{object}	[]identityResponse
+//	@Failure		500	{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/api/auth/oidc/identities [get]
+func (s OIDCService) GetIdentitiesHandler(c *gin.Context) {
+	user := c.MustGet("user").(DatabaseAbstraction.User)
+
+	identities, err := s.DB.GetUserIdentitiesByUserID(user.IndexID)
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, gin.H{"error": "Failed to get identities"})
+		return
+	}
+
+	response := make([]identityResponse, len(identities))
+	for i, identity := range identities {
+		response[i] = identityResponse{
+			Provider:  identity.Provider,
+			Email:     identity.Email,
+			CreatedAt: identity.CreatedAt,
+		}
+	}
+
+	c.JSON(200, response)
+}
+
+// UnlinkHandler godoc
+//
+//	@Summary		Unlink an identity provider
+//	@Description	Removes the link between the current user and an external account
+//	@Tags			Authentication
+//	@Param			provider	path	string	true	"Provider name"
+//	@Produce		json
+//	@Success		200	{object}	map[string]string
+//	@Failure		400	{object}	map[string]string
+//	@Failure		500	{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/api/auth/oidc/{provider} [delete]
+func (s OIDCService) UnlinkHandler(c *gin.Context) {
+	user := c.MustGet("user").(DatabaseAbstraction.User)
+
+	identities, err := s.DB.GetUserIdentitiesByUserID(user.IndexID)
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, gin.H{"error": "Failed to get identities"})
+		return
+	}
+
+	// Users created through SSO don't know their random password, they need a way back in
+	if len(identities) == 1 && user.Email == "" {
+		c.JSON(400, gin.H{"error": ErrLastLoginMethod.Error()})
+		return
+	}
+
+	err = s.DB.DeleteUserIdentity(user.IndexID, c.Param("provider"))
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, gin.H{"error": "Failed to unlink identity"})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "identity unlinked"})
+}
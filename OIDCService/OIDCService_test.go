@@ -0,0 +1,342 @@
+package OIDCService
+
+import (
+	"EntitlementServer/AuditLog"
+	"EntitlementServer/AuthenticationManagement"
+	"EntitlementServer/DatabaseAbstraction"
+	"EntitlementServer/DatabaseAbstraction/mocks"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+var errNoRows = errors.New("no rows in result set")
+
+// mockIdentityProvider is a minimal OpenID Connect provider issuing ID tokens with the claims set by the test
+type mockIdentityProvider struct {
+	server        *httptest.Server
+	key           *rsa.PrivateKey
+	codeChallenge string
+	claims        map[string]interface{}
+}
+
+func newMockIdentityProvider(t *testing.T) *mockIdentityProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	idp := &mockIdentityProvider{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.server.URL,
+			"authorization_endpoint": idp.server.URL + "/authorize",
+			"token_endpoint":         idp.server.URL + "/token",
+			"jwks_uri":               idp.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "test-key",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		verifier := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+		if r.PostFormValue("code") != "valid-code" || base64.RawURLEncoding.EncodeToString(verifier[:]) != idp.codeChallenge {
+			w.WriteHeader(400)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"id_token": idp.sign(t, idp.claims)})
+	})
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+
+	return idp
+}
+
+func (idp *mockIdentityProvider) sign(t *testing.T, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test-key", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, idp.key, crypto.SHA256, digest[:])
+	assert.NoError(t, err)
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// startLogin runs StartLogin against the mock provider and returns the flow that was stored
+func startLogin(t *testing.T, svc OIDCService, mockDB *mocks.DBOrm, idp *mockIdentityProvider) DatabaseAbstraction.OIDCLoginFlow {
+	var flow DatabaseAbstraction.OIDCLoginFlow
+	mockDB.On("AddOIDCLoginFlow", mock.AnythingOfType("DatabaseAbstraction.OIDCLoginFlow")).Run(func(args mock.Arguments) {
+		flow = args.Get(0).(DatabaseAbstraction.OIDCLoginFlow)
+	}).Return(nil).Once()
+
+	authorizationURL, state, err := svc.StartLogin("school", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, flow.State, state)
+
+	parsed, err := url.Parse(authorizationURL)
+	assert.NoError(t, err)
+	assert.Equal(t, flow.State, parsed.Query().Get("state"))
+	assert.Equal(t, "S256", parsed.Query().Get("code_challenge_method"))
+	idp.codeChallenge = parsed.Query().Get("code_challenge")
+
+	mockDB.On("ConsumeOIDCLoginFlow", flow.State).Return(flow, nil).Once()
+	return flow
+}
+
+func newTestService(idp *mockIdentityProvider) (OIDCService, *mocks.DBOrm) {
+	mockDB := new(mocks.DBOrm)
+	provider := NewProvider(ProviderConfig{Name: "school", Issuer: idp.server.URL, ClientID: "bkbdemy"}, idp.server.Client())
+
+	return OIDCService{
+		DB:        mockDB,
+		Auth:      AuthenticationManagement.AuthenticationService{DB: mockDB},
+		Providers: map[string]*Provider{"school": provider},
+		PublicURL: "https://bkbdemy.example",
+	}, mockDB
+}
+
+func idTokenClaims(idp *mockIdentityProvider, nonce string) map[string]interface{} {
+	return map[string]interface{}{
+		"iss":            idp.server.URL,
+		"sub":            "external-42",
+		"aud":            "bkbdemy",
+		"exp":            time.Now().Add(time.Hour).Unix(),
+		"iat":            time.Now().Unix(),
+		"nonce":          nonce,
+		"email":          "Max@School.example",
+		"email_verified": true,
+	}
+}
+
+func TestLoginWithLinkedIdentity(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	idp := newMockIdentityProvider(t)
+	svc, mockDB := newTestService(idp)
+
+	flow := startLogin(t, svc, mockDB, idp)
+	idp.claims = idTokenClaims(idp, flow.Nonce)
+
+	mockDB.On("GetUserIdentity", "school", "external-42").Return(DatabaseAbstraction.UserIdentity{UserID: 7, Provider: "school", Subject: "external-42"}, nil)
+	mockDB.On("GetUserByIndexID", 7).Return(DatabaseAbstraction.User{IndexID: 7, Username: "max"}, nil)
+	mockDB.On("AddToken", 7, mock.AnythingOfType("string"), mock.AnythingOfType("time.Time")).Return(nil)
+
+	r := gin.Default()
+	svc.RegisterHandlers(r, func(c *gin.Context) {})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/auth/oidc/school/callback?state="+url.QueryEscape(flow.State)+"&code=valid-code", nil)
+	req.AddCookie(&http.Cookie{Name: stateCookieName, Value: flow.State})
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, 302, w.Code)
+	assert.Equal(t, "https://bkbdemy.example/", w.Header().Get("Location"))
+	assert.Contains(t, setCookies(w), "authtoken=")
+	mockDB.AssertNotCalled(t, "AddUserIdentity", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestCallbackRequiresStateCookie(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	idp := newMockIdentityProvider(t)
+	svc, mockDB := newTestService(idp)
+
+	mockDB.On("AddOIDCLoginFlow", mock.AnythingOfType("DatabaseAbstraction.OIDCLoginFlow")).Return(nil)
+
+	r := gin.New()
+	svc.RegisterHandlers(r, func(c *gin.Context) {})
+
+	// starting the login sets the cookie for the callback only
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/auth/oidc/school/login", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, 302, w.Code)
+	cookie := w.Header().Get("Set-Cookie")
+	assert.Contains(t, cookie, stateCookieName+"=")
+	assert.Contains(t, cookie, "Path=/api/auth/oidc/school/callback")
+	assert.Contains(t, cookie, "HttpOnly")
+	assert.Contains(t, cookie, "SameSite=Lax")
+
+	// a callback URL sent to another browser, with its own or without any state cookie
+	for _, stateCookie := range []string{"", "other-state"} {
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", "/api/auth/oidc/school/callback?state=attacker-state&code=attacker-code", nil)
+		if stateCookie != "" {
+			req.AddCookie(&http.Cookie{Name: stateCookieName, Value: stateCookie})
+		}
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, 400, w.Code, stateCookie)
+		assert.NotContains(t, setCookies(w), "authtoken=")
+	}
+	mockDB.AssertNotCalled(t, "ConsumeOIDCLoginFlow", mock.Anything)
+}
+
+func TestLoginRejectsNonceMismatch(t *testing.T) {
+	idp := newMockIdentityProvider(t)
+	svc, mockDB := newTestService(idp)
+
+	flow := startLogin(t, svc, mockDB, idp)
+	idp.claims = idTokenClaims(idp, "replayed-nonce")
+
+	_, err := svc.FinishLogin("school", flow.State, "valid-code")
+	assert.ErrorIs(t, err, ErrNonceMismatch)
+	mockDB.AssertNotCalled(t, "GetUserIdentity", mock.Anything, mock.Anything)
+}
+
+func TestLoginRejectsWrongAudience(t *testing.T) {
+	idp := newMockIdentityProvider(t)
+	svc, mockDB := newTestService(idp)
+
+	flow := startLogin(t, svc, mockDB, idp)
+	idp.claims = idTokenClaims(idp, flow.Nonce)
+	idp.claims["aud"] = "another-client"
+
+	_, err := svc.FinishLogin("school", flow.State, "valid-code")
+	assert.ErrorIs(t, err, ErrInvalidIDToken)
+}
+
+func TestLoginLinksVerifiedEmail(t *testing.T) {
+	idp := newMockIdentityProvider(t)
+	svc, mockDB := newTestService(idp)
+
+	flow := startLogin(t, svc, mockDB, idp)
+	idp.claims = idTokenClaims(idp, flow.Nonce)
+
+	existing := DatabaseAbstraction.User{IndexID: 3, Username: "max", Email: "max@school.example", EmailVerified: true}
+	mockDB.On("GetUserIdentity", "school", "external-42").Return(DatabaseAbstraction.UserIdentity{}, errNoRows)
+	mockDB.On("GetUserByEmail", "max@school.example").Return(existing, nil)
+	mockDB.On("AddUserIdentity", 3, "school", "external-42", "Max@School.example").Return(nil)
+	mockDB.On("GetUserByIndexID", 3).Return(existing, nil)
+
+	user, err := svc.FinishLogin("school", flow.State, "valid-code")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, user.IndexID)
+	mockDB.AssertNotCalled(t, "AddUser", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestLoginCreatesUser(t *testing.T) {
+	idp := newMockIdentityProvider(t)
+	svc, mockDB := newTestService(idp)
+
+	flow := startLogin(t, svc, mockDB, idp)
+	idp.claims = idTokenClaims(idp, flow.Nonce)
+	// an unverified address must not be used to take over the local account
+	idp.claims["email_verified"] = false
+	idp.claims["preferred_username"] = "Max Mustermann"
+
+	created := DatabaseAbstraction.User{IndexID: 9, Username: "max-mustermann"}
+	mockDB.On("GetUserIdentity", "school", "external-42").Return(DatabaseAbstraction.UserIdentity{}, errNoRows)
+	mockDB.On("GetUserByEmail", "max@school.example").Return(DatabaseAbstraction.User{IndexID: 3, Username: "max"}, nil)
+	mockDB.On("GetUserByUsername", "max-mustermann").Return(DatabaseAbstraction.User{}, errNoRows).Once()
+	mockDB.On("AddUser", "max-mustermann", mock.AnythingOfType("string"), "").Return(nil)
+	mockDB.On("GetUserByUsername", "max-mustermann").Return(created, nil)
+	mockDB.On("AddUserIdentity", 9, "school", "external-42", "Max@School.example").Return(nil)
+	mockDB.On("GetUserByIndexID", 9).Return(created, nil)
+
+	user, err := svc.FinishLogin("school", flow.State, "valid-code")
+	assert.NoError(t, err)
+	assert.Equal(t, 9, user.IndexID)
+	mockDB.AssertNotCalled(t, "AddUserIdentity", 3, mock.Anything, mock.Anything, mock.Anything)
+	mockDB.AssertNotCalled(t, "VerifyUserEmail", mock.Anything, mock.Anything)
+}
+
+// recordingAuditor keeps the events in memory
+type recordingAuditor struct {
+	events *[]DatabaseAbstraction.AuditEvent
+}
+
+func (a recordingAuditor) Record(event DatabaseAbstraction.AuditEvent) {
+	*a.events = append(*a.events, event)
+}
+
+// setCookies joins all Set-Cookie headers of the response
+func setCookies(w *httptest.ResponseRecorder) string {
+	return strings.Join(w.Header().Values("Set-Cookie"), "\n")
+}
+
+// callback runs the callback for a user with a linked identity and returns the response
+func callback(t *testing.T, svc OIDCService, mockDB *mocks.DBOrm, idp *mockIdentityProvider, user DatabaseAbstraction.User) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	flow := startLogin(t, svc, mockDB, idp)
+	idp.claims = idTokenClaims(idp, flow.Nonce)
+	mockDB.On("GetUserIdentity", "school", "external-42").Return(DatabaseAbstraction.UserIdentity{UserID: user.IndexID, Provider: "school", Subject: "external-42"}, nil)
+	mockDB.On("GetUserByIndexID", user.IndexID).Return(user, nil)
+
+	r := gin.New()
+	svc.RegisterHandlers(r, func(c *gin.Context) {})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/auth/oidc/school/callback?state="+url.QueryEscape(flow.State)+"&code=valid-code", nil)
+	req.AddCookie(&http.Cookie{Name: stateCookieName, Value: flow.State})
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestLoginRequiresSecondFactor(t *testing.T) {
+	idp := newMockIdentityProvider(t)
+	svc, mockDB := newTestService(idp)
+	svc.Auth = AuthenticationManagement.AuthenticationService{DB: mockDB, SigningKey: []byte("test-signing-key")}
+
+	mockDB.On("AddTwoFactorChallenge", 7, mock.AnythingOfType("string"), mock.AnythingOfType("time.Time")).Return(nil)
+
+	w := callback(t, svc, mockDB, idp, DatabaseAbstraction.User{IndexID: 7, Username: "max", Password: "hash", TOTPEnabled: true})
+
+	// the provider replaces the password, not the code
+	assert.Equal(t, 302, w.Code)
+	location, err := url.Parse(w.Header().Get("Location"))
+	assert.NoError(t, err)
+	assert.Equal(t, "/login/2fa", location.Path)
+	assert.Contains(t, location.Fragment, "challenge=")
+	assert.NotContains(t, setCookies(w), "authtoken=")
+	mockDB.AssertNotCalled(t, "AddToken", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestLoginRejectsSuspendedUser(t *testing.T) {
+	idp := newMockIdentityProvider(t)
+	svc, mockDB := newTestService(idp)
+	events := []DatabaseAbstraction.AuditEvent{}
+	svc.Audit = recordingAuditor{&events}
+
+	w := callback(t, svc, mockDB, idp, DatabaseAbstraction.User{IndexID: 7, Username: "max", Suspended: true})
+
+	assert.Equal(t, 403, w.Code)
+	assert.NotContains(t, setCookies(w), "authtoken=")
+	mockDB.AssertNotCalled(t, "AddToken", mock.Anything, mock.Anything, mock.Anything)
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, AuditLog.OutcomeFailure, events[0].Outcome)
+	}
+}
+
+func TestLoginRejectsMissingTwoFactorSetup(t *testing.T) {
+	idp := newMockIdentityProvider(t)
+	svc, mockDB := newTestService(idp)
+	svc.Auth = AuthenticationManagement.AuthenticationService{DB: mockDB, TwoFactorRequiredRoles: []string{"admin"}}
+
+	w := callback(t, svc, mockDB, idp, DatabaseAbstraction.User{IndexID: 7, Username: "max", Role: "admin"})
+
+	assert.Equal(t, 403, w.Code)
+	assert.NotContains(t, setCookies(w), "authtoken=")
+	mockDB.AssertNotCalled(t, "AddToken", mock.Anything, mock.Anything, mock.Anything)
+}
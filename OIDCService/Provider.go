@@ -0,0 +1,254 @@
+package OIDCService
+
+import (
+	"EntitlementServer/JWTManagement"
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// keys are refetched at most this often when a token with an unknown kid shows up
+	jwksRefreshInterval = time.Minute
+	clockLeeway         = time.Minute
+)
+
+var (
+	ErrInvalidIDToken = errors.New("invalid ID token")
+	ErrNonceMismatch  = errors.New("ID token nonce does not match")
+)
+
+// ProviderConfig describes a client registration at an OpenID Connect provider
+type ProviderConfig struct {
+	Name         string // used in the URLs, e.g. /api/auth/oidc/{name}/login
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+}
+
+type providerMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// IDTokenClaims are the claims of an ID token we use
+type IDTokenClaims struct {
+	JWTManagement.RegisteredClaims
+	Nonce             string `json:"nonce"`
+	Email             string `json:"email"`
+	EmailVerified     bool   `json:"email_verified"`
+	PreferredUsername string `json:"preferred_username"`
+	Name              string `json:"name"`
+}
+
+// Provider talks to a single OpenID Connect provider, metadata and keys are fetched lazily and cached
+type Provider struct {
+	Config ProviderConfig
+	client *http.Client
+
+	mutex       sync.Mutex
+	metadata    *providerMetadata
+	keys        JWTManagement.KeySet
+	keysFetched time.Time
+}
+
+func NewProvider(config ProviderConfig, client *http.Client) *Provider {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"openid", "email", "profile"}
+	}
+	return &Provider{Config: config, client: client}
+}
+
+// ProvidersFromEnv reads the comma separated OIDC_PROVIDERS and OIDC_{NAME}_ISSUER, OIDC_{NAME}_CLIENT_ID and
+// OIDC_{NAME}_CLIENT_SECRET for every provider
+func ProvidersFromEnv() (map[string]*Provider, error) {
+	providers := map[string]*Provider{}
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		config := ProviderConfig{
+			Name:         name,
+			Issuer:       os.Getenv(prefix + "ISSUER"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+		}
+		if config.Issuer == "" || config.ClientID == "" {
+			return nil, fmt.Errorf("%sISSUER and %sCLIENT_ID are required", prefix, prefix)
+		}
+
+		providers[name] = NewProvider(config, nil)
+	}
+
+	return providers, nil
+}
+
+func (p *Provider) getJSON(endpoint string, target interface{}) error {
+	resp, err := p.client.Get(endpoint)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned status %d", endpoint, resp.StatusCode)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(target)
+}
+
+// discover fetches the provider metadata once
+func (p *Provider) discover() (*providerMetadata, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+
+	var metadata providerMetadata
+	err := p.getJSON(strings.TrimSuffix(p.Config.Issuer, "/")+"/.well-known/openid-configuration", &metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	// OpenID Connect Discovery 1.0, section 4.3
+	if metadata.Issuer != p.Config.Issuer {
+		return nil, fmt.Errorf("provider %s announced issuer %q instead of %q", p.Config.Name, metadata.Issuer, p.Config.Issuer)
+	}
+
+	p.metadata = &metadata
+	return p.metadata, nil
+}
+
+// AuthorizationURL is where the browser is sent to log in, using the authorization code flow with PKCE (S256)
+func (p *Provider) AuthorizationURL(redirectURI string, state string, nonce string, codeChallenge string) (string, error) {
+	metadata, err := p.discover()
+	if err != nil {
+		return "", err
+	}
+
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", p.Config.ClientID)
+	query.Set("redirect_uri", redirectURI)
+	query.Set("scope", strings.Join(p.Config.Scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", codeChallenge)
+	query.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(metadata.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return metadata.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+// Exchange redeems an authorization code and returns the raw ID token
+func (p *Provider) Exchange(code string, redirectURI string, codeVerifier string) (string, error) {
+	metadata, err := p.discover()
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", redirectURI)
+	form.Set("client_id", p.Config.ClientID)
+	form.Set("code_verifier", codeVerifier)
+	if p.Config.ClientSecret != "" {
+		form.Set("client_secret", p.Config.ClientSecret)
+	}
+
+	resp, err := p.client.PostForm(metadata.TokenEndpoint, form)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var tokenResponse struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&tokenResponse)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK || tokenResponse.Error != "" {
+		return "", fmt.Errorf("token endpoint returned status %d: %s %s", resp.StatusCode, tokenResponse.Error, tokenResponse.ErrorDescription)
+	}
+	if tokenResponse.IDToken == "" {
+		return "", errors.New("token endpoint did not return an ID token")
+	}
+
+	return tokenResponse.IDToken, nil
+}
+
+// VerifyIDToken checks signature, issuer, audience, validity period and nonce of an ID token
+func (p *Provider) VerifyIDToken(rawIDToken string, nonce string) (IDTokenClaims, error) {
+	var claims IDTokenClaims
+	_, err := JWTManagement.Verify(rawIDToken, p.publicKey, &claims)
+	if err != nil {
+		return IDTokenClaims{}, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+
+	err = claims.Validate(p.Config.Issuer, p.Config.ClientID, time.Now(), clockLeeway)
+	if err != nil {
+		return IDTokenClaims{}, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+	if claims.Subject == "" {
+		return IDTokenClaims{}, fmt.Errorf("%w: missing subject", ErrInvalidIDToken)
+	}
+	if claims.Nonce != nonce {
+		return IDTokenClaims{}, ErrNonceMismatch
+	}
+
+	return claims, nil
+}
+
+// publicKey looks up the signing key of a token, refreshing the key set if the provider rotated its keys
+func (p *Provider) publicKey(header JWTManagement.Header) (crypto.PublicKey, error) {
+	metadata, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	key, err := p.keys.Find(header.KeyID)
+	if err != nil && time.Since(p.keysFetched) > jwksRefreshInterval {
+		var keys JWTManagement.KeySet
+		fetchErr := p.getJSON(metadata.JWKSURI, &keys)
+		if fetchErr != nil {
+			return nil, fetchErr
+		}
+		p.keys = keys
+		p.keysFetched = time.Now()
+		key, err = p.keys.Find(header.KeyID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return key.PublicKey()
+}
@@ -0,0 +1,544 @@
+package OrganizationService
+
+import (
+	"EntitlementServer/AuditLog"
+	"EntitlementServer/AuthenticationManagement"
+	"EntitlementServer/DatabaseAbstraction"
+	"EntitlementServer/MailManagement"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/sirupsen/logrus"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const maxOrganizationNameLength = 100
+
+var (
+	ErrInviteNotFound    = errors.New("invite not found")
+	ErrInviteForSomeone  = errors.New("the invite was sent to another email address or yours is not verified yet")
+	ErrInvalidSeatAmount = fmt.Errorf("between 1 and %d seats can be bought at once", maxSeatsPerPurchase)
+)
+
+// OrganizationService lets schools and companies buy seats for products and hand them out to their members
+// Organization admins manage members, invites and seats, the seats count as entitlements of the members
+type OrganizationService struct {
+	DB        DatabaseAbstraction.DBOrm
+	Mailer    MailManagement.Mailer // sends invites, they are only logged if nil
+	PublicURL string                // base URL of the frontend for links in invites
+	Audit     AuditLog.Auditor      // records membership and seat changes
+	// RequireVerifiedEmail blocks buying seats until the buyer confirmed their email address, like every other checkout
+	RequireVerifiedEmail bool
+}
+
+func (s OrganizationService) RegisterHandlers(r *gin.Engine, middleware ...gin.HandlerFunc) {
+	r.POST("/api/organizations", middleware[0], s.CreateOrganizationHandler)
+	r.GET("/api/organizations", middleware[0], s.ListOrganizationsHandler)
+	r.POST("/api/organizations/join", middleware[0], s.JoinOrganizationHandler)
+	r.GET("/api/organizations/:id/members", middleware[0], s.ListMembersHandler)
+	r.DELETE("/api/organizations/:id/members/:userId", middleware[0], s.RemoveMemberHandler)
+	r.POST("/api/organizations/:id/invites", middleware[0], s.CreateInviteHandler)
+	r.GET("/api/organizations/:id/invites", middleware[0], s.ListInvitesHandler)
+	r.DELETE("/api/organizations/:id/invites/:inviteId", middleware[0], s.DeleteInviteHandler)
+	r.POST("/api/organizations/:id/seats", middleware[0], s.BuySeatsHandler)
+	r.GET("/api/organizations/:id/seats", middleware[0], s.ListSeatsHandler)
+	r.PUT("/api/organizations/:id/seats/:productId/members/:userId", middleware[0], s.AssignSeatHandler)
+	r.DELETE("/api/organizations/:id/seats/:productId/members/:userId", middleware[0], s.ReclaimSeatHandler)
+	r.GET("/api/organizations/:id/progress", middleware[0], s.GetProgressHandler)
+}
+
+func (s OrganizationService) GetLabel() string {
+	return "Organization Service"
+}
+
+func (s OrganizationService) mailer() MailManagement.Mailer {
+	if s.Mailer == nil {
+		return MailManagement.LogMailer{}
+	}
+	return s.Mailer
+}
+
+func (s OrganizationService) publicURL() string {
+	if s.PublicURL == "" {
+		return "http://localhost:8080"
+	}
+	return strings.TrimSuffix(s.PublicURL, "/")
+}
+
+// record stores an event of an organization action, the organization is part of the details
+func (s OrganizationService) record(c *gin.Context, action string, organizationID int, targetUserID int, details map[string]interface{}) {
+	event := AuditLog.NewEvent(c, action)
+	event.TargetUserID = targetUserID
+	event.Details["organization_id"] = organizationID
+	for key, value := range details {
+		event.Details[key] = value
+	}
+	AuditLog.OrDefault(s.Audit).Record(event)
+}
+
+// membership returns the signed-in user's membership in the organization of the request
+// It responds with 404 to non-members, so organizations can't be enumerated, and with 403 if adminOnly and the user is no admin
+func (s OrganizationService) membership(c *gin.Context, adminOnly bool) (DatabaseAbstraction.OrganizationMember, bool) {
+	organizationID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid organization ID"})
+		return DatabaseAbstraction.OrganizationMember{}, false
+	}
+
+	user := c.MustGet("user").(DatabaseAbstraction.User)
+	member, err := s.DB.GetOrganizationMember(organizationID, user.IndexID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(404, gin.H{"error": "Organization not found"})
+		return member, false
+	}
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, gin.H{"error": "Failed to get organization"})
+		return member, false
+	}
+	if adminOnly && member.Role != DatabaseAbstraction.OrganizationRoleAdmin {
+		c.JSON(403, gin.H{"error": "Only admins of the organization can do this"})
+		return member, false
+	}
+
+	return member, true
+}
+
+type organizationRequest struct {
+	Name string `json:"name"`
+}
+
+type organizationResponse struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type memberResponse struct {
+	UserID   int       `json:"user_id"`
+	Username string    `json:"username"`
+	Email    string    `json:"email,omitempty"`
+	Role     string    `json:"role"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
+type inviteRequest struct {
+	Email string `json:"email"` // optional, without it the invite is a join code for everybody who gets it
+}
+
+type joinRequest struct {
+	Code string `json:"code"`
+}
+
+type inviteResponse struct {
+	ID         int        `json:"id"`
+	Code       string     `json:"code"`
+	Email      string     `json:"email,omitempty"`
+	CreatedBy  int        `json:"created_by,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	AcceptedBy int        `json:"accepted_by,omitempty"`
+	AcceptedAt *time.Time `json:"accepted_at,omitempty"`
+}
+
+func newInviteResponse(invite DatabaseAbstraction.OrganizationInvite) inviteResponse {
+	return inviteResponse{invite.IndexID, invite.Code, invite.Email, invite.CreatedBy, invite.CreatedAt, invite.AcceptedBy, invite.AcceptedAt}
+}
+
+// Invite creates an invite to the organization, an invite with email is mailed to the address and can only be accepted by it
+func (s OrganizationService) Invite(organization DatabaseAbstraction.Organization, email string, admin DatabaseAbstraction.User) (DatabaseAbstraction.OrganizationInvite, error) {
+	code, err := DatabaseAbstraction.NewInviteCode()
+	if err != nil {
+		return DatabaseAbstraction.OrganizationInvite{}, err
+	}
+
+	invite := DatabaseAbstraction.OrganizationInvite{
+		OrganizationID:   organization.IndexID,
+		OrganizationName: organization.Name,
+		Code:             code,
+		Email:            email,
+		CreatedBy:        admin.IndexID,
+		CreatedAt:        time.Now(),
+	}
+	invite.IndexID, err = s.DB.AddOrganizationInvite(invite)
+	if err != nil {
+		return DatabaseAbstraction.OrganizationInvite{}, err
+	}
+	if email == "" {
+		return invite, nil
+	}
+
+	link := fmt.Sprintf("%s/join?code=%s", s.publicURL(), url.QueryEscape(code))
+	body := fmt.Sprintf("Hello,\n\n"+
+		"%s invited you to join %s on BKBdemy.\n"+
+		"Open the following link and sign in or register with this email address to accept:\n\n"+
+		"%s\n\n"+
+		"Your invite code is %s\n", admin.Username, organization.Name, link, code)
+
+	// the invite stays valid if the mail fails, the admin can pass the code on themselves
+	err = s.mailer().SendMail(email, "Join "+organization.Name+" on BKBdemy", body)
+	if err != nil {
+		logrus.Errorf("Failed to send invite %d: %v", invite.IndexID, err)
+	}
+	return invite, nil
+}
+
+// JoinOrganization adds the user to the organization of the invite code
+func (s OrganizationService) JoinOrganization(code string, user DatabaseAbstraction.User) (DatabaseAbstraction.OrganizationInvite, error) {
+	invite, err := s.DB.GetOrganizationInviteByCode(code)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return DatabaseAbstraction.OrganizationInvite{}, ErrInviteNotFound
+	}
+	if err != nil {
+		return DatabaseAbstraction.OrganizationInvite{}, err
+	}
+	// an unverified address could belong to anybody
+	if invite.Email != "" && (!user.EmailVerified || invite.Email != user.Email) {
+		return DatabaseAbstraction.OrganizationInvite{}, ErrInviteForSomeone
+	}
+
+	err = s.DB.AcceptOrganizationInvite(invite, user.IndexID)
+	if err != nil {
+		return DatabaseAbstraction.OrganizationInvite{}, err
+	}
+
+	return invite, nil
+}
+
+// CreateOrganizationHandler godoc
+//
+//	@Summary		Create an organization
+//	@Description	Creates a school or company, the current user becomes its first admin
+//	@Tags			Organizations
+//	@Accept			json
+//	@Produce		json
+//	@Param			organization	body		organizationRequest	true	"Name"
+//	@Success		201				{object}	organizationResponse
+//	@Failure		400				{object}	map[string]string
+//	@Failure		500				{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/api/organizations [post]
+func (s OrganizationService) CreateOrganizationHandler(c *gin.Context) {
+	var request organizationRequest
+	err := c.ShouldBindJSON(&request)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request"})
+		return
+	}
+	request.Name = strings.TrimSpace(request.Name)
+	if request.Name == "" || utf8.RuneCountInString(request.Name) > maxOrganizationNameLength {
+		c.JSON(400, gin.H{"error": "Invalid name"})
+		return
+	}
+
+	user := c.MustGet("user").(DatabaseAbstraction.User)
+	organizationID, err := s.DB.CreateOrganization(request.Name, user.IndexID)
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, gin.H{"error": "Failed to create organization"})
+		return
+	}
+
+	s.record(c, AuditLog.ActionOrganizationCreated, organizationID, 0, map[string]interface{}{"name": request.Name})
+
+	c.JSON(201, organizationResponse{organizationID, request.Name, DatabaseAbstraction.OrganizationRoleAdmin, time.Now()})
+}
+
+// ListOrganizationsHandler godoc
+//
+//	@Summary		List organizations
+//	@Description	The organizations the current user is a member of, with their role
+//	@Tags			Organizations
+//	@Produce		json
+//	@Success		200	{object}	[]organizationResponse
+//	@Failure		500	{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/api/organizations [get]
+func (s OrganizationService) ListOrganizationsHandler(c *gin.Context) {
+	user := c.MustGet("user").(DatabaseAbstraction.User)
+
+	organizations, err := s.DB.GetOrganizationsByUserID(user.IndexID)
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, gin.H{"error": "Failed to get organizations"})
+		return
+	}
+
+	response := make([]organizationResponse, len(organizations))
+	for i, organization := range organizations {
+		response[i] = organizationResponse{organization.IndexID, organization.Name, organization.Role, organization.CreatedAt}
+	}
+
+	c.JSON(200, response)
+}
+
+// JoinOrganizationHandler godoc
+//
+//	@Summary		Join an organization
+//	@Description	Accepts an invite code, an invite sent by email only works for an account with that verified email address
+//	@Tags			Organizations
+//	@Accept			json
+//	@Produce		json
+//	@Param			invite	body		joinRequest	true	"Invite code"
+//	@Success		200		{object}	organizationResponse
+//	@Failure		400		{object}	map[string]string
+//	@Failure		403		{object}	map[string]string
+//	@Failure		404		{object}	map[string]string
+//	@Failure		409		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/api/organizations/join [post]
+func (s OrganizationService) JoinOrganizationHandler(c *gin.Context) {
+	var request joinRequest
+	err := c.ShouldBindJSON(&request)
+	if err != nil || strings.TrimSpace(request.Code) == "" {
+		c.JSON(400, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	user := c.MustGet("user").(DatabaseAbstraction.User)
+	invite, err := s.JoinOrganization(request.Code, user)
+	switch {
+	case errors.Is(err, ErrInviteNotFound):
+		c.JSON(404, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, ErrInviteForSomeone):
+		c.JSON(403, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, DatabaseAbstraction.ErrAlreadyMember), errors.Is(err, DatabaseAbstraction.ErrInviteUnavailable):
+		c.JSON(409, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		logrus.Error(err)
+		c.JSON(500, gin.H{"error": "Failed to join organization"})
+		return
+	}
+
+	s.record(c, AuditLog.ActionMemberJoined, invite.OrganizationID, user.IndexID, map[string]interface{}{"invite_id": invite.IndexID})
+
+	c.JSON(200, organizationResponse{invite.OrganizationID, invite.OrganizationName, DatabaseAbstraction.OrganizationRoleMember, time.Now()})
+}
+
+// ListMembersHandler godoc
+//
+//	@Summary		List members
+//	@Description	The members of the organization, only for its admins
+//	@Tags			Organizations
+//	@Produce		json
+//	@Param			id	path		int	true	"Organization ID"
+//	@Success		200	{object}	[]memberResponse
+//	@Failure		403	{object}	map[string]string
+//	@Failure		404	{object}	map[string]string
+//	@Failure		500	{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/api/organizations/{id}/members [get]
+func (s OrganizationService) ListMembersHandler(c *gin.Context) {
+	admin, ok := s.membership(c, true)
+	if !ok {
+		return
+	}
+
+	members, err := s.DB.GetOrganizationMembers(admin.OrganizationID)
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, gin.H{"error": "Failed to get members"})
+		return
+	}
+
+	response := make([]memberResponse, len(members))
+	for i, member := range members {
+		response[i] = memberResponse{member.UserID, member.Username, member.Email, member.Role, member.JoinedAt}
+	}
+
+	c.JSON(200, response)
+}
+
+// RemoveMemberHandler godoc
+//
+//	@Summary		Remove a member
+//	@Description	Removes the user from the organization and reclaims all their seats. Admins can't remove themselves
+//	@Tags			Organizations
+//	@Produce		json
+//	@Param			id		path		int	true	"Organization ID"
+//	@Param			userId	path		int	true	"User ID"
+//	@Success		200		{object}	map[string]string
+//	@Failure		400		{object}	map[string]string
+//	@Failure		403		{object}	map[string]string
+//	@Failure		404		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/api/organizations/{id}/members/{userId} [delete]
+func (s OrganizationService) RemoveMemberHandler(c *gin.Context) {
+	admin, ok := s.membership(c, true)
+	if !ok {
+		return
+	}
+
+	userID, err := strconv.Atoi(c.Param("userId"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	// keeps the organization from ending up without admin
+	if userID == admin.UserID {
+		c.JSON(400, gin.H{"error": "Admins can't remove themselves"})
+		return
+	}
+
+	err = s.DB.RemoveOrganizationMember(admin.OrganizationID, userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(404, gin.H{"error": "Member not found"})
+		return
+	}
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, gin.H{"error": "Failed to remove member"})
+		return
+	}
+
+	s.record(c, AuditLog.ActionMemberRemoved, admin.OrganizationID, userID, nil)
+
+	c.JSON(200, gin.H{"message": "Member removed"})
+}
+
+// CreateInviteHandler godoc
+//
+//	@Summary		Invite members
+//	@Description	With email the invite is mailed and can be accepted once by that address
+//	@Description	Without email the code can be handed out to a whole class, it works until it is deleted
+//	@Tags			Organizations
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		int				true	"Organization ID"
+//	@Param			invite	body		inviteRequest	false	"Email address"
+//	@Success		201		{object}	inviteResponse
+//	@Failure		400		{object}	map[string]string
+//	@Failure		403		{object}	map[string]string
+//	@Failure		404		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/api/organizations/{id}/invites [post]
+func (s OrganizationService) CreateInviteHandler(c *gin.Context) {
+	admin, ok := s.membership(c, true)
+	if !ok {
+		return
+	}
+
+	var request inviteRequest
+	if c.Request.ContentLength != 0 {
+		err := c.ShouldBindJSON(&request)
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Invalid request"})
+			return
+		}
+	}
+	if strings.TrimSpace(request.Email) != "" {
+		email, err := AuthenticationManagement.NormalizeEmail(request.Email)
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Invalid email address"})
+			return
+		}
+		request.Email = email
+	}
+
+	organization, err := s.DB.GetOrganizationByIndexID(admin.OrganizationID)
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, gin.H{"error": "Failed to get organization"})
+		return
+	}
+
+	invite, err := s.Invite(organization, request.Email, c.MustGet("user").(DatabaseAbstraction.User))
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, gin.H{"error": "Failed to create invite"})
+		return
+	}
+
+	s.record(c, AuditLog.ActionMemberInvited, admin.OrganizationID, 0, map[string]interface{}{
+		"invite_id": invite.IndexID,
+		"by_email":  invite.Email != "",
+	})
+
+	c.JSON(201, newInviteResponse(invite))
+}
+
+// ListInvitesHandler godoc
+//
+//	@Summary		List invites
+//	@Description	The invites of the organization, newest first
+//	@Tags			Organizations
+//	@Produce		json
+//	@Param			id	path		int	true	"Organization ID"
+//	@Success		200	{object}	[]inviteResponse
+//	@Failure		403	{object}	map[string]string
+//	@Failure		404	{object}	map[string]string
+//	@Failure		500	{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/api/organizations/{id}/invites [get]
+func (s OrganizationService) ListInvitesHandler(c *gin.Context) {
+	admin, ok := s.membership(c, true)
+	if !ok {
+		return
+	}
+
+	invites, err := s.DB.GetOrganizationInvites(admin.OrganizationID)
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, gin.H{"error": "Failed to get invites"})
+		return
+	}
+
+	response := make([]inviteResponse, len(invites))
+	for i, invite := range invites {
+		response[i] = newInviteResponse(invite)
+	}
+
+	c.JSON(200, response)
+}
+
+// DeleteInviteHandler godoc
+//
+//	@Summary		Delete an invite
+//	@Description	The code can't be used anymore, members who joined with it stay
+//	@Tags			Organizations
+//	@Produce		json
+//	@Param			id			path		int	true	"Organization ID"
+//	@Param			inviteId	path		int	true	"Invite ID"
+//	@Success		200			{object}	map[string]string
+//	@Failure		400			{object}	map[string]string
+//	@Failure		403			{object}	map[string]string
+//	@Failure		404			{object}	map[string]string
+//	@Failure		500			{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/api/organizations/{id}/invites/{inviteId} [delete]
+func (s OrganizationService) DeleteInviteHandler(c *gin.Context) {
+	admin, ok := s.membership(c, true)
+	if !ok {
+		return
+	}
+
+	inviteID, err := strconv.Atoi(c.Param("inviteId"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid invite ID"})
+		return
+	}
+
+	err = s.DB.DeleteOrganizationInvite(admin.OrganizationID, inviteID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(404, gin.H{"error": ErrInviteNotFound.Error()})
+		return
+	}
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, gin.H{"error": "Failed to delete invite"})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "Invite deleted"})
+}
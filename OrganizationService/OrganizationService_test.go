@@ -0,0 +1,270 @@
+package OrganizationService_test
+
+import (
+	"EntitlementServer/AuditLog"
+	"EntitlementServer/DatabaseAbstraction"
+	"EntitlementServer/DatabaseAbstraction/mocks"
+	"EntitlementServer/OrganizationService"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recordingAuditor keeps the events in memory
+type recordingAuditor struct {
+	events *[]DatabaseAbstraction.AuditEvent
+}
+
+func (a recordingAuditor) Record(event DatabaseAbstraction.AuditEvent) {
+	*a.events = append(*a.events, event)
+}
+
+type recordingMailer struct {
+	to   []string
+	body []string
+}
+
+func (m *recordingMailer) SendMail(to string, subject string, body string) error {
+	m.to = append(m.to, to)
+	m.body = append(m.body, body)
+	return nil
+}
+
+var (
+	teacher = DatabaseAbstraction.User{IndexID: 1, Username: "teacher", Email: "teacher@bkb.example", EmailVerified: true}
+	student = DatabaseAbstraction.User{IndexID: 2, Username: "student", Email: "student@bkb.example", EmailVerified: true}
+	school  = DatabaseAbstraction.Organization{IndexID: 5, Name: "BKB Berufskolleg"}
+)
+
+func newRouter(svc OrganizationService.OrganizationService, signedIn DatabaseAbstraction.User) (*gin.Engine, *[]DatabaseAbstraction.AuditEvent) {
+	gin.SetMode(gin.TestMode)
+	events := &[]DatabaseAbstraction.AuditEvent{}
+	svc.Audit = recordingAuditor{events}
+
+	r := gin.New()
+	svc.RegisterHandlers(r, func(c *gin.Context) {
+		c.Set("user", signedIn)
+	})
+	return r, events
+}
+
+func request(r *gin.Engine, method string, path string, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// withMembers makes the teacher admin and the student member of the school
+func withMembers(mockDB *mocks.DBOrm) {
+	mockDB.On("GetOrganizationMember", school.IndexID, teacher.IndexID).Return(DatabaseAbstraction.OrganizationMember{
+		OrganizationID: school.IndexID, UserID: teacher.IndexID, Role: DatabaseAbstraction.OrganizationRoleAdmin}, nil)
+	mockDB.On("GetOrganizationMember", school.IndexID, student.IndexID).Return(DatabaseAbstraction.OrganizationMember{
+		OrganizationID: school.IndexID, UserID: student.IndexID, Role: DatabaseAbstraction.OrganizationRoleMember}, nil)
+	mockDB.On("GetOrganizationMember", mock.Anything, mock.Anything).Return(DatabaseAbstraction.OrganizationMember{}, pgx.ErrNoRows)
+}
+
+func TestMembership(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	withMembers(mockDB)
+	mockDB.On("GetOrganizationMembers", school.IndexID).Return([]DatabaseAbstraction.OrganizationMember{
+		{OrganizationID: school.IndexID, UserID: teacher.IndexID, Username: "teacher", Role: DatabaseAbstraction.OrganizationRoleAdmin},
+	}, nil)
+
+	r, _ := newRouter(OrganizationService.OrganizationService{DB: mockDB}, teacher)
+	w := request(r, http.MethodGet, "/api/organizations/5/members", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"username":"teacher"`)
+	// organizations of others look like they don't exist
+	w = request(r, http.MethodGet, "/api/organizations/6/members", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = request(r, http.MethodGet, "/api/organizations/x/members", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	r, _ = newRouter(OrganizationService.OrganizationService{DB: mockDB}, student)
+	w = request(r, http.MethodGet, "/api/organizations/5/members", "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	mockDB.AssertNumberOfCalls(t, "GetOrganizationMembers", 1)
+}
+
+func TestCreateOrganization(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	mockDB.On("CreateOrganization", "BKB Berufskolleg", teacher.IndexID).Return(5, nil)
+	r, events := newRouter(OrganizationService.OrganizationService{DB: mockDB}, teacher)
+
+	w := request(r, http.MethodPost, "/api/organizations", `{"name": " BKB Berufskolleg "}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Contains(t, w.Body.String(), `"role":"admin"`)
+	w = request(r, http.MethodPost, "/api/organizations", `{"name": " "}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	if assert.Len(t, *events, 1) {
+		assert.Equal(t, AuditLog.ActionOrganizationCreated, (*events)[0].Action)
+		assert.Equal(t, 5, (*events)[0].Details["organization_id"])
+	}
+}
+
+func TestInviteByEmail(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	withMembers(mockDB)
+	mockDB.On("GetOrganizationByIndexID", school.IndexID).Return(school, nil)
+	mockDB.On("AddOrganizationInvite", mock.MatchedBy(func(invite DatabaseAbstraction.OrganizationInvite) bool {
+		return invite.OrganizationID == school.IndexID && invite.CreatedBy == teacher.IndexID
+	})).Return(3, nil)
+	mailer := &recordingMailer{}
+	r, events := newRouter(OrganizationService.OrganizationService{DB: mockDB, Mailer: mailer, PublicURL: "https://bkbdemy.example/"}, teacher)
+
+	w := request(r, http.MethodPost, "/api/organizations/5/invites", `{"email": "New.Student@BKB.example"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Contains(t, w.Body.String(), `"email":"new.student@bkb.example"`)
+	if assert.Len(t, mailer.to, 1) {
+		assert.Equal(t, "new.student@bkb.example", mailer.to[0])
+		assert.Contains(t, mailer.body[0], "https://bkbdemy.example/join?code=")
+	}
+
+	// a code for the whole class isn't mailed
+	w = request(r, http.MethodPost, "/api/organizations/5/invites", "")
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Len(t, mailer.to, 1)
+
+	w = request(r, http.MethodPost, "/api/organizations/5/invites", `{"email": "no address"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Len(t, *events, 2)
+}
+
+func TestJoinOrganization(t *testing.T) {
+	classCode := DatabaseAbstraction.OrganizationInvite{IndexID: 1, OrganizationID: school.IndexID, OrganizationName: school.Name, Code: "CLASS"}
+	personal := DatabaseAbstraction.OrganizationInvite{IndexID: 2, OrganizationID: school.IndexID, OrganizationName: school.Name, Code: "PERSONAL", Email: student.Email}
+	mockDB := new(mocks.DBOrm)
+	mockDB.On("GetOrganizationInviteByCode", "CLASS").Return(classCode, nil)
+	mockDB.On("GetOrganizationInviteByCode", "PERSONAL").Return(personal, nil)
+	mockDB.On("GetOrganizationInviteByCode", mock.Anything).Return(DatabaseAbstraction.OrganizationInvite{}, pgx.ErrNoRows)
+	mockDB.On("AcceptOrganizationInvite", classCode, teacher.IndexID).Return(DatabaseAbstraction.ErrAlreadyMember)
+	mockDB.On("AcceptOrganizationInvite", mock.Anything, student.IndexID).Return(nil)
+	svc := OrganizationService.OrganizationService{DB: mockDB}
+
+	_, err := svc.JoinOrganization("CLASS", student)
+	assert.NoError(t, err)
+	_, err = svc.JoinOrganization("PERSONAL", student)
+	assert.NoError(t, err)
+
+	// personal invites only work for the verified address they were sent to
+	_, err = svc.JoinOrganization("PERSONAL", teacher)
+	assert.ErrorIs(t, err, OrganizationService.ErrInviteForSomeone)
+	unverified := student
+	unverified.EmailVerified = false
+	_, err = svc.JoinOrganization("PERSONAL", unverified)
+	assert.ErrorIs(t, err, OrganizationService.ErrInviteForSomeone)
+
+	_, err = svc.JoinOrganization("WRONG", student)
+	assert.ErrorIs(t, err, OrganizationService.ErrInviteNotFound)
+
+	r, events := newRouter(svc, teacher)
+	w := request(r, http.MethodPost, "/api/organizations/join", `{"code": "CLASS"}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Empty(t, *events)
+}
+
+func TestRemoveMember(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	withMembers(mockDB)
+	mockDB.On("RemoveOrganizationMember", school.IndexID, student.IndexID).Return(nil)
+	mockDB.On("RemoveOrganizationMember", school.IndexID, 9).Return(pgx.ErrNoRows)
+	r, events := newRouter(OrganizationService.OrganizationService{DB: mockDB}, teacher)
+
+	w := request(r, http.MethodDelete, "/api/organizations/5/members/2", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = request(r, http.MethodDelete, "/api/organizations/5/members/9", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = request(r, http.MethodDelete, "/api/organizations/5/members/1", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	mockDB.AssertNotCalled(t, "RemoveOrganizationMember", school.IndexID, teacher.IndexID)
+	if assert.Len(t, *events, 1) {
+		assert.Equal(t, AuditLog.ActionMemberRemoved, (*events)[0].Action)
+		assert.Equal(t, student.IndexID, (*events)[0].TargetUserID)
+	}
+}
+
+func TestBuySeats(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	mockDB.On("GetProductByIndexID", 3).Return(DatabaseAbstraction.Product{IndexID: 3, Price: 500}, nil)
+	mockDB.On("GetProductByIndexID", 9).Return(DatabaseAbstraction.Product{}, pgx.ErrNoRows)
+	mockDB.On("GetUserByIndexID", teacher.IndexID).Return(DatabaseAbstraction.User{IndexID: teacher.IndexID, Balance: 10000}, nil)
+	mockDB.On("AddOrganizationSeats", DatabaseAbstraction.SeatPurchase{
+		OrganizationID: school.IndexID, ProductID: 3, BuyerID: teacher.IndexID, Seats: 20, Price: 500, Reason: "20 seats of product 3 for organization 5",
+	}).Return(nil)
+	svc := OrganizationService.OrganizationService{DB: mockDB}
+
+	_, err := svc.BuySeats(school.IndexID, 3, 20, teacher)
+	assert.NoError(t, err)
+	_, err = svc.BuySeats(school.IndexID, 3, 21, teacher)
+	assert.ErrorIs(t, err, OrganizationService.ErrNotEnoughMoney)
+	_, err = svc.BuySeats(school.IndexID, 3, 0, teacher)
+	assert.ErrorIs(t, err, OrganizationService.ErrInvalidSeatAmount)
+	_, err = svc.BuySeats(school.IndexID, 9, 1, teacher)
+	assert.ErrorIs(t, err, pgx.ErrNoRows)
+
+	// like every other checkout, seats need a verified email address
+	svc.RequireVerifiedEmail = true
+	_, err = svc.BuySeats(school.IndexID, 3, 20, teacher)
+	assert.ErrorIs(t, err, OrganizationService.ErrEmailNotVerified)
+	mockDB.AssertNumberOfCalls(t, "AddOrganizationSeats", 1)
+}
+
+func TestAssignSeat(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	withMembers(mockDB)
+	mockDB.On("AssignSeat", school.IndexID, 3, student.IndexID).Return(nil).Once()
+	mockDB.On("AssignSeat", school.IndexID, 3, student.IndexID).Return(DatabaseAbstraction.ErrSeatAssigned)
+	mockDB.On("AssignSeat", school.IndexID, 4, teacher.IndexID).Return(DatabaseAbstraction.ErrNoSeatsLeft)
+	mockDB.On("AssignSeat", school.IndexID, 9, student.IndexID).Return(pgx.ErrNoRows)
+	r, events := newRouter(OrganizationService.OrganizationService{DB: mockDB}, teacher)
+
+	for _, test := range []struct {
+		path   string
+		status int
+	}{
+		{"/api/organizations/5/seats/3/members/2", http.StatusOK},
+		{"/api/organizations/5/seats/3/members/2", http.StatusConflict},
+		{"/api/organizations/5/seats/4/members/1", http.StatusConflict},
+		{"/api/organizations/5/seats/9/members/2", http.StatusNotFound},
+		// not a member of the school
+		{"/api/organizations/5/seats/3/members/7", http.StatusNotFound},
+		{"/api/organizations/5/seats/x/members/2", http.StatusBadRequest},
+	} {
+		w := request(r, http.MethodPut, test.path, "")
+		assert.Equal(t, test.status, w.Code, test.path)
+	}
+
+	mockDB.AssertNotCalled(t, "AssignSeat", school.IndexID, 3, 7)
+	if assert.Len(t, *events, 1) {
+		assert.Equal(t, AuditLog.ActionSeatAssigned, (*events)[0].Action)
+		assert.Equal(t, 3, (*events)[0].Details["product_id"])
+	}
+}
+
+func TestGetProgress(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	withMembers(mockDB)
+	assignedAt := time.Date(2024, 9, 2, 8, 0, 0, 0, time.UTC)
+	mockDB.On("GetOrganizationProgress", school.IndexID).Return([]DatabaseAbstraction.SeatProgress{
+		{UserID: 2, Username: "student", ProductID: 3, ProductName: "Python", WatchedVideos: 3, TotalVideos: 4, AssignedAt: assignedAt},
+		{UserID: 2, Username: "student", ProductID: 4, ProductName: "SQL", WatchedVideos: 0, TotalVideos: 0, AssignedAt: assignedAt},
+		{UserID: 6, Username: "trainee", ProductID: 3, ProductName: "Python", WatchedVideos: 1, TotalVideos: 4, AssignedAt: assignedAt},
+	}, nil)
+	r, _ := newRouter(OrganizationService.OrganizationService{DB: mockDB}, teacher)
+
+	w := request(r, http.MethodGet, "/api/organizations/5/progress", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 2, strings.Count(w.Body.String(), `"username"`))
+	assert.Contains(t, w.Body.String(), `"percent":75`)
+	assert.Contains(t, w.Body.String(), `"percent":25`)
+}
@@ -0,0 +1,330 @@
+package OrganizationService
+
+import (
+	"EntitlementServer/AuditLog"
+	"EntitlementServer/DatabaseAbstraction"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/sirupsen/logrus"
+	"strconv"
+	"time"
+)
+
+const maxSeatsPerPurchase = 1000
+
+var (
+	ErrNotEnoughMoney   = errors.New("not enough money")
+	ErrNotAMember       = errors.New("the user is not a member of the organization")
+	ErrEmailNotVerified = errors.New("email address has to be verified before purchasing")
+)
+
+type buySeatsRequest struct {
+	ProductID int `json:"product_id"`
+	Seats     int `json:"seats"`
+}
+
+type seatsResponse struct {
+	ProductID   int    `json:"product_id"`
+	ProductName string `json:"product_name"`
+	Seats       int    `json:"seats"`
+	Assigned    int    `json:"assigned"`
+}
+
+type productProgressResponse struct {
+	ProductID     int       `json:"product_id"`
+	ProductName   string    `json:"product_name"`
+	WatchedVideos int       `json:"watched_videos"`
+	TotalVideos   int       `json:"total_videos"`
+	Percent       int       `json:"percent"`
+	AssignedAt    time.Time `json:"assigned_at"`
+}
+
+type memberProgressResponse struct {
+	UserID   int                       `json:"user_id"`
+	Username string                    `json:"username"`
+	Products []productProgressResponse `json:"products"`
+}
+
+// BuySeats debits the admin for the seats and adds them to the organization, the product's price is paid per seat
+func (s OrganizationService) BuySeats(organizationID int, productID int, seats int, admin DatabaseAbstraction.User) (DatabaseAbstraction.Product, error) {
+	if seats < 1 || seats > maxSeatsPerPurchase {
+		return DatabaseAbstraction.Product{}, ErrInvalidSeatAmount
+	}
+
+	product, err := s.DB.GetProductByIndexID(productID)
+	if err != nil {
+		return DatabaseAbstraction.Product{}, err
+	}
+
+	// the balance in the session may be outdated
+	admin, err = s.DB.GetUserByIndexID(admin.IndexID)
+	if err != nil {
+		return DatabaseAbstraction.Product{}, err
+	}
+	if s.RequireVerifiedEmail && !admin.EmailVerified {
+		return DatabaseAbstraction.Product{}, ErrEmailNotVerified
+	}
+	if admin.Balance < product.Price*seats {
+		return DatabaseAbstraction.Product{}, ErrNotEnoughMoney
+	}
+
+	return product, s.DB.AddOrganizationSeats(DatabaseAbstraction.SeatPurchase{
+		OrganizationID: organizationID,
+		ProductID:      product.IndexID,
+		BuyerID:        admin.IndexID,
+		Seats:          seats,
+		Price:          product.Price,
+		Reason:         fmt.Sprintf("%d seats of product %d for organization %d", seats, product.IndexID, organizationID),
+	})
+}
+
+// AssignSeat gives a member of the organization a seat for the product
+func (s OrganizationService) AssignSeat(organizationID int, productID int, userID int) error {
+	_, err := s.DB.GetOrganizationMember(organizationID, userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrNotAMember
+	}
+	if err != nil {
+		return err
+	}
+
+	return s.DB.AssignSeat(organizationID, productID, userID)
+}
+
+// seatParams reads the product and user of a seat route, it responds with an error and returns false if one is invalid
+func seatParams(c *gin.Context) (int, int, bool) {
+	productID, err := strconv.Atoi(c.Param("productId"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid product ID"})
+		return 0, 0, false
+	}
+	userID, err := strconv.Atoi(c.Param("userId"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid user ID"})
+		return 0, 0, false
+	}
+	return productID, userID, true
+}
+
+// BuySeatsHandler godoc
+//
+//	@Summary		Buy seats
+//	@Description	Buys seats for a product from the balance of the current admin, each seat costs the product's price
+//	@Description	Seats bought later for the same product are added to the existing ones
+//	@Tags			Organizations
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		int				true	"Organization ID"
+//	@Param			seats	body		buySeatsRequest	true	"Product and number of seats"
+//	@Success		201		{object}	map[string]string
+//	@Failure		400		{object}	map[string]string
+//	@Failure		403		{object}	map[string]string
+//	@Failure		404		{object}	map[string]string
+//	@Failure		500		{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/api/organizations/{id}/seats [post]
+func (s OrganizationService) BuySeatsHandler(c *gin.Context) {
+	admin, ok := s.membership(c, true)
+	if !ok {
+		return
+	}
+
+	var request buySeatsRequest
+	err := c.ShouldBindJSON(&request)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	product, err := s.BuySeats(admin.OrganizationID, request.ProductID, request.Seats, c.MustGet("user").(DatabaseAbstraction.User))
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		c.JSON(404, gin.H{"error": "Product not found"})
+		return
+	case errors.Is(err, ErrInvalidSeatAmount), errors.Is(err, ErrNotEnoughMoney), errors.Is(err, ErrEmailNotVerified):
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		logrus.Error(err)
+		c.JSON(500, gin.H{"error": "Failed to buy seats"})
+		return
+	}
+
+	s.record(c, AuditLog.ActionSeatsPurchased, admin.OrganizationID, 0, map[string]interface{}{
+		"product_id": product.IndexID,
+		"seats":      request.Seats,
+		"amount":     product.Price * request.Seats,
+	})
+
+	c.JSON(201, gin.H{"message": "Seats bought"})
+}
+
+// ListSeatsHandler godoc
+//
+//	@Summary		List seats
+//	@Description	The seats of the organization per product and how many of them are assigned
+//	@Tags			Organizations
+//	@Produce		json
+//	@Param			id	path		int	true	"Organization ID"
+//	@Success		200	{object}	[]seatsResponse
+//	@Failure		403	{object}	map[string]string
+//	@Failure		404	{object}	map[string]string
+//	@Failure		500	{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/api/organizations/{id}/seats [get]
+func (s OrganizationService) ListSeatsHandler(c *gin.Context) {
+	admin, ok := s.membership(c, true)
+	if !ok {
+		return
+	}
+
+	seats, err := s.DB.GetOrganizationSeats(admin.OrganizationID)
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, gin.H{"error": "Failed to get seats"})
+		return
+	}
+
+	response := make([]seatsResponse, len(seats))
+	for i, productSeats := range seats {
+		response[i] = seatsResponse{productSeats.ProductID, productSeats.ProductName, productSeats.Seats, productSeats.Assigned}
+	}
+
+	c.JSON(200, response)
+}
+
+// AssignSeatHandler godoc
+//
+//	@Summary		Assign a seat
+//	@Description	Gives a member access to the product with one of the organization's seats
+//	@Tags			Organizations
+//	@Produce		json
+//	@Param			id			path		int	true	"Organization ID"
+//	@Param			productId	path		int	true	"Product ID"
+//	@Param			userId		path		int	true	"User ID of the member"
+//	@Success		200			{object}	map[string]string
+//	@Failure		400			{object}	map[string]string
+//	@Failure		403			{object}	map[string]string
+//	@Failure		404			{object}	map[string]string
+//	@Failure		409			{object}	map[string]string
+//	@Failure		500			{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/api/organizations/{id}/seats/{productId}/members/{userId} [put]
+func (s OrganizationService) AssignSeatHandler(c *gin.Context) {
+	admin, ok := s.membership(c, true)
+	if !ok {
+		return
+	}
+	productID, userID, ok := seatParams(c)
+	if !ok {
+		return
+	}
+
+	err := s.AssignSeat(admin.OrganizationID, productID, userID)
+	switch {
+	case errors.Is(err, ErrNotAMember):
+		c.JSON(404, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, pgx.ErrNoRows):
+		c.JSON(404, gin.H{"error": "The organization has no seats for the product"})
+		return
+	case errors.Is(err, DatabaseAbstraction.ErrNoSeatsLeft), errors.Is(err, DatabaseAbstraction.ErrSeatAssigned):
+		c.JSON(409, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		logrus.Error(err)
+		c.JSON(500, gin.H{"error": "Failed to assign seat"})
+		return
+	}
+
+	s.record(c, AuditLog.ActionSeatAssigned, admin.OrganizationID, userID, map[string]interface{}{"product_id": productID})
+
+	c.JSON(200, gin.H{"message": "Seat assigned"})
+}
+
+// ReclaimSeatHandler godoc
+//
+//	@Summary		Reclaim a seat
+//	@Description	Takes the seat back from the member, it can be assigned to somebody else right away
+//	@Tags			Organizations
+//	@Produce		json
+//	@Param			id			path		int	true	"Organization ID"
+//	@Param			productId	path		int	true	"Product ID"
+//	@Param			userId		path		int	true	"User ID of the member"
+//	@Success		200			{object}	map[string]string
+//	@Failure		400			{object}	map[string]string
+//	@Failure		403			{object}	map[string]string
+//	@Failure		404			{object}	map[string]string
+//	@Failure		500			{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/api/organizations/{id}/seats/{productId}/members/{userId} [delete]
+func (s OrganizationService) ReclaimSeatHandler(c *gin.Context) {
+	admin, ok := s.membership(c, true)
+	if !ok {
+		return
+	}
+	productID, userID, ok := seatParams(c)
+	if !ok {
+		return
+	}
+
+	err := s.DB.ReclaimSeat(admin.OrganizationID, productID, userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(404, gin.H{"error": "Seat not found"})
+		return
+	}
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, gin.H{"error": "Failed to reclaim seat"})
+		return
+	}
+
+	s.record(c, AuditLog.ActionSeatReclaimed, admin.OrganizationID, userID, map[string]interface{}{"product_id": productID})
+
+	c.JSON(200, gin.H{"message": "Seat reclaimed"})
+}
+
+// GetProgressHandler godoc
+//
+//	@Summary		Class progress
+//	@Description	The progress of every member in the products they have a seat for, by member
+//	@Tags			Organizations
+//	@Produce		json
+//	@Param			id	path		int	true	"Organization ID"
+//	@Success		200	{object}	[]memberProgressResponse
+//	@Failure		403	{object}	map[string]string
+//	@Failure		404	{object}	map[string]string
+//	@Failure		500	{object}	map[string]string
+//	@Security		ApiKeyAuth
+//	@Router			/api/organizations/{id}/progress [get]
+func (s OrganizationService) GetProgressHandler(c *gin.Context) {
+	admin, ok := s.membership(c, true)
+	if !ok {
+		return
+	}
+
+	progress, err := s.DB.GetOrganizationProgress(admin.OrganizationID)
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, gin.H{"error": "Failed to get progress"})
+		return
+	}
+
+	// the rows are ordered by member, so each member's products follow each other
+	response := []memberProgressResponse{}
+	for _, seat := range progress {
+		if len(response) == 0 || response[len(response)-1].UserID != seat.UserID {
+			response = append(response, memberProgressResponse{UserID: seat.UserID, Username: seat.Username, Products: []productProgressResponse{}})
+		}
+		percent := 0
+		if seat.TotalVideos > 0 {
+			percent = seat.WatchedVideos * 100 / seat.TotalVideos
+		}
+		member := &response[len(response)-1]
+		member.Products = append(member.Products, productProgressResponse{seat.ProductID, seat.ProductName, seat.WatchedVideos, seat.TotalVideos, percent, seat.AssignedAt})
+	}
+
+	c.JSON(200, response)
+}
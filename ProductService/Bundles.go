@@ -0,0 +1,297 @@
+package ProductService
+
+import (
+	"EntitlementServer/AuditLog"
+	"EntitlementServer/DatabaseAbstraction"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/sirupsen/logrus"
+	"strconv"
+)
+
+var ErrBundleAlreadyOwned = errors.New("user already owns every product of the bundle")
+
+type bundleProductResponse struct {
+	ID    int
+	Name  string
+	Price int
+	Image string
+	Owned bool
+}
+
+type bundleResponse struct {
+	ID          int
+	Name        string
+	Description string
+	Image       string
+	Price       int // bundle price without credit
+	ListPrice   int // sum of the product prices
+	Credit      int // discount for products the user already owns
+	DuePrice    int // what the user pays, Price minus Credit
+	Products    []bundleProductResponse
+}
+
+// bundlePrice is what a user pays for the products of the bundle they don't own yet
+// Owned products are credited with their share of the list price, so the bundle discount applies to the rest as well.
+// The share is rounded down in favor of the user.
+func bundlePrice(bundle DatabaseAbstraction.Bundle, products map[int]DatabaseAbstraction.Product, owned map[int]bool) int {
+	var listPrice, missingPrice int64
+	for _, productID := range bundle.ProductIDs {
+		weight := bundleWeight(products[productID])
+		listPrice += weight
+		if !owned[productID] {
+			missingPrice += weight
+		}
+	}
+	if listPrice == 0 {
+		return bundle.Price
+	}
+	return int(int64(bundle.Price) * missingPrice / listPrice)
+}
+
+// bundleWeight is the share of a product in the bundle price
+// Free products count as one so bundles of free products still split their price
+func bundleWeight(product DatabaseAbstraction.Product) int64 {
+	if product.Price == 0 {
+		return 1
+	}
+	return int64(product.Price)
+}
+
+// splitBundlePrice distributes the price over the items by their list price, the purchase rows record what each product cost.
+// The last item gets the rounding remainder so the items add up to the price
+func splitBundlePrice(price int, items []DatabaseAbstraction.PurchaseItem, products map[int]DatabaseAbstraction.Product) {
+	var total int64
+	for _, item := range items {
+		total += bundleWeight(products[item.ProductID])
+	}
+	remaining := price
+	for i := range items {
+		if i == len(items)-1 {
+			items[i].Price = remaining
+			break
+		}
+		items[i].Price = int(int64(price) * bundleWeight(products[items[i].ProductID]) / total)
+		remaining -= items[i].Price
+	}
+}
+
+// bundleProducts loads the products of the bundles with a single query
+func (p ProductService) bundleProducts() (map[int]DatabaseAbstraction.Product, error) {
+	products, err := p.DB.GetAllProducts()
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[int]DatabaseAbstraction.Product, len(products))
+	for _, product := range products {
+		byID[product.IndexID] = product
+	}
+	return byID, nil
+}
+
+// ownedProductIDs returns the products of the signed in user, anonymous users own nothing
+func (p ProductService) ownedProductIDs(c *gin.Context) (map[int]bool, error) {
+	user, signedIn := c.Get("user")
+	if !signedIn {
+		return map[int]bool{}, nil
+	}
+	return p.ownedProductsOf(user.(DatabaseAbstraction.User).IndexID)
+}
+
+// ownedProductsOf returns the products the user owns for good, rentals and subscriptions are left out
+func (p ProductService) ownedProductsOf(userID int) (map[int]bool, error) {
+	ownedProducts, err := p.DB.GetOwnedProducts(userID)
+	if err != nil {
+		return nil, err
+	}
+	owned := make(map[int]bool, len(ownedProducts))
+	for _, product := range ownedProducts {
+		owned[product.IndexID] = true
+	}
+	return owned, nil
+}
+
+func newBundleResponse(bundle DatabaseAbstraction.Bundle, products map[int]DatabaseAbstraction.Product, owned map[int]bool) bundleResponse {
+	response := bundleResponse{
+		ID:          bundle.IndexID,
+		Name:        bundle.Name,
+		Description: bundle.Description,
+		Image:       bundle.Image,
+		Price:       bundle.Price,
+		DuePrice:    bundlePrice(bundle, products, owned),
+		Products:    []bundleProductResponse{},
+	}
+	response.Credit = response.Price - response.DuePrice
+	for _, productID := range bundle.ProductIDs {
+		product := products[productID]
+		response.ListPrice += product.Price
+		response.Products = append(response.Products, bundleProductResponse{product.IndexID, product.Name, product.Price, product.Image, owned[productID]})
+	}
+	return response
+}
+
+// GetBundlesHandler godoc
+// @Summary List the bundles
+// @Description Learning paths of several products for one price. Signed in users get credit for the products they own
+// @Tags Bundles
+// @Accept  json
+// @Produce  json
+// @Success 200 {object} []bundleResponse
+// @Failure 500 {object} productErrorResponse
+// @Router /api/bundles [get]
+func (p ProductService) GetBundlesHandler(c *gin.Context) {
+	bundles, err := p.DB.GetBundles()
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, productErrorResponse{Error: "failed to get bundles"})
+		return
+	}
+	products, err := p.bundleProducts()
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, productErrorResponse{Error: "failed to get products"})
+		return
+	}
+	owned, err := p.ownedProductIDs(c)
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, productErrorResponse{Error: "failed to get owned products"})
+		return
+	}
+
+	response := make([]bundleResponse, len(bundles))
+	for i, bundle := range bundles {
+		response[i] = newBundleResponse(bundle, products, owned)
+	}
+
+	c.JSON(200, response)
+}
+
+// GetBundleHandler godoc
+// @Summary Get a bundle
+// @Description Get a bundle with its products in the recommended order
+// @Tags Bundles
+// @Accept  json
+// @Produce  json
+// @Param id path int true "Bundle ID"
+// @Success 200 {object} bundleResponse
+// @Failure 400 {object} productErrorResponse
+// @Failure 404 {object} productErrorResponse
+// @Failure 500 {object} productErrorResponse
+// @Router /api/bundles/{id} [get]
+func (p ProductService) GetBundleHandler(c *gin.Context) {
+	bundleID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, productErrorResponse{Error: "invalid bundle id"})
+		return
+	}
+
+	bundle, err := p.DB.GetBundleByIndexID(bundleID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(404, productErrorResponse{Error: "bundle not found"})
+		return
+	}
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, productErrorResponse{Error: "failed to get bundle"})
+		return
+	}
+	products, err := p.bundleProducts()
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, productErrorResponse{Error: "failed to get products"})
+		return
+	}
+	owned, err := p.ownedProductIDs(c)
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, productErrorResponse{Error: "failed to get owned products"})
+		return
+	}
+
+	c.JSON(200, newBundleResponse(bundle, products, owned))
+}
+
+// PurchaseBundle grants the products of the bundle the user doesn't own yet, they pay the bundle price minus their credit
+func (p ProductService) PurchaseBundle(bundleID int, user DatabaseAbstraction.User) error {
+	bundle, err := p.DB.GetBundleByIndexID(bundleID)
+	if err != nil {
+		return err
+	}
+	products, err := p.bundleProducts()
+	if err != nil {
+		return err
+	}
+
+	user, err = p.prepareCheckout(user)
+	if err != nil {
+		return err
+	}
+	// only products owned for good are credited, a rental or subscription ends
+	ownedProducts, err := p.ownedProductsOf(user.IndexID)
+	if err != nil {
+		return err
+	}
+
+	var items []DatabaseAbstraction.PurchaseItem
+	for _, productID := range bundle.ProductIDs {
+		if !ownedProducts[productID] {
+			items = append(items, DatabaseAbstraction.PurchaseItem{ProductID: productID})
+		}
+	}
+	if len(items) == 0 {
+		return ErrBundleAlreadyOwned
+	}
+	splitBundlePrice(bundlePrice(bundle, products, ownedProducts), items, products)
+
+	return p.checkout(user, DatabaseAbstraction.PurchaseOrder{
+		UserID:   user.IndexID,
+		Items:    items,
+		Reason:   fmt.Sprintf("purchase of bundle %d", bundle.IndexID),
+		BundleID: bundle.IndexID,
+	})
+}
+
+// PurchaseBundleHandler godoc
+// @Summary Purchase a bundle
+// @Description Purchase the products of a bundle the user doesn't own yet, owned products are credited
+// @Tags Bundles
+// @Accept  json
+// @Produce  json
+// @Param id path int true "Bundle ID"
+// @Success 200 {object} purchaseProductResponse
+// @Failure 400 {object} purchaseProductResponse
+// @Failure 404 {object} purchaseProductResponse
+// @Security ApiKeyAuth
+// @Router /api/bundles/{id}/purchase [post]
+func (p ProductService) PurchaseBundleHandler(c *gin.Context) {
+	bundleID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, purchaseProductResponse{Error: "invalid bundle id"})
+		return
+	}
+
+	user := c.MustGet("user").(DatabaseAbstraction.User)
+
+	event := AuditLog.NewEvent(c, AuditLog.ActionPurchase)
+	event.Details["bundle_id"] = bundleID
+
+	err = p.PurchaseBundle(bundleID, user)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(404, purchaseProductResponse{Error: "bundle not found"})
+		return
+	}
+	if err != nil {
+		event.Outcome = AuditLog.OutcomeFailure
+		event.Details["reason"] = err.Error()
+		AuditLog.OrDefault(p.Audit).Record(event)
+		c.JSON(400, purchaseProductResponse{Error: "Error purchasing bundle: " + err.Error()})
+		return
+	}
+
+	AuditLog.OrDefault(p.Audit).Record(event)
+
+	c.JSON(200, purchaseProductResponse{Message: "bundle purchased"})
+}
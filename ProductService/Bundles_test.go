@@ -0,0 +1,120 @@
+package ProductService_test
+
+import (
+	"EntitlementServer/DatabaseAbstraction"
+	"EntitlementServer/DatabaseAbstraction/mocks"
+	"EntitlementServer/ProductService"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+var webBundle = DatabaseAbstraction.Bundle{IndexID: 1, Name: "Lernpfad Webentwicklung", Price: 1000, ProductIDs: []int{3, 6, 8}}
+
+var bundleMembers = []DatabaseAbstraction.Product{
+	{IndexID: 3, Name: "Erstelle deine eigene Webseite", Price: 500},
+	{IndexID: 6, Name: "One-Page Website mit HTML & CSS", Price: 500},
+	{IndexID: 8, Name: "Javascript Tutorial für Anfänger", Price: 300},
+}
+
+func bundleDB(balance int, owned ...DatabaseAbstraction.Product) *mocks.DBOrm {
+	mockDB := new(mocks.DBOrm)
+	mockDB.On("GetBundleByIndexID", 1).Return(webBundle, nil)
+	mockDB.On("GetAllProducts").Return(bundleMembers, nil)
+	mockDB.On("GetUserByIndexID", 2).Return(DatabaseAbstraction.User{IndexID: 2, Balance: balance}, nil)
+	mockDB.On("GetOwnedProducts", 2).Return(owned, nil)
+	return mockDB
+}
+
+func TestGetBundleCreditsOwnedProducts(t *testing.T) {
+	mockDB := bundleDB(0, bundleMembers[1])
+	user := DatabaseAbstraction.User{IndexID: 2}
+
+	w := httpGet(newCatalogRouter(mockDB, &user), "/api/bundles/1")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var bundle struct {
+		Price, ListPrice, Credit, DuePrice int
+		Products                           []struct {
+			ID    int
+			Owned bool
+		}
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &bundle))
+	assert.Equal(t, 1300, bundle.ListPrice)
+	// the owned course makes up 500 of 1300 of the list price
+	assert.Equal(t, 615, bundle.DuePrice)
+	assert.Equal(t, 385, bundle.Credit)
+	assert.True(t, bundle.Products[1].Owned)
+
+	// anonymous users pay the full bundle price
+	w = httpGet(newCatalogRouter(mockDB, nil), "/api/bundles/1")
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &bundle))
+	assert.Equal(t, 1000, bundle.DuePrice)
+}
+
+func TestPurchaseBundleGrantsMissingProducts(t *testing.T) {
+	mockDB := bundleDB(700, bundleMembers[1])
+	// the price is split by list price, the last product gets the rounding remainder
+	mockDB.On("PurchaseProducts", DatabaseAbstraction.PurchaseOrder{
+		UserID:   2,
+		Items:    []DatabaseAbstraction.PurchaseItem{{ProductID: 3, Price: 384}, {ProductID: 8, Price: 231}},
+		Reason:   "purchase of bundle 1",
+		BundleID: 1,
+	}).Return(nil)
+	svc := ProductService.ProductService{DB: mockDB}
+
+	err := svc.PurchaseBundle(1, DatabaseAbstraction.User{IndexID: 2})
+	assert.NoError(t, err)
+	mockDB.AssertExpectations(t)
+}
+
+func TestPurchaseBundleChecks(t *testing.T) {
+	svc := ProductService.ProductService{DB: bundleDB(5000, bundleMembers...)}
+	assert.ErrorIs(t, svc.PurchaseBundle(1, DatabaseAbstraction.User{IndexID: 2}), ProductService.ErrBundleAlreadyOwned)
+
+	mockDB := bundleDB(999)
+	svc = ProductService.ProductService{DB: mockDB}
+	assert.ErrorIs(t, svc.PurchaseBundle(1, DatabaseAbstraction.User{IndexID: 2}), ProductService.ErrNotEnoughMoney)
+	mockDB.AssertNotCalled(t, "PurchaseProducts", mock.Anything)
+}
+
+func TestPurchaseBundleHandler(t *testing.T) {
+	mockDB := bundleDB(1000)
+	mockDB.On("PurchaseProducts", mock.Anything).Return(nil)
+	user := DatabaseAbstraction.User{IndexID: 2}
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/bundles/1/purchase", nil)
+	newCatalogRouter(mockDB, &user).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestPurchaseProductIsOneOrder(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	mockDB.On("GetProductByIndexID", 3).Return(bundleMembers[0], nil)
+	mockDB.On("GetUserByIndexID", 2).Return(DatabaseAbstraction.User{IndexID: 2, Balance: 500}, nil)
+	mockDB.On("GetEntitlements", 2).Return([]DatabaseAbstraction.Entitlement{}, nil).Once()
+	mockDB.On("PurchaseProducts", DatabaseAbstraction.PurchaseOrder{UserID: 2, Items: []DatabaseAbstraction.PurchaseItem{{ProductID: 3, Price: 500}}, Reason: "purchase of product 3"}).Return(nil)
+	svc := ProductService.ProductService{DB: mockDB}
+
+	assert.NoError(t, svc.PurchaseProduct(3, DatabaseAbstraction.User{IndexID: 2}, ""))
+
+	mockDB.On("GetEntitlements", 2).Return([]DatabaseAbstraction.Entitlement{{Source: DatabaseAbstraction.EntitlementPurchase, ProductID: 3}}, nil)
+	assert.ErrorIs(t, svc.PurchaseProduct(3, DatabaseAbstraction.User{IndexID: 2}, ""), ProductService.ErrProductAlreadyOwned)
+	mockDB.AssertNumberOfCalls(t, "PurchaseProducts", 1)
+}
+
+func TestConcurrentPurchaseIsRejected(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	mockDB.On("GetProductByIndexID", 3).Return(bundleMembers[0], nil)
+	mockDB.On("GetUserByIndexID", 2).Return(DatabaseAbstraction.User{IndexID: 2, Balance: 1000}, nil)
+	mockDB.On("GetEntitlements", 2).Return([]DatabaseAbstraction.Entitlement{}, nil)
+	// both requests passed the ownership check, the second checkout finds the product of the first one
+	mockDB.On("PurchaseProducts", mock.Anything).Return(DatabaseAbstraction.ErrProductOwned)
+	svc := ProductService.ProductService{DB: mockDB}
+
+	assert.ErrorIs(t, svc.PurchaseProduct(3, DatabaseAbstraction.User{IndexID: 2}, ""), ProductService.ErrProductAlreadyOwned)
+}
@@ -0,0 +1,233 @@
+package ProductService
+
+import (
+	"EntitlementServer/DatabaseAbstraction"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultCatalogPageSize = 20
+	maxCatalogPageSize     = 100
+)
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+type catalogProductResponse struct {
+	productResponse
+	CategoryID int // 0 for uncategorized products
+	Tags       []string
+	Purchases  int
+}
+
+type catalogResponse struct {
+	Products   []catalogProductResponse `json:"products"`
+	NextCursor string                   `json:"next_cursor,omitempty"` // empty on the last page
+}
+
+// catalogCursor is the opaque next_cursor, it only continues the sort order it was created for
+type catalogCursor struct {
+	Sort      string    `json:"s"`
+	ID        int       `json:"id"`
+	CreatedAt time.Time `json:"c"`
+	Price     int       `json:"p"`
+	Purchases int       `json:"n"`
+}
+
+func encodeCursor(sort string, cursor DatabaseAbstraction.ProductCursor) string {
+	encoded, _ := json.Marshal(catalogCursor{sort, cursor.ID, cursor.CreatedAt, cursor.Price, cursor.Purchases})
+	return base64.RawURLEncoding.EncodeToString(encoded)
+}
+
+func decodeCursor(sort string, value string) (*DatabaseAbstraction.ProductCursor, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	var cursor catalogCursor
+	err = json.Unmarshal(decoded, &cursor)
+	if err != nil || cursor.Sort != sort || cursor.ID < 1 {
+		return nil, errInvalidCursor
+	}
+	return &DatabaseAbstraction.ProductCursor{ID: cursor.ID, CreatedAt: cursor.CreatedAt, Price: cursor.Price, Purchases: cursor.Purchases}, nil
+}
+
+// catalogQuery reads the query parameters of the catalog, it responds with an error and returns false if one is invalid
+func (p ProductService) catalogQuery(c *gin.Context) (DatabaseAbstraction.ProductQuery, bool) {
+	query := DatabaseAbstraction.ProductQuery{
+		Sort:  c.DefaultQuery("sort", DatabaseAbstraction.ProductSortNewest),
+		Limit: defaultCatalogPageSize,
+	}
+	fail := func(message string) (DatabaseAbstraction.ProductQuery, bool) {
+		c.JSON(400, productErrorResponse{Error: message})
+		return query, false
+	}
+
+	switch query.Sort {
+	case DatabaseAbstraction.ProductSortNewest, DatabaseAbstraction.ProductSortPriceAsc, DatabaseAbstraction.ProductSortPriceDesc, DatabaseAbstraction.ProductSortPopular:
+	default:
+		return fail("invalid sort, expected newest, price_asc, price_desc or popular")
+	}
+
+	if c.Query("limit") != "" {
+		limit, err := strconv.Atoi(c.Query("limit"))
+		if err != nil || limit < 1 || limit > maxCatalogPageSize {
+			return fail("invalid limit, expected 1 to 100")
+		}
+		query.Limit = limit
+	}
+
+	if c.Query("cursor") != "" {
+		cursor, err := decodeCursor(query.Sort, c.Query("cursor"))
+		if err != nil {
+			return fail(err.Error())
+		}
+		query.After = cursor
+	}
+
+	for _, value := range splitList(c.Query("difficulty")) {
+		difficulty, err := strconv.Atoi(value)
+		if err != nil {
+			return fail("invalid difficulty")
+		}
+		query.Difficulties = append(query.Difficulties, difficulty)
+	}
+
+	for param, target := range map[string]**int{"min_price": &query.MinPrice, "max_price": &query.MaxPrice} {
+		if c.Query(param) == "" {
+			continue
+		}
+		price, err := strconv.Atoi(c.Query(param))
+		if err != nil || price < 0 {
+			return fail("invalid " + param)
+		}
+		*target = &price
+	}
+
+	for _, tag := range splitList(c.Query("tags")) {
+		query.Tags = append(query.Tags, strings.ToLower(tag))
+	}
+
+	if c.Query("category") != "" {
+		categoryID, err := p.categoryID(c.Query("category"))
+		if errors.Is(err, errUnknownCategory) {
+			return fail(err.Error())
+		}
+		if err != nil {
+			logrus.Error(err)
+			c.JSON(500, productErrorResponse{Error: "failed to get categories"})
+			return query, false
+		}
+		query.CategoryID = categoryID
+	}
+
+	if c.Query("owned") != "" {
+		owned, err := strconv.ParseBool(c.Query("owned"))
+		if err != nil {
+			return fail("invalid owned, expected true or false")
+		}
+		user, signedIn := c.Get("user")
+		if !signedIn {
+			c.JSON(401, productErrorResponse{Error: "sign in to filter by ownership"})
+			return query, false
+		}
+		query.UserID = user.(DatabaseAbstraction.User).IndexID
+		query.Owned = &owned
+	}
+
+	return query, true
+}
+
+// splitList splits a comma separated query parameter, ignoring empty entries
+func splitList(value string) []string {
+	var entries []string
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// GetCatalogHandler godoc
+// @Summary List the product catalog
+// @Description Products page by page, newest first by default. Pass next_cursor as cursor to get the next page
+// @Description difficulty and tags are comma separated, a product needs all of the tags. owned requires a signed in user
+// @Description category is an ID or a slug and includes its subcategories
+// @Tags Products
+// @Accept  json
+// @Produce  json
+// @Param sort query string false "newest, price_asc, price_desc or popular"
+// @Param limit query int false "Products per page, at most 100"
+// @Param cursor query string false "next_cursor of the previous page"
+// @Param difficulty query string false "Difficulties, e.g. 1,2"
+// @Param min_price query int false "Minimum price"
+// @Param max_price query int false "Maximum price"
+// @Param tags query string false "Tags, e.g. web,backend"
+// @Param category query string false "Category ID or slug"
+// @Param owned query bool false "Only owned or only not owned products"
+// @Success 200 {object} catalogResponse
+// @Failure 400 {object} productErrorResponse
+// @Failure 401 {object} productErrorResponse
+// @Failure 500 {object} productErrorResponse
+// @Router /api/products [get]
+func (p ProductService) GetCatalogHandler(c *gin.Context) {
+	query, ok := p.catalogQuery(c)
+	if !ok {
+		return
+	}
+
+	// one more than the page tells whether there is a next page
+	pageSize := query.Limit
+	query.Limit++
+	catalogProducts, err := p.DB.GetCatalog(query)
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, productErrorResponse{Error: "failed to get products"})
+		return
+	}
+
+	response := catalogResponse{Products: []catalogProductResponse{}}
+	if len(catalogProducts) > pageSize {
+		catalogProducts = catalogProducts[:pageSize]
+		response.NextCursor = encodeCursor(query.Sort, catalogProducts[pageSize-1].Cursor())
+	}
+
+	products := make([]DatabaseAbstraction.Product, len(catalogProducts))
+	for i, product := range catalogProducts {
+		products[i] = product.Product
+	}
+	enriched, err := p.enrichDatabaseProducts(products, false)
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, productErrorResponse{Error: "failed to get products"})
+		return
+	}
+	for i, product := range enriched {
+		response.Products = append(response.Products, catalogProductResponse{
+			productResponse: productResponse{
+				CreatedAt:   product.CreatedAt,
+				UpdatedAt:   product.UpdatedAt,
+				ID:          product.ID,
+				Name:        product.Name,
+				Description: product.Description,
+				Price:       product.Price,
+				Image:       product.Image,
+				Difficulty:  product.Difficulty,
+				PreviewURL:  product.PreviewURL,
+				Videos:      product.Videos,
+			},
+			CategoryID: catalogProducts[i].CategoryID,
+			Tags:       catalogProducts[i].Tags,
+			Purchases:  catalogProducts[i].Purchases,
+		})
+	}
+
+	c.JSON(200, response)
+}
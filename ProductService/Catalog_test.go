@@ -0,0 +1,115 @@
+package ProductService_test
+
+import (
+	"EntitlementServer/DatabaseAbstraction"
+	"EntitlementServer/DatabaseAbstraction/mocks"
+	"EntitlementServer/ProductService"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+type catalogPage struct {
+	Products []struct {
+		ID   int
+		Tags []string
+	} `json:"products"`
+	NextCursor string `json:"next_cursor"`
+}
+
+func newCatalogRouter(mockDB *mocks.DBOrm, signedIn *DatabaseAbstraction.User) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	authenticate := func(c *gin.Context) {
+		if signedIn != nil {
+			c.Set("user", *signedIn)
+		}
+	}
+	ProductService.ProductService{DB: mockDB}.RegisterHandlers(r, authenticate, authenticate)
+	return r
+}
+
+func httpGet(r *gin.Engine, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", path, nil)
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func getCatalog(r *gin.Engine, query string) *httptest.ResponseRecorder {
+	return httpGet(r, "/api/products?"+query)
+}
+
+func catalogProduct(id int, price int) DatabaseAbstraction.CatalogProduct {
+	return DatabaseAbstraction.CatalogProduct{
+		Product: DatabaseAbstraction.Product{IndexID: id, Price: price, CreatedAt: time.Date(2024, 5, id, 12, 0, 0, 0, time.UTC)},
+		Tags:    []string{"web"},
+	}
+}
+
+func TestCatalogCursorPagination(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	mockDB.On("GetVideosByProductIndexIDs", mock.Anything).Return(map[int][]DatabaseAbstraction.Video{}, nil)
+	// the filters reach the query and one more product than the page is requested
+	mockDB.On("GetCatalog", mock.MatchedBy(func(query DatabaseAbstraction.ProductQuery) bool {
+		return query.After == nil && query.Limit == 3 && query.Sort == DatabaseAbstraction.ProductSortPriceAsc &&
+			*query.MaxPrice == 1000 && assert.ObjectsAreEqual([]string{"web", "backend"}, query.Tags) && assert.ObjectsAreEqual([]int{1, 2}, query.Difficulties)
+	})).Return([]DatabaseAbstraction.CatalogProduct{catalogProduct(3, 300), catalogProduct(1, 500), catalogProduct(2, 500)}, nil).Once()
+	mockDB.On("GetCatalog", mock.MatchedBy(func(query DatabaseAbstraction.ProductQuery) bool {
+		return query.After != nil && query.After.ID == 1 && query.After.Price == 500
+	})).Return([]DatabaseAbstraction.CatalogProduct{catalogProduct(2, 500)}, nil).Once()
+	r := newCatalogRouter(mockDB, nil)
+
+	w := getCatalog(r, "sort=price_asc&limit=2&max_price=1000&tags=web,Backend&difficulty=1,2")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var page catalogPage
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+	assert.Len(t, page.Products, 2)
+	assert.Equal(t, []string{"web"}, page.Products[0].Tags)
+	assert.NotEmpty(t, page.NextCursor)
+	cursor := page.NextCursor
+
+	w = getCatalog(r, "sort=price_asc&limit=2&cursor="+url.QueryEscape(cursor))
+	assert.Equal(t, http.StatusOK, w.Code)
+	page = catalogPage{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+	assert.Len(t, page.Products, 1)
+	assert.Empty(t, page.NextCursor)
+
+	// a cursor only continues the order it was created for
+	w = getCatalog(r, "sort=newest&cursor="+url.QueryEscape(cursor))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	mockDB.AssertExpectations(t)
+}
+
+func TestCatalogRejectsInvalidParameters(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	r := newCatalogRouter(mockDB, nil)
+
+	for _, query := range []string{"sort=cheapest", "limit=1000", "min_price=-1", "difficulty=hard", "owned=maybe"} {
+		assert.Equal(t, http.StatusBadRequest, getCatalog(r, query).Code, query)
+	}
+	// ownership needs a signed in user
+	assert.Equal(t, http.StatusUnauthorized, getCatalog(r, "owned=true").Code)
+	mockDB.AssertNotCalled(t, "GetCatalog", mock.Anything)
+}
+
+func TestCatalogOwnedFilter(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	mockDB.On("GetCatalog", mock.MatchedBy(func(query DatabaseAbstraction.ProductQuery) bool {
+		return query.UserID == 4 && query.Owned != nil && !*query.Owned
+	})).Return([]DatabaseAbstraction.CatalogProduct{}, nil)
+	r := newCatalogRouter(mockDB, &DatabaseAbstraction.User{IndexID: 4})
+
+	w := getCatalog(r, "owned=false")
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"products": []}`, w.Body.String())
+	mockDB.AssertExpectations(t)
+}
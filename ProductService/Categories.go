@@ -0,0 +1,181 @@
+package ProductService
+
+import (
+	"EntitlementServer/DatabaseAbstraction"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/sirupsen/logrus"
+	"strconv"
+)
+
+const (
+	defaultRelatedProducts = 5
+	maxRelatedProducts     = 20
+)
+
+var errUnknownCategory = errors.New("unknown category")
+
+type categoryResponse struct {
+	ID       int
+	Name     string
+	Slug     string
+	Children []categoryResponse
+}
+
+type relatedProductResponse struct {
+	productResponse
+	SharedTags  int
+	CoPurchases int
+}
+
+// categoryTree nests the categories below their parents, categories whose parent is missing become top-level categories
+func categoryTree(categories []DatabaseAbstraction.Category) []categoryResponse {
+	known := make(map[int]bool, len(categories))
+	children := make(map[int][]DatabaseAbstraction.Category)
+	for _, category := range categories {
+		known[category.IndexID] = true
+	}
+	for _, category := range categories {
+		parentID := category.ParentID
+		if !known[parentID] {
+			parentID = 0
+		}
+		children[parentID] = append(children[parentID], category)
+	}
+
+	var build func(parentID int) []categoryResponse
+	build = func(parentID int) []categoryResponse {
+		nodes := []categoryResponse{}
+		for _, category := range children[parentID] {
+			nodes = append(nodes, categoryResponse{
+				ID:       category.IndexID,
+				Name:     category.Name,
+				Slug:     category.Slug,
+				Children: build(category.IndexID),
+			})
+		}
+		return nodes
+	}
+
+	return build(0)
+}
+
+// categoryID resolves a category ID or slug, IDs are not checked since an unknown one simply matches no products
+func (p ProductService) categoryID(value string) (int, error) {
+	if id, err := strconv.Atoi(value); err == nil {
+		return id, nil
+	}
+
+	categories, err := p.DB.GetCategories()
+	if err != nil {
+		return 0, err
+	}
+	for _, category := range categories {
+		if category.Slug == value {
+			return category.IndexID, nil
+		}
+	}
+	return 0, errUnknownCategory
+}
+
+// GetCategoriesHandler godoc
+// @Summary List the categories
+// @Description All categories as a tree, sorted by name
+// @Tags Products
+// @Accept  json
+// @Produce  json
+// @Success 200 {object} []categoryResponse
+// @Failure 500 {object} productErrorResponse
+// @Router /api/categories [get]
+func (p ProductService) GetCategoriesHandler(c *gin.Context) {
+	categories, err := p.DB.GetCategories()
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, productErrorResponse{Error: "failed to get categories"})
+		return
+	}
+
+	c.JSON(200, categoryTree(categories))
+}
+
+// GetRelatedProductsHandler godoc
+// @Summary Get related products
+// @Description Products sharing tags with the product or bought by the same users, most related first
+// @Tags Products
+// @Accept  json
+// @Produce  json
+// @Param id path int true "Product ID"
+// @Param limit query int false "Number of products, at most 20"
+// @Success 200 {object} []relatedProductResponse
+// @Failure 400 {object} productErrorResponse
+// @Failure 404 {object} productErrorResponse
+// @Failure 500 {object} productErrorResponse
+// @Router /api/products/{id}/related [get]
+func (p ProductService) GetRelatedProductsHandler(c *gin.Context) {
+	productID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, productErrorResponse{Error: "invalid product id"})
+		return
+	}
+
+	limit := defaultRelatedProducts
+	if c.Query("limit") != "" {
+		limit, err = strconv.Atoi(c.Query("limit"))
+		if err != nil || limit < 1 || limit > maxRelatedProducts {
+			c.JSON(400, productErrorResponse{Error: "invalid limit, expected 1 to 20"})
+			return
+		}
+	}
+
+	_, err = p.DB.GetProductByIndexID(productID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(404, productErrorResponse{Error: "product not found"})
+		return
+	}
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, productErrorResponse{Error: "failed to get product"})
+		return
+	}
+
+	related, err := p.DB.GetRelatedProducts(productID, limit)
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, productErrorResponse{Error: "failed to get related products"})
+		return
+	}
+
+	products := make([]DatabaseAbstraction.Product, len(related))
+	for i, product := range related {
+		products[i] = product.Product
+	}
+	enriched, err := p.enrichDatabaseProducts(products, false)
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, productErrorResponse{Error: "failed to get related products"})
+		return
+	}
+
+	response := make([]relatedProductResponse, len(enriched))
+	for i, product := range enriched {
+		response[i] = relatedProductResponse{
+			productResponse: productResponse{
+				CreatedAt:   product.CreatedAt,
+				UpdatedAt:   product.UpdatedAt,
+				ID:          product.ID,
+				Name:        product.Name,
+				Description: product.Description,
+				Price:       product.Price,
+				Image:       product.Image,
+				Difficulty:  product.Difficulty,
+				PreviewURL:  product.PreviewURL,
+				Videos:      product.Videos,
+			},
+			SharedTags:  related[i].SharedTags,
+			CoPurchases: related[i].CoPurchases,
+		}
+	}
+
+	c.JSON(200, response)
+}
@@ -0,0 +1,88 @@
+package ProductService_test
+
+import (
+	"EntitlementServer/DatabaseAbstraction"
+	"EntitlementServer/DatabaseAbstraction/mocks"
+	"encoding/json"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"net/http"
+	"testing"
+)
+
+type categoryNode struct {
+	ID       int
+	Slug     string
+	Children []categoryNode
+}
+
+func TestGetCategoriesAsTree(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	mockDB.On("GetCategories").Return([]DatabaseAbstraction.Category{
+		{IndexID: 4, Name: "IT-Sicherheit", Slug: "it-sicherheit"},
+		{IndexID: 1, Name: "Programmierung", Slug: "programmierung"},
+		{IndexID: 3, Name: "Programmiersprachen", Slug: "programmiersprachen", ParentID: 1},
+		{IndexID: 2, Name: "Webentwicklung", Slug: "webentwicklung", ParentID: 1},
+	}, nil)
+	r := newCatalogRouter(mockDB, nil)
+
+	w := httpGet(r, "/api/categories")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var tree []categoryNode
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &tree))
+	assert.Equal(t, []categoryNode{
+		{ID: 4, Slug: "it-sicherheit", Children: []categoryNode{}},
+		{ID: 1, Slug: "programmierung", Children: []categoryNode{
+			{ID: 3, Slug: "programmiersprachen", Children: []categoryNode{}},
+			{ID: 2, Slug: "webentwicklung", Children: []categoryNode{}},
+		}},
+	}, tree)
+}
+
+func TestCatalogCategoryFilter(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	mockDB.On("GetCategories").Return([]DatabaseAbstraction.Category{{IndexID: 2, Name: "Webentwicklung", Slug: "webentwicklung"}}, nil)
+	mockDB.On("GetVideosByProductIndexIDs", mock.Anything).Return(map[int][]DatabaseAbstraction.Video{}, nil)
+	mockDB.On("GetCatalog", mock.MatchedBy(func(query DatabaseAbstraction.ProductQuery) bool {
+		return query.CategoryID == 2
+	})).Return([]DatabaseAbstraction.CatalogProduct{catalogProduct(1, 500)}, nil).Twice()
+	r := newCatalogRouter(mockDB, nil)
+
+	w := getCatalog(r, "category=webentwicklung")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = getCatalog(r, "category=2")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = getCatalog(r, "category=cooking")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	mockDB.AssertExpectations(t)
+}
+
+func TestGetRelatedProducts(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	mockDB.On("GetProductByIndexID", 3).Return(DatabaseAbstraction.Product{IndexID: 3}, nil)
+	mockDB.On("GetProductByIndexID", 99).Return(DatabaseAbstraction.Product{}, pgx.ErrNoRows)
+	mockDB.On("GetRelatedProducts", 3, 5).Return([]DatabaseAbstraction.RelatedProduct{
+		{Product: DatabaseAbstraction.Product{IndexID: 6}, SharedTags: 3, CoPurchases: 1},
+		{Product: DatabaseAbstraction.Product{IndexID: 1}, SharedTags: 1},
+	}, nil)
+	mockDB.On("GetVideosByProductIndexIDs", []int{6, 1}).Return(map[int][]DatabaseAbstraction.Video{}, nil)
+	r := newCatalogRouter(mockDB, nil)
+
+	w := httpGet(r, "/api/products/3/related")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var related []struct {
+		ID          int
+		SharedTags  int
+		CoPurchases int
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &related))
+	assert.Equal(t, 6, related[0].ID)
+	assert.Equal(t, 3, related[0].SharedTags)
+	assert.Equal(t, 1, related[1].ID)
+
+	w = httpGet(r, "/api/products/99/related")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = httpGet(r, "/api/products/3/related?limit=500")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
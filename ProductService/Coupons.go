@@ -0,0 +1,150 @@
+package ProductService
+
+import (
+	"EntitlementServer/DatabaseAbstraction"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/sirupsen/logrus"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrCouponNotFound      = errors.New("unknown coupon code")
+	ErrCouponNotApplicable = errors.New("the coupon doesn't apply to this product")
+	ErrCouponNotValid      = errors.New("the coupon isn't valid at this time")
+	ErrCouponUsedUp        = errors.New("the coupon has been used up")
+)
+
+// PriceQuote is the price of a product for a user, CouponID is 0 without a coupon
+type PriceQuote struct {
+	Price    int
+	Discount int
+	DuePrice int
+	CouponID int
+}
+
+type priceResponse struct {
+	Price    int
+	Discount int
+	DuePrice int
+	Coupon   string
+}
+
+// couponDiscount is the amount a coupon takes off the price, it never exceeds the price
+func couponDiscount(coupon DatabaseAbstraction.Coupon, price int) int {
+	discount := coupon.Value
+	if coupon.Kind == DatabaseAbstraction.CouponPercent {
+		discount = price * coupon.Value / 100
+	}
+	if discount > price {
+		return price
+	}
+	return discount
+}
+
+// QuotePrice applies the coupon to the product price, an empty code quotes the full price
+// The usage limit per user is only checked for signed in users, userID 0 quotes for anonymous visitors.
+// The limits are checked again when the coupon is redeemed since it can be used up in the meantime.
+func (p ProductService) QuotePrice(product DatabaseAbstraction.Product, couponCode string, userID int) (PriceQuote, error) {
+	quote := PriceQuote{Price: product.Price, DuePrice: product.Price}
+	couponCode = strings.TrimSpace(couponCode)
+	if couponCode == "" {
+		return quote, nil
+	}
+
+	coupon, err := p.DB.GetCouponByCode(couponCode)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return quote, ErrCouponNotFound
+	}
+	if err != nil {
+		return quote, err
+	}
+
+	if coupon.ProductID != 0 && coupon.ProductID != product.IndexID {
+		return quote, ErrCouponNotApplicable
+	}
+	now := time.Now()
+	if (coupon.ValidFrom != nil && now.Before(*coupon.ValidFrom)) || (coupon.ValidUntil != nil && !now.Before(*coupon.ValidUntil)) {
+		return quote, ErrCouponNotValid
+	}
+	if coupon.MaxUses != 0 && coupon.Uses >= coupon.MaxUses {
+		return quote, ErrCouponUsedUp
+	}
+	if coupon.MaxUsesPerUser != 0 && userID != 0 {
+		uses, err := p.DB.CountCouponUses(coupon.IndexID, userID)
+		if err != nil {
+			return quote, err
+		}
+		if uses >= coupon.MaxUsesPerUser {
+			return quote, ErrCouponUsedUp
+		}
+	}
+
+	quote.CouponID = coupon.IndexID
+	quote.Discount = couponDiscount(coupon, product.Price)
+	quote.DuePrice = product.Price - quote.Discount
+	return quote, nil
+}
+
+// isCouponError tells the errors of QuotePrice that are the fault of the coupon code
+func isCouponError(err error) bool {
+	return errors.Is(err, ErrCouponNotFound) || errors.Is(err, ErrCouponNotApplicable) || errors.Is(err, ErrCouponNotValid) ||
+		errors.Is(err, ErrCouponUsedUp) || errors.Is(err, DatabaseAbstraction.ErrCouponUnavailable)
+}
+
+// GetPriceHandler godoc
+// @Summary Preview the price of a product
+// @Description The price with the coupon applied, signed in users also get their own usage limit checked
+// @Tags Products
+// @Accept  json
+// @Produce  json
+// @Param id path int true "Product ID"
+// @Param coupon query string false "Coupon code"
+// @Success 200 {object} priceResponse
+// @Failure 400 {object} productErrorResponse
+// @Failure 404 {object} productErrorResponse
+// @Failure 500 {object} productErrorResponse
+// @Router /api/products/{id}/price [get]
+func (p ProductService) GetPriceHandler(c *gin.Context) {
+	productID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, productErrorResponse{Error: "invalid product id"})
+		return
+	}
+
+	product, err := p.DB.GetProductByIndexID(productID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(404, productErrorResponse{Error: "product not found"})
+		return
+	}
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, productErrorResponse{Error: "failed to get product"})
+		return
+	}
+
+	userID := 0
+	if user, signedIn := c.Get("user"); signedIn {
+		userID = user.(DatabaseAbstraction.User).IndexID
+	}
+
+	quote, err := p.QuotePrice(product, c.Query("coupon"), userID)
+	if isCouponError(err) {
+		c.JSON(400, productErrorResponse{Error: err.Error()})
+		return
+	}
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, productErrorResponse{Error: "failed to get price"})
+		return
+	}
+
+	response := priceResponse{Price: quote.Price, Discount: quote.Discount, DuePrice: quote.DuePrice}
+	if quote.CouponID != 0 {
+		response.Coupon = strings.ToUpper(strings.TrimSpace(c.Query("coupon")))
+	}
+	c.JSON(200, response)
+}
@@ -0,0 +1,118 @@
+package ProductService_test
+
+import (
+	"EntitlementServer/DatabaseAbstraction"
+	"EntitlementServer/DatabaseAbstraction/mocks"
+	"EntitlementServer/ProductService"
+	"encoding/json"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var pythonCourse = DatabaseAbstraction.Product{IndexID: 2, Name: "Python Grundlagen", Price: 2000}
+
+func TestQuotePrice(t *testing.T) {
+	yesterday := time.Now().Add(-24 * time.Hour)
+	mockDB := new(mocks.DBOrm)
+	for _, coupon := range []DatabaseAbstraction.Coupon{
+		{IndexID: 1, Code: "WILLKOMMEN10", Kind: DatabaseAbstraction.CouponPercent, Value: 10, MaxUsesPerUser: 1},
+		{IndexID: 2, Code: "MINUS5000", Kind: DatabaseAbstraction.CouponAmount, Value: 5000},
+		{IndexID: 3, Code: "NURPHP", Kind: DatabaseAbstraction.CouponPercent, Value: 50, ProductID: 1},
+		{IndexID: 4, Code: "ABGELAUFEN", Kind: DatabaseAbstraction.CouponPercent, Value: 50, ValidUntil: &yesterday},
+		{IndexID: 5, Code: "AUSVERKAUFT", Kind: DatabaseAbstraction.CouponPercent, Value: 50, MaxUses: 100, Uses: 100},
+	} {
+		mockDB.On("GetCouponByCode", coupon.Code).Return(coupon, nil)
+	}
+	mockDB.On("GetCouponByCode", mock.Anything).Return(DatabaseAbstraction.Coupon{}, pgx.ErrNoRows)
+	mockDB.On("CountCouponUses", 1, 7).Return(1, nil)
+	mockDB.On("CountCouponUses", 1, 8).Return(0, nil)
+	svc := ProductService.ProductService{DB: mockDB}
+
+	quote, err := svc.QuotePrice(pythonCourse, "", 8)
+	assert.NoError(t, err)
+	assert.Equal(t, ProductService.PriceQuote{Price: 2000, DuePrice: 2000}, quote)
+
+	quote, err = svc.QuotePrice(pythonCourse, "WILLKOMMEN10", 8)
+	assert.NoError(t, err)
+	assert.Equal(t, ProductService.PriceQuote{Price: 2000, Discount: 200, DuePrice: 1800, CouponID: 1}, quote)
+
+	// an amount larger than the price makes the product free
+	quote, err = svc.QuotePrice(pythonCourse, "MINUS5000", 8)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, quote.DuePrice)
+
+	_, err = svc.QuotePrice(pythonCourse, "WILLKOMMEN10", 7)
+	assert.ErrorIs(t, err, ProductService.ErrCouponUsedUp)
+	_, err = svc.QuotePrice(pythonCourse, "NURPHP", 8)
+	assert.ErrorIs(t, err, ProductService.ErrCouponNotApplicable)
+	_, err = svc.QuotePrice(pythonCourse, "ABGELAUFEN", 8)
+	assert.ErrorIs(t, err, ProductService.ErrCouponNotValid)
+	_, err = svc.QuotePrice(pythonCourse, "AUSVERKAUFT", 8)
+	assert.ErrorIs(t, err, ProductService.ErrCouponUsedUp)
+	_, err = svc.QuotePrice(pythonCourse, "GIBTSNICHT", 8)
+	assert.ErrorIs(t, err, ProductService.ErrCouponNotFound)
+}
+
+func TestGetPriceHandler(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	mockDB.On("GetProductByIndexID", 2).Return(pythonCourse, nil)
+	mockDB.On("GetCouponByCode", "willkommen10").Return(DatabaseAbstraction.Coupon{IndexID: 1, Code: "WILLKOMMEN10", Kind: DatabaseAbstraction.CouponPercent, Value: 10, MaxUsesPerUser: 1}, nil)
+	mockDB.On("GetCouponByCode", "nope").Return(DatabaseAbstraction.Coupon{}, pgx.ErrNoRows)
+	r := newCatalogRouter(mockDB, nil)
+
+	w := httpGet(r, "/api/products/2/price?coupon=willkommen10")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var price struct {
+		Price, Discount, DuePrice int
+		Coupon                    string
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &price))
+	assert.Equal(t, 1800, price.DuePrice)
+	assert.Equal(t, "WILLKOMMEN10", price.Coupon)
+
+	w = httpGet(r, "/api/products/2/price?coupon=nope")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "unknown coupon code")
+
+	// anonymous visitors don't have a per-user limit to check
+	mockDB.AssertNotCalled(t, "CountCouponUses", mock.Anything, mock.Anything)
+}
+
+func TestPurchaseWithCoupon(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	mockDB.On("GetProductByIndexID", 2).Return(pythonCourse, nil)
+	mockDB.On("GetUserByIndexID", 8).Return(DatabaseAbstraction.User{IndexID: 8, Balance: 1800}, nil)
+	mockDB.On("GetEntitlements", 8).Return([]DatabaseAbstraction.Entitlement{}, nil)
+	mockDB.On("GetCouponByCode", "WILLKOMMEN10").Return(DatabaseAbstraction.Coupon{IndexID: 1, Code: "WILLKOMMEN10", Kind: DatabaseAbstraction.CouponPercent, Value: 10}, nil)
+	mockDB.On("PurchaseProducts", DatabaseAbstraction.PurchaseOrder{
+		UserID:   8,
+		Items:    []DatabaseAbstraction.PurchaseItem{{ProductID: 2, Price: 1800, Discount: 200}},
+		Reason:   "purchase of product 2",
+		CouponID: 1,
+	}).Return(nil).Once()
+	// the last use was taken by a concurrent purchase
+	mockDB.On("PurchaseProducts", mock.Anything).Return(DatabaseAbstraction.ErrCouponUnavailable).Once()
+	user := DatabaseAbstraction.User{IndexID: 8}
+	r := newCatalogRouter(mockDB, &user)
+
+	purchase := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/products/2/purchase", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := purchase(`{"coupon": "WILLKOMMEN10"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = purchase(`{"coupon": "WILLKOMMEN10"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "no longer available")
+	mockDB.AssertExpectations(t)
+}
@@ -0,0 +1,147 @@
+package ProductService
+
+import (
+	"EntitlementServer/DatabaseAbstraction"
+	"EntitlementServer/Invoicing"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/sirupsen/logrus"
+	"strconv"
+	"time"
+)
+
+var ErrInvoiceNotFound = errors.New("invoice not found")
+
+type invoiceListResponse struct {
+	ID        int
+	Number    string
+	Kind      string // purchase, top_up or credit_note
+	Gross     int
+	VAT       int
+	CreatedAt time.Time
+}
+
+// issuer returns the configured seller, a zero value ProductService uses the default
+func (p ProductService) issuer() Invoicing.Issuer {
+	if p.Issuer == nil {
+		return Invoicing.DefaultIssuer
+	}
+	return *p.Issuer
+}
+
+// GetInvoice returns the invoice if it belongs to the user, invoices of other users look like they don't exist
+func (p ProductService) GetInvoice(invoiceID int, user DatabaseAbstraction.User) (DatabaseAbstraction.Invoice, error) {
+	invoice, err := p.DB.GetInvoiceByIndexID(invoiceID)
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && invoice.UserID != user.IndexID) {
+		return DatabaseAbstraction.Invoice{}, ErrInvoiceNotFound
+	}
+	return invoice, err
+}
+
+// sendInvoice responds with the PDF of the invoice
+func (p ProductService) sendInvoice(c *gin.Context, invoiceID int) {
+	invoice, err := p.GetInvoice(invoiceID, c.MustGet("user").(DatabaseAbstraction.User))
+	if errors.Is(err, ErrInvoiceNotFound) {
+		c.JSON(404, productErrorResponse{Error: err.Error()})
+		return
+	}
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, productErrorResponse{Error: "failed to get invoice"})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, Invoicing.Filename(invoice)))
+	c.Header("Cache-Control", "no-store")
+	c.Data(200, "application/pdf", Invoicing.Render(invoice, p.issuer()))
+}
+
+// GetPurchaseInvoiceHandler godoc
+// @Summary Download the invoice of a purchase
+// @Description The invoice as PDF, products bought together share one invoice. Granted products and purchases
+// @Description made before invoices were introduced have none
+// @Tags Purchases
+// @Produce  application/pdf
+// @Param id path int true "Purchase ID"
+// @Success 200 {file} file
+// @Failure 400 {object} productErrorResponse
+// @Failure 404 {object} productErrorResponse
+// @Failure 500 {object} productErrorResponse
+// @Security ApiKeyAuth
+// @Router /api/purchases/{id}/invoice [get]
+func (p ProductService) GetPurchaseInvoiceHandler(c *gin.Context) {
+	purchaseID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, productErrorResponse{Error: "invalid purchase id"})
+		return
+	}
+
+	user := c.MustGet("user").(DatabaseAbstraction.User)
+	purchase, err := p.DB.GetPurchaseByIndexID(purchaseID)
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && purchase.UserID != user.IndexID) {
+		c.JSON(404, productErrorResponse{Error: ErrPurchaseNotFound.Error()})
+		return
+	}
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, productErrorResponse{Error: "failed to get purchase"})
+		return
+	}
+	if purchase.InvoiceID == 0 {
+		c.JSON(404, productErrorResponse{Error: "no invoice was issued for this purchase"})
+		return
+	}
+
+	p.sendInvoice(c, purchase.InvoiceID)
+}
+
+// GetInvoicesHandler godoc
+// @Summary Get invoices
+// @Description The invoices of the current user for purchases, subscriptions and top-ups and the credit notes of refunds, newest first
+// @Tags Purchases
+// @Produce  json
+// @Success 200 {object} []invoiceListResponse
+// @Failure 500 {object} productErrorResponse
+// @Security ApiKeyAuth
+// @Router /api/invoices [get]
+func (p ProductService) GetInvoicesHandler(c *gin.Context) {
+	user := c.MustGet("user").(DatabaseAbstraction.User)
+
+	invoices, err := p.DB.GetInvoicesByUserID(user.IndexID)
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, productErrorResponse{Error: "failed to get invoices"})
+		return
+	}
+
+	response := make([]invoiceListResponse, len(invoices))
+	for i, invoice := range invoices {
+		response[i] = invoiceListResponse{invoice.IndexID, invoice.Number, invoice.Kind, invoice.Gross, invoice.VAT, invoice.CreatedAt}
+	}
+
+	c.JSON(200, response)
+}
+
+// GetInvoiceHandler godoc
+// @Summary Download an invoice
+// @Description The invoice as PDF
+// @Tags Purchases
+// @Produce  application/pdf
+// @Param id path int true "Invoice ID"
+// @Success 200 {file} file
+// @Failure 400 {object} productErrorResponse
+// @Failure 404 {object} productErrorResponse
+// @Failure 500 {object} productErrorResponse
+// @Security ApiKeyAuth
+// @Router /api/invoices/{id} [get]
+func (p ProductService) GetInvoiceHandler(c *gin.Context) {
+	invoiceID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, productErrorResponse{Error: "invalid invoice id"})
+		return
+	}
+
+	p.sendInvoice(c, invoiceID)
+}
@@ -0,0 +1,68 @@
+package ProductService_test
+
+import (
+	"EntitlementServer/DatabaseAbstraction"
+	"EntitlementServer/DatabaseAbstraction/mocks"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func invoiceDB() *mocks.DBOrm {
+	mockDB := new(mocks.DBOrm)
+	mockDB.On("GetPurchaseByIndexID", 1).Return(DatabaseAbstraction.Purchase{IndexID: 1, UserID: 2, ProductID: 2, InvoiceID: 4}, nil)
+	mockDB.On("GetPurchaseByIndexID", 2).Return(DatabaseAbstraction.Purchase{IndexID: 2, UserID: 2, ProductID: 3}, nil)
+	mockDB.On("GetPurchaseByIndexID", 3).Return(DatabaseAbstraction.Purchase{IndexID: 3, UserID: 7, ProductID: 2, InvoiceID: 5}, nil)
+	mockDB.On("GetPurchaseByIndexID", mock.Anything).Return(DatabaseAbstraction.Purchase{}, pgx.ErrNoRows)
+	mockDB.On("GetInvoiceByIndexID", 4).Return(DatabaseAbstraction.Invoice{
+		IndexID: 4, Number: "INV-2026-000004", UserID: 2, Kind: DatabaseAbstraction.InvoicePurchase, BillingName: "Schule am See",
+		Country: "DE", VATRate: 1900, Gross: 1000, Net: 840, VAT: 160, CreatedAt: time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC),
+		Items: []DatabaseAbstraction.InvoiceItem{{Description: "PHP Fundament", Quantity: 1, Amount: 1000}},
+	}, nil)
+	mockDB.On("GetInvoiceByIndexID", 5).Return(DatabaseAbstraction.Invoice{IndexID: 5, UserID: 7}, nil)
+	mockDB.On("GetInvoiceByIndexID", mock.Anything).Return(DatabaseAbstraction.Invoice{}, pgx.ErrNoRows)
+	return mockDB
+}
+
+func TestGetPurchaseInvoice(t *testing.T) {
+	user := DatabaseAbstraction.User{IndexID: 2}
+	r := newCatalogRouter(invoiceDB(), &user)
+
+	w := httpGet(r, "/api/purchases/1/invoice")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/pdf", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Header().Get("Content-Disposition"), `filename="INV-2026-000004.pdf"`)
+	assert.True(t, strings.HasPrefix(w.Body.String(), "%PDF-"))
+
+	for path, status := range map[string]int{
+		"/api/purchases/2/invoice": http.StatusNotFound, // bought before invoices existed
+		"/api/purchases/3/invoice": http.StatusNotFound, // someone else's purchase
+		"/api/purchases/9/invoice": http.StatusNotFound,
+		"/api/purchases/x/invoice": http.StatusBadRequest,
+		"/api/invoices/4":          http.StatusOK,
+		"/api/invoices/5":          http.StatusNotFound, // someone else's invoice
+		"/api/invoices/9":          http.StatusNotFound,
+	} {
+		w = httpGet(r, path)
+		assert.Equal(t, status, w.Code, path)
+	}
+}
+
+func TestGetInvoices(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	mockDB.On("GetInvoicesByUserID", 2).Return([]DatabaseAbstraction.Invoice{
+		{IndexID: 6, Number: "INV-2026-000006", UserID: 2, Kind: DatabaseAbstraction.InvoiceTopUp, Gross: 5000, Net: 5000},
+		{IndexID: 4, Number: "INV-2026-000004", UserID: 2, Kind: DatabaseAbstraction.InvoicePurchase, Gross: 1000, Net: 840, VAT: 160},
+	}, nil)
+	r := newCatalogRouter(mockDB, &DatabaseAbstraction.User{IndexID: 2})
+
+	w := httpGet(r, "/api/invoices")
+	assert.Equal(t, http.StatusOK, w.Code)
+	body := w.Body.String()
+	assert.Contains(t, body, `"Number":"INV-2026-000006","Kind":"top_up"`)
+	assert.Less(t, strings.Index(body, "INV-2026-000006"), strings.Index(body, "INV-2026-000004"))
+}
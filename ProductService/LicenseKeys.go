@@ -0,0 +1,252 @@
+package ProductService
+
+import (
+	"EntitlementServer/AuditLog"
+	"EntitlementServer/DatabaseAbstraction"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/sirupsen/logrus"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	maxLicenseKeysPerPurchase = 100 // enough for a school class
+	maxLicenseKeyLabelLength  = 100
+	maxListedLicenseKeys      = 1000
+)
+
+var (
+	ErrLicenseKeyNotFound      = errors.New("license key not found")
+	ErrInvalidLicenseKeyAmount = fmt.Errorf("between 1 and %d license keys can be bought at once", maxLicenseKeysPerPurchase)
+)
+
+type licenseKeyPurchaseRequest struct {
+	Quantity int
+	Label    string // optional, e.g. the class the keys are for
+}
+
+type licenseKeyRedeemRequest struct {
+	Key string
+}
+
+type licenseKeyResponse struct {
+	ID          int
+	Key         string
+	ProductID   int
+	ProductName string
+	Label       string
+	Price       int
+	Status      string
+	CreatedAt   time.Time
+	RedeemedAt  *time.Time
+	RevokedAt   *time.Time
+}
+
+func newLicenseKeyResponses(keys []DatabaseAbstraction.LicenseKey) []licenseKeyResponse {
+	response := make([]licenseKeyResponse, len(keys))
+	for i, key := range keys {
+		response[i] = licenseKeyResponse{key.IndexID, key.Code, key.ProductID, key.ProductName, key.Label, key.Price, key.Status,
+			key.CreatedAt, key.RedeemedAt, key.RevokedAt}
+	}
+	return response
+}
+
+// PurchaseLicenseKeys buys keys for a product that the user can give away, owning the product doesn't matter
+func (p ProductService) PurchaseLicenseKeys(productID int, user DatabaseAbstraction.User, quantity int, label string) ([]DatabaseAbstraction.LicenseKey, error) {
+	if quantity < 1 || quantity > maxLicenseKeysPerPurchase {
+		return nil, ErrInvalidLicenseKeyAmount
+	}
+
+	product, err := p.DB.GetProductByIndexID(productID)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err = p.prepareCheckout(user)
+	if err != nil {
+		return nil, err
+	}
+	if user.Balance < product.Price*quantity {
+		return nil, ErrNotEnoughMoney
+	}
+
+	return p.DB.CreateLicenseKeys(DatabaseAbstraction.LicenseKeyBatch{
+		ProductID: product.IndexID,
+		CreatedBy: user.IndexID,
+		Quantity:  quantity,
+		Label:     label,
+		Price:     product.Price,
+		Reason:    fmt.Sprintf("%d license keys for product %d", quantity, product.IndexID),
+	})
+}
+
+// RedeemLicenseKey grants the product of the key to the user, a key for an owned product stays unused so it can be passed on
+func (p ProductService) RedeemLicenseKey(code string, user DatabaseAbstraction.User) (DatabaseAbstraction.LicenseKey, error) {
+	key, err := p.DB.GetLicenseKeyByCode(code)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return DatabaseAbstraction.LicenseKey{}, ErrLicenseKeyNotFound
+	}
+	if err != nil {
+		return DatabaseAbstraction.LicenseKey{}, err
+	}
+	if key.Status != DatabaseAbstraction.LicenseKeyUnused {
+		return DatabaseAbstraction.LicenseKey{}, DatabaseAbstraction.ErrLicenseKeyUnavailable
+	}
+
+	owned, err := p.entitlements().Owns(user, key.ProductID)
+	if err != nil {
+		return DatabaseAbstraction.LicenseKey{}, err
+	}
+	if owned {
+		return DatabaseAbstraction.LicenseKey{}, ErrProductAlreadyOwned
+	}
+
+	err = p.DB.RedeemLicenseKey(key.IndexID, user.IndexID)
+	if err != nil {
+		return DatabaseAbstraction.LicenseKey{}, err
+	}
+
+	return key, nil
+}
+
+// PurchaseLicenseKeysHandler godoc
+// @Summary Buy license keys
+// @Description Buys up to 100 keys for a product, each costs the product's price. Anybody who redeems a key gets the product
+// @Tags License keys
+// @Accept  json
+// @Produce  json
+// @Param id path int true "Product ID"
+// @Param keys body licenseKeyPurchaseRequest true "Quantity and an optional label"
+// @Success 201 {object} []licenseKeyResponse
+// @Failure 400 {object} purchaseProductResponse
+// @Failure 404 {object} purchaseProductResponse
+// @Failure 500 {object} purchaseProductResponse
+// @Security ApiKeyAuth
+// @Router /api/products/{id}/keys [post]
+func (p ProductService) PurchaseLicenseKeysHandler(c *gin.Context) {
+	productID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, purchaseProductResponse{Error: "invalid product id"})
+		return
+	}
+
+	var request licenseKeyPurchaseRequest
+	err = c.ShouldBindJSON(&request)
+	if err != nil {
+		c.JSON(400, purchaseProductResponse{Error: "invalid request"})
+		return
+	}
+	request.Label = strings.TrimSpace(request.Label)
+	if utf8.RuneCountInString(request.Label) > maxLicenseKeyLabelLength {
+		c.JSON(400, purchaseProductResponse{Error: "the label is too long"})
+		return
+	}
+
+	user := c.MustGet("user").(DatabaseAbstraction.User)
+
+	event := AuditLog.NewEvent(c, AuditLog.ActionLicenseKeysPurchased)
+	event.Details["product_id"] = productID
+	event.Details["quantity"] = request.Quantity
+
+	keys, err := p.PurchaseLicenseKeys(productID, user, request.Quantity, request.Label)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(404, purchaseProductResponse{Error: "product not found"})
+		return
+	}
+	if err != nil {
+		event.Outcome = AuditLog.OutcomeFailure
+		event.Details["reason"] = err.Error()
+		AuditLog.OrDefault(p.Audit).Record(event)
+		c.JSON(400, purchaseProductResponse{Error: "Error purchasing license keys: " + err.Error()})
+		return
+	}
+
+	event.Details["amount"] = keys[0].Price * len(keys)
+	if request.Label != "" {
+		event.Details["label"] = request.Label
+	}
+	AuditLog.OrDefault(p.Audit).Record(event)
+
+	c.JSON(201, newLicenseKeyResponses(keys))
+}
+
+// GetLicenseKeysHandler godoc
+// @Summary Get bought license keys
+// @Description The license keys the user bought with their status, newest first. label narrows them to a batch
+// @Tags License keys
+// @Accept  json
+// @Produce  json
+// @Param label query string false "Label of the batch"
+// @Success 200 {object} []licenseKeyResponse
+// @Failure 500 {object} productErrorResponse
+// @Security ApiKeyAuth
+// @Router /api/keys [get]
+func (p ProductService) GetLicenseKeysHandler(c *gin.Context) {
+	user := c.MustGet("user").(DatabaseAbstraction.User)
+
+	keys, _, err := p.DB.GetLicenseKeys(DatabaseAbstraction.LicenseKeyFilter{CreatedBy: user.IndexID, Label: c.Query("label")}, maxListedLicenseKeys, 0)
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, productErrorResponse{Error: "failed to get license keys"})
+		return
+	}
+
+	c.JSON(200, newLicenseKeyResponses(keys))
+}
+
+// RedeemLicenseKeyHandler godoc
+// @Summary Redeem a license key
+// @Description Grants the product of the key to the user, case and dashes of the key don't matter
+// @Tags License keys
+// @Accept  json
+// @Produce  json
+// @Param key body licenseKeyRedeemRequest true "License key"
+// @Success 200 {object} purchaseProductResponse
+// @Failure 400 {object} purchaseProductResponse
+// @Failure 404 {object} purchaseProductResponse
+// @Failure 409 {object} purchaseProductResponse
+// @Failure 500 {object} purchaseProductResponse
+// @Security ApiKeyAuth
+// @Router /api/keys/redeem [post]
+func (p ProductService) RedeemLicenseKeyHandler(c *gin.Context) {
+	var request licenseKeyRedeemRequest
+	err := c.ShouldBindJSON(&request)
+	if err != nil || strings.TrimSpace(request.Key) == "" {
+		c.JSON(400, purchaseProductResponse{Error: "invalid request"})
+		return
+	}
+
+	user := c.MustGet("user").(DatabaseAbstraction.User)
+
+	// the key itself is left out of the audit log, failed attempts are still visible per user
+	event := AuditLog.NewEvent(c, AuditLog.ActionLicenseKeyRedeemed)
+
+	key, err := p.RedeemLicenseKey(request.Key, user)
+	if err != nil {
+		status := 409
+		switch {
+		case errors.Is(err, ErrLicenseKeyNotFound):
+			status = 404
+		case !errors.Is(err, DatabaseAbstraction.ErrLicenseKeyUnavailable) && !errors.Is(err, ErrProductAlreadyOwned):
+			logrus.Error(err)
+			c.JSON(500, purchaseProductResponse{Error: "failed to redeem license key"})
+			return
+		}
+		event.Outcome = AuditLog.OutcomeFailure
+		event.Details["reason"] = err.Error()
+		AuditLog.OrDefault(p.Audit).Record(event)
+		c.JSON(status, purchaseProductResponse{Error: err.Error()})
+		return
+	}
+
+	event.Details["license_key_id"] = key.IndexID
+	event.Details["product_id"] = key.ProductID
+	AuditLog.OrDefault(p.Audit).Record(event)
+
+	c.JSON(200, purchaseProductResponse{Message: "license key redeemed, " + key.ProductName + " was added to your products"})
+}
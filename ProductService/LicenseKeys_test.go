@@ -0,0 +1,92 @@
+package ProductService_test
+
+import (
+	"EntitlementServer/DatabaseAbstraction"
+	"EntitlementServer/DatabaseAbstraction/mocks"
+	"EntitlementServer/ProductService"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var classKeys = []DatabaseAbstraction.LicenseKey{
+	{IndexID: 1, Code: "AAAAA-BBBBB-CCCCC-DDDDD", ProductID: 2, Price: 2000, Label: "7b", Status: DatabaseAbstraction.LicenseKeyUnused},
+	{IndexID: 2, Code: "EEEEE-FFFFF-GGGGG-HHHHH", ProductID: 2, Price: 2000, Label: "7b", Status: DatabaseAbstraction.LicenseKeyUnused},
+}
+
+func TestPurchaseLicenseKeys(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	mockDB.On("GetProductByIndexID", 2).Return(pythonCourse, nil)
+	mockDB.On("GetUserByIndexID", 5).Return(DatabaseAbstraction.User{IndexID: 5, Balance: 5000}, nil)
+	mockDB.On("CreateLicenseKeys", DatabaseAbstraction.LicenseKeyBatch{ProductID: 2, CreatedBy: 5, Quantity: 2, Label: "7b", Price: 2000,
+		Reason: "2 license keys for product 2"}).Return(classKeys, nil)
+	svc := ProductService.ProductService{DB: mockDB}
+	user := DatabaseAbstraction.User{IndexID: 5}
+
+	keys, err := svc.PurchaseLicenseKeys(2, user, 2, "7b")
+	assert.NoError(t, err)
+	assert.Len(t, keys, 2)
+
+	_, err = svc.PurchaseLicenseKeys(2, user, 3, "7b")
+	assert.ErrorIs(t, err, ProductService.ErrNotEnoughMoney)
+	_, err = svc.PurchaseLicenseKeys(2, user, 0, "")
+	assert.ErrorIs(t, err, ProductService.ErrInvalidLicenseKeyAmount)
+	_, err = svc.PurchaseLicenseKeys(2, user, 101, "")
+	assert.ErrorIs(t, err, ProductService.ErrInvalidLicenseKeyAmount)
+	mockDB.AssertNumberOfCalls(t, "CreateLicenseKeys", 1)
+}
+
+func TestRedeemLicenseKey(t *testing.T) {
+	redeemed := classKeys[1]
+	redeemed.Status = DatabaseAbstraction.LicenseKeyRedeemed
+	mockDB := new(mocks.DBOrm)
+	mockDB.On("GetLicenseKeyByCode", "aaaaa-bbbbb-ccccc-ddddd").Return(classKeys[0], nil)
+	mockDB.On("GetLicenseKeyByCode", "EEEEEFFFFFGGGGGHHHHH").Return(redeemed, nil)
+	mockDB.On("GetLicenseKeyByCode", mock.Anything).Return(DatabaseAbstraction.LicenseKey{}, pgx.ErrNoRows)
+	// a running rental doesn't stop user 6 from getting the product for good
+	rentalEnd := time.Now().Add(time.Hour)
+	mockDB.On("GetEntitlements", 6).Return([]DatabaseAbstraction.Entitlement{{Source: DatabaseAbstraction.EntitlementRental, ProductID: 2, ExpiresAt: &rentalEnd}}, nil)
+	mockDB.On("GetEntitlements", 7).Return([]DatabaseAbstraction.Entitlement{{Source: DatabaseAbstraction.EntitlementPurchase, ProductID: 2}}, nil)
+	mockDB.On("RedeemLicenseKey", 1, 6).Return(nil)
+	svc := ProductService.ProductService{DB: mockDB}
+
+	key, err := svc.RedeemLicenseKey("aaaaa-bbbbb-ccccc-ddddd", DatabaseAbstraction.User{IndexID: 6})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, key.ProductID)
+
+	// the key stays unused for somebody who doesn't own the product yet
+	_, err = svc.RedeemLicenseKey("aaaaa-bbbbb-ccccc-ddddd", DatabaseAbstraction.User{IndexID: 7})
+	assert.ErrorIs(t, err, ProductService.ErrProductAlreadyOwned)
+	_, err = svc.RedeemLicenseKey("EEEEEFFFFFGGGGGHHHHH", DatabaseAbstraction.User{IndexID: 6})
+	assert.ErrorIs(t, err, DatabaseAbstraction.ErrLicenseKeyUnavailable)
+	_, err = svc.RedeemLicenseKey("nope", DatabaseAbstraction.User{IndexID: 6})
+	assert.ErrorIs(t, err, ProductService.ErrLicenseKeyNotFound)
+	mockDB.AssertNumberOfCalls(t, "RedeemLicenseKey", 1)
+}
+
+func TestRedeemLicenseKeyHandler(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	mockDB.On("GetLicenseKeyByCode", classKeys[0].Code).Return(classKeys[0], nil)
+	mockDB.On("GetLicenseKeyByCode", mock.Anything).Return(DatabaseAbstraction.LicenseKey{}, pgx.ErrNoRows)
+	mockDB.On("GetEntitlements", 6).Return([]DatabaseAbstraction.Entitlement{}, nil)
+	// somebody else redeemed the key in the meantime
+	mockDB.On("RedeemLicenseKey", 1, 6).Return(DatabaseAbstraction.ErrLicenseKeyUnavailable)
+	user := DatabaseAbstraction.User{IndexID: 6}
+	r := newCatalogRouter(mockDB, &user)
+
+	for body, status := range map[string]int{
+		`{"Key": "` + classKeys[0].Code + `"}`: http.StatusConflict,
+		`{"Key": "ZZZZZ-ZZZZZ-ZZZZZ-ZZZZZ"}`:   http.StatusNotFound,
+		`{"Key": " "}`:                         http.StatusBadRequest,
+	} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/keys/redeem", strings.NewReader(body))
+		r.ServeHTTP(w, req)
+		assert.Equal(t, status, w.Code, body)
+	}
+}
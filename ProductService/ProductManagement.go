@@ -4,6 +4,7 @@ import (
 	"EntitlementServer/DatabaseAbstraction"
 	"EntitlementServer/VideoService"
 	"errors"
+	"fmt"
 	"github.com/sirupsen/logrus"
 )
 
@@ -16,93 +17,127 @@ func (p ProductService) GetProduct(ProductID int) (Product, error) {
 		return Product{}, errors.New("product not found")
 	}
 
-	return p.enrichDatabaseProducts([]DatabaseAbstraction.Product{product})[0], nil
+	products, err := p.enrichDatabaseProducts([]DatabaseAbstraction.Product{product}, false)
+	if err != nil {
+		return Product{}, err
+	}
+	return products[0], nil
 }
 
-func (p ProductService) GetAllProducts() []Product {
+func (p ProductService) GetAllProducts() ([]Product, error) {
 	// Get all the products from the database
 	products, err := p.DB.GetAllProducts()
 	if err != nil {
-		return []Product{}
+		return []Product{}, err
 	}
 
-	return p.enrichDatabaseProducts(products)
+	return p.enrichDatabaseProducts(products, false)
 }
 
 var ErrNotEnoughMoney = errors.New("Not enough money")
+var ErrEmailNotVerified = errors.New("email address has to be verified before purchasing")
+var ErrProductAlreadyOwned = errors.New("user already owns product")
 
-func (p ProductService) PurchaseProduct(ProductID int, user DatabaseAbstraction.User) error {
+// PurchaseProduct buys a product for the user, couponCode is optional
+func (p ProductService) PurchaseProduct(ProductID int, user DatabaseAbstraction.User, couponCode string) error {
 	product, err := p.DB.GetProductByIndexID(ProductID)
 	if err != nil {
 		return err
 	}
 
-	// Get a fresh user object from the database
-	user, err = p.DB.GetUserByIndexID(user.IndexID)
+	user, err = p.prepareCheckout(user)
 	if err != nil {
 		return err
 	}
-
-	// Check if the user already owns the product
-	ownedProducts, err := p.DB.GetOwnedProducts(user.IndexID)
+	// a rental or subscription doesn't stop the user from buying the product for good
+	owned, err := p.entitlements().Owns(user, product.IndexID)
 	if err != nil {
 		return err
 	}
-	for _, ownedProduct := range ownedProducts {
-		if ownedProduct.IndexID == product.IndexID {
-			return errors.New("user already owns product")
-		}
+	if owned {
+		return ErrProductAlreadyOwned
 	}
 
-	// Check if the user has enough money to purchase the product
-	if user.Balance < product.Price {
-		return ErrNotEnoughMoney
+	quote, err := p.QuotePrice(product, couponCode, user.IndexID)
+	if err != nil {
+		return err
 	}
 
-	// Update the user's balance
-	err = p.DB.DecreaseUserBalance(user.IndexID, product.Price)
+	return p.checkout(user, DatabaseAbstraction.PurchaseOrder{
+		UserID:   user.IndexID,
+		Items:    []DatabaseAbstraction.PurchaseItem{{ProductID: product.IndexID, Price: quote.DuePrice, Discount: quote.Discount}},
+		Reason:   fmt.Sprintf("purchase of product %d", product.IndexID),
+		CouponID: quote.CouponID,
+	})
+}
+
+// prepareCheckout gets a fresh user object from the database and checks whether they may purchase
+func (p ProductService) prepareCheckout(user DatabaseAbstraction.User) (DatabaseAbstraction.User, error) {
+	user, err := p.DB.GetUserByIndexID(user.IndexID)
 	if err != nil {
-		logrus.Error(err)
-		// If this Error happens, we have probably just prevented a racy purchase
-		return err
+		return DatabaseAbstraction.User{}, err
+	}
+
+	if p.RequireVerifiedEmail && !user.EmailVerified {
+		return DatabaseAbstraction.User{}, ErrEmailNotVerified
+	}
+
+	return user, nil
+}
+
+// checkout debits the user and grants the products of the order
+func (p ProductService) checkout(user DatabaseAbstraction.User, order DatabaseAbstraction.PurchaseOrder) error {
+	// Check if the user has enough money to purchase the products
+	if user.Balance < order.Total() {
+		return ErrNotEnoughMoney
 	}
 
-	// Add the product to the user's owned products
-	err = p.DB.AddOwnedProduct(user.IndexID, product.IndexID)
+	err := p.DB.PurchaseProducts(order)
+	if errors.Is(err, DatabaseAbstraction.ErrProductOwned) {
+		// a concurrent checkout of the same product won
+		return ErrProductAlreadyOwned
+	}
 	if err != nil {
 		logrus.Error(err)
+		// If this Error happens, we have probably just prevented a racy purchase
 		return err
 	}
 
-	logrus.Println("User", user.IndexID, "purchased product", product.IndexID)
-
 	return nil
 }
 
-func (p ProductService) GetOwnedProducts(user DatabaseAbstraction.User) []Product {
+// GetOwnedProducts returns the products of the user, including the video filenames needed for streaming
+func (p ProductService) GetOwnedProducts(user DatabaseAbstraction.User) ([]Product, error) {
 	// Get owned products from the database
 	ownedProducts, err := p.DB.GetOwnedProducts(user.IndexID)
 	if err != nil {
-		logrus.Error(err)
-		return []Product{}
+		return []Product{}, err
 	}
 
 	// Convert the products to the correct format
-	return p.enrichDatabaseProducts(ownedProducts)
+	return p.enrichDatabaseProducts(ownedProducts, true)
 }
 
-// enrichDatabaseProducts takes a slice of database products and converts them to the ProductManagement format, including the license keys
-func (p ProductService) enrichDatabaseProducts(products []DatabaseAbstraction.Product) []Product {
-	// Convert the products to the correct format
-	var convertedProducts []Product
+// enrichDatabaseProducts takes a slice of database products and converts them to the ProductManagement format, including their videos
+// The videos of all products are loaded with a single query. Filenames are only included for owners, the catalog leaves them out
+func (p ProductService) enrichDatabaseProducts(products []DatabaseAbstraction.Product, withFilenames bool) ([]Product, error) {
+	convertedProducts := make([]Product, 0, len(products))
+	if len(products) == 0 {
+		return convertedProducts, nil
+	}
+
+	productIDs := make([]int, len(products))
+	for i, product := range products {
+		productIDs[i] = product.IndexID
+	}
+	videos, err := p.DB.GetVideosByProductIndexIDs(productIDs)
+	if err != nil {
+		return []Product{}, err
+	}
+
 	for _, product := range products {
-		// Fetch the videos for the product
-		videos, err := p.DB.GetVideosByProductIndexID(product.IndexID)
-		if err != nil {
-			continue
-		}
-		vsvideos := make([]VideoService.VSVideo, len(videos))
-		for i, video := range videos {
+		vsvideos := make([]VideoService.VSVideo, len(videos[product.IndexID]))
+		for i, video := range videos[product.IndexID] {
 			vsvideos[i] = VideoService.VSVideo{
 				IndexID:     video.IndexID,
 				Name:        video.Name,
@@ -110,6 +145,9 @@ func (p ProductService) enrichDatabaseProducts(products []DatabaseAbstraction.Pr
 				Points:      video.Points,
 				Thumbnail:   video.Thumbnail,
 			}
+			if withFilenames {
+				vsvideos[i].Filename = video.Filename
+			}
 		}
 
 		convertedProducts = append(convertedProducts, Product{
@@ -121,8 +159,10 @@ func (p ProductService) enrichDatabaseProducts(products []DatabaseAbstraction.Pr
 			Difficulty:  product.Difficulty,
 			Videos:      vsvideos,
 			PreviewURL:  product.PreviewURL,
+			CreatedAt:   product.CreatedAt,
+			UpdatedAt:   product.UpdatedAt,
 		})
 	}
 
-	return convertedProducts
+	return convertedProducts, nil
 }
@@ -0,0 +1,91 @@
+package ProductService_test
+
+import (
+	"EntitlementServer/DatabaseAbstraction"
+	"EntitlementServer/DatabaseAbstraction/mocks"
+	"EntitlementServer/ProductService"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// countingDB serves a catalog of generated products and counts the queries made,
+// any query other than the two overridden ones fails on the embedded mock
+type countingDB struct {
+	*mocks.DBOrm
+	products []DatabaseAbstraction.Product
+	videos   map[int][]DatabaseAbstraction.Video
+	queries  int
+}
+
+func newCountingDB(productCount int) *countingDB {
+	db := &countingDB{DBOrm: new(mocks.DBOrm), videos: make(map[int][]DatabaseAbstraction.Video)}
+	for id := 1; id <= productCount; id++ {
+		db.products = append(db.products, DatabaseAbstraction.Product{IndexID: id, Name: fmt.Sprintf("Course %d", id)})
+		for video := 1; video <= 5; video++ {
+			db.videos[id] = append(db.videos[id], DatabaseAbstraction.Video{IndexID: id*10 + video, Filename: "lesson.mp4"})
+		}
+	}
+	return db
+}
+
+func (db *countingDB) GetAllProducts() ([]DatabaseAbstraction.Product, error) {
+	db.queries++
+	return db.products, nil
+}
+
+func (db *countingDB) GetVideosByProductIndexIDs(productIDs []int) (map[int][]DatabaseAbstraction.Video, error) {
+	db.queries++
+	videos := make(map[int][]DatabaseAbstraction.Video, len(productIDs))
+	for _, id := range productIDs {
+		if productVideos, found := db.videos[id]; found {
+			videos[id] = productVideos
+		}
+	}
+	return videos, nil
+}
+
+func TestGetAllProductsQueryCountIsConstant(t *testing.T) {
+	for _, productCount := range []int{1, 10, 100} {
+		db := newCountingDB(productCount)
+
+		products, err := ProductService.ProductService{DB: db}.GetAllProducts()
+
+		assert.NoError(t, err)
+		assert.Len(t, products, productCount)
+		assert.Len(t, products[0].Videos, 5)
+		// the catalog doesn't hand out the filenames of paid videos
+		assert.Empty(t, products[0].Videos[0].Filename)
+		assert.Equal(t, 2, db.queries, "%d products", productCount)
+	}
+}
+
+func TestGetOwnedProductsPropagatesVideoErrors(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	mockDB.On("GetOwnedProducts", 1).Return([]DatabaseAbstraction.Product{{IndexID: 1}, {IndexID: 2}}, nil)
+	mockDB.On("GetVideosByProductIndexIDs", []int{1, 2}).Return(nil, errors.New("connection reset"))
+
+	products, err := ProductService.ProductService{DB: mockDB}.GetOwnedProducts(DatabaseAbstraction.User{IndexID: 1})
+
+	assert.Error(t, err)
+	assert.Empty(t, products)
+}
+
+func BenchmarkGetAllProducts(b *testing.B) {
+	for _, productCount := range []int{10, 100, 1000} {
+		b.Run(fmt.Sprintf("%d products", productCount), func(b *testing.B) {
+			db := newCountingDB(productCount)
+			svc := ProductService.ProductService{DB: db}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_, err := svc.GetAllProducts()
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(db.queries)/float64(b.N), "queries/op")
+		})
+	}
+}
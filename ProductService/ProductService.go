@@ -1,10 +1,18 @@
 package ProductService
 
 import (
+	"EntitlementServer/AuditLog"
 	"EntitlementServer/DatabaseAbstraction"
+	"EntitlementServer/Entitlements"
+	"EntitlementServer/Invoicing"
 	"EntitlementServer/VideoService"
+	"errors"
 	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/sirupsen/logrus"
+	"io"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -23,22 +31,72 @@ type Product struct {
 
 type ProductServiceProvider interface {
 	GetProduct(ProductID int) (Product, error)
-	GetAllProducts() []Product
-	PurchaseProduct(ProductID int, user DatabaseAbstraction.User) error
-	GetOwnedProducts(user DatabaseAbstraction.User) []Product
+	GetAllProducts() ([]Product, error)
+	PurchaseProduct(ProductID int, user DatabaseAbstraction.User, couponCode string) error
+	QuotePrice(product DatabaseAbstraction.Product, couponCode string, userID int) (PriceQuote, error)
+	RequestRefund(purchaseID int, user DatabaseAbstraction.User, reason string) (DatabaseAbstraction.Refund, error)
+	PurchaseBundle(bundleID int, user DatabaseAbstraction.User) error
+	PurchaseLicenseKeys(productID int, user DatabaseAbstraction.User, quantity int, label string) ([]DatabaseAbstraction.LicenseKey, error)
+	RedeemLicenseKey(code string, user DatabaseAbstraction.User) (DatabaseAbstraction.LicenseKey, error)
+	RentProduct(productID int, user DatabaseAbstraction.User) (time.Time, error)
+	Subscribe(planID int, user DatabaseAbstraction.User) (DatabaseAbstraction.Subscription, error)
+	CancelSubscription(subscriptionID int, user DatabaseAbstraction.User) (DatabaseAbstraction.Subscription, error)
+	GetOwnedProducts(user DatabaseAbstraction.User) ([]Product, error)
 	AddProduct(Product Product) (int, error)
 }
 
 type ProductService struct {
-	DB DatabaseAbstraction.DBOrm
+	DB                   DatabaseAbstraction.DBOrm
+	RequireVerifiedEmail bool              // refuse purchases of users without a verified email address
+	Audit                AuditLog.Auditor  // records purchases
+	Refunds              *RefundPolicy     // DefaultRefundPolicy if nil
+	Issuer               *Invoicing.Issuer // seller printed on invoices, Invoicing.DefaultIssuer if nil
 }
 
+func (p ProductService) entitlements() Entitlements.Manager {
+	return Entitlements.Manager{DB: p.DB, Audit: p.Audit}
+}
+
+// RegisterHandlers needs the authentication middleware, an optional second middleware
+// signs in users on public routes so the catalog, search and bundles can take ownership into account
 func (p ProductService) RegisterHandlers(r *gin.Engine, middleware ...gin.HandlerFunc) {
-	r.GET("/api/products", p.GetAllProductsHandler)
+	optionalAuthentication := func(c *gin.Context) { c.Next() }
+	if len(middleware) > 1 {
+		optionalAuthentication = middleware[1]
+	}
+
+	r.GET("/api/products", optionalAuthentication, p.GetCatalogHandler)
+	r.GET("/api/search", optionalAuthentication, p.SearchHandler)
+	r.GET("/api/categories", p.GetCategoriesHandler)
 	r.GET("/api/products/:id", p.GetProductHandler)
+	r.GET("/api/products/:id/related", p.GetRelatedProductsHandler)
+	r.GET("/api/products/:id/price", optionalAuthentication, p.GetPriceHandler)
 	r.POST("/api/products/:id/purchase", middleware[0], p.PurchaseProductHandler)
 	r.GET("/api/products/owned", middleware[0], p.GetOwnedProductsHandler)
 
+	r.GET("/api/bundles", optionalAuthentication, p.GetBundlesHandler)
+	r.GET("/api/bundles/:id", optionalAuthentication, p.GetBundleHandler)
+	r.POST("/api/bundles/:id/purchase", middleware[0], p.PurchaseBundleHandler)
+
+	r.GET("/api/purchases", middleware[0], p.GetPurchasesHandler)
+	r.POST("/api/purchases/:id/refund", middleware[0], p.RequestRefundHandler)
+	r.GET("/api/purchases/:id/invoice", middleware[0], p.GetPurchaseInvoiceHandler)
+	r.GET("/api/invoices", middleware[0], p.GetInvoicesHandler)
+	r.GET("/api/invoices/:id", middleware[0], p.GetInvoiceHandler)
+	r.GET("/api/refunds", middleware[0], p.GetRefundsHandler)
+
+	r.POST("/api/products/:id/keys", middleware[0], p.PurchaseLicenseKeysHandler)
+	r.GET("/api/keys", middleware[0], p.GetLicenseKeysHandler)
+	r.POST("/api/keys/redeem", middleware[0], p.RedeemLicenseKeyHandler)
+
+	r.GET("/api/products/:id/rental", p.GetRentalOfferHandler)
+	r.POST("/api/products/:id/rent", middleware[0], p.RentProductHandler)
+	r.GET("/api/plans", p.GetPlansHandler)
+	r.POST("/api/subscriptions", middleware[0], p.SubscribeHandler)
+	r.GET("/api/subscriptions", middleware[0], p.GetSubscriptionsHandler)
+	r.DELETE("/api/subscriptions/:id", middleware[0], p.CancelSubscriptionHandler)
+	r.GET("/api/entitlements", middleware[0], p.GetEntitlementsHandler)
+
 	r.GET("/api/products/:id/comments", p.GetProductComments)
 	r.POST("/api/products/:id/comments", middleware[0], p.PostProductComment)
 }
@@ -60,37 +118,6 @@ type productErrorResponse struct {
 	Error string
 }
 
-// GetAllProductsHandler godoc
-// @Summary Get all products
-// @Description Get all products
-// @Tags Products
-// @Accept  json
-// @Produce  json
-// @Success 200 {object} []productResponse
-// @Failure 500 {object} string
-// @Router /api/products [get]
-func (p ProductService) GetAllProductsHandler(c *gin.Context) {
-	products := p.GetAllProducts()
-
-	productResponses := make([]productResponse, len(products))
-	for i, product := range products {
-		productResponses[i] = productResponse{
-			CreatedAt:   product.CreatedAt,
-			UpdatedAt:   product.UpdatedAt,
-			ID:          product.ID,
-			Name:        product.Name,
-			Description: product.Description,
-			Price:       product.Price,
-			Image:       product.Image,
-			Difficulty:  product.Difficulty,
-			PreviewURL:  product.PreviewURL,
-			Videos:      product.Videos,
-		}
-	}
-
-	c.JSON(200, products)
-}
-
 // GetProductHandler godoc
 // @Summary Get a product
 // @Description Get a product
@@ -111,10 +138,15 @@ func (p ProductService) GetProductHandler(c *gin.Context) {
 	}
 
 	product, err := p.GetProduct(convertedProductID)
-	if err != nil {
+	if errors.Is(err, pgx.ErrNoRows) {
 		c.JSON(404, productErrorResponse{Error: "product not found"})
 		return
 	}
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, productErrorResponse{Error: "failed to get product"})
+		return
+	}
 
 	responseProduct := productResponse{
 		CreatedAt:   product.CreatedAt,
@@ -141,13 +173,18 @@ type purchaseProductResponse struct {
 	Message string
 }
 
+type purchaseRequest struct {
+	Coupon string
+}
+
 // PurchaseProductHandler godoc
 // @Summary Purchase a product
-// @Description Purchase a product and add it to the user's owned products
+// @Description Purchase a product and add it to the user's owned products, the body with a coupon code is optional
 // @Tags Products
 // @Accept  json
 // @Produce  json
 // @Param id path int true "Product ID"
+// @Param purchase body purchaseRequest false "Coupon"
 // @Success 200 {object} purchaseProductResponse
 // @Failure 400 {object} purchaseProductResponse
 // @Failure 500 {object} purchaseProductResponse
@@ -161,14 +198,32 @@ func (p ProductService) PurchaseProductHandler(c *gin.Context) {
 		return
 	}
 
+	var request purchaseRequest
+	err = c.ShouldBindJSON(&request)
+	if err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(400, purchaseProductResponse{Error: "invalid request"})
+		return
+	}
+
 	user := c.MustGet("user").(DatabaseAbstraction.User)
 
-	err = p.PurchaseProduct(convertedProductID, user)
+	event := AuditLog.NewEvent(c, AuditLog.ActionPurchase)
+	event.Details["product_id"] = convertedProductID
+	if request.Coupon != "" {
+		event.Details["coupon"] = strings.ToUpper(strings.TrimSpace(request.Coupon))
+	}
+
+	err = p.PurchaseProduct(convertedProductID, user, request.Coupon)
 	if err != nil {
+		event.Outcome = AuditLog.OutcomeFailure
+		event.Details["reason"] = err.Error()
+		AuditLog.OrDefault(p.Audit).Record(event)
 		c.JSON(400, purchaseProductResponse{Error: "Error purchasing product: " + err.Error()})
 		return
 	}
 
+	AuditLog.OrDefault(p.Audit).Record(event)
+
 	c.JSON(200, purchaseProductResponse{Message: "product purchased"})
 }
 
@@ -185,29 +240,15 @@ func (p ProductService) PurchaseProductHandler(c *gin.Context) {
 func (p ProductService) GetOwnedProductsHandler(c *gin.Context) {
 	user := c.MustGet("user").(DatabaseAbstraction.User)
 
-	products := p.GetOwnedProducts(user)
+	products, err := p.GetOwnedProducts(user)
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, gin.H{"Error": "Error getting products"})
+		return
+	}
 
 	productResponses := make([]productResponse, len(products))
 	for i, product := range products {
-		// Get the videos for the product
-		videos, err := p.DB.GetVideosByProductIndexID(product.ID)
-		if err != nil {
-			c.JSON(500, gin.H{"Error": "Error getting videos"})
-			return
-		}
-		// Convert the videos to video responses
-		videoResponses := make([]VideoService.VSVideo, len(videos))
-		for i, video := range videos {
-			videoResponses[i] = VideoService.VSVideo{
-				IndexID:     video.IndexID,
-				Name:        video.Name,
-				Description: video.Description,
-				Points:      video.Points,
-				Thumbnail:   video.Thumbnail,
-				Filename:    video.Filename,
-			}
-		}
-
 		productResponses[i] = productResponse{
 			CreatedAt:   product.CreatedAt,
 			UpdatedAt:   product.UpdatedAt,
@@ -216,7 +257,7 @@ func (p ProductService) GetOwnedProductsHandler(c *gin.Context) {
 			Description: product.Description,
 			Price:       product.Price,
 			Image:       product.Image,
-			Videos:      videoResponses,
+			Videos:      product.Videos,
 			PreviewURL:  product.PreviewURL,
 			Difficulty:  product.Difficulty,
 		}
@@ -0,0 +1,300 @@
+package ProductService
+
+import (
+	"EntitlementServer/AuditLog"
+	"EntitlementServer/DatabaseAbstraction"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const maxRefundReasonLength = 1000
+
+var (
+	ErrPurchaseNotFound     = errors.New("purchase not found")
+	ErrRefundWindowClosed   = errors.New("the refund window of this purchase has closed")
+	ErrRefundTooMuchWatched = errors.New("too much of the product has been watched for a refund")
+	ErrNothingToRefund      = errors.New("nothing was paid for this purchase")
+)
+
+// RefundPolicy decides which purchases users can ask to be refunded, an admin approves every refund
+type RefundPolicy struct {
+	Window            time.Duration // time after the purchase
+	MaxWatchedPercent int           // share of the product's videos the user may have watched
+}
+
+var DefaultRefundPolicy = RefundPolicy{
+	Window:            14 * 24 * time.Hour,
+	MaxWatchedPercent: 20,
+}
+
+// RefundPolicyFromEnv reads REFUND_WINDOW_DAYS and REFUND_MAX_WATCHED_PERCENT, falling back to DefaultRefundPolicy
+func RefundPolicyFromEnv() (RefundPolicy, error) {
+	policy := DefaultRefundPolicy
+
+	if value := os.Getenv("REFUND_WINDOW_DAYS"); value != "" {
+		days, err := strconv.Atoi(value)
+		if err != nil || days < 0 {
+			return RefundPolicy{}, fmt.Errorf("REFUND_WINDOW_DAYS is not a valid number of days: %q", value)
+		}
+		policy.Window = time.Duration(days) * 24 * time.Hour
+	}
+
+	if value := os.Getenv("REFUND_MAX_WATCHED_PERCENT"); value != "" {
+		percent, err := strconv.Atoi(value)
+		if err != nil || percent < 0 || percent > 100 {
+			return RefundPolicy{}, fmt.Errorf("REFUND_MAX_WATCHED_PERCENT is not a percentage: %q", value)
+		}
+		policy.MaxWatchedPercent = percent
+	}
+
+	return policy, nil
+}
+
+// refundPolicy returns the configured policy, a zero value ProductService uses the defaults
+func (p ProductService) refundPolicy() RefundPolicy {
+	if p.Refunds == nil {
+		return DefaultRefundPolicy
+	}
+	return *p.Refunds
+}
+
+type purchaseListResponse struct {
+	ID           int
+	ProductID    int
+	ProductName  string
+	BundleID     int
+	LicenseKeyID int
+	Price        int
+	Discount     int
+	PurchasedAt  time.Time
+	ExpiresAt    *time.Time // set for rentals
+	InvoiceID    int        // 0 if nothing was paid, the PDF is at /api/purchases/{id}/invoice
+	RefundStatus string     // empty if no refund was requested
+}
+
+type refundRequest struct {
+	Reason string
+}
+
+type refundResponse struct {
+	ID             int
+	PurchaseID     int
+	ProductID      int
+	ProductName    string
+	Amount         int
+	Reason         string
+	WatchedPercent int
+	Status         string
+	DecisionNote   string
+	CreatedAt      time.Time
+	DecidedAt      *time.Time
+}
+
+func newRefundResponse(refund DatabaseAbstraction.Refund) refundResponse {
+	return refundResponse{refund.IndexID, refund.PurchaseID, refund.ProductID, refund.ProductName, refund.Amount, refund.Reason,
+		refund.WatchedPercent, refund.Status, refund.DecisionNote, refund.CreatedAt, refund.DecidedAt}
+}
+
+// watchedPercent is the share of the product's videos the user has watched
+func (p ProductService) watchedPercent(userID int, productID int) (int, error) {
+	progress, err := p.DB.GetProgressByUserID(userID)
+	if err != nil {
+		return 0, err
+	}
+	for _, productProgress := range progress {
+		if productProgress.ProductID == productID && productProgress.TotalVideos > 0 {
+			return productProgress.WatchedVideos * 100 / productProgress.TotalVideos, nil
+		}
+	}
+	return 0, nil
+}
+
+// RequestRefund checks the purchase against the refund policy and stores a pending refund for an admin to decide
+func (p ProductService) RequestRefund(purchaseID int, user DatabaseAbstraction.User, reason string) (DatabaseAbstraction.Refund, error) {
+	purchase, err := p.DB.GetPurchaseByIndexID(purchaseID)
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && purchase.UserID != user.IndexID) {
+		return DatabaseAbstraction.Refund{}, ErrPurchaseNotFound
+	}
+	if err != nil {
+		return DatabaseAbstraction.Refund{}, err
+	}
+
+	policy := p.refundPolicy()
+	if time.Since(purchase.CreatedAt) > policy.Window {
+		return DatabaseAbstraction.Refund{}, ErrRefundWindowClosed
+	}
+	if purchase.Price == 0 {
+		return DatabaseAbstraction.Refund{}, ErrNothingToRefund
+	}
+
+	watched, err := p.watchedPercent(user.IndexID, purchase.ProductID)
+	if err != nil {
+		return DatabaseAbstraction.Refund{}, err
+	}
+	if watched > policy.MaxWatchedPercent {
+		return DatabaseAbstraction.Refund{}, ErrRefundTooMuchWatched
+	}
+
+	refund := DatabaseAbstraction.Refund{
+		UserID:         user.IndexID,
+		PurchaseID:     purchase.IndexID,
+		ProductID:      purchase.ProductID,
+		ProductName:    purchase.ProductName,
+		Amount:         purchase.Price,
+		Reason:         reason,
+		WatchedPercent: watched,
+		Status:         DatabaseAbstraction.RefundPending,
+		CreatedAt:      time.Now(),
+	}
+	refund.IndexID, err = p.DB.AddRefundRequest(refund)
+	if err != nil {
+		return DatabaseAbstraction.Refund{}, err
+	}
+
+	return refund, nil
+}
+
+// GetPurchasesHandler godoc
+// @Summary Get purchases
+// @Description The purchases of the user with the price paid and the status of their refund, oldest first
+// @Tags Purchases
+// @Accept  json
+// @Produce  json
+// @Success 200 {object} []purchaseListResponse
+// @Failure 500 {object} productErrorResponse
+// @Security ApiKeyAuth
+// @Router /api/purchases [get]
+func (p ProductService) GetPurchasesHandler(c *gin.Context) {
+	user := c.MustGet("user").(DatabaseAbstraction.User)
+
+	purchases, err := p.DB.GetPurchasesByUserID(user.IndexID)
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, productErrorResponse{Error: "failed to get purchases"})
+		return
+	}
+	refunds, err := p.DB.GetRefundsByUserID(user.IndexID)
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, productErrorResponse{Error: "failed to get refunds"})
+		return
+	}
+	refundStatus := make(map[int]string, len(refunds))
+	for _, refund := range refunds {
+		refundStatus[refund.PurchaseID] = refund.Status
+	}
+
+	response := make([]purchaseListResponse, len(purchases))
+	for i, purchase := range purchases {
+		response[i] = purchaseListResponse{purchase.IndexID, purchase.ProductID, purchase.ProductName, purchase.BundleID, purchase.LicenseKeyID, purchase.Price,
+			purchase.Discount, purchase.CreatedAt, purchase.ExpiresAt, purchase.InvoiceID, refundStatus[purchase.IndexID]}
+	}
+
+	c.JSON(200, response)
+}
+
+// RequestRefundHandler godoc
+// @Summary Request a refund
+// @Description Asks for a refund of a purchase, an admin has to approve it. Refunds are possible for a limited time
+// @Description after the purchase and only while little of the product has been watched, which is checked again on approval
+// @Tags Purchases
+// @Accept  json
+// @Produce  json
+// @Param id path int true "Purchase ID"
+// @Param refund body refundRequest false "Reason"
+// @Success 201 {object} refundResponse
+// @Failure 400 {object} productErrorResponse
+// @Failure 404 {object} productErrorResponse
+// @Failure 409 {object} productErrorResponse
+// @Failure 500 {object} productErrorResponse
+// @Security ApiKeyAuth
+// @Router /api/purchases/{id}/refund [post]
+func (p ProductService) RequestRefundHandler(c *gin.Context) {
+	purchaseID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, productErrorResponse{Error: "invalid purchase id"})
+		return
+	}
+
+	var request refundRequest
+	err = c.ShouldBindJSON(&request)
+	if err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(400, productErrorResponse{Error: "invalid request"})
+		return
+	}
+	request.Reason = strings.TrimSpace(request.Reason)
+	if utf8.RuneCountInString(request.Reason) > maxRefundReasonLength {
+		c.JSON(400, productErrorResponse{Error: "the reason is too long"})
+		return
+	}
+
+	user := c.MustGet("user").(DatabaseAbstraction.User)
+
+	event := AuditLog.NewEvent(c, AuditLog.ActionRefundRequested)
+	event.Details["purchase_id"] = purchaseID
+
+	refund, err := p.RequestRefund(purchaseID, user, request.Reason)
+	if err != nil {
+		status := 400
+		switch {
+		case errors.Is(err, ErrPurchaseNotFound):
+			status = 404
+		case errors.Is(err, DatabaseAbstraction.ErrRefundExists):
+			status = 409
+		case !errors.Is(err, ErrRefundWindowClosed) && !errors.Is(err, ErrRefundTooMuchWatched) && !errors.Is(err, ErrNothingToRefund):
+			logrus.Error(err)
+			c.JSON(500, productErrorResponse{Error: "failed to request refund"})
+			return
+		}
+		event.Outcome = AuditLog.OutcomeFailure
+		event.Details["reason"] = err.Error()
+		AuditLog.OrDefault(p.Audit).Record(event)
+		c.JSON(status, productErrorResponse{Error: err.Error()})
+		return
+	}
+
+	event.Details["refund_id"] = refund.IndexID
+	event.Details["product_id"] = refund.ProductID
+	event.Details["amount"] = refund.Amount
+	event.Details["watched_percent"] = refund.WatchedPercent
+	AuditLog.OrDefault(p.Audit).Record(event)
+
+	c.JSON(201, newRefundResponse(refund))
+}
+
+// GetRefundsHandler godoc
+// @Summary Get refunds
+// @Description The refunds the user requested, newest first
+// @Tags Purchases
+// @Accept  json
+// @Produce  json
+// @Success 200 {object} []refundResponse
+// @Failure 500 {object} productErrorResponse
+// @Security ApiKeyAuth
+// @Router /api/refunds [get]
+func (p ProductService) GetRefundsHandler(c *gin.Context) {
+	user := c.MustGet("user").(DatabaseAbstraction.User)
+
+	refunds, err := p.DB.GetRefundsByUserID(user.IndexID)
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, productErrorResponse{Error: "failed to get refunds"})
+		return
+	}
+
+	response := make([]refundResponse, len(refunds))
+	for i, refund := range refunds {
+		response[i] = newRefundResponse(refund)
+	}
+
+	c.JSON(200, response)
+}
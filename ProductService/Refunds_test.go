@@ -0,0 +1,105 @@
+package ProductService_test
+
+import (
+	"EntitlementServer/DatabaseAbstraction"
+	"EntitlementServer/DatabaseAbstraction/mocks"
+	"EntitlementServer/ProductService"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func refundDB() *mocks.DBOrm {
+	mockDB := new(mocks.DBOrm)
+	recent := time.Now().Add(-48 * time.Hour)
+	for _, purchase := range []DatabaseAbstraction.Purchase{
+		{IndexID: 1, UserID: 2, ProductID: 2, Price: 1800, Discount: 200, CreatedAt: recent},
+		{IndexID: 2, UserID: 2, ProductID: 3, Price: 500, CreatedAt: time.Now().Add(-30 * 24 * time.Hour)},
+		{IndexID: 3, UserID: 2, ProductID: 4, Price: 500, CreatedAt: recent},
+		{IndexID: 4, UserID: 2, ProductID: 5, Price: 0, CreatedAt: recent},
+		{IndexID: 5, UserID: 7, ProductID: 2, Price: 2000, CreatedAt: recent},
+	} {
+		mockDB.On("GetPurchaseByIndexID", purchase.IndexID).Return(purchase, nil)
+	}
+	mockDB.On("GetPurchaseByIndexID", mock.Anything).Return(DatabaseAbstraction.Purchase{}, pgx.ErrNoRows)
+	mockDB.On("GetProgressByUserID", 2).Return([]DatabaseAbstraction.ProductProgress{
+		{ProductID: 2, WatchedVideos: 1, TotalVideos: 10},
+		{ProductID: 4, WatchedVideos: 3, TotalVideos: 4},
+	}, nil)
+	return mockDB
+}
+
+func TestRequestRefundAppliesPolicy(t *testing.T) {
+	mockDB := refundDB()
+	mockDB.On("AddRefundRequest", mock.MatchedBy(func(refund DatabaseAbstraction.Refund) bool {
+		return refund.PurchaseID == 1 && refund.Amount == 1800 && refund.WatchedPercent == 10 && refund.Status == DatabaseAbstraction.RefundPending
+	})).Return(4, nil)
+	svc := ProductService.ProductService{DB: mockDB}
+	user := DatabaseAbstraction.User{IndexID: 2}
+
+	refund, err := svc.RequestRefund(1, user, "nicht das Richtige")
+	assert.NoError(t, err)
+	assert.Equal(t, 4, refund.IndexID)
+
+	_, err = svc.RequestRefund(2, user, "")
+	assert.ErrorIs(t, err, ProductService.ErrRefundWindowClosed)
+	_, err = svc.RequestRefund(3, user, "")
+	assert.ErrorIs(t, err, ProductService.ErrRefundTooMuchWatched)
+	_, err = svc.RequestRefund(4, user, "")
+	assert.ErrorIs(t, err, ProductService.ErrNothingToRefund)
+	// purchases of other users look like they don't exist
+	_, err = svc.RequestRefund(5, user, "")
+	assert.ErrorIs(t, err, ProductService.ErrPurchaseNotFound)
+	_, err = svc.RequestRefund(9, user, "")
+	assert.ErrorIs(t, err, ProductService.ErrPurchaseNotFound)
+
+	// a longer window and a higher threshold allow more refunds
+	svc.Refunds = &ProductService.RefundPolicy{Window: 60 * 24 * time.Hour, MaxWatchedPercent: 80}
+	mockDB.On("AddRefundRequest", mock.Anything).Return(5, nil)
+	_, err = svc.RequestRefund(2, user, "")
+	assert.NoError(t, err)
+	_, err = svc.RequestRefund(3, user, "")
+	assert.NoError(t, err)
+}
+
+func TestRequestRefundHandler(t *testing.T) {
+	mockDB := refundDB()
+	mockDB.On("AddRefundRequest", mock.Anything).Return(0, DatabaseAbstraction.ErrRefundExists)
+	user := DatabaseAbstraction.User{IndexID: 2}
+	r := newCatalogRouter(mockDB, &user)
+
+	for path, status := range map[string]int{
+		"/api/purchases/1/refund": http.StatusConflict,
+		"/api/purchases/3/refund": http.StatusBadRequest,
+		"/api/purchases/5/refund": http.StatusNotFound,
+		"/api/purchases/x/refund": http.StatusBadRequest,
+	} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", path, strings.NewReader(`{"Reason": "Zu schwer"}`))
+		r.ServeHTTP(w, req)
+		assert.Equal(t, status, w.Code, path)
+	}
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/purchases/1/refund", strings.NewReader(`{"Reason": "`+strings.Repeat("a", 1001)+`"}`))
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestRefundPolicyFromEnv(t *testing.T) {
+	t.Setenv("REFUND_WINDOW_DAYS", "30")
+	t.Setenv("REFUND_MAX_WATCHED_PERCENT", "")
+	policy, err := ProductService.RefundPolicyFromEnv()
+	assert.NoError(t, err)
+	assert.Equal(t, 30*24*time.Hour, policy.Window)
+	assert.Equal(t, ProductService.DefaultRefundPolicy.MaxWatchedPercent, policy.MaxWatchedPercent)
+
+	t.Setenv("REFUND_MAX_WATCHED_PERCENT", "120")
+	_, err = ProductService.RefundPolicyFromEnv()
+	assert.Error(t, err)
+}
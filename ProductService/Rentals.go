@@ -0,0 +1,202 @@
+package ProductService
+
+import (
+	"EntitlementServer/AuditLog"
+	"EntitlementServer/DatabaseAbstraction"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/sirupsen/logrus"
+	"strconv"
+	"time"
+)
+
+var ErrNotForRent = errors.New("the product can't be rented")
+var ErrCoveredBySubscription = errors.New("the running subscription already covers the product")
+var ErrCoveredBySeat = errors.New("a seat of the organization already covers the product")
+
+type rentalOfferResponse struct {
+	ProductID int
+	Price     int
+	Days      int
+}
+
+type rentalResponse struct {
+	Message   string
+	ExpiresAt time.Time
+}
+
+type entitlementResponse struct {
+	Source         string
+	ProductID      int // 0 for subscriptions, they cover every product
+	PurchaseID     int
+	SubscriptionID int
+	OrganizationID int // set for seats of an organization
+	StartsAt       time.Time
+	ExpiresAt      *time.Time
+}
+
+// RentProduct grants the product for the days of its rental offer
+// Renting again while a rental runs extends it, owners, subscribers and seat holders don't need to rent
+func (p ProductService) RentProduct(productID int, user DatabaseAbstraction.User) (time.Time, error) {
+	offer, err := p.DB.GetRentalOffer(productID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return time.Time{}, ErrNotForRent
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	user, err = p.prepareCheckout(user)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	// only a running rental is extended, everything else already grants the product
+	entitlements, err := p.DB.GetEntitlements(user.IndexID)
+	if err != nil {
+		return time.Time{}, err
+	}
+	start := time.Now()
+	var covered error
+	for _, entitlement := range entitlements {
+		if !entitlement.Covers(productID) {
+			continue
+		}
+		switch entitlement.Source {
+		case DatabaseAbstraction.EntitlementPurchase:
+			return time.Time{}, ErrProductAlreadyOwned
+		case DatabaseAbstraction.EntitlementSubscription:
+			covered = ErrCoveredBySubscription
+		case DatabaseAbstraction.EntitlementSeat:
+			if covered == nil {
+				covered = ErrCoveredBySeat
+			}
+		case DatabaseAbstraction.EntitlementRental:
+			if entitlement.ExpiresAt.After(start) {
+				start = *entitlement.ExpiresAt
+			}
+		}
+	}
+	if covered != nil {
+		return time.Time{}, covered
+	}
+	expiresAt := start.AddDate(0, 0, offer.Days)
+
+	err = p.checkout(user, DatabaseAbstraction.PurchaseOrder{
+		UserID:    user.IndexID,
+		Items:     []DatabaseAbstraction.PurchaseItem{{ProductID: productID, Price: offer.Price}},
+		Reason:    fmt.Sprintf("rental of product %d", productID),
+		ExpiresAt: &expiresAt,
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return expiresAt, nil
+}
+
+// GetRentalOfferHandler godoc
+// @Summary Get the rental offer of a product
+// @Description Price and duration of renting the product, 404 if it can't be rented
+// @Tags Products
+// @Accept  json
+// @Produce  json
+// @Param id path int true "Product ID"
+// @Success 200 {object} rentalOfferResponse
+// @Failure 400 {object} productErrorResponse
+// @Failure 404 {object} productErrorResponse
+// @Router /api/products/{id}/rental [get]
+func (p ProductService) GetRentalOfferHandler(c *gin.Context) {
+	productID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, productErrorResponse{Error: "invalid product id"})
+		return
+	}
+
+	offer, err := p.DB.GetRentalOffer(productID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(404, productErrorResponse{Error: ErrNotForRent.Error()})
+		return
+	}
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, productErrorResponse{Error: "failed to get rental offer"})
+		return
+	}
+
+	c.JSON(200, rentalOfferResponse{offer.ProductID, offer.Price, offer.Days})
+}
+
+// RentProductHandler godoc
+// @Summary Rent a product
+// @Description Grants the product for the days of its rental offer, renting again extends a running rental
+// @Tags Products
+// @Accept  json
+// @Produce  json
+// @Param id path int true "Product ID"
+// @Success 200 {object} rentalResponse
+// @Failure 400 {object} purchaseProductResponse
+// @Failure 404 {object} purchaseProductResponse
+// @Security ApiKeyAuth
+// @Router /api/products/{id}/rent [post]
+func (p ProductService) RentProductHandler(c *gin.Context) {
+	productID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, purchaseProductResponse{Error: "invalid product id"})
+		return
+	}
+
+	user := c.MustGet("user").(DatabaseAbstraction.User)
+
+	event := AuditLog.NewEvent(c, AuditLog.ActionRentalPurchased)
+	event.Details["product_id"] = productID
+
+	expiresAt, err := p.RentProduct(productID, user)
+	if errors.Is(err, ErrNotForRent) {
+		c.JSON(404, purchaseProductResponse{Error: err.Error()})
+		return
+	}
+	if err != nil {
+		event.Outcome = AuditLog.OutcomeFailure
+		event.Details["reason"] = err.Error()
+		AuditLog.OrDefault(p.Audit).Record(event)
+		c.JSON(400, purchaseProductResponse{Error: "Error renting product: " + err.Error()})
+		return
+	}
+
+	event.Details["expires_at"] = expiresAt
+	AuditLog.OrDefault(p.Audit).Record(event)
+
+	c.JSON(200, rentalResponse{Message: "product rented", ExpiresAt: expiresAt})
+}
+
+// GetEntitlementsHandler godoc
+// @Summary Get entitlements
+// @Description Everything that currently gives the user access: owned products, rentals and subscriptions with their end
+// @Tags Products
+// @Accept  json
+// @Produce  json
+// @Success 200 {object} []entitlementResponse
+// @Failure 500 {object} productErrorResponse
+// @Security ApiKeyAuth
+// @Router /api/entitlements [get]
+func (p ProductService) GetEntitlementsHandler(c *gin.Context) {
+	user := c.MustGet("user").(DatabaseAbstraction.User)
+
+	entitlements, err := p.DB.GetEntitlements(user.IndexID)
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, productErrorResponse{Error: "failed to get entitlements"})
+		return
+	}
+
+	response := make([]entitlementResponse, len(entitlements))
+	for i, entitlement := range entitlements {
+		response[i] = entitlementResponse{entitlement.Source, entitlement.ProductID, entitlement.PurchaseID, entitlement.SubscriptionID,
+			entitlement.OrganizationID, entitlement.StartsAt, entitlement.ExpiresAt}
+	}
+
+	c.JSON(200, response)
+}
@@ -0,0 +1,113 @@
+package ProductService
+
+import (
+	"EntitlementServer/DatabaseAbstraction"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"html"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	maxSearchHits        = 50
+	maxSearchQueryLength = 200
+)
+
+type searchLessonResponse struct {
+	ID      int    `json:"id"`
+	Name    string `json:"name"`
+	Snippet string `json:"snippet"`
+}
+
+// searchProductResponse is a product with its matching lessons, Snippet is empty if only lessons matched
+type searchProductResponse struct {
+	ID      int                    `json:"id"`
+	Name    string                 `json:"name"`
+	Snippet string                 `json:"snippet,omitempty"`
+	Owned   bool                   `json:"owned"`
+	Lessons []searchLessonResponse `json:"lessons"`
+}
+
+type searchResponse struct {
+	Query   string                  `json:"query"`
+	Results []searchProductResponse `json:"results"`
+}
+
+// highlight escapes a snippet for HTML and wraps the matched words in <mark>
+func highlight(snippet string) string {
+	escaped := html.EscapeString(snippet)
+	escaped = strings.ReplaceAll(escaped, DatabaseAbstraction.SearchMatchStart, "<mark>")
+	return strings.ReplaceAll(escaped, DatabaseAbstraction.SearchMatchEnd, "</mark>")
+}
+
+// groupSearchHits groups the hits by product, the products are ordered by their best hit
+func groupSearchHits(hits []DatabaseAbstraction.SearchHit, owned map[int]bool) []searchProductResponse {
+	results := []searchProductResponse{}
+	positions := make(map[int]int)
+	for _, hit := range hits {
+		position, found := positions[hit.ProductID]
+		if !found {
+			position = len(results)
+			positions[hit.ProductID] = position
+			results = append(results, searchProductResponse{
+				ID:      hit.ProductID,
+				Name:    hit.ProductName,
+				Owned:   owned[hit.ProductID],
+				Lessons: []searchLessonResponse{},
+			})
+		}
+
+		if hit.VideoID == 0 {
+			results[position].Snippet = highlight(hit.Snippet)
+		} else {
+			results[position].Lessons = append(results[position].Lessons, searchLessonResponse{hit.VideoID, hit.Name, highlight(hit.Snippet)})
+		}
+	}
+	return results
+}
+
+// SearchHandler godoc
+// @Summary Search courses and lessons
+// @Description Full-text search over the names and descriptions of products and their videos, tolerating typos in names
+// @Description Results are grouped by product, best match first. Snippets are HTML with the matched words in <mark>
+// @Description owned is only true for signed in users
+// @Tags Products
+// @Produce  json
+// @Param q query string true "Search query, quoted phrases, OR and -word are supported"
+// @Success 200 {object} searchResponse
+// @Failure 400 {object} productErrorResponse
+// @Failure 500 {object} productErrorResponse
+// @Router /api/search [get]
+func (p ProductService) SearchHandler(c *gin.Context) {
+	query := strings.TrimSpace(c.Query("q"))
+	if utf8.RuneCountInString(query) < 2 || utf8.RuneCountInString(query) > maxSearchQueryLength {
+		c.JSON(400, productErrorResponse{Error: "the query needs 2 to 200 characters"})
+		return
+	}
+
+	hits, err := p.DB.Search(query, maxSearchHits)
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, productErrorResponse{Error: "search failed"})
+		return
+	}
+
+	owned := make(map[int]bool)
+	if user, signedIn := c.Get("user"); signedIn && len(hits) > 0 {
+		ownedProducts, err := p.DB.GetOwnedProducts(user.(DatabaseAbstraction.User).IndexID)
+		if err != nil {
+			logrus.Error(err)
+			c.JSON(500, productErrorResponse{Error: "search failed"})
+			return
+		}
+		for _, product := range ownedProducts {
+			owned[product.IndexID] = true
+		}
+	}
+
+	c.JSON(200, searchResponse{
+		Query:   query,
+		Results: groupSearchHits(hits, owned),
+	})
+}
@@ -0,0 +1,46 @@
+package ProductService_test
+
+import (
+	"EntitlementServer/DatabaseAbstraction"
+	"EntitlementServer/DatabaseAbstraction/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"net/http"
+	"testing"
+)
+
+func TestSearchGroupsHitsByProduct(t *testing.T) {
+	mark := func(word string) string {
+		return DatabaseAbstraction.SearchMatchStart + word + DatabaseAbstraction.SearchMatchEnd
+	}
+	mockDB := new(mocks.DBOrm)
+	mockDB.On("Search", "schleifen", 50).Return([]DatabaseAbstraction.SearchHit{
+		{ProductID: 2, ProductName: "Python Grundlagen", VideoID: 21, Name: "Schleifen", Snippet: "for-" + mark("Schleifen") + " & while", Rank: 0.9},
+		{ProductID: 1, ProductName: "PHP Fundament", VideoID: 0, Name: "PHP Fundament", Snippet: "<b>" + mark("Schleifen") + "</b>", Rank: 0.5},
+		{ProductID: 2, ProductName: "Python Grundlagen", VideoID: 0, Name: "Python Grundlagen", Snippet: mark("Schleifen") + " und Listen", Rank: 0.3},
+	}, nil)
+	mockDB.On("GetOwnedProducts", 4).Return([]DatabaseAbstraction.Product{{IndexID: 2}}, nil)
+	r := newCatalogRouter(mockDB, &DatabaseAbstraction.User{IndexID: 4})
+
+	w := httpGet(r, "/api/search?q=+schleifen+")
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"query": "schleifen", "results": [
+		{"id": 2, "name": "Python Grundlagen", "snippet": "<mark>Schleifen</mark> und Listen", "owned": true,
+			"lessons": [{"id": 21, "name": "Schleifen", "snippet": "for-<mark>Schleifen</mark> &amp; while"}]},
+		{"id": 1, "name": "PHP Fundament", "snippet": "&lt;b&gt;<mark>Schleifen</mark>&lt;/b&gt;", "owned": false, "lessons": []}
+	]}`, w.Body.String())
+}
+
+func TestSearchWithoutSignIn(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	mockDB.On("Search", "pyton", 50).Return([]DatabaseAbstraction.SearchHit{{ProductID: 2, ProductName: "Python Grundlagen", Name: "Python Grundlagen"}}, nil)
+	r := newCatalogRouter(mockDB, nil)
+
+	w := httpGet(r, "/api/search?q=pyton")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"owned":false`)
+	mockDB.AssertNotCalled(t, "GetOwnedProducts", mock.Anything)
+
+	assert.Equal(t, http.StatusBadRequest, httpGet(r, "/api/search?q=p").Code)
+}
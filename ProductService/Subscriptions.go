@@ -0,0 +1,266 @@
+package ProductService
+
+import (
+	"EntitlementServer/AuditLog"
+	"EntitlementServer/DatabaseAbstraction"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/sirupsen/logrus"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrPlanNotAvailable      = errors.New("the subscription plan is not available")
+	ErrSubscriptionNotFound  = errors.New("subscription not found")
+	ErrSubscriptionCancelled = errors.New("the subscription is not active")
+)
+
+type planResponse struct {
+	ID          int
+	Name        string
+	Description string
+	Price       int
+	PeriodDays  int
+	TrialDays   int
+}
+
+type subscribeRequest struct {
+	PlanID int
+}
+
+type subscriptionResponse struct {
+	ID               int
+	PlanID           int
+	PlanName         string
+	Status           string
+	Trial            bool
+	StartedAt        time.Time
+	CurrentPeriodEnd time.Time // access ends here unless the subscription renews
+}
+
+func newSubscriptionResponse(subscription DatabaseAbstraction.Subscription) subscriptionResponse {
+	return subscriptionResponse{subscription.IndexID, subscription.PlanID, subscription.PlanName, subscription.Status, subscription.Trial,
+		subscription.StartedAt, subscription.CurrentPeriodEnd}
+}
+
+// Subscribe starts a subscription of the plan, the first subscription of a plan with trial days starts with a free trial
+// Later periods are charged by the expiry job
+func (p ProductService) Subscribe(planID int, user DatabaseAbstraction.User) (DatabaseAbstraction.Subscription, error) {
+	plan, err := p.DB.GetSubscriptionPlanByIndexID(planID)
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && !plan.Active) {
+		return DatabaseAbstraction.Subscription{}, ErrPlanNotAvailable
+	}
+	if err != nil {
+		return DatabaseAbstraction.Subscription{}, err
+	}
+
+	user, err = p.prepareCheckout(user)
+	if err != nil {
+		return DatabaseAbstraction.Subscription{}, err
+	}
+
+	previous, err := p.DB.CountSubscriptions(user.IndexID, plan.IndexID)
+	if err != nil {
+		return DatabaseAbstraction.Subscription{}, err
+	}
+
+	now := time.Now()
+	subscription := DatabaseAbstraction.Subscription{
+		UserID:           user.IndexID,
+		PlanID:           plan.IndexID,
+		PlanName:         plan.Name,
+		Status:           DatabaseAbstraction.SubscriptionActive,
+		StartedAt:        now,
+		CurrentPeriodEnd: now.AddDate(0, 0, plan.PeriodDays),
+	}
+	price := plan.Price
+	if plan.TrialDays > 0 && previous == 0 {
+		subscription.Trial = true
+		subscription.CurrentPeriodEnd = now.AddDate(0, 0, plan.TrialDays)
+		price = 0
+	}
+	if user.Balance < price {
+		return DatabaseAbstraction.Subscription{}, ErrNotEnoughMoney
+	}
+
+	subscription.IndexID, err = p.DB.StartSubscription(subscription, price, fmt.Sprintf("subscription of plan %d", plan.IndexID))
+	if err != nil {
+		return DatabaseAbstraction.Subscription{}, err
+	}
+
+	return subscription, nil
+}
+
+// CancelSubscription stops the renewal of a subscription of the user, the access lasts until the end of the period
+func (p ProductService) CancelSubscription(subscriptionID int, user DatabaseAbstraction.User) (DatabaseAbstraction.Subscription, error) {
+	subscription, err := p.DB.GetSubscriptionByIndexID(subscriptionID)
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && subscription.UserID != user.IndexID) {
+		return DatabaseAbstraction.Subscription{}, ErrSubscriptionNotFound
+	}
+	if err != nil {
+		return DatabaseAbstraction.Subscription{}, err
+	}
+
+	err = p.DB.CancelSubscription(subscription.IndexID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return DatabaseAbstraction.Subscription{}, ErrSubscriptionCancelled
+	}
+	if err != nil {
+		return DatabaseAbstraction.Subscription{}, err
+	}
+
+	subscription.Status = DatabaseAbstraction.SubscriptionCancelled
+	return subscription, nil
+}
+
+// GetPlansHandler godoc
+// @Summary Get subscription plans
+// @Description Plans that unlock the whole catalog, cheapest first
+// @Tags Subscriptions
+// @Accept  json
+// @Produce  json
+// @Success 200 {object} []planResponse
+// @Failure 500 {object} productErrorResponse
+// @Router /api/plans [get]
+func (p ProductService) GetPlansHandler(c *gin.Context) {
+	plans, err := p.DB.GetSubscriptionPlans(false)
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, productErrorResponse{Error: "failed to get plans"})
+		return
+	}
+
+	response := make([]planResponse, len(plans))
+	for i, plan := range plans {
+		response[i] = planResponse{plan.IndexID, plan.Name, plan.Description, plan.Price, plan.PeriodDays, plan.TrialDays}
+	}
+
+	c.JSON(200, response)
+}
+
+// SubscribeHandler godoc
+// @Summary Subscribe to a plan
+// @Description Charges the first period, or starts the free trial for the first subscription of a plan that has one
+// @Description The subscription renews from the balance at the end of every period until it is cancelled
+// @Tags Subscriptions
+// @Accept  json
+// @Produce  json
+// @Param subscription body subscribeRequest true "Plan"
+// @Success 201 {object} subscriptionResponse
+// @Failure 400 {object} purchaseProductResponse
+// @Failure 404 {object} purchaseProductResponse
+// @Failure 409 {object} purchaseProductResponse
+// @Security ApiKeyAuth
+// @Router /api/subscriptions [post]
+func (p ProductService) SubscribeHandler(c *gin.Context) {
+	var request subscribeRequest
+	err := c.ShouldBindJSON(&request)
+	if err != nil {
+		c.JSON(400, purchaseProductResponse{Error: "invalid request"})
+		return
+	}
+
+	user := c.MustGet("user").(DatabaseAbstraction.User)
+
+	event := AuditLog.NewEvent(c, AuditLog.ActionSubscriptionStarted)
+	event.Details["plan_id"] = request.PlanID
+
+	subscription, err := p.Subscribe(request.PlanID, user)
+	if err != nil {
+		status := 400
+		switch {
+		case errors.Is(err, ErrPlanNotAvailable):
+			c.JSON(404, purchaseProductResponse{Error: err.Error()})
+			return
+		case errors.Is(err, DatabaseAbstraction.ErrSubscriptionExists):
+			status = 409
+		}
+		event.Outcome = AuditLog.OutcomeFailure
+		event.Details["reason"] = err.Error()
+		AuditLog.OrDefault(p.Audit).Record(event)
+		c.JSON(status, purchaseProductResponse{Error: "Error subscribing: " + err.Error()})
+		return
+	}
+
+	event.Details["subscription_id"] = subscription.IndexID
+	event.Details["trial"] = subscription.Trial
+	AuditLog.OrDefault(p.Audit).Record(event)
+
+	c.JSON(201, newSubscriptionResponse(subscription))
+}
+
+// GetSubscriptionsHandler godoc
+// @Summary Get subscriptions
+// @Description The subscriptions of the user including expired ones, newest first
+// @Tags Subscriptions
+// @Accept  json
+// @Produce  json
+// @Success 200 {object} []subscriptionResponse
+// @Failure 500 {object} productErrorResponse
+// @Security ApiKeyAuth
+// @Router /api/subscriptions [get]
+func (p ProductService) GetSubscriptionsHandler(c *gin.Context) {
+	user := c.MustGet("user").(DatabaseAbstraction.User)
+
+	subscriptions, err := p.DB.GetSubscriptionsByUserID(user.IndexID)
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, productErrorResponse{Error: "failed to get subscriptions"})
+		return
+	}
+
+	response := make([]subscriptionResponse, len(subscriptions))
+	for i, subscription := range subscriptions {
+		response[i] = newSubscriptionResponse(subscription)
+	}
+
+	c.JSON(200, response)
+}
+
+// CancelSubscriptionHandler godoc
+// @Summary Cancel a subscription
+// @Description Stops the renewal, the catalog stays unlocked until the end of the current period
+// @Tags Subscriptions
+// @Accept  json
+// @Produce  json
+// @Param id path int true "Subscription ID"
+// @Success 200 {object} subscriptionResponse
+// @Failure 400 {object} productErrorResponse
+// @Failure 404 {object} productErrorResponse
+// @Failure 409 {object} productErrorResponse
+// @Security ApiKeyAuth
+// @Router /api/subscriptions/{id} [delete]
+func (p ProductService) CancelSubscriptionHandler(c *gin.Context) {
+	subscriptionID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, productErrorResponse{Error: "invalid subscription id"})
+		return
+	}
+
+	user := c.MustGet("user").(DatabaseAbstraction.User)
+
+	subscription, err := p.CancelSubscription(subscriptionID, user)
+	if errors.Is(err, ErrSubscriptionNotFound) {
+		c.JSON(404, productErrorResponse{Error: err.Error()})
+		return
+	}
+	if errors.Is(err, ErrSubscriptionCancelled) {
+		c.JSON(409, productErrorResponse{Error: err.Error()})
+		return
+	}
+	if err != nil {
+		logrus.Error(err)
+		c.JSON(500, productErrorResponse{Error: "failed to cancel subscription"})
+		return
+	}
+
+	event := AuditLog.NewEvent(c, AuditLog.ActionSubscriptionCancelled)
+	event.Details["subscription_id"] = subscription.IndexID
+	event.Details["period_end"] = subscription.CurrentPeriodEnd
+	AuditLog.OrDefault(p.Audit).Record(event)
+
+	c.JSON(200, newSubscriptionResponse(subscription))
+}
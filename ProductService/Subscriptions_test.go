@@ -0,0 +1,134 @@
+package ProductService_test
+
+import (
+	"EntitlementServer/DatabaseAbstraction"
+	"EntitlementServer/DatabaseAbstraction/mocks"
+	"EntitlementServer/ProductService"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var flatrate = DatabaseAbstraction.SubscriptionPlan{IndexID: 1, Name: "Flatrate Monat", Price: 900, PeriodDays: 30, TrialDays: 7, Active: true}
+
+func TestRentProduct(t *testing.T) {
+	rentalEnd := time.Now().Add(24 * time.Hour)
+	mockDB := new(mocks.DBOrm)
+	mockDB.On("GetRentalOffer", 2).Return(DatabaseAbstraction.RentalOffer{ProductID: 2, Price: 200, Days: 30}, nil)
+	mockDB.On("GetRentalOffer", mock.Anything).Return(DatabaseAbstraction.RentalOffer{}, pgx.ErrNoRows)
+	mockDB.On("GetUserByIndexID", 3).Return(DatabaseAbstraction.User{IndexID: 3, Balance: 1000}, nil)
+	mockDB.On("GetUserByIndexID", 4).Return(DatabaseAbstraction.User{IndexID: 4, Balance: 1000}, nil)
+	mockDB.On("GetEntitlements", 3).Return([]DatabaseAbstraction.Entitlement{
+		{Source: DatabaseAbstraction.EntitlementRental, ProductID: 2, ExpiresAt: &rentalEnd},
+	}, nil)
+	mockDB.On("GetEntitlements", 4).Return([]DatabaseAbstraction.Entitlement{
+		{Source: DatabaseAbstraction.EntitlementPurchase, ProductID: 2},
+	}, nil)
+	mockDB.On("GetUserByIndexID", 5).Return(DatabaseAbstraction.User{IndexID: 5, Balance: 1000}, nil)
+	mockDB.On("GetEntitlements", 5).Return([]DatabaseAbstraction.Entitlement{
+		{Source: DatabaseAbstraction.EntitlementSubscription, SubscriptionID: 1, ExpiresAt: &rentalEnd},
+	}, nil)
+	mockDB.On("GetUserByIndexID", 6).Return(DatabaseAbstraction.User{IndexID: 6, Balance: 1000}, nil)
+	mockDB.On("GetEntitlements", 6).Return([]DatabaseAbstraction.Entitlement{
+		{Source: DatabaseAbstraction.EntitlementSeat, ProductID: 2, OrganizationID: 7},
+	}, nil)
+	// renting again extends the running rental
+	mockDB.On("PurchaseProducts", mock.MatchedBy(func(order DatabaseAbstraction.PurchaseOrder) bool {
+		return order.UserID == 3 && order.Total() == 200 && order.ExpiresAt.Equal(rentalEnd.AddDate(0, 0, 30))
+	})).Return(nil)
+	svc := ProductService.ProductService{DB: mockDB}
+
+	expiresAt, err := svc.RentProduct(2, DatabaseAbstraction.User{IndexID: 3})
+	assert.NoError(t, err)
+	assert.Equal(t, rentalEnd.AddDate(0, 0, 30), expiresAt)
+
+	_, err = svc.RentProduct(2, DatabaseAbstraction.User{IndexID: 4})
+	assert.ErrorIs(t, err, ProductService.ErrProductAlreadyOwned)
+	// subscribers and seat holders are never charged for a rental
+	_, err = svc.RentProduct(2, DatabaseAbstraction.User{IndexID: 5})
+	assert.ErrorIs(t, err, ProductService.ErrCoveredBySubscription)
+	_, err = svc.RentProduct(2, DatabaseAbstraction.User{IndexID: 6})
+	assert.ErrorIs(t, err, ProductService.ErrCoveredBySeat)
+	_, err = svc.RentProduct(5, DatabaseAbstraction.User{IndexID: 4})
+	assert.ErrorIs(t, err, ProductService.ErrNotForRent)
+	mockDB.AssertNumberOfCalls(t, "PurchaseProducts", 1)
+}
+
+func TestSubscribeStartsTrialOnce(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	mockDB.On("GetSubscriptionPlanByIndexID", 1).Return(flatrate, nil)
+	mockDB.On("GetSubscriptionPlanByIndexID", 2).Return(DatabaseAbstraction.SubscriptionPlan{IndexID: 2}, nil)
+	mockDB.On("GetUserByIndexID", 3).Return(DatabaseAbstraction.User{IndexID: 3}, nil)
+	mockDB.On("GetUserByIndexID", 4).Return(DatabaseAbstraction.User{IndexID: 4, Balance: 500}, nil)
+	mockDB.On("CountSubscriptions", 3, 1).Return(0, nil)
+	mockDB.On("CountSubscriptions", 4, 1).Return(1, nil)
+	mockDB.On("StartSubscription", mock.MatchedBy(func(subscription DatabaseAbstraction.Subscription) bool {
+		return subscription.Trial && subscription.CurrentPeriodEnd.Sub(subscription.StartedAt) == 7*24*time.Hour
+	}), 0, "subscription of plan 1").Return(6, nil)
+	svc := ProductService.ProductService{DB: mockDB}
+
+	subscription, err := svc.Subscribe(1, DatabaseAbstraction.User{IndexID: 3})
+	assert.NoError(t, err)
+	assert.Equal(t, 6, subscription.IndexID)
+
+	// subscribed before, the first period is charged right away
+	_, err = svc.Subscribe(1, DatabaseAbstraction.User{IndexID: 4})
+	assert.ErrorIs(t, err, ProductService.ErrNotEnoughMoney)
+	_, err = svc.Subscribe(2, DatabaseAbstraction.User{IndexID: 4})
+	assert.ErrorIs(t, err, ProductService.ErrPlanNotAvailable)
+	mockDB.AssertNumberOfCalls(t, "StartSubscription", 1)
+}
+
+func TestSubscribeHandler(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	mockDB.On("GetSubscriptionPlanByIndexID", 1).Return(flatrate, nil)
+	mockDB.On("GetSubscriptionPlanByIndexID", mock.Anything).Return(DatabaseAbstraction.SubscriptionPlan{}, pgx.ErrNoRows)
+	mockDB.On("GetUserByIndexID", 3).Return(DatabaseAbstraction.User{IndexID: 3, Balance: 900}, nil)
+	mockDB.On("CountSubscriptions", 3, 1).Return(1, nil)
+	mockDB.On("StartSubscription", mock.Anything, 900, "subscription of plan 1").Return(0, DatabaseAbstraction.ErrSubscriptionExists)
+	user := DatabaseAbstraction.User{IndexID: 3}
+	r := newCatalogRouter(mockDB, &user)
+
+	for body, status := range map[string]int{
+		`{"PlanID": 1}`: http.StatusConflict,
+		`{"PlanID": 9}`: http.StatusNotFound,
+		`{"PlanID": "`:  http.StatusBadRequest,
+	} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/subscriptions", strings.NewReader(body))
+		r.ServeHTTP(w, req)
+		assert.Equal(t, status, w.Code, body)
+	}
+}
+
+func TestCancelSubscriptionHandler(t *testing.T) {
+	mockDB := new(mocks.DBOrm)
+	mockDB.On("GetSubscriptionByIndexID", 1).Return(DatabaseAbstraction.Subscription{IndexID: 1, UserID: 3, Status: DatabaseAbstraction.SubscriptionActive}, nil)
+	mockDB.On("GetSubscriptionByIndexID", 2).Return(DatabaseAbstraction.Subscription{IndexID: 2, UserID: 3, Status: DatabaseAbstraction.SubscriptionCancelled}, nil)
+	mockDB.On("GetSubscriptionByIndexID", 3).Return(DatabaseAbstraction.Subscription{IndexID: 3, UserID: 8}, nil)
+	mockDB.On("GetSubscriptionByIndexID", mock.Anything).Return(DatabaseAbstraction.Subscription{}, pgx.ErrNoRows)
+	mockDB.On("CancelSubscription", 1).Return(nil)
+	mockDB.On("CancelSubscription", 2).Return(pgx.ErrNoRows)
+	user := DatabaseAbstraction.User{IndexID: 3}
+	r := newCatalogRouter(mockDB, &user)
+
+	for path, status := range map[string]int{
+		"/api/subscriptions/1": http.StatusOK,
+		"/api/subscriptions/2": http.StatusConflict,
+		// subscriptions of other users look like they don't exist
+		"/api/subscriptions/3": http.StatusNotFound,
+		"/api/subscriptions/4": http.StatusNotFound,
+		"/api/subscriptions/x": http.StatusBadRequest,
+	} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("DELETE", path, nil)
+		r.ServeHTTP(w, req)
+		assert.Equal(t, status, w.Code, path)
+	}
+	mockDB.AssertNotCalled(t, "CancelSubscription", 3)
+}
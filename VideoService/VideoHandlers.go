@@ -2,8 +2,10 @@ package VideoService
 
 import (
 	"EntitlementServer/DatabaseAbstraction"
+	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
 	"io"
 	"mime"
 	"net/http"
@@ -94,10 +96,12 @@ func (V VSService) MarkFinishedEndpoint(c *gin.Context) {
 
 // StartVideoStream godoc
 // @Summary Start video stream
-// @Description Start video stream
+// @Description Start video stream, only owners of the video's product can watch it
 // @Tags Videos
 // @Param number path int true "VSVideo ID"
 // @Success 200
+// @Failure 403 {string} string "not owned"
+// @Failure 404 {string} string "not found"
 // @Security ApiKeyAuth
 // @Router /api/video/{number}/stream [get]
 func (V VSService) StartVideoStream(c *gin.Context) {
@@ -105,9 +109,23 @@ func (V VSService) StartVideoStream(c *gin.Context) {
 
 	// Convert videoID to int
 	videoIDInt, err := strconv.Atoi(videoID)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "invalid video id"})
+		return
+	}
 
-	// Retrieve video filename from database
-	video, err := V.DB.GetVideoByIndexID(videoIDInt)
+	user := c.MustGet("user").(DatabaseAbstraction.User)
+
+	// Retrieve video filename from database after checking the ownership
+	filename, err := V.StreamVideo(videoIDInt, user.IndexID)
+	if errors.Is(err, ErrVideoNotOwned) {
+		c.JSON(403, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(404, gin.H{"error": "video not found"})
+		return
+	}
 	if err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
@@ -115,7 +133,7 @@ func (V VSService) StartVideoStream(c *gin.Context) {
 
 	// Start stream of file with basepath + filename
 	//basepath := os.Getenv("VIDEO_BASE_PATH")
-	serveFile(c, video.Filename)
+	serveFile(c, filename)
 	//c.File(video.Filename)
 }
 
@@ -2,6 +2,8 @@ package VideoService
 
 import (
 	"EntitlementServer/DatabaseAbstraction"
+	"EntitlementServer/Entitlements"
+	"errors"
 	"github.com/gin-gonic/gin"
 )
 
@@ -9,9 +11,11 @@ type VideoService interface {
 	GetAllVideos() ([]VSVideo, error)
 	GetVideoByIndexID(indexID int) (VSVideo, error)
 	GetVideosOfProduct(productID int) ([]VSVideo, error)
-	StreamVideo(indexID int) (string, error)
+	StreamVideo(indexID int, userID int) (string, error)
 }
 
+var ErrVideoNotOwned = errors.New("the video belongs to a product the user doesn't own")
+
 type VSVideo struct {
 	IndexID     int
 	Name        string
@@ -26,7 +30,7 @@ type VSService struct {
 }
 
 func (V VSService) RegisterHandlers(r *gin.Engine, middleware ...gin.HandlerFunc) {
-	r.GET("/api/video/:number/stream", V.StartVideoStream)
+	r.GET("/api/video/:number/stream", middleware[0], V.StartVideoStream)
 	r.GET("/api/video", middleware[0], V.GetAllVideosHandler)
 	r.GET("/api/video/:number", middleware[0], V.GetVideoInfoHandler)
 	r.POST("/api/video/:number/progress", middleware[0], V.MarkFinishedEndpoint)
@@ -100,18 +104,14 @@ func (V VSService) StreamVideo(indexID int, userID int) (string, error) {
 		return "", err
 	}
 
-	// Check if the user owns the product
-	ownsProduct, err := V.DB.GetOwnedProducts(userID)
+	// Check if the user may watch the product, refunded or lapsed products are not covered anymore
+	_, err = Entitlements.Manager{DB: V.DB}.Check(DatabaseAbstraction.User{IndexID: userID}, product.IndexID)
+	if errors.Is(err, Entitlements.ErrNotEntitled) {
+		return "", ErrVideoNotOwned
+	}
 	if err != nil {
 		return "", err
 	}
 
-	// Check if the user owns the product
-	for _, p := range ownsProduct {
-		if p.IndexID == product.IndexID {
-			return video.Filename, nil
-		}
-	}
-
-	return video.Name, nil
+	return video.Filename, nil
 }
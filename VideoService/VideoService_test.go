@@ -77,3 +77,30 @@ func TestGetVideoByIndexIDHandler(t *testing.T) {
 	assert.Equal(t, http.StatusOK, w.Code)
 	mockDB.AssertExpectations(t)
 }
+
+func TestStartVideoStreamRequiresOwnership(t *testing.T) {
+	w := httptest.NewRecorder()
+	_, r := gin.CreateTestContext(w)
+
+	// Mock DB, the user owns another product, e.g. because the video's product was refunded
+	mockDB := new(mocks.DBOrm)
+	mockDB.On("GetVideoByIndexID", 1).Return(DatabaseAbstraction.Video{IndexID: 1, Filename: "filename1.mp4"}, nil)
+	mockDB.On("GetProductByVideoIndexID", 1).Return(DatabaseAbstraction.Product{IndexID: 2}, nil)
+	mockDB.On("GetEntitlements", 5).Return([]DatabaseAbstraction.Entitlement{{Source: DatabaseAbstraction.EntitlementPurchase, ProductID: 3}}, nil)
+
+	videoSvc := VideoService.VSService{DB: mockDB}
+
+	_, err := videoSvc.StreamVideo(1, 5)
+	assert.ErrorIs(t, err, VideoService.ErrVideoNotOwned)
+
+	// Register handlers with a middleware that signs in the user
+	videoSvc.RegisterHandlers(r, func(c *gin.Context) {
+		c.Set("user", DatabaseAbstraction.User{IndexID: 5})
+	})
+
+	req, _ := http.NewRequest(http.MethodGet, "/api/video/1/stream", nil)
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	mockDB.AssertExpectations(t)
+}
@@ -1,17 +1,28 @@
 package main
 
 import (
+	"EntitlementServer/AdminService"
+	"EntitlementServer/AuditLog"
 	"EntitlementServer/AuthenticationManagement"
+	"EntitlementServer/DataExportService"
 	"EntitlementServer/DatabaseAbstraction"
+	"EntitlementServer/Entitlements"
+	"EntitlementServer/Invoicing"
+	"EntitlementServer/MailManagement"
+	"EntitlementServer/OIDCService"
+	"EntitlementServer/OrganizationService"
 	"EntitlementServer/ProductService"
 	"EntitlementServer/VideoService"
 	_ "EntitlementServer/docs"
+	"crypto/rand"
 	"github.com/gin-contrib/pprof"
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
 	"log"
+	"os"
+	"strings"
 )
 
 type HTTPService interface {
@@ -39,20 +50,95 @@ func main() {
 	DB.DB = conn
 	defer conn.Close()
 
+	hashParams, err := AuthenticationManagement.Argon2ParamsFromEnv()
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	registrationPolicy, err := AuthenticationManagement.RegistrationPolicyFromEnv()
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	mailer, err := MailManagement.NewMailerFromEnv()
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	oidcProviders, err := OIDCService.ProvidersFromEnv()
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	accessTokenKeys, err := AuthenticationManagement.AccessTokenKeysFromEnv()
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	cookieConfig, err := AuthenticationManagement.CookieConfigFromEnv()
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	refundPolicy, err := ProductService.RefundPolicyFromEnv()
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
 	// Instantiate the service structs and pass DB connection to them
-	authenticationSvc := AuthenticationManagement.AuthenticationService{DB: &DB} // handles authentication
-	productSvc := ProductService.ProductService{DB: &DB}                         // handles products
-	videoSvc := VideoService.VSService{DB: &DB}                                  // handles videos
+	auditor := AuditLog.DBAuditor{DB: &DB}                                                       // records security and commerce events
+	authenticationSvc := AuthenticationManagement.AuthenticationService{DB: &DB, Audit: auditor} // handles authentication
+	productSvc := ProductService.ProductService{DB: &DB, Audit: auditor}                         // handles products
+	videoSvc := VideoService.VSService{DB: &DB}                                                  // handles videos
+
+	authenticationSvc.HashParams = hashParams
+	authenticationSvc.Policy = &registrationPolicy
+	authenticationSvc.Mailer = mailer
+	authenticationSvc.PublicURL = os.Getenv("PUBLIC_URL")
+	authenticationSvc.SigningKey = signingKeyFromEnv()
+	authenticationSvc.RequireEmailVerification = os.Getenv("REQUIRE_EMAIL_VERIFICATION") == "true"
+	productSvc.RequireVerifiedEmail = authenticationSvc.RequireEmailVerification
+	productSvc.Refunds = &refundPolicy
+	invoiceIssuer := Invoicing.IssuerFromEnv()
+	productSvc.Issuer = &invoiceIssuer
+	authenticationSvc.TwoFactorRequiredRoles = twoFactorRequiredRolesFromEnv()
+	authenticationSvc.AccessTokenKeys = accessTokenKeys
+	authenticationSvc.Revocations = AuthenticationManagement.NewRevocationList()
+	authenticationSvc.Cookies = &cookieConfig
+	authenticationSvc.TrustedOrigins = listFromEnv("CSRF_TRUSTED_ORIGINS")
+
+	// handles logins with external identity providers
+	oidcSvc := OIDCService.OIDCService{DB: &DB, Auth: authenticationSvc, Providers: oidcProviders, PublicURL: authenticationSvc.PublicURL, Audit: auditor}
+	dataExportSvc := DataExportService.DataExportService{DB: &DB}                           // handles GDPR data exports
+	adminSvc := AdminService.AdminService{DB: &DB, Auth: authenticationSvc, Audit: auditor} // handles user management by admins
+	adminSvc.Refunds = &refundPolicy
+
+	// handles organizations and the seats they buy for their members
+	orgSvc := OrganizationService.OrganizationService{DB: &DB, Mailer: mailer, PublicURL: authenticationSvc.PublicURL, Audit: auditor}
+	orgSvc.RequireVerifiedEmail = authenticationSvc.RequireEmailVerification
+
+	// renews subscriptions and expires lapsed rentals and subscriptions in the background
+	entitlementManager := Entitlements.Manager{DB: &DB, Audit: auditor}
+	go entitlementManager.RunExpiry(Entitlements.DefaultExpiryInterval)
 
 	r := gin.Default()
+	// X-Forwarded-For is only believed from these proxies, otherwise clients could forge the IPs in the audit log
+	err = r.SetTrustedProxies(listFromEnv("TRUSTED_PROXIES"))
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	// Register the HTTP handlers for the services
 	// The authentication service is always first, it may be ignored if authentication is not needed by the service endpoint
 	// Even if you don't need authentication, you still need to register the service BEFORE the other services
 	// Middleware registration must happen in every route, because all middleware ties into a central router and a .Use call will apply to all routes
 	authenticationSvc.RegisterHandlers(r)
-	productSvc.RegisterHandlers(r, authenticationSvc.AuthenticationMiddleware)
+	productSvc.RegisterHandlers(r, authenticationSvc.AuthenticationMiddleware, authenticationSvc.OptionalAuthenticationMiddleware)
 	videoSvc.RegisterHandlers(r, authenticationSvc.AuthenticationMiddleware)
+	oidcSvc.RegisterHandlers(r, authenticationSvc.AuthenticationMiddleware)
+	dataExportSvc.RegisterHandlers(r, authenticationSvc.AuthenticationMiddleware)
+	adminSvc.RegisterHandlers(r, authenticationSvc.AuthenticationMiddleware)
+	orgSvc.RegisterHandlers(r, authenticationSvc.AuthenticationMiddleware)
 
 	// Register the Swagger documentation handler
 	r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
@@ -64,3 +150,40 @@ func main() {
 		log.Fatal(err)
 	}
 }
+
+// signingKeyFromEnv reads the HMAC key for signed links from AUTH_SIGNING_KEY
+// Without it a random key is used, which invalidates all outstanding links on restart
+func signingKeyFromEnv() []byte {
+	key := os.Getenv("AUTH_SIGNING_KEY")
+	if key != "" {
+		return []byte(key)
+	}
+
+	logrus.Warn("AUTH_SIGNING_KEY is not set, using a random key")
+	randomKey := make([]byte, 32)
+	_, err := rand.Read(randomKey)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	return randomKey
+}
+
+// twoFactorRequiredRolesFromEnv reads the comma separated TWO_FACTOR_REQUIRED_ROLES, admins and instructors by default
+// Set it to an empty string to make two-factor authentication optional for everyone
+func twoFactorRequiredRolesFromEnv() []string {
+	if _, set := os.LookupEnv("TWO_FACTOR_REQUIRED_ROLES"); !set {
+		return []string{DatabaseAbstraction.RoleAdmin, DatabaseAbstraction.RoleInstructor}
+	}
+	return listFromEnv("TWO_FACTOR_REQUIRED_ROLES")
+}
+
+// listFromEnv splits a comma separated environment variable, ignoring empty entries
+func listFromEnv(key string) []string {
+	var entries []string
+	for _, entry := range strings.Split(os.Getenv(key), ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
@@ -5,11 +5,56 @@ DROP TABLE IF EXISTS video CASCADE;
 DROP TABLE IF EXISTS product_comments CASCADE;
 DROP TABLE IF EXISTS user_watched_videos CASCADE;
 DROP TABLE IF EXISTS user_tokens CASCADE;
+DROP TABLE IF EXISTS password_reset_tokens CASCADE;
+DROP TABLE IF EXISTS user_recovery_codes CASCADE;
+DROP TABLE IF EXISTS two_factor_challenges CASCADE;
+DROP TABLE IF EXISTS user_identities CASCADE;
+DROP TABLE IF EXISTS oidc_login_flows CASCADE;
+DROP TABLE IF EXISTS revoked_tokens CASCADE;
+DROP TABLE IF EXISTS api_keys CASCADE;
+DROP TABLE IF EXISTS balance_transactions CASCADE;
+DROP TABLE IF EXISTS data_exports CASCADE;
+DROP TABLE IF EXISTS audit_events CASCADE;
+DROP TABLE IF EXISTS product_tags CASCADE;
+DROP TABLE IF EXISTS categories CASCADE;
+DROP TABLE IF EXISTS bundles CASCADE;
+DROP TABLE IF EXISTS bundle_products CASCADE;
+DROP TABLE IF EXISTS coupons CASCADE;
+DROP TABLE IF EXISTS refund_requests CASCADE;
+DROP TABLE IF EXISTS license_keys CASCADE;
+DROP TABLE IF EXISTS rental_offers CASCADE;
+DROP TABLE IF EXISTS subscription_plans CASCADE;
+DROP TABLE IF EXISTS subscriptions CASCADE;
+DROP TABLE IF EXISTS organizations CASCADE;
+DROP TABLE IF EXISTS organization_members CASCADE;
+DROP TABLE IF EXISTS organization_invites CASCADE;
+DROP TABLE IF EXISTS organization_seats CASCADE;
+DROP TABLE IF EXISTS seat_assignments CASCADE;
+DROP TABLE IF EXISTS vat_rates CASCADE;
+DROP TABLE IF EXISTS invoices CASCADE;
+DROP TABLE IF EXISTS invoice_items CASCADE;
+DROP TABLE IF EXISTS invoice_counters CASCADE;
+
+/* Typo tolerant search */
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
 
 CREATE TABLE users (
     id SERIAL PRIMARY KEY,
     username VARCHAR NOT NULL UNIQUE,
     password VARCHAR NOT NULL,
+    email VARCHAR,
+    email_verified_at TIMESTAMP,
+    role VARCHAR NOT NULL DEFAULT 'student',
+    totp_secret VARCHAR,
+    totp_enabled BOOLEAN NOT NULL DEFAULT false,
+    totp_last_step BIGINT NOT NULL DEFAULT 0,
+    display_name VARCHAR,
+    avatar_url VARCHAR,
+    locale VARCHAR,
+    country VARCHAR, /* ISO 3166 code, decides the VAT rate on invoices */
+    deleted_at TIMESTAMP,
+    suspended_at TIMESTAMP,
+    suspension_reason VARCHAR,
     balance INTEGER DEFAULT 0,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
@@ -19,12 +64,78 @@ CREATE TABLE users (
 CREATE TABLE user_tokens (
     id         SERIAL PRIMARY KEY,
     user_id    INTEGER NOT NULL,
+    impersonator_id INTEGER,
     token      VARCHAR NOT NULL,
     expiry    TIMESTAMP NOT NULL,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
 
+CREATE TABLE password_reset_tokens (
+    id         SERIAL PRIMARY KEY,
+    user_id    INTEGER NOT NULL,
+    token_hash VARCHAR NOT NULL UNIQUE,
+    expiry     TIMESTAMP NOT NULL,
+    used_at    TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE user_recovery_codes (
+    id         SERIAL PRIMARY KEY,
+    user_id    INTEGER NOT NULL,
+    code_hash  VARCHAR NOT NULL,
+    used_at    TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE user_identities (
+    id         SERIAL PRIMARY KEY,
+    user_id    INTEGER NOT NULL,
+    provider   VARCHAR NOT NULL,
+    subject    VARCHAR NOT NULL,
+    email      VARCHAR NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (provider, subject),
+    UNIQUE (user_id, provider)
+);
+
+/* logins waiting for the second factor, attempts are counted so codes can't be guessed */
+CREATE TABLE two_factor_challenges (
+    challenge_hash VARCHAR PRIMARY KEY,
+    user_id        INTEGER NOT NULL,
+    attempts       INTEGER NOT NULL DEFAULT 0,
+    expiry         TIMESTAMP NOT NULL
+);
+
+CREATE TABLE oidc_login_flows (
+    state         VARCHAR PRIMARY KEY,
+    provider      VARCHAR NOT NULL,
+    nonce         VARCHAR NOT NULL,
+    code_verifier VARCHAR NOT NULL,
+    user_id       INTEGER,
+    expiry        TIMESTAMP NOT NULL
+);
+
+CREATE TABLE revoked_tokens (
+    id         SERIAL PRIMARY KEY,
+    token_id   VARCHAR,
+    user_id    INTEGER NOT NULL,
+    revoked_at TIMESTAMP NOT NULL,
+    expiry     TIMESTAMP NOT NULL
+);
+
+CREATE TABLE api_keys (
+    id           SERIAL PRIMARY KEY,
+    user_id      INTEGER NOT NULL,
+    name         VARCHAR NOT NULL,
+    prefix       VARCHAR NOT NULL,
+    key_hash     VARCHAR NOT NULL UNIQUE,
+    scopes       VARCHAR[] NOT NULL,
+    expiry       TIMESTAMP,
+    last_used_at TIMESTAMP,
+    created_at   TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
 CREATE TABLE products (
     id SERIAL PRIMARY KEY,
     name VARCHAR NOT NULL,
@@ -33,8 +144,27 @@ CREATE TABLE products (
     image VARCHAR NOT NULL,
     difficulty INTEGER NOT NULL DEFAULT 1,
     preview_url VARCHAR NOT NULL DEFAULT '',
+    category_id INTEGER,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    /* the content is German, English stems catch the many English technical terms */
+    search_vector TSVECTOR GENERATED ALWAYS AS (
+        setweight(to_tsvector('german', name), 'A') || setweight(to_tsvector('english', name), 'A') ||
+        setweight(to_tsvector('german', description), 'B') || setweight(to_tsvector('english', description), 'B')
+    ) STORED
+);
+
+CREATE TABLE categories (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR NOT NULL,
+    slug VARCHAR NOT NULL UNIQUE,
+    parent_id INTEGER
+);
+
+CREATE TABLE product_tags (
+    product_id INTEGER NOT NULL,
+    tag VARCHAR NOT NULL,
+    PRIMARY KEY (product_id, tag)
 );
 
 CREATE TABLE video (
@@ -46,17 +176,167 @@ CREATE TABLE video (
     thumbnail VARCHAR NOT NULL,
     filename VARCHAR NOT NULL,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    search_vector TSVECTOR GENERATED ALWAYS AS (
+        setweight(to_tsvector('german', name), 'A') || setweight(to_tsvector('english', name), 'A') ||
+        setweight(to_tsvector('german', description), 'B') || setweight(to_tsvector('english', description), 'B')
+    ) STORED
+);
+
+CREATE TABLE bundles (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR NOT NULL,
+    description VARCHAR NOT NULL,
+    price INTEGER NOT NULL,
+    image VARCHAR NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
 
+/* position orders the products of a learning path */
+CREATE TABLE bundle_products (
+    bundle_id INTEGER NOT NULL,
+    product_id INTEGER NOT NULL,
+    position INTEGER NOT NULL,
+    PRIMARY KEY (bundle_id, product_id)
+);
+
+/* a NULL limit or bound means unrestricted, uses counts the redemptions of all users */
+CREATE TABLE coupons (
+    id SERIAL PRIMARY KEY,
+    code VARCHAR NOT NULL UNIQUE,
+    kind VARCHAR NOT NULL,
+    value INTEGER NOT NULL,
+    product_id INTEGER,
+    max_uses INTEGER,
+    max_uses_per_user INTEGER,
+    uses INTEGER NOT NULL DEFAULT 0,
+    valid_from TIMESTAMP,
+    valid_until TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
 CREATE TABLE user_purchases (
     id SERIAL PRIMARY KEY,
     user_id INTEGER NOT NULL,
     product_id INTEGER NOT NULL,
+    bundle_id INTEGER, /* set if the product was bought as part of a bundle */
+    coupon_id INTEGER,
+    license_key_id INTEGER, /* set if the product was granted by redeeming a license key */
+    invoice_id INTEGER, /* products bought together share an invoice */
+    price INTEGER NOT NULL DEFAULT 0, /* what the user paid, after the discount */
+    discount INTEGER NOT NULL DEFAULT 0,
+    expires_at TIMESTAMP, /* set for rentals, NULL means the product is owned */
+    expired BOOLEAN NOT NULL DEFAULT false, /* set by the expiry job once a rental lapsed */
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
 
+/* products without an offer can't be rented */
+CREATE TABLE rental_offers (
+    product_id INTEGER PRIMARY KEY,
+    price INTEGER NOT NULL,
+    days INTEGER NOT NULL
+);
+
+/* a subscription unlocks the whole catalog, price is charged per period */
+CREATE TABLE subscription_plans (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR NOT NULL,
+    description VARCHAR NOT NULL DEFAULT '',
+    price INTEGER NOT NULL,
+    period_days INTEGER NOT NULL,
+    trial_days INTEGER NOT NULL DEFAULT 0,
+    active BOOLEAN NOT NULL DEFAULT true,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+/* access lasts until current_period_end, the expiry job renews active and expires lapsed subscriptions */
+CREATE TABLE subscriptions (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    plan_id INTEGER NOT NULL,
+    status VARCHAR NOT NULL,
+    trial BOOLEAN NOT NULL DEFAULT false,
+    started_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    current_period_end TIMESTAMP NOT NULL
+);
+
+/* schools and companies, their admins buy seats and assign them to members */
+CREATE TABLE organizations (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE organization_members (
+    organization_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    role VARCHAR NOT NULL DEFAULT 'member',
+    joined_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (organization_id, user_id)
+);
+
+/* an invite with email can be accepted once by that address, one without is a join code for a whole class */
+CREATE TABLE organization_invites (
+    id SERIAL PRIMARY KEY,
+    organization_id INTEGER NOT NULL,
+    code VARCHAR NOT NULL UNIQUE,
+    email VARCHAR,
+    created_by INTEGER,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    accepted_by INTEGER,
+    accepted_at TIMESTAMP
+);
+
+/* seats bought per product, at most that many members can be assigned one */
+CREATE TABLE organization_seats (
+    organization_id INTEGER NOT NULL,
+    product_id INTEGER NOT NULL,
+    seats INTEGER NOT NULL,
+    PRIMARY KEY (organization_id, product_id)
+);
+
+/* an assigned seat gives the member access to the product until it is reclaimed */
+CREATE TABLE seat_assignments (
+    organization_id INTEGER NOT NULL,
+    product_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    assigned_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (organization_id, product_id, user_id)
+);
+
+/* created_by bought the keys or is the admin who generated them, price is paid per key */
+CREATE TABLE license_keys (
+    id SERIAL PRIMARY KEY,
+    code VARCHAR NOT NULL UNIQUE,
+    product_id INTEGER NOT NULL,
+    created_by INTEGER,
+    label VARCHAR NOT NULL DEFAULT '',
+    price INTEGER NOT NULL DEFAULT 0,
+    status VARCHAR NOT NULL DEFAULT 'unused',
+    redeemed_by INTEGER,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    redeemed_at TIMESTAMP,
+    revoked_at TIMESTAMP
+);
+
+/* product_id and amount are copies, approving a refund deletes the purchase */
+CREATE TABLE refund_requests (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    purchase_id INTEGER,
+    product_id INTEGER NOT NULL,
+    amount INTEGER NOT NULL,
+    reason VARCHAR NOT NULL,
+    watched_percent INTEGER NOT NULL,
+    status VARCHAR NOT NULL,
+    decided_by INTEGER,
+    decision_note VARCHAR NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    decided_at TIMESTAMP
+);
+
 CREATE TABLE product_comments (
     id SERIAL PRIMARY KEY,
     user_id INTEGER NOT NULL,
@@ -74,6 +354,76 @@ CREATE TABLE user_watched_videos (
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
 
+CREATE TABLE balance_transactions (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    amount INTEGER NOT NULL,
+    reason VARCHAR NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+/* rates are in hundredths of a percent, 1900 is 19 % */
+CREATE TABLE vat_rates (
+    country VARCHAR PRIMARY KEY,
+    rate INTEGER NOT NULL,
+    is_default BOOLEAN NOT NULL DEFAULT false /* charged to users without country */
+);
+
+/* billing details, rate and amounts are copied when the invoice is issued and never change */
+CREATE TABLE invoices (
+    id SERIAL PRIMARY KEY,
+    number VARCHAR NOT NULL UNIQUE,
+    user_id INTEGER,
+    kind VARCHAR NOT NULL,
+    billing_name VARCHAR NOT NULL,
+    billing_email VARCHAR NOT NULL DEFAULT '',
+    country VARCHAR NOT NULL DEFAULT '',
+    vat_rate INTEGER NOT NULL DEFAULT 0,
+    gross INTEGER NOT NULL,
+    net INTEGER NOT NULL,
+    vat INTEGER NOT NULL,
+    cancels_invoice_id INTEGER, /* set for credit notes */
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE invoice_items (
+    id SERIAL PRIMARY KEY,
+    invoice_id INTEGER NOT NULL,
+    description VARCHAR NOT NULL,
+    quantity INTEGER NOT NULL DEFAULT 1,
+    amount INTEGER NOT NULL, /* paid for all units, after the discount */
+    discount INTEGER NOT NULL DEFAULT 0
+);
+
+/* the last invoice number per year, the row is locked while an invoice is issued so numbers have no gaps */
+CREATE TABLE invoice_counters (
+    year INTEGER PRIMARY KEY,
+    last_number INTEGER NOT NULL
+);
+
+CREATE TABLE data_exports (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    status VARCHAR NOT NULL,
+    archive BYTEA,
+    error VARCHAR,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    completed_at TIMESTAMP,
+    expiry TIMESTAMP NOT NULL
+);
+
+CREATE TABLE audit_events (
+    id SERIAL PRIMARY KEY,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    action VARCHAR NOT NULL,
+    outcome VARCHAR NOT NULL,
+    actor_id INTEGER,
+    impersonator_id INTEGER,
+    target_user_id INTEGER,
+    ip VARCHAR,
+    details JSONB
+);
+
 /* --Constraints-- */
 
 /* Video deleted -> delete watched videos */
@@ -97,6 +447,226 @@ FOREIGN KEY (parent_product_id)
 REFERENCES products (id)
 ON DELETE CASCADE;
 
+/* Product deleted -> delete tags */
+ALTER TABLE product_tags
+ADD CONSTRAINT fk_product_tags
+FOREIGN KEY (product_id)
+REFERENCES products (id)
+ON DELETE CASCADE;
+
+/* Category deleted -> delete subcategories */
+ALTER TABLE categories
+ADD CONSTRAINT fk_category_parent
+FOREIGN KEY (parent_id)
+REFERENCES categories (id)
+ON DELETE CASCADE;
+
+/* Category deleted -> products become uncategorized */
+ALTER TABLE products
+ADD CONSTRAINT fk_product_category
+FOREIGN KEY (category_id)
+REFERENCES categories (id)
+ON DELETE SET NULL;
+
+/* Bundle deleted -> delete its product list */
+ALTER TABLE bundle_products
+ADD CONSTRAINT fk_bundle_products_bundle
+FOREIGN KEY (bundle_id)
+REFERENCES bundles (id)
+ON DELETE CASCADE;
+
+/* Product deleted -> remove it from bundles */
+ALTER TABLE bundle_products
+ADD CONSTRAINT fk_bundle_products_product
+FOREIGN KEY (product_id)
+REFERENCES products (id)
+ON DELETE CASCADE;
+
+/* Bundle deleted -> keep the purchases of its products */
+ALTER TABLE user_purchases
+ADD CONSTRAINT fk_user_purchases_bundle
+FOREIGN KEY (bundle_id)
+REFERENCES bundles (id)
+ON DELETE SET NULL;
+
+/* Product deleted -> delete its coupons */
+ALTER TABLE coupons
+ADD CONSTRAINT fk_coupon_product
+FOREIGN KEY (product_id)
+REFERENCES products (id)
+ON DELETE CASCADE;
+
+/* Coupon deleted -> keep the purchases made with it */
+ALTER TABLE user_purchases
+ADD CONSTRAINT fk_user_purchases_coupon
+FOREIGN KEY (coupon_id)
+REFERENCES coupons (id)
+ON DELETE SET NULL;
+
+/* Purchase deleted by an approved refund -> keep the refund */
+ALTER TABLE refund_requests
+ADD CONSTRAINT fk_refund_purchase
+FOREIGN KEY (purchase_id)
+REFERENCES user_purchases (id)
+ON DELETE SET NULL;
+
+/* User deleted -> delete refunds */
+ALTER TABLE refund_requests
+ADD CONSTRAINT fk_refund_user
+FOREIGN KEY (user_id)
+REFERENCES users (id)
+ON DELETE CASCADE;
+
+/* Product deleted -> delete refunds */
+ALTER TABLE refund_requests
+ADD CONSTRAINT fk_refund_product
+FOREIGN KEY (product_id)
+REFERENCES products (id)
+ON DELETE CASCADE;
+
+/* Admin deleted -> keep their decisions */
+ALTER TABLE refund_requests
+ADD CONSTRAINT fk_refund_decided_by
+FOREIGN KEY (decided_by)
+REFERENCES users (id)
+ON DELETE SET NULL;
+
+/* Product deleted -> delete its license keys */
+ALTER TABLE license_keys
+ADD CONSTRAINT fk_license_key_product
+FOREIGN KEY (product_id)
+REFERENCES products (id)
+ON DELETE CASCADE;
+
+/* Buyer deleted -> the keys they gave away stay valid */
+ALTER TABLE license_keys
+ADD CONSTRAINT fk_license_key_created_by
+FOREIGN KEY (created_by)
+REFERENCES users (id)
+ON DELETE SET NULL;
+
+/* User who redeemed the key deleted -> keep the key redeemed */
+ALTER TABLE license_keys
+ADD CONSTRAINT fk_license_key_redeemed_by
+FOREIGN KEY (redeemed_by)
+REFERENCES users (id)
+ON DELETE SET NULL;
+
+/* License key deleted -> keep the purchase */
+ALTER TABLE user_purchases
+ADD CONSTRAINT fk_user_purchases_license_key
+FOREIGN KEY (license_key_id)
+REFERENCES license_keys (id)
+ON DELETE SET NULL;
+
+/* User deleted -> keep their invoices for the accounting */
+ALTER TABLE invoices
+ADD CONSTRAINT fk_invoices_user
+FOREIGN KEY (user_id)
+REFERENCES users (id)
+ON DELETE SET NULL;
+
+/* Invoices are kept, a credit note always refers to the invoice it cancels */
+ALTER TABLE invoices
+ADD CONSTRAINT fk_invoices_cancels
+FOREIGN KEY (cancels_invoice_id)
+REFERENCES invoices (id);
+
+/* Invoice deleted -> delete its lines */
+ALTER TABLE invoice_items
+ADD CONSTRAINT fk_invoice_items_invoice
+FOREIGN KEY (invoice_id)
+REFERENCES invoices (id)
+ON DELETE CASCADE;
+
+/* Invoice deleted -> keep the purchase */
+ALTER TABLE user_purchases
+ADD CONSTRAINT fk_user_purchases_invoice
+FOREIGN KEY (invoice_id)
+REFERENCES invoices (id)
+ON DELETE SET NULL;
+
+/* Product deleted -> delete its rental offer */
+ALTER TABLE rental_offers
+ADD CONSTRAINT fk_rental_offer_product
+FOREIGN KEY (product_id)
+REFERENCES products (id)
+ON DELETE CASCADE;
+
+/* User deleted -> delete subscriptions */
+ALTER TABLE subscriptions
+ADD CONSTRAINT fk_subscription_user
+FOREIGN KEY (user_id)
+REFERENCES users (id)
+ON DELETE CASCADE;
+
+/* Plans are deactivated instead of deleted, a plan with subscriptions can't be deleted */
+ALTER TABLE subscriptions
+ADD CONSTRAINT fk_subscription_plan
+FOREIGN KEY (plan_id)
+REFERENCES subscription_plans (id)
+ON DELETE RESTRICT;
+
+/* Organization deleted -> delete members, invites and seats */
+ALTER TABLE organization_members
+ADD CONSTRAINT fk_organization_member_organization
+FOREIGN KEY (organization_id)
+REFERENCES organizations (id)
+ON DELETE CASCADE;
+
+/* User deleted -> leave organizations */
+ALTER TABLE organization_members
+ADD CONSTRAINT fk_organization_member_user
+FOREIGN KEY (user_id)
+REFERENCES users (id)
+ON DELETE CASCADE;
+
+ALTER TABLE organization_invites
+ADD CONSTRAINT fk_organization_invite_organization
+FOREIGN KEY (organization_id)
+REFERENCES organizations (id)
+ON DELETE CASCADE;
+
+/* Admin or invited user deleted -> keep the invite */
+ALTER TABLE organization_invites
+ADD CONSTRAINT fk_organization_invite_created_by
+FOREIGN KEY (created_by)
+REFERENCES users (id)
+ON DELETE SET NULL;
+
+ALTER TABLE organization_invites
+ADD CONSTRAINT fk_organization_invite_accepted_by
+FOREIGN KEY (accepted_by)
+REFERENCES users (id)
+ON DELETE SET NULL;
+
+ALTER TABLE organization_seats
+ADD CONSTRAINT fk_organization_seats_organization
+FOREIGN KEY (organization_id)
+REFERENCES organizations (id)
+ON DELETE CASCADE;
+
+/* Product deleted -> delete its seats */
+ALTER TABLE organization_seats
+ADD CONSTRAINT fk_organization_seats_product
+FOREIGN KEY (product_id)
+REFERENCES products (id)
+ON DELETE CASCADE;
+
+/* Seats deleted -> reclaim them */
+ALTER TABLE seat_assignments
+ADD CONSTRAINT fk_seat_assignment_seats
+FOREIGN KEY (organization_id, product_id)
+REFERENCES organization_seats (organization_id, product_id)
+ON DELETE CASCADE;
+
+/* Member removed -> reclaim their seats */
+ALTER TABLE seat_assignments
+ADD CONSTRAINT fk_seat_assignment_member
+FOREIGN KEY (organization_id, user_id)
+REFERENCES organization_members (organization_id, user_id)
+ON DELETE CASCADE;
+
 /* User deleted -> delete purchases */
 ALTER TABLE user_purchases
 ADD CONSTRAINT fk_user_purchases
@@ -118,15 +688,134 @@ FOREIGN KEY (user_id)
 REFERENCES users (id)
 ON DELETE CASCADE;
 
+/* Impersonating admin deleted -> delete their impersonation sessions */
+ALTER TABLE user_tokens
+ADD CONSTRAINT fk_user_tokens_impersonator
+FOREIGN KEY (impersonator_id)
+REFERENCES users (id)
+ON DELETE CASCADE;
+
+/* User deleted -> delete password reset tokens */
+ALTER TABLE password_reset_tokens
+ADD CONSTRAINT fk_user_password_reset
+FOREIGN KEY (user_id)
+REFERENCES users (id)
+ON DELETE CASCADE;
+
+/* User deleted -> delete recovery codes */
+ALTER TABLE user_recovery_codes
+ADD CONSTRAINT fk_user_recovery_codes
+FOREIGN KEY (user_id)
+REFERENCES users (id)
+ON DELETE CASCADE;
+
+/* User deleted -> delete pending two-factor logins */
+ALTER TABLE two_factor_challenges
+ADD CONSTRAINT fk_two_factor_challenges
+FOREIGN KEY (user_id)
+REFERENCES users (id)
+ON DELETE CASCADE;
+
+/* User deleted -> delete external identities */
+ALTER TABLE user_identities
+ADD CONSTRAINT fk_user_identities
+FOREIGN KEY (user_id)
+REFERENCES users (id)
+ON DELETE CASCADE;
+
+/* User deleted -> delete revocation entries */
+ALTER TABLE revoked_tokens
+ADD CONSTRAINT fk_revoked_tokens
+FOREIGN KEY (user_id)
+REFERENCES users (id)
+ON DELETE CASCADE;
+
+/* User deleted -> delete API keys */
+ALTER TABLE api_keys
+ADD CONSTRAINT fk_api_keys
+FOREIGN KEY (user_id)
+REFERENCES users (id)
+ON DELETE CASCADE;
+
+/* User deleted -> delete wallet history */
+ALTER TABLE balance_transactions
+ADD CONSTRAINT fk_balance_transactions
+FOREIGN KEY (user_id)
+REFERENCES users (id)
+ON DELETE CASCADE;
+
+/* User deleted -> delete data exports */
+ALTER TABLE data_exports
+ADD CONSTRAINT fk_data_exports
+FOREIGN KEY (user_id)
+REFERENCES users (id)
+ON DELETE CASCADE;
+
+/* Audit events outlive the users they mention and can't be changed or deleted */
+CREATE RULE audit_events_no_update AS ON UPDATE TO audit_events DO INSTEAD NOTHING;
+CREATE RULE audit_events_no_delete AS ON DELETE TO audit_events DO INSTEAD NOTHING;
+
+/* Only known roles */
+ALTER TABLE users
+ADD CONSTRAINT check_role
+CHECK (role IN ('student', 'instructor', 'admin'));
+
+/* Usernames are unique regardless of case */
+CREATE UNIQUE INDEX users_username_unique ON users (lower(username));
+
+/* Email addresses are unique regardless of case */
+CREATE UNIQUE INDEX users_email_unique ON users (lower(email));
+
 /* Prevent negative balance */
 ALTER TABLE users
 ADD CONSTRAINT check_balance
 CHECK (balance >= 0);
 
+/* Percent coupons take 1 to 100 percent off, amount coupons a positive amount */
+ALTER TABLE coupons
+ADD CONSTRAINT check_coupon_value
+CHECK ((kind = 'percent' AND value BETWEEN 1 AND 100) OR (kind = 'amount' AND value > 0));
+
+ALTER TABLE refund_requests
+ADD CONSTRAINT check_refund_status
+CHECK (status IN ('pending', 'approved', 'rejected'));
+
+ALTER TABLE license_keys
+ADD CONSTRAINT check_license_key_status
+CHECK (status IN ('unused', 'redeemed', 'revoked'));
+
+ALTER TABLE rental_offers
+ADD CONSTRAINT check_rental_offer
+CHECK (price >= 0 AND days > 0);
+
+ALTER TABLE subscription_plans
+ADD CONSTRAINT check_subscription_plan
+CHECK (price > 0 AND period_days > 0 AND trial_days >= 0);
+
+ALTER TABLE subscriptions
+ADD CONSTRAINT check_subscription_status
+CHECK (status IN ('active', 'cancelled', 'expired'));
+
+ALTER TABLE organization_members
+ADD CONSTRAINT check_organization_member_role
+CHECK (role IN ('admin', 'member'));
+
+ALTER TABLE organization_seats
+ADD CONSTRAINT check_organization_seats
+CHECK (seats > 0);
+
+ALTER TABLE invoices
+ADD CONSTRAINT check_invoice_kind
+CHECK (kind IN ('purchase', 'top_up', 'credit_note'));
+
+ALTER TABLE vat_rates
+ADD CONSTRAINT check_vat_rate
+CHECK (rate >= 0 AND rate < 10000);
+
 /* Sample data */
 
-INSERT INTO users (username, password, balance)
-VALUES ('admin', '$argon2id$v=19$m=256000,t=6,p=1$dGVzdHRlc3Q$MMMzLViNOBi+zmhnFWj4y1y6TqYfRvmUAI6BiH30mIk', 1000);
+INSERT INTO users (username, password, balance, role)
+VALUES ('admin', '$argon2id$v=19$m=256000,t=6,p=1$dGVzdHRlc3Q$MMMzLViNOBi+zmhnFWj4y1y6TqYfRvmUAI6BiH30mIk', 1000, 'admin');
 /* password is admin */
 
 INSERT INTO users (username, password)
@@ -402,12 +1091,91 @@ VALUES (2, 1, 'Das ist auch ein Kommentar');
 INSERT INTO product_comments (user_id, course_id, comment)
 VALUES (1, 1, 'das ist ja krass bro');
 
+INSERT INTO product_tags (product_id, tag)
+VALUES (1, 'php'), (1, 'web'), (1, 'backend'),
+       (2, 'python'), (2, 'backend'),
+       (3, 'html'), (3, 'css'), (3, 'web'),
+       (4, 'java'), (4, 'oop'),
+       (5, 'cpp'), (5, 'oop'),
+       (6, 'html'), (6, 'css'), (6, 'web'),
+       (7, 'security'), (7, 'web'), (7, 'backend'),
+       (8, 'javascript'), (8, 'web'),
+       (9, 'csharp'), (9, 'oop');
+
+INSERT INTO categories (name, slug, parent_id)
+VALUES ('Programmierung', 'programmierung', NULL),
+       ('Webentwicklung', 'webentwicklung', 1),
+       ('Programmiersprachen', 'programmiersprachen', 1),
+       ('IT-Sicherheit', 'it-sicherheit', NULL);
+
+UPDATE products SET category_id = 2 WHERE id IN (1, 3, 6, 8);
+UPDATE products SET category_id = 3 WHERE id IN (2, 4, 5, 9);
+UPDATE products SET category_id = 4 WHERE id = 7;
+
+INSERT INTO bundles (name, description, price, image)
+VALUES ('Lernpfad Webentwicklung', 'Von der ersten HTML-Seite bis zu interaktiven Webseiten mit Javascript: drei Kurse in der empfohlenen Reihenfolge zum Vorteilspreis.', 1000, '/static/htmlcss/thumbnail.jpg');
+
+INSERT INTO bundle_products (bundle_id, product_id, position)
+VALUES (1, 3, 1), (1, 6, 2), (1, 8, 3);
+
+INSERT INTO coupons (code, kind, value, max_uses_per_user)
+VALUES ('WILLKOMMEN10', 'percent', 10, 1);
+
+INSERT INTO rental_offers (product_id, price, days)
+VALUES (1, 200, 30), (2, 200, 30);
+
+INSERT INTO subscription_plans (name, description, price, period_days, trial_days)
+VALUES ('Flatrate Monat', 'Alle Kurse für 30 Tage, die ersten 7 Tage sind kostenlos.', 900, 30, 7);
+
+INSERT INTO vat_rates (country, rate, is_default)
+VALUES ('DE', 1900, true), ('AT', 2000, false), ('CH', 810, false);
+
 /* --Indexes-- */
 CREATE INDEX idx_user_purchases_user_id ON user_purchases (user_id);
 CREATE INDEX idx_user_purchases_product_id ON user_purchases (product_id);
+CREATE INDEX idx_user_purchases_coupon_id ON user_purchases (coupon_id);
+
+CREATE INDEX idx_refund_requests_user_id ON refund_requests (user_id);
+/* at most one refund per purchase */
+CREATE UNIQUE INDEX idx_refund_requests_purchase_id ON refund_requests (purchase_id);
+
+CREATE INDEX idx_license_keys_product_id ON license_keys (product_id);
+CREATE INDEX idx_license_keys_created_by ON license_keys (created_by);
+CREATE INDEX idx_user_purchases_license_key_id ON user_purchases (license_key_id);
+
+CREATE INDEX idx_subscriptions_user_id ON subscriptions (user_id);
+/* a user has at most one running subscription per plan, concurrent trials would otherwise both be renewed */
+CREATE UNIQUE INDEX idx_subscriptions_user_plan_unexpired ON subscriptions (user_id, plan_id) WHERE status <> 'expired';
+/* the expiry job looks for lapsed subscriptions and rentals */
+CREATE INDEX idx_subscriptions_current_period_end ON subscriptions (current_period_end) WHERE status <> 'expired';
+CREATE INDEX idx_user_purchases_expires_at ON user_purchases (expires_at) WHERE NOT expired;
+
+CREATE INDEX idx_organization_members_user_id ON organization_members (user_id);
+CREATE INDEX idx_organization_invites_organization_id ON organization_invites (organization_id);
+CREATE INDEX idx_seat_assignments_user_id ON seat_assignments (user_id);
+
+CREATE INDEX idx_invoices_user_id ON invoices (user_id);
+CREATE INDEX idx_invoice_items_invoice_id ON invoice_items (invoice_id);
+CREATE INDEX idx_invoices_cancels_invoice_id ON invoices (cancels_invoice_id);
+CREATE INDEX idx_user_purchases_invoice_id ON user_purchases (invoice_id);
+/* at most one default rate */
+CREATE UNIQUE INDEX idx_vat_rates_default ON vat_rates (is_default) WHERE is_default;
 
 CREATE INDEX idx_video_comments_user_id ON product_comments (user_id);
 CREATE INDEX idx_video_comments_video_id ON product_comments (course_id);
 
 CREATE INDEX idx_video_parent_product_id ON video (parent_product_id);
 
+CREATE INDEX idx_audit_events_action ON audit_events (action);
+CREATE INDEX idx_audit_events_actor_id ON audit_events (actor_id);
+CREATE INDEX idx_audit_events_target_user_id ON audit_events (target_user_id);
+
+CREATE INDEX idx_product_tags_tag ON product_tags (tag);
+CREATE INDEX idx_products_category_id ON products (category_id);
+CREATE INDEX idx_categories_parent_id ON categories (parent_id);
+CREATE INDEX idx_bundle_products_product_id ON bundle_products (product_id);
+
+CREATE INDEX idx_products_search ON products USING GIN (search_vector);
+CREATE INDEX idx_video_search ON video USING GIN (search_vector);
+CREATE INDEX idx_products_name_trgm ON products USING GIN (name gin_trgm_ops);
+CREATE INDEX idx_video_name_trgm ON video USING GIN (name gin_trgm_ops);